@@ -1,7 +1,11 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"slices"
@@ -13,8 +17,22 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/rakyll/statik/fs"
 	"github.com/riotpot/pkg/api"
+	"github.com/riotpot/pkg/artifacts"
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/export"
+	"github.com/riotpot/pkg/geoip"
+	"github.com/riotpot/pkg/hpfeeds"
 	"github.com/riotpot/pkg/logger"
 	"github.com/riotpot/pkg/plugins"
+	"github.com/riotpot/pkg/proxy"
+	"github.com/riotpot/pkg/replay"
+	"github.com/riotpot/pkg/scanners"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/sessions"
+	"github.com/riotpot/pkg/shell"
+	"github.com/riotpot/pkg/signatures"
+	"github.com/riotpot/pkg/tracing"
+	"github.com/riotpot/pkg/utils"
 	"github.com/riotpot/ui"
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
@@ -22,11 +40,22 @@ import (
 	_ "github.com/riotpot/statik"
 )
 
-func setup(output string, pluginsPath string, services []string) {
+// Set the logger and the exporter of the traces
+func setup(output string, otlpEndpoint string) {
 
 	// Set the logger
 	logger.Log = logger.New(zerolog.DebugLevel, output)
 
+	// Export the traces of the sessions to an OpenTelemetry collector
+	if otlpEndpoint != "" {
+		tracing.Tracer.SetExporter(tracing.NewOTLPExporter(otlpEndpoint, 0))
+		logger.Log.Log().Msg(fmt.Sprintf("Exporting traces to %s", otlpEndpoint))
+	}
+}
+
+// Start the proxies of the services and the interception. Everything they use, e.g., the sinks
+// of the events or the GeoIP databases, must be configured before
+func startServices(pluginsPath string, services []string, upstreams []string, transparentPort int) {
 	// Load plugins
 	px, err := plugins.LoadPlugins(pluginsPath)
 	if err != nil {
@@ -46,6 +75,15 @@ func setup(output string, pluginsPath string, services []string) {
 			continue
 		}
 
+		// Accept the PROXY protocol from the upstreams in front of riotpot.
+		// The UDP proxies do not read the header
+		if p.GetNetwork() == utils.TCP {
+			_, err = p.SetTrustedUpstreams(upstreams)
+			if err != nil {
+				panic(err)
+			}
+		}
+
 		err = p.Start()
 		if err != nil {
 			panic(err)
@@ -53,6 +91,16 @@ func setup(output string, pluginsPath string, services []string) {
 
 		logger.Log.Log().Msg(fmt.Sprintf("Proxy %s started. Listening in %d", p.GetService().GetName(), p.GetPort()))
 	}
+
+	// Receive the connections redirected by the firewall from any port
+	if transparentPort > 0 {
+		err = proxy.NewInterceptor(transparentPort).Start()
+		if err != nil {
+			panic(err)
+		}
+
+		logger.Log.Log().Msg(fmt.Sprintf("Transparent interception started. Listening in %d", transparentPort))
+	}
 }
 
 func createApiRouter(whitelist []string, startUi bool) *gin.Engine {
@@ -73,6 +121,17 @@ func createApiRouter(whitelist []string, startUi bool) *gin.Engine {
 	group := router.Group("/api/")
 	api.ProxiesRouter.AddToGroup(group)
 	api.ServiceRouter.AddToGroup(group)
+	api.FingerprintsRouter.AddToGroup(group)
+	api.AlertsRouter.AddToGroup(group)
+	api.ExportRouter.AddToGroup(group)
+	api.SessionsRouter.AddToGroup(group)
+	api.AttackersRouter.AddToGroup(group)
+	api.ScannersRouter.AddToGroup(group)
+	api.ArtifactsRouter.AddToGroup(group)
+	api.SignaturesRouter.AddToGroup(group)
+
+	// Metrics for Prometheus
+	router.GET("metrics", api.GetMetrics)
 
 	if startUi {
 		ui.AddRoutes(router)
@@ -106,7 +165,235 @@ func parseSetupFlags(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 
-	setup(outFlag, pluginsFlag, srvFlag)
+	upstreamsFlag, err := fgs.GetStringSlice("trusted-upstreams")
+	if err != nil {
+		panic(err)
+	}
+
+	transparentFlag, err := fgs.GetInt("transparent-port")
+	if err != nil {
+		panic(err)
+	}
+
+	otlpFlag, err := fgs.GetString("otlp-endpoint")
+	if err != nil {
+		panic(err)
+	}
+
+	setup(outFlag, otlpFlag)
+
+	syslogFlag, err := fgs.GetString("syslog")
+	if err != nil {
+		panic(err)
+	}
+
+	syslogFormatFlag, err := fgs.GetString("syslog-format")
+	if err != nil {
+		panic(err)
+	}
+
+	syslogFacilityFlag, err := fgs.GetString("syslog-facility")
+	if err != nil {
+		panic(err)
+	}
+
+	syslogMapFlag, err := fgs.GetStringToString("syslog-map")
+	if err != nil {
+		panic(err)
+	}
+
+	syslogCAFlag, err := fgs.GetString("syslog-ca")
+	if err != nil {
+		panic(err)
+	}
+
+	if syslogFlag != "" {
+		setupSyslog(syslogFlag, syslogFormatFlag, syslogFacilityFlag, syslogMapFlag, syslogCAFlag)
+	}
+
+	hpfeedsFlag, err := fgs.GetString("hpfeeds")
+	if err != nil {
+		panic(err)
+	}
+
+	hpfeedsIdentFlag, err := fgs.GetString("hpfeeds-ident")
+	if err != nil {
+		panic(err)
+	}
+
+	hpfeedsSecretFlag, err := fgs.GetString("hpfeeds-secret")
+	if err != nil {
+		panic(err)
+	}
+
+	hpfeedsChannelFlag, err := fgs.GetString("hpfeeds-channel")
+	if err != nil {
+		panic(err)
+	}
+
+	geoipCityFlag, err := fgs.GetString("geoip-city")
+	if err != nil {
+		panic(err)
+	}
+
+	geoipASNFlag, err := fgs.GetString("geoip-asn")
+	if err != nil {
+		panic(err)
+	}
+
+	// Locate the attackers with the local databases
+	if geoipCityFlag != "" {
+		db, err := geoip.Open(geoipCityFlag)
+		if err != nil {
+			panic(err)
+		}
+		geoip.GeoIP.SetCityDatabase(db)
+	}
+
+	if geoipASNFlag != "" {
+		db, err := geoip.Open(geoipASNFlag)
+		if err != nil {
+			panic(err)
+		}
+		geoip.GeoIP.SetASNDatabase(db)
+	}
+
+	scannersFlag, err := fgs.GetString("scanners")
+	if err != nil {
+		panic(err)
+	}
+
+	// Replace the default list of known scanners with the local one
+	if scannersFlag != "" {
+		if err := scanners.Scanners.Load(scannersFlag); err != nil {
+			panic(err)
+		}
+	}
+
+	signaturesFlag, err := fgs.GetString("signatures")
+	if err != nil {
+		panic(err)
+	}
+
+	// Replace the default rules of the signatures with the local ones
+	if signaturesFlag != "" {
+		if err := signatures.Signatures.Load(signaturesFlag); err != nil {
+			panic(err)
+		}
+	}
+
+	shellImageFlag, err := fgs.GetString("shell-image")
+	if err != nil {
+		panic(err)
+	}
+
+	// Replace the default filesystem of the shells with the local one
+	if shellImageFlag != "" {
+		image, err := shell.LoadImage(shellImageFlag)
+		if err != nil {
+			panic(err)
+		}
+		shell.SetImage(image)
+	}
+
+	artifactsFlag, err := fgs.GetString("artifacts")
+	if err != nil {
+		panic(err)
+	}
+
+	artifactsMaxSizeFlag, err := fgs.GetInt64("artifacts-max-size")
+	if err != nil {
+		panic(err)
+	}
+
+	artifactsMaxTotalFlag, err := fgs.GetInt64("artifacts-max-total")
+	if err != nil {
+		panic(err)
+	}
+
+	artifactsDownloadFlag, err := fgs.GetBool("artifacts-download")
+	if err != nil {
+		panic(err)
+	}
+
+	// Keep the files of the attackers, only their metadata is kept without a directory
+	artifacts.Artifacts.SetLimits(artifactsMaxSizeFlag, artifactsMaxTotalFlag)
+	if artifactsFlag != "" {
+		if err := artifacts.Artifacts.SetDirectory(artifactsFlag); err != nil {
+			panic(err)
+		}
+	}
+	artifacts.Artifacts.SetDownloads(artifactsDownloadFlag, false)
+
+	eventsFileFlag, err := fgs.GetString("events-file")
+	if err != nil {
+		panic(err)
+	}
+
+	// Keep the events in a file, e.g., to export them later
+	if eventsFileFlag != "" {
+		sink, err := events.NewFileSink(eventsFileFlag)
+		if err != nil {
+			panic(err)
+		}
+
+		_, err = events.Events.Register(sink)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Publish the events in an HPFeeds broker
+	if hpfeedsFlag != "" {
+		_, err = events.Events.Register(hpfeeds.NewSink(hpfeedsFlag, hpfeedsIdentFlag, hpfeedsSecretFlag, hpfeedsChannelFlag))
+		if err != nil {
+			panic(err)
+		}
+
+		logger.Log.Log().Msg(fmt.Sprintf("Publishing events in the HPFeeds broker %s", hpfeedsFlag))
+	}
+
+	// The connections are accepted once everything is configured
+	startServices(pluginsFlag, srvFlag, upstreamsFlag, transparentFlag)
+}
+
+// Send the events to a syslog server
+func setupSyslog(server string, format string, facility string, mapping map[string]string, caPath string) {
+	f, err := events.ParseFormat(format)
+	if err != nil {
+		panic(err)
+	}
+
+	fc, err := events.ParseFacility(facility)
+	if err != nil {
+		panic(err)
+	}
+
+	// Trust the CA of the server, e.g., when it uses a self-signed certificate
+	tlsConfig := &tls.Config{}
+	if caPath != "" {
+		ca, err := os.ReadFile(caPath)
+		if err != nil {
+			panic(err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			panic(fmt.Errorf("no certificates found in %s", caPath))
+		}
+	}
+
+	sink, err := events.NewSyslogSink(server, fc, events.NewFormatter(f, mapping), tlsConfig)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = events.Events.Register(sink)
+	if err != nil {
+		panic(err)
+	}
+
+	logger.Log.Log().Msg(fmt.Sprintf("Sending events to %s as %s", server, f))
 }
 
 func NewRootCommand() *cobra.Command {
@@ -126,6 +413,28 @@ func NewRootCommand() *cobra.Command {
 	rootFlags.StringSlice("services", []string{}, "Comma-separated list of services to start")
 	rootFlags.String("output", "", "Path to output file. E.g., 'path/to/riotpot.log'")
 	rootFlags.String("plugins", "plugins/*.so", "Path to plugins folder")
+	rootFlags.Int("transparent-port", 0, "Port receiving the connections redirected by iptables/nftables (Linux only). Disabled when 0")
+	rootFlags.StringSlice("trusted-upstreams", []string{}, "Comma-separated list of CIDRs allowed to send PROXY protocol headers. E.g., 10.0.0.0/8")
+	rootFlags.String("otlp-endpoint", "", "OTLP/HTTP traces endpoint of an OpenTelemetry collector. E.g., http://localhost:4318/v1/traces. Disabled when empty")
+	rootFlags.String("syslog", "", "Syslog server to send the events to. E.g., udp://siem:514, tcp://siem:601 or tls://siem:6514. Disabled when empty")
+	rootFlags.String("syslog-format", string(events.JSONFormat), "Format of the syslog messages: json, cef or leef")
+	rootFlags.String("syslog-facility", "local0", "Facility of the syslog messages")
+	rootFlags.StringToString("syslog-map", map[string]string{}, "Rename the fields of the events in the syslog messages, or leave them out with an empty name. E.g., user=duser,password=")
+	rootFlags.String("syslog-ca", "", "Path to the CA certificate of the syslog server, used with TLS")
+	rootFlags.String("geoip-city", "", "Path to a MaxMind DB with the city or country of the networks. E.g., 'path/to/GeoLite2-City.mmdb'")
+	rootFlags.String("geoip-asn", "", "Path to a MaxMind DB with the autonomous system of the networks. E.g., 'path/to/GeoLite2-ASN.mmdb'")
+	rootFlags.String("scanners", "", "Path to a JSON file with the known scanners, replacing the default list. Each scanner has a 'name' and lists of 'cidrs', reverse DNS 'domains' and request 'signatures'")
+	rootFlags.String("signatures", "", "Path to a file, or a directory of .yar files, with YARA-like rules matched with the payloads and the commands, replacing the default rules")
+	rootFlags.String("shell-image", "", "Path to a JSON file with the files of the filesystem of the fake shells, replacing the default image. Each file has a 'path', a 'type' ('file' or 'dir'), a 'mode' in octal and a 'content'. The 'persona' sets the output of commands such as uname or busybox")
+	rootFlags.String("artifacts", "", "Directory where the files uploaded by the attackers, or downloaded from their commands, are kept by SHA-256. Only their metadata is kept when empty")
+	rootFlags.Int64("artifacts-max-size", artifacts.DefaultMaxSize, "Maximum size of each artifact in bytes, larger files only keep their metadata")
+	rootFlags.Int64("artifacts-max-total", artifacts.DefaultMaxTotal, "Maximum size of all the artifacts kept in bytes")
+	rootFlags.Bool("artifacts-download", false, "Download the files of the URLs in the commands of the attackers, e.g., wget http://... Only public addresses are reached")
+	rootFlags.String("events-file", "", "Path to a file where the events are appended, one JSON object per line. E.g., 'path/to/events.jsonl'")
+	rootFlags.String("hpfeeds", "", "Address of an HPFeeds broker to publish the events in. E.g., hpfeeds.example.com:10000. Disabled when empty")
+	rootFlags.String("hpfeeds-ident", "riotpot", "Identity used to authenticate in the HPFeeds broker")
+	rootFlags.String("hpfeeds-secret", "", "Secret used to authenticate in the HPFeeds broker")
+	rootFlags.String("hpfeeds-channel", "riotpot", "Prefix of the HPFeeds channels. The events are published in a channel for each service, e.g., riotpot.ssh")
 
 	return cmds
 }
@@ -171,6 +480,228 @@ func NewServerCommand() *cobra.Command {
 	return cmdApi
 }
 
+// Start the plugin service with the given name and return its address
+func startPluginService(pluginsPath string, name string) (address string, err error) {
+	services, err := plugins.GetPluginServices(pluginsPath)
+	if err != nil {
+		return
+	}
+
+	for _, srv := range services {
+		if !strings.EqualFold(srv.GetName(), name) {
+			continue
+		}
+
+		pg, ok := srv.(service.PluginService)
+		if !ok {
+			return "", fmt.Errorf("service %s can not be started", srv.GetName())
+		}
+		go pg.Run()
+
+		// Wait for the service to accept connections
+		address = srv.GetAddress()
+		for i := 0; i < 50; i++ {
+			conn, derr := net.DialTimeout(srv.GetNetwork().String(), address, 100*time.Millisecond)
+			if derr == nil {
+				conn.Close()
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		return "", fmt.Errorf("service %s did not start", srv.GetName())
+	}
+
+	err = fmt.Errorf("service not found: %s", name)
+	return
+}
+
+func NewReplayCommand() *cobra.Command {
+	var cmdReplay = &cobra.Command{
+		Use:   "replay",
+		Short: "Replays a recorded session against a service",
+		Long:  "replay sends the client side of a recorded attacker session to a service (a local plugin or any other address) and compares the responses with the recording",
+		Run: func(cmd *cobra.Command, args []string) {
+			fgs := cmd.Flags()
+
+			recordingFlag, err := fgs.GetString("recording")
+			if err != nil {
+				panic(err)
+			}
+
+			addressFlag, err := fgs.GetString("address")
+			if err != nil {
+				panic(err)
+			}
+
+			serviceFlag, err := fgs.GetString("service")
+			if err != nil {
+				panic(err)
+			}
+
+			pluginsFlag, err := fgs.GetString("plugins")
+			if err != nil {
+				panic(err)
+			}
+
+			timingFlag, err := fgs.GetString("timing")
+			if err != nil {
+				panic(err)
+			}
+
+			maxDelayFlag, err := fgs.GetDuration("max-delay")
+			if err != nil {
+				panic(err)
+			}
+
+			readTimeoutFlag, err := fgs.GetDuration("read-timeout")
+			if err != nil {
+				panic(err)
+			}
+
+			idleTimeoutFlag, err := fgs.GetDuration("idle-timeout")
+			if err != nil {
+				panic(err)
+			}
+
+			timing, err := replay.ParseTiming(timingFlag)
+			if err != nil {
+				panic(err)
+			}
+
+			rec, err := replay.Load(recordingFlag)
+			if err != nil {
+				panic(err)
+			}
+
+			// Start the local plugin when the target is a service rather than an address
+			if addressFlag == "" {
+				if serviceFlag == "" {
+					serviceFlag = rec.Service
+				}
+
+				addressFlag, err = startPluginService(pluginsFlag, serviceFlag)
+				if err != nil {
+					panic(err)
+				}
+			}
+
+			rp := replay.NewReplayer(timing)
+			rp.MaxDelay = maxDelayFlag
+			rp.ReadTimeout = readTimeoutFlag
+			rp.IdleTimeout = idleTimeoutFlag
+
+			res, err := rp.Replay(rec, addressFlag)
+			if err != nil {
+				panic(err)
+			}
+
+			for _, m := range res.Mismatches {
+				fmt.Println(m.String())
+			}
+			fmt.Printf("Sent %d chunks, compared %d responses, %d mismatches\n", res.Sent, res.Compared, len(res.Mismatches))
+
+			if !res.Equal() {
+				os.Exit(1)
+			}
+		},
+	}
+
+	replayFlags := cmdReplay.Flags()
+	replayFlags.String("recording", "", "Path to the recorded session. E.g., 'path/to/session.json'")
+	replayFlags.String("address", "", "Address of the service to replay against. E.g., 'localhost:2222'")
+	replayFlags.String("service", "", "Name of the plugin service to replay against. Default: the service in the recording")
+	replayFlags.String("plugins", "plugins/*.so", "Path to plugins folder")
+	replayFlags.String("timing", replay.OriginalTimingValue, "Timing between the client messages: 'original' or 'compressed'")
+	replayFlags.Duration("max-delay", 100*time.Millisecond, "Maximum delay between client messages with the compressed timing")
+	replayFlags.Duration("read-timeout", 2*time.Second, "Time to wait for each response of the service")
+	replayFlags.Duration("idle-timeout", 200*time.Millisecond, "Time without data after which a response of the service is complete")
+	cmdReplay.MarkFlagRequired("recording")
+
+	return cmdReplay
+}
+
+func NewExportCommand() *cobra.Command {
+	var cmdExport = &cobra.Command{
+		Use:   "export",
+		Short: "Exports the sessions stored in an events file",
+		Long:  "export groups the events written with --events-file in sessions and exports the indicators observed as a STIX 2.1 bundle or a MISP event",
+		Run: func(cmd *cobra.Command, args []string) {
+			fgs := cmd.Flags()
+
+			eventsFlag, err := fgs.GetString("events")
+			if err != nil {
+				panic(err)
+			}
+
+			formatFlag, err := fgs.GetString("format")
+			if err != nil {
+				panic(err)
+			}
+
+			outputFlag, err := fgs.GetString("output")
+			if err != nil {
+				panic(err)
+			}
+
+			sinceFlag, err := fgs.GetString("since")
+			if err != nil {
+				panic(err)
+			}
+
+			format, err := export.ParseFormat(formatFlag)
+			if err != nil {
+				panic(err)
+			}
+
+			var since time.Time
+			if sinceFlag != "" {
+				since, err = time.Parse(time.RFC3339, sinceFlag)
+				if err != nil {
+					panic(err)
+				}
+			}
+
+			evs, err := events.LoadEvents(eventsFlag)
+			if err != nil {
+				panic(err)
+			}
+
+			store := sessions.NewStore(len(evs) + 1)
+			store.Load(evs)
+
+			exported, err := export.Export(format, store.GetSessions(since))
+			if err != nil {
+				panic(err)
+			}
+
+			data, err := json.MarshalIndent(exported, "", "  ")
+			if err != nil {
+				panic(err)
+			}
+
+			if outputFlag == "" {
+				fmt.Println(string(data))
+				return
+			}
+
+			err = os.WriteFile(outputFlag, append(data, '\n'), 0644)
+			if err != nil {
+				panic(err)
+			}
+		},
+	}
+
+	exportFlags := cmdExport.Flags()
+	exportFlags.String("events", "", "Path to the events file. E.g., 'path/to/events.jsonl'")
+	exportFlags.String("format", string(export.STIXFormat), "Format of the export: 'stix' or 'misp'")
+	exportFlags.String("output", "", "Path to the output file. Default: standard output")
+	exportFlags.String("since", "", "Export only the sessions active since an RFC 3339 time. E.g., 2024-01-02T15:04:05Z")
+	cmdExport.MarkFlagRequired("events")
+
+	return cmdExport
+}
+
 func NewRiotpotCommand() *cobra.Command {
 
 	cmds := NewRootCommand()
@@ -181,5 +712,7 @@ func NewRiotpotCommand() *cobra.Command {
 	serverFlags.AddFlagSet(rootFlags)
 
 	cmds.AddCommand(cmdServer)
+	cmds.AddCommand(NewReplayCommand())
+	cmds.AddCommand(NewExportCommand())
 	return cmds
 }
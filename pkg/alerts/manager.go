@@ -0,0 +1,242 @@
+package alerts
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	lr "github.com/riotpot/pkg/logger"
+	"github.com/riotpot/pkg/metrics"
+)
+
+var (
+	// Exportable alerts manager with the rules
+	Alerts = NewAlertManager()
+)
+
+var (
+	alertsTotal = metrics.Metrics.NewCounter(
+		"riotpot_alerts_total",
+		"Alerts of the rules by the result of the delivery",
+		"rule", "status",
+	)
+)
+
+const (
+	// Number of alerts waiting to be delivered before new alerts are dropped
+	queueSize = 256
+	// Number of alerts delivered at the same time
+	workers = 4
+)
+
+// Alert waiting to be POSTed to a webhook
+type delivery struct {
+	rule    *Rule
+	webhook string
+	payload []byte
+}
+
+type AlertManager interface {
+	// Add a rule
+	AddRule(rule *Rule) (*Rule, error)
+	// Replace the rule with the same ID
+	UpdateRule(id string, rule *Rule) (*Rule, error)
+	// Remove a rule by ID
+	DeleteRule(id string) error
+	// Get a rule by ID
+	GetRule(id string) (*Rule, error)
+	// Get the rules
+	GetRules() []*Rule
+}
+
+// Events sink that sends the alerts of the rules the events match
+type alertManager struct {
+	AlertManager
+
+	mu    sync.RWMutex
+	rules []*Rule
+
+	queue  chan delivery
+	client *http.Client
+
+	// Number of times an alert is sent again after failing
+	Retries int
+	// Time waited before the first retry, doubled on each retry
+	Backoff time.Duration
+}
+
+func (am *alertManager) GetName() string {
+	return "alerts"
+}
+
+// Queue the alerts of the rules matched by the event
+func (am *alertManager) Send(ev *events.Event) error {
+	now := time.Now()
+
+	for _, rule := range am.GetRules() {
+		if !rule.Match(ev) {
+			continue
+		}
+		rule.matched.Add(1)
+
+		if !rule.allow(now) {
+			rule.limited.Add(1)
+			alertsTotal.With(rule.Name, "rate_limited").Inc()
+			continue
+		}
+
+		// A rule that can not render the event does not keep the rest from alerting
+		payload, err := rule.Render(ev)
+		if err != nil {
+			rule.failed.Add(1)
+			alertsTotal.With(rule.Name, "failed").Inc()
+			lr.Log.Warn().Err(err).Str("rule", rule.Name).Msg("Could not render the alert")
+			continue
+		}
+
+		for _, webhook := range rule.Webhooks {
+			select {
+			case am.queue <- delivery{rule: rule, webhook: webhook, payload: payload}:
+			default:
+				rule.failed.Add(1)
+				alertsTotal.With(rule.Name, "dropped").Inc()
+				lr.Log.Warn().Str("rule", rule.Name).Msg("Alerts queue full, alert dropped")
+			}
+		}
+	}
+
+	return nil
+}
+
+func (am *alertManager) AddRule(rule *Rule) (r *Rule, err error) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	for _, registered := range am.rules {
+		if registered.Name == rule.Name {
+			err = fmt.Errorf("rule already registered: %s", rule.Name)
+			return
+		}
+	}
+
+	am.rules = append(am.rules, rule)
+	return rule, nil
+}
+
+func (am *alertManager) UpdateRule(id string, rule *Rule) (r *Rule, err error) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	ind := -1
+	for i, registered := range am.rules {
+		if registered.id == id {
+			ind = i
+		} else if registered.Name == rule.Name {
+			err = fmt.Errorf("rule already registered: %s", rule.Name)
+			return
+		}
+	}
+
+	if ind < 0 {
+		err = fmt.Errorf("rule not found: %s", id)
+		return
+	}
+
+	// The rule keeps its ID
+	rule.id = id
+	am.rules[ind] = rule
+	return rule, nil
+}
+
+func (am *alertManager) DeleteRule(id string) (err error) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	for ind, rule := range am.rules {
+		if rule.id == id {
+			am.rules = append(am.rules[:ind], am.rules[ind+1:]...)
+			return
+		}
+	}
+
+	err = fmt.Errorf("rule not found: %s", id)
+	return
+}
+
+func (am *alertManager) GetRule(id string) (rule *Rule, err error) {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	for _, rule := range am.rules {
+		if rule.id == id {
+			return rule, nil
+		}
+	}
+
+	err = fmt.Errorf("rule not found: %s", id)
+	return
+}
+
+func (am *alertManager) GetRules() []*Rule {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	return append([]*Rule{}, am.rules...)
+}
+
+// Deliver the alerts in the queue
+func (am *alertManager) deliver() {
+	for d := range am.queue {
+		err := am.post(d)
+		for retry := 0; err != nil && retry < am.Retries; retry++ {
+			time.Sleep(am.Backoff << retry)
+			err = am.post(d)
+		}
+
+		if err != nil {
+			d.rule.failed.Add(1)
+			alertsTotal.With(d.rule.Name, "failed").Inc()
+			lr.Log.Warn().Err(err).Str("rule", d.rule.Name).Str("webhook", d.webhook).Msg("Could not send the alert")
+			continue
+		}
+
+		d.rule.success.Add(1)
+		alertsTotal.With(d.rule.Name, "sent").Inc()
+	}
+}
+
+// POST the alert to the webhook
+func (am *alertManager) post(d delivery) error {
+	resp, err := am.client.Post(d.webhook, "application/json", bytes.NewReader(d.payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Constructor for the alerts manager
+func NewAlertManager() *alertManager {
+	am := &alertManager{
+		queue:   make(chan delivery, queueSize),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		Retries: 3,
+		Backoff: time.Second,
+	}
+
+	for i := 0; i < workers; i++ {
+		go am.deliver()
+	}
+	return am
+}
+
+func init() {
+	events.Events.Register(Alerts)
+}
@@ -0,0 +1,264 @@
+/*
+This package implements the alerts sent to webhooks when the events emitted by the
+proxies and the services match a rule
+*/
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/riotpot/pkg/events"
+)
+
+// Template used when the rule does not have one
+const defaultTemplate = `{"rule": {{json .Rule.Name}}, "event": {{json .Event}}}`
+
+// Comparison made by a condition
+type Operator string
+
+const (
+	// The field is equal to the value, ignoring the case
+	EqualOperator Operator = "eq"
+	// The field is not equal to the value, ignoring the case
+	NotEqualOperator Operator = "ne"
+	// The field contains the value
+	ContainsOperator Operator = "contains"
+	// The field matches the regular expression in the value
+	MatchesOperator Operator = "matches"
+	// The field is set
+	ExistsOperator Operator = "exists"
+)
+
+// Comparison of a field of the events with a value, e.g., "type eq auth"
+type Condition struct {
+	// Name of the attribute or field of the event, e.g., "service" or "command"
+	Field    string
+	Operator Operator
+	Value    string
+
+	regex *regexp.Regexp
+}
+
+// Whether the event satisfies the condition
+func (c *Condition) Match(ev *events.Event) bool {
+	value, ok := ev.Get(c.Field)
+	if c.Operator == ExistsOperator {
+		return ok && value != ""
+	}
+
+	str := ""
+	if ok {
+		switch v := value.(type) {
+		case string:
+			str = v
+		case []string:
+			str = strings.Join(v, ",")
+		default:
+			str = fmt.Sprintf("%v", v)
+		}
+	}
+
+	switch c.Operator {
+	case EqualOperator:
+		return strings.EqualFold(str, c.Value)
+	case NotEqualOperator:
+		return !strings.EqualFold(str, c.Value)
+	case ContainsOperator:
+		return ok && strings.Contains(str, c.Value)
+	case MatchesOperator:
+		return ok && c.regex.MatchString(str)
+	}
+	return false
+}
+
+func NewCondition(field string, operator Operator, value string) (c *Condition, err error) {
+	if field == "" {
+		return nil, fmt.Errorf("the field of the condition can not be empty")
+	}
+
+	c = &Condition{Field: field, Operator: operator, Value: value}
+
+	switch operator {
+	case EqualOperator, NotEqualOperator, ContainsOperator, ExistsOperator:
+	case MatchesOperator:
+		c.regex, err = regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid operator: %s", operator)
+	}
+	return
+}
+
+// Data given to the templates of the rules
+type alert struct {
+	Rule  *Rule
+	Event *events.Event
+}
+
+// Sends an alert to the webhooks when an event satisfies all the conditions
+type Rule struct {
+	id   string
+	Name string
+
+	Conditions []*Condition
+	// URLs the alerts are POSTed to
+	Webhooks []string
+	// Template of the JSON payload, rendered with the rule and the event
+	Template string
+	// Maximum number of alerts sent in a minute, 0 for no limit
+	RateLimit int
+
+	tmpl *template.Template
+
+	// Fixed window of the rate limit
+	mu     sync.Mutex
+	window time.Time
+	sent   int
+
+	// Statistics of the rule
+	matched atomic.Int64
+	limited atomic.Int64
+	failed  atomic.Int64
+	success atomic.Int64
+}
+
+func (r *Rule) GetID() string {
+	return r.id
+}
+
+// Whether the event satisfies all the conditions
+func (r *Rule) Match(ev *events.Event) bool {
+	for _, c := range r.Conditions {
+		if !c.Match(ev) {
+			return false
+		}
+	}
+	return true
+}
+
+// Render the payload of the alert for the event
+func (r *Rule) Render(ev *events.Event) (payload []byte, err error) {
+	var buf bytes.Buffer
+	if err = r.tmpl.Execute(&buf, alert{Rule: r, Event: ev}); err != nil {
+		return
+	}
+
+	payload = buf.Bytes()
+	if !json.Valid(payload) {
+		return nil, fmt.Errorf("the template of the rule %s did not render valid JSON", r.Name)
+	}
+	return
+}
+
+// Number of events that matched the rule
+func (r *Rule) GetMatched() int64 {
+	return r.matched.Load()
+}
+
+// Number of alerts dropped by the rate limit
+func (r *Rule) GetLimited() int64 {
+	return r.limited.Load()
+}
+
+// Number of alerts delivered to a webhook
+func (r *Rule) GetSent() int64 {
+	return r.success.Load()
+}
+
+// Number of alerts that could not be rendered, or delivered to a webhook after retrying
+func (r *Rule) GetFailed() int64 {
+	return r.failed.Load()
+}
+
+// Take a slot of the rate limit. Returns false when the limit is reached
+func (r *Rule) allow(now time.Time) bool {
+	if r.RateLimit <= 0 {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.window) >= time.Minute {
+		r.window = now
+		r.sent = 0
+	}
+
+	if r.sent >= r.RateLimit {
+		return false
+	}
+
+	r.sent++
+	return true
+}
+
+// Create a rule. The template is validated against an empty event
+func NewRule(name string, conditions []*Condition, webhooks []string, tmpl string, rateLimit int) (r *Rule, err error) {
+	if name == "" {
+		return nil, fmt.Errorf("the name of the rule can not be empty")
+	}
+
+	if len(webhooks) == 0 {
+		return nil, fmt.Errorf("the rule needs at least a webhook")
+	}
+
+	for _, webhook := range webhooks {
+		u, err := url.Parse(webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook: %s", webhook)
+		}
+	}
+
+	if rateLimit < 0 {
+		return nil, fmt.Errorf("the rate limit can not be negative")
+	}
+
+	if tmpl == "" {
+		tmpl = defaultTemplate
+	}
+
+	r = &Rule{
+		id:         uuid.New().String(),
+		Name:       name,
+		Conditions: conditions,
+		Webhooks:   webhooks,
+		Template:   tmpl,
+		RateLimit:  rateLimit,
+	}
+
+	r.tmpl, err = template.New(name).Funcs(funcs).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = r.Render(events.NewEvent(events.ConnectionEvent, "", "")); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Functions available in the templates
+var funcs = template.FuncMap{
+	// Encode a value as JSON, e.g., {{json .Event.Source}}
+	"json": func(value interface{}) (string, error) {
+		ret, err := json.Marshal(value)
+		return string(ret), err
+	},
+	// Get an attribute or a field of the event, e.g., {{field .Event "user" | json}}
+	"field": func(ev *events.Event, key string) interface{} {
+		value, _ := ev.Get(key)
+		return value
+	},
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riotpot/pkg/alerts"
+)
+
+// Structures used to serialize data:
+type GetCondition struct {
+	Field    string `json:"field" binding:"required"`
+	Operator string `json:"operator" binding:"required"`
+	Value    string `json:"value"`
+}
+
+type GetRule struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	Conditions []GetCondition `json:"conditions"`
+	Webhooks   []string       `json:"webhooks"`
+	Template   string         `json:"template"`
+	RateLimit  int            `json:"rate_limit"`
+	Matched    int64          `json:"matched"`
+	Sent       int64          `json:"sent"`
+	Failed     int64          `json:"failed"`
+	Limited    int64          `json:"rate_limited"`
+}
+
+type CreateRule struct {
+	Name       string         `json:"name" binding:"required"`
+	Conditions []GetCondition `json:"conditions"`
+	Webhooks   []string       `json:"webhooks" binding:"required"`
+	Template   string         `json:"template"`
+	RateLimit  int            `json:"rate_limit"`
+}
+
+// Routes
+var (
+	// General routes for the alert rules
+	alertsRoutes = []Route{
+		NewRoute("", "GET", getRules),
+		NewRoute("", "POST", createRule),
+	}
+
+	// Routes for a rule
+	alertRoutes = []Route{
+		NewRoute("", "GET", getRule),
+		NewRoute("", "PUT", updateRule),
+		NewRoute("", "DELETE", delRule),
+	}
+)
+
+// Routers
+var (
+	// Alerts
+	AlertsRouter = NewRouter("alerts/", alertsRoutes, []Router{AlertRouter})
+	AlertRouter  = NewRouter(":id/", alertRoutes, nil)
+)
+
+func NewRule(r *alerts.Rule) *GetRule {
+	conditions := []GetCondition{}
+	for _, c := range r.Conditions {
+		conditions = append(conditions, GetCondition{
+			Field:    c.Field,
+			Operator: string(c.Operator),
+			Value:    c.Value,
+		})
+	}
+
+	return &GetRule{
+		ID:         r.GetID(),
+		Name:       r.Name,
+		Conditions: conditions,
+		Webhooks:   r.Webhooks,
+		Template:   r.Template,
+		RateLimit:  r.RateLimit,
+		Matched:    r.GetMatched(),
+		Sent:       r.GetSent(),
+		Failed:     r.GetFailed(),
+		Limited:    r.GetLimited(),
+	}
+}
+
+// Create a rule from the input of a request
+func parseRule(input CreateRule) (r *alerts.Rule, err error) {
+	conditions := []*alerts.Condition{}
+	for _, c := range input.Conditions {
+		condition, err := alerts.NewCondition(c.Field, alerts.Operator(c.Operator), c.Value)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	return alerts.NewRule(input.Name, conditions, input.Webhooks, input.Template, input.RateLimit)
+}
+
+// GET the alert rules
+func getRules(ctx *gin.Context) {
+	casted := []GetRule{}
+
+	for _, r := range alerts.Alerts.GetRules() {
+		casted = append(casted, *NewRule(r))
+	}
+
+	ctx.JSON(http.StatusOK, casted)
+}
+
+// POST a new alert rule
+func createRule(ctx *gin.Context) {
+	var input CreateRule
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, err := parseRule(input)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, err = alerts.Alerts.AddRule(r)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewRule(r))
+}
+
+func getRule(ctx *gin.Context) {
+	r, err := alerts.Alerts.GetRule(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewRule(r))
+}
+
+// PUT a rule, replacing the previous one
+func updateRule(ctx *gin.Context) {
+	var input CreateRule
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, err := parseRule(input)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, err = alerts.Alerts.UpdateRule(ctx.Param("id"), r)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, NewRule(r))
+}
+
+// DELETE a rule
+func delRule(ctx *gin.Context) {
+	err := alerts.Alerts.DeleteRule(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "Rule deleted"})
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riotpot/pkg/artifacts"
+)
+
+// Routes
+var (
+	// General routes for the artifacts
+	artifactsRoutes = []Route{
+		NewRoute("", "GET", getArtifacts),
+	}
+
+	// Routes for an artifact
+	artifactRoutes = []Route{
+		NewRoute("", "GET", getArtifact),
+		NewRoute("/download", "GET", downloadArtifact),
+	}
+)
+
+// Routers
+var (
+	// Artifacts
+	ArtifactsRouter = NewRouter("artifacts/", artifactsRoutes, []Router{ArtifactRouter})
+	ArtifactRouter  = NewRouter(":sha256/", artifactRoutes, nil)
+)
+
+var sha256Regex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Returns the SHA-256 in the path, or an error if it is not valid
+func getSHA256(ctx *gin.Context) (sha string, err error) {
+	sha = ctx.Param("sha256")
+	if !sha256Regex.MatchString(sha) {
+		err = fmt.Errorf("invalid sha256, expected 64 lowercase hexadecimal characters")
+	}
+	return
+}
+
+// GET the artifacts, the last seen first
+func getArtifacts(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, artifacts.Artifacts.GetArtifacts())
+}
+
+// GET the metadata of an artifact
+func getArtifact(ctx *gin.Context) {
+	sha, err := getSHA256(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	a, err := artifacts.Artifacts.GetArtifact(sha)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, a)
+}
+
+// GET the content of an artifact. The file is always sent as an attachment
+// named by its SHA-256, it must never be run or rendered by the browser
+func downloadArtifact(ctx *gin.Context) {
+	sha, err := getSHA256(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	f, a, err := artifacts.Artifacts.Open(sha)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sha))
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.DataFromReader(http.StatusOK, a.Size, "application/octet-stream", f, nil)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riotpot/pkg/attackers"
+	"github.com/riotpot/pkg/sessions"
+)
+
+// Routes
+var (
+	// General routes for the attackers
+	attackersRoutes = []Route{
+		NewRoute("", "GET", getAttackers),
+	}
+
+	// Routes for an attacker
+	attackerRoutes = []Route{
+		NewRoute("", "GET", getAttacker),
+	}
+)
+
+// Routers
+var (
+	// Attackers
+	AttackersRouter = NewRouter("attackers/", attackersRoutes, []Router{AttackerRouter})
+	AttackerRouter  = NewRouter(":id/", attackerRoutes, nil)
+)
+
+// Returns the grouping and the sessions requested in the query
+func attackerSessions(ctx *gin.Context) (by attackers.GroupBy, ss []sessions.Session, ok bool) {
+	by, err := attackers.ParseGroupBy(ctx.Query("group"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var since time.Time
+	if s := ctx.Query("since"); s != "" {
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid since, expected an RFC 3339 time"})
+			return
+		}
+	}
+
+	return by, sessions.Sessions.GetSessions(since), true
+}
+
+// GET the profiles of the attackers, the first seen first.
+// Contains filters to group the sessions, to get only the attackers active
+// since a time, to get only a class of attackers and to leave out the known scanners
+func getAttackers(ctx *gin.Context) {
+	by, ss, ok := attackerSessions(ctx)
+	if !ok {
+		return
+	}
+
+	class := ctx.Query("class")
+	excludeScanners := ctx.Query("exclude_scanners") == "true"
+
+	filtered := []attackers.Profile{}
+	for _, p := range attackers.Profiles(ss, by) {
+		if class != "" && string(p.Class) != class {
+			continue
+		}
+		if excludeScanners && p.Scanner != "" {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+
+	ctx.JSON(http.StatusOK, filtered)
+}
+
+func getAttacker(ctx *gin.Context) {
+	by, ss, ok := attackerSessions(ctx)
+	if !ok {
+		return
+	}
+
+	p, err := attackers.GetProfile(ss, by, ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, p)
+}
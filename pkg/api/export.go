@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riotpot/pkg/export"
+	"github.com/riotpot/pkg/sessions"
+)
+
+// Routes
+var (
+	// Routes to export the sessions
+	exportRoutes = []Route{
+		NewRoute(":format/", "GET", exportSessions),
+	}
+)
+
+// Routers
+var (
+	// Export
+	ExportRouter = NewRouter("export/", exportRoutes, nil)
+)
+
+// GET the sessions as a STIX 2.1 bundle or a MISP event.
+// Contains a filter to export only the sessions active since a time
+func exportSessions(ctx *gin.Context) {
+	format, err := export.ParseFormat(ctx.Param("format"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var since time.Time
+	if s := ctx.Query("since"); s != "" {
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid since, expected an RFC 3339 time"})
+			return
+		}
+	}
+
+	exported, err := export.Export(format, sessions.Sessions.GetSessions(since))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, exported)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riotpot/pkg/fingerprint"
+)
+
+// Structures used to serialize data:
+type GetFingerprintType struct {
+	Type   string `json:"type"`
+	Unique int    `json:"unique"`
+	Total  int    `json:"total"`
+}
+
+// Routes
+var (
+	// General routes for the fingerprints
+	fingerprintsRoutes = []Route{
+		NewRoute("", "GET", getFingerprintTypes),
+		NewRoute(":type/", "GET", getFingerprints),
+	}
+)
+
+// Routers
+var (
+	// Fingerprints
+	FingerprintsRouter = NewRouter("fingerprints/", fingerprintsRoutes, nil)
+)
+
+// GET the types of fingerprints with the number of different values and times seen
+func getFingerprintTypes(ctx *gin.Context) {
+	casted := []GetFingerprintType{}
+
+	for _, t := range fingerprint.Fingerprints.GetTypes() {
+		counts, err := fingerprint.Fingerprints.GetCounts(t)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		total := 0
+		for _, c := range counts {
+			total += c.Count
+		}
+
+		casted = append(casted, GetFingerprintType{
+			Type:   string(t),
+			Unique: len(counts),
+			Total:  total,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, casted)
+}
+
+// GET the fingerprints of a type, the most frequent first.
+// Contains a filter to limit the number of fingerprints
+func getFingerprints(ctx *gin.Context) {
+	counts, err := fingerprint.Fingerprints.GetCounts(fingerprint.Type(ctx.Param("type")))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if limit := ctx.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+
+		if l < len(counts) {
+			counts = counts[:l]
+		}
+	}
+
+	ctx.JSON(http.StatusOK, counts)
+}
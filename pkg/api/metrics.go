@@ -0,0 +1,12 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/riotpot/pkg/metrics"
+)
+
+// GET the metrics of the application in the Prometheus text format.
+// Served in the root of the server, where Prometheus expects it
+func GetMetrics(ctx *gin.Context) {
+	metrics.Metrics.Handler().ServeHTTP(ctx.Writer, ctx.Request)
+}
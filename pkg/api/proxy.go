@@ -1,10 +1,12 @@
 package api
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/riotpot/pkg/certificates"
 	"github.com/riotpot/pkg/proxy"
 	srvs "github.com/riotpot/pkg/service"
 	"github.com/riotpot/pkg/utils"
@@ -13,11 +15,64 @@ import (
 
 // Structures used to serialize data:
 type GetProxy struct {
-	ID      string      `json:"id" binding:"required" gorm:"primary_key"`
-	Port    int         `json:"port"`
-	Network string      `json:"network"`
-	Status  string      `json:"status"`
-	Service *GetService `json:"service"`
+	ID               string          `json:"id" binding:"required" gorm:"primary_key"`
+	Port             int             `json:"port"`
+	Network          string          `json:"network"`
+	Status           string          `json:"status"`
+	Service          *GetService     `json:"service"`
+	Backends         []GetBackend    `json:"backends"`
+	Balancing        string          `json:"balancing"`
+	TrustedUpstreams []string        `json:"trusted_upstreams"`
+	Escalation       *GetEscalation  `json:"escalation"`
+	Scanners         *GetScanners    `json:"scanners"`
+	TLS              *GetTermination `json:"tls"`
+}
+
+type GetTermination struct {
+	Fingerprint string `json:"fingerprint"`
+	Subject     string `json:"subject"`
+	SelfSigned  bool   `json:"self_signed"`
+	Reencrypt   bool   `json:"reencrypt"`
+}
+
+type GetEscalation struct {
+	Sessions  int      `json:"sessions"`
+	Login     bool     `json:"login"`
+	Commands  []string `json:"commands"`
+	Escalated []string `json:"escalated"`
+}
+
+type ChangeProxyEscalation struct {
+	Sessions int      `json:"sessions"`
+	Login    bool     `json:"login"`
+	Commands []string `json:"commands"`
+}
+
+type GetScanners struct {
+	Action  string      `json:"action"`
+	Persona *GetService `json:"persona"`
+}
+
+type ChangeProxyScanners struct {
+	Action string `json:"action" binding:"required"`
+	// Service shown to the scanners by the persona action
+	ServiceID string `json:"service_id"`
+}
+
+type GetBackend struct {
+	Service     *GetService `json:"service"`
+	Weight      int         `json:"weight"`
+	Healthy     bool        `json:"healthy"`
+	Connections int64       `json:"connections"`
+}
+
+type AddProxyBackend struct {
+	ServiceID string `json:"service_id" binding:"required"`
+	Weight    int    `json:"weight"`
+}
+
+type ChangeProxyBalancing struct {
+	Balancing string `json:"balancing" binding:"required"`
 }
 
 type PatchProxy struct {
@@ -30,6 +85,15 @@ type PatchProxy struct {
 type CreateProxy struct {
 	Port    int    `json:"port" binding:"required"`
 	Network string `json:"network" binding:"required"`
+	// Terminate TLS in the proxy, only for TCP proxies
+	TLS *CreateTermination `json:"tls"`
+}
+
+type CreateTermination struct {
+	// PEM encoded certificate and key. A self-signed certificate is generated when empty
+	Certificate string `json:"certificate"`
+	Key         string `json:"key"`
+	Reencrypt   bool   `json:"reencrypt"`
 }
 
 type ChangeProxyStatus struct {
@@ -40,6 +104,10 @@ type ChangeProxyPort struct {
 	Port int `json:"port" binding:"required"`
 }
 
+type ChangeProxyUpstreams struct {
+	TrustedUpstreams []string `json:"trusted_upstreams"`
+}
+
 // Routes
 var (
 
@@ -58,6 +126,14 @@ var (
 		NewRoute("", "DELETE", delProxy),
 		NewRoute("/port", "POST", changeProxyPort),
 		NewRoute("/status", "POST", changeProxyStatus),
+		NewRoute("/upstreams", "POST", changeProxyUpstreams),
+		NewRoute("/balancing", "POST", changeProxyBalancing),
+		NewRoute("/backends", "POST", addProxyBackend),
+		NewRoute("/backends/:service", "DELETE", delProxyBackend),
+		NewRoute("/escalation", "POST", changeProxyEscalation),
+		NewRoute("/escalation", "DELETE", delProxyEscalation),
+		NewRoute("/scanners", "POST", changeProxyScanners),
+		NewRoute("/scanners", "DELETE", delProxyScanners),
 	}
 )
 
@@ -68,15 +144,83 @@ var (
 	ProxyRouter   = NewRouter(":id/", proxyRoutes, []Router{ServiceRouter})
 )
 
+func NewBackend(b *proxy.Backend) *GetBackend {
+	return &GetBackend{
+		Service:     NewService(b.GetService()),
+		Weight:      b.GetWeight(),
+		Healthy:     b.IsHealthy(),
+		Connections: b.GetConnections(),
+	}
+}
+
+// Returns the serialized escalation policy, or nil if there is none
+func NewEscalation(e *proxy.Escalation) *GetEscalation {
+	if e == nil {
+		return nil
+	}
+
+	return &GetEscalation{
+		Sessions:  e.Sessions,
+		Login:     e.Login,
+		Commands:  e.Commands,
+		Escalated: e.GetEscalated(),
+	}
+}
+
+// Returns the serialized policy for the known scanners, or nil if there is none
+func NewScanners(p *proxy.ScannerPolicy) *GetScanners {
+	if p == nil {
+		return nil
+	}
+
+	var persona *GetService
+	if p.GetPersona() != nil {
+		persona = NewService(p.GetPersona())
+	}
+
+	return &GetScanners{
+		Action:  string(p.Action),
+		Persona: persona,
+	}
+}
+
+// Returns the serialized TLS settings of the proxy, or nil if the proxy does not terminate TLS
+func NewTermination(px proxy.Proxy) *GetTermination {
+	tp, ok := px.(proxy.TLSProxy)
+	if !ok || tp.GetTermination() == nil {
+		return nil
+	}
+
+	t := tp.GetTermination()
+	return &GetTermination{
+		Fingerprint: t.GetFingerprint(),
+		Subject:     t.GetSubject(),
+		SelfSigned:  t.IsSelfSigned(),
+		Reencrypt:   t.Reencrypt,
+	}
+}
+
 func NewProxy(px proxy.Proxy) *GetProxy {
 	serv := NewService(px.GetService())
 
+	backends := []GetBackend{}
+	for _, b := range px.GetBackends() {
+		backends = append(backends, *NewBackend(b))
+	}
+
 	return &GetProxy{
-		ID:      px.GetID(),
-		Port:    px.GetPort(),
-		Network: px.GetNetwork().String(),
-		Status:  px.IsRunning().String(),
-		Service: serv,
+		ID:        px.GetID(),
+		Port:      px.GetPort(),
+		Network:   px.GetNetwork().String(),
+		Status:    px.IsRunning().String(),
+		Service:   serv,
+		Backends:  backends,
+		Balancing: px.GetBalancing().String(),
+
+		TrustedUpstreams: px.GetTrustedUpstreams(),
+		Escalation:       NewEscalation(px.GetEscalation()),
+		Scanners:         NewScanners(px.GetScannerPolicy()),
+		TLS:              NewTermination(px),
 	}
 }
 
@@ -114,7 +258,13 @@ func createProxy(ctx *gin.Context) {
 	}
 
 	// Create a new proxy
-	pe, err := proxy.Proxies.CreateProxy(nt, input.Port)
+	var pe proxy.Proxy
+	if input.TLS != nil {
+		pe, err = createTLSProxy(nt, input.Port, input.TLS)
+	} else {
+		pe, err = proxy.Proxies.CreateProxy(nt, input.Port)
+	}
+
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -125,6 +275,29 @@ func createProxy(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, pr)
 }
 
+// Create a proxy that terminates TLS with the certificate given, or a self-signed one
+func createTLSProxy(nt utils.Network, port int, input *CreateTermination) (pe proxy.Proxy, err error) {
+	if nt != utils.TCP {
+		return nil, fmt.Errorf("TLS can only be terminated in TCP proxies")
+	}
+
+	var cert *tls.Certificate
+	if input.Certificate != "" || input.Key != "" {
+		parsed, err := certificates.Parse([]byte(input.Certificate), []byte(input.Key))
+		if err != nil {
+			return nil, err
+		}
+		cert = &parsed
+	}
+
+	termination, err := proxy.NewTermination(cert, input.Reencrypt)
+	if err != nil {
+		return
+	}
+
+	return proxy.Proxies.CreateTLSProxy(port, termination)
+}
+
 func getProxy(ctx *gin.Context) {
 	id := ctx.Param("id")
 	pe, err := proxy.Proxies.GetProxy(id)
@@ -276,3 +449,228 @@ func changeProxyPort(ctx *gin.Context) {
 	pr := NewProxy(pe)
 	ctx.JSON(http.StatusOK, pr)
 }
+
+// POST request to change the upstreams allowed to send PROXY protocol headers to the proxy
+func changeProxyUpstreams(ctx *gin.Context) {
+	// Validate the post request to update the upstreams
+	var input ChangeProxyUpstreams
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get the proxy to update
+	id := ctx.Param("id")
+	pe, err := proxy.Proxies.GetProxy(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Update the upstreams
+	_, err = pe.SetTrustedUpstreams(input.TrustedUpstreams)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Serialize the proxy and send it as a response
+	pr := NewProxy(pe)
+	ctx.JSON(http.StatusOK, pr)
+}
+
+// POST request to change how the proxy chooses the service for each connection
+func changeProxyBalancing(ctx *gin.Context) {
+	var input ChangeProxyBalancing
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get the proxy to update
+	id := ctx.Param("id")
+	pe, err := proxy.Proxies.GetProxy(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	balancing, err := utils.ParseBalancing(input.Balancing)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pe.SetBalancing(balancing)
+
+	// Serialize the proxy and send it as a response
+	pr := NewProxy(pe)
+	ctx.JSON(http.StatusOK, pr)
+}
+
+// POST request to add a service behind the proxy
+func addProxyBackend(ctx *gin.Context) {
+	var input AddProxyBackend
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get the proxy to update
+	id := ctx.Param("id")
+	pe, err := proxy.Proxies.GetProxy(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sv, err := srvs.Services.GetService(input.ServiceID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = pe.AddBackend(sv, input.Weight)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Serialize the proxy and send it as a response
+	pr := NewProxy(pe)
+	ctx.JSON(http.StatusOK, pr)
+}
+
+// DELETE a service from the proxy
+func delProxyBackend(ctx *gin.Context) {
+	// Get the proxy to update
+	id := ctx.Param("id")
+	pe, err := proxy.Proxies.GetProxy(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = pe.RemoveBackend(ctx.Param("service"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Serialize the proxy and send it as a response
+	pr := NewProxy(pe)
+	ctx.JSON(http.StatusOK, pr)
+}
+
+// POST request to set the policy that escalates attackers from low to high interaction services
+func changeProxyEscalation(ctx *gin.Context) {
+	var input ChangeProxyEscalation
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get the proxy to update
+	id := ctx.Param("id")
+	pe, err := proxy.Proxies.GetProxy(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Sessions < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "the number of sessions can not be negative"})
+		return
+	}
+
+	if input.Commands == nil {
+		input.Commands = []string{}
+	}
+
+	pe.SetEscalation(proxy.NewEscalation(input.Sessions, input.Login, input.Commands))
+
+	// Serialize the proxy and send it as a response
+	pr := NewProxy(pe)
+	ctx.JSON(http.StatusOK, pr)
+}
+
+// DELETE the escalation policy of the proxy
+func delProxyEscalation(ctx *gin.Context) {
+	// Get the proxy to update
+	id := ctx.Param("id")
+	pe, err := proxy.Proxies.GetProxy(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pe.SetEscalation(nil)
+
+	// Serialize the proxy and send it as a response
+	pr := NewProxy(pe)
+	ctx.JSON(http.StatusOK, pr)
+}
+
+// POST request to set what the proxy does with the known scanners
+func changeProxyScanners(ctx *gin.Context) {
+	var input ChangeProxyScanners
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get the proxy to update
+	id := ctx.Param("id")
+	pe, err := proxy.Proxies.GetProxy(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	action, err := proxy.ParseScannerAction(input.Action)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var persona srvs.Service
+	if action == proxy.PersonaScanners {
+		persona, err = srvs.Services.GetService(input.ServiceID)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	policy, err := proxy.NewScannerPolicy(action, persona)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err = pe.SetScannerPolicy(policy); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Serialize the proxy and send it as a response
+	pr := NewProxy(pe)
+	ctx.JSON(http.StatusOK, pr)
+}
+
+// DELETE the policy for the known scanners, so they are served like any other client
+func delProxyScanners(ctx *gin.Context) {
+	// Get the proxy to update
+	id := ctx.Param("id")
+	pe, err := proxy.Proxies.GetProxy(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pe.SetScannerPolicy(nil)
+
+	// Serialize the proxy and send it as a response
+	pr := NewProxy(pe)
+	ctx.JSON(http.StatusOK, pr)
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riotpot/pkg/scanners"
+)
+
+// Routes
+var (
+	// General routes for the known scanners
+	scannersRoutes = []Route{
+		NewRoute("", "GET", getScanners),
+	}
+)
+
+// Routers
+var (
+	// Scanners
+	ScannersRouter = NewRouter("scanners/", scannersRoutes, nil)
+)
+
+// GET the known scanners
+func getScanners(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, scanners.Scanners.GetScanners())
+}
@@ -13,27 +13,30 @@ import (
 )
 
 type GetService struct {
-	ID          string `json:"id" binding:"required" gorm:"primary_key"`
-	Name        string `json:"name"`
-	Port        int    `json:"port"`
-	Host        string `json:"host"`
-	Network     string `json:"network"`
-	Locked      bool   `json:"locked"`
-	Interaction string `json:"interaction"`
+	ID            string `json:"id" binding:"required" gorm:"primary_key"`
+	Name          string `json:"name"`
+	Port          int    `json:"port"`
+	Host          string `json:"host"`
+	Network       string `json:"network"`
+	Locked        bool   `json:"locked"`
+	Interaction   string `json:"interaction"`
+	ProxyProtocol string `json:"proxy_protocol"`
 }
 
 type CreateService struct {
-	Name        string `json:"name" binding:"required"`
-	Port        int    `json:"port" binding:"required"`
-	Host        string `json:"host" binding:"required"`
-	Network     string `json:"network" binding:"required"`
-	Interaction string `json:"interaction" binding:"required"`
+	Name          string `json:"name" binding:"required"`
+	Port          int    `json:"port" binding:"required"`
+	Host          string `json:"host" binding:"required"`
+	Network       string `json:"network" binding:"required"`
+	Interaction   string `json:"interaction" binding:"required"`
+	ProxyProtocol string `json:"proxy_protocol,omitempty"`
 }
 
 type PatchService struct {
-	Name string `json:"name" binding:"required"`
-	Port int    `json:"port" binding:"required"`
-	Host string `json:"host" binding:"required"`
+	Name          string `json:"name" binding:"required"`
+	Port          int    `json:"port" binding:"required"`
+	Host          string `json:"host" binding:"required"`
+	ProxyProtocol string `json:"proxy_protocol"`
 }
 
 type ServiceProxy struct {
@@ -76,12 +79,13 @@ var (
 func NewService(serv service.Service) (sv *GetService) {
 	if serv != nil {
 		sv = &GetService{
-			ID:          serv.GetID(),
-			Port:        serv.GetPort(),
-			Name:        serv.GetName(),
-			Host:        serv.GetHost(),
-			Network:     serv.GetNetwork().String(),
-			Interaction: serv.GetInteraction().String(),
+			ID:            serv.GetID(),
+			Port:          serv.GetPort(),
+			Name:          serv.GetName(),
+			Host:          serv.GetHost(),
+			Network:       serv.GetNetwork().String(),
+			Interaction:   serv.GetInteraction().String(),
+			ProxyProtocol: serv.GetProxyProtocol().String(),
 		}
 	}
 	return
@@ -135,11 +139,18 @@ func createService(ctx *gin.Context) {
 		return
 	}
 
+	pp, err := utils.ParseProxyProtocol(input.ProxyProtocol)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	sv, err := service.Services.CreateService(input.Name, input.Port, nt, input.Host, i)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	sv.SetProxyProtocol(pp)
 
 	ret := NewService(sv)
 	ctx.JSON(http.StatusOK, ret)
@@ -165,11 +176,18 @@ func newServiceAndProxy(ctx *gin.Context) {
 		return
 	}
 
+	pp, err := utils.ParseProxyProtocol(input.ProxyProtocol)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	sv, err := service.Services.CreateService(input.Name, input.Port, nt, input.Host, i)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	sv.SetProxyProtocol(pp)
 
 	// Create a new proxy using the parameters from the service
 	pe, err := proxy.Proxies.CreateProxy(nt, input.Port)
@@ -233,6 +251,12 @@ func patchService(ctx *gin.Context) {
 		errors = append(errors, err)
 	}
 
+	// Validate the PROXY protocol version
+	validProxyProtocol, err := utils.ParseProxyProtocol(input.ProxyProtocol)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
 	if ctx.Param("locked") != "" && !service.IsRemovableService(sv) {
 		errors = append(errors, fmt.Errorf("the lock status of this service can not change"))
 	}
@@ -247,6 +271,7 @@ func patchService(ctx *gin.Context) {
 	sv.SetPort(validPort)
 	sv.SetName(validName)
 	sv.SetHost(input.Host)
+	sv.SetProxyProtocol(validProxyProtocol)
 	//sv.SetLocked(input.Locked)
 
 	// Serialize the service and send it as a response
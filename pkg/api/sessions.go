@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riotpot/pkg/sessions"
+)
+
+// Routes
+var (
+	// General routes for the sessions
+	sessionsRoutes = []Route{
+		NewRoute("", "GET", getSessions),
+	}
+
+	// Routes for a session
+	sessionRoutes = []Route{
+		NewRoute("", "GET", getSession),
+		NewRoute("/recording", "GET", getRecording),
+	}
+)
+
+// Routers
+var (
+	// Sessions
+	SessionsRouter = NewRouter("sessions/", sessionsRoutes, []Router{SessionRouter})
+	SessionRouter  = NewRouter(":id/", sessionRoutes, nil)
+)
+
+// GET the sessions, the oldest first.
+// Contains a filter to get only the sessions active since a time
+func getSessions(ctx *gin.Context) {
+	var since time.Time
+	if s := ctx.Query("since"); s != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid since, expected an RFC 3339 time"})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, sessions.Sessions.GetSessions(since))
+}
+
+func getSession(ctx *gin.Context) {
+	s, err := sessions.Sessions.GetSession(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, s)
+}
+
+// GET the terminal of a session in the asciicast v2 format, to replay it in the UI
+func getRecording(ctx *gin.Context) {
+	cast, err := sessions.Recordings.GetRecording(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/x-asciicast", cast)
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riotpot/pkg/signatures"
+)
+
+// Routes
+var (
+	// General routes for the rules of the signatures
+	signaturesRoutes = []Route{
+		NewRoute("", "GET", getSignatures),
+	}
+)
+
+// Routers
+var (
+	// Signatures
+	SignaturesRouter = NewRouter("signatures/", signaturesRoutes, nil)
+)
+
+// GET the rules matched with the payloads and the commands
+func getSignatures(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, signatures.Signatures.GetRules())
+}
@@ -0,0 +1,412 @@
+/*
+This package keeps the files uploaded by the attackers, or referenced in their commands,
+in a local store addressed by their SHA-256
+*/
+package artifacts
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	lr "github.com/riotpot/pkg/logger"
+	"github.com/riotpot/pkg/signatures"
+)
+
+var (
+	// Exportable store of the artifacts captured by the services
+	Artifacts = NewStore()
+)
+
+const (
+	// Default maximum size of an artifact, larger files only keep their metadata
+	DefaultMaxSize = 10 << 20
+	// Default maximum size of all the artifacts kept
+	DefaultMaxTotal = 1 << 30
+
+	// Maximum number of origins kept for an artifact
+	maxOrigins = 100
+)
+
+// How an artifact was captured
+const (
+	FTPMethod      = "ftp"
+	SCPMethod      = "scp"
+	SFTPMethod     = "sftp"
+	HTTPMethod     = "http"
+	DownloadMethod = "download"
+	// Written in a file of the fake shell, e.g., with echo
+	ShellMethod = "shell"
+)
+
+var sha256Regex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Where an artifact comes from
+type Origin struct {
+	// Session and address of the attacker
+	Session string `json:"session,omitempty"`
+	Source  string `json:"source"`
+	Service string `json:"service,omitempty"`
+	// How the file was captured, e.g., "ftp" or "download"
+	Method string `json:"method"`
+	// Name or path of the file given by the attacker
+	Filename string `json:"filename,omitempty"`
+	// URL the file was downloaded from, if any
+	URL  string    `json:"url,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// File captured from the attackers
+type Artifact struct {
+	SHA256      string `json:"sha256"`
+	MD5         string `json:"md5"`
+	SHA1        string `json:"sha1"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	// Whether the content is kept, the files over the limits only keep their metadata
+	Stored bool `json:"stored"`
+
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Times the file was captured
+	Count int `json:"count"`
+	// Where the file comes from, the first ones first
+	Origins []Origin `json:"origins"`
+
+	// Rules of the signatures matched by the content, and the families of the malware
+	Rules    []string `json:"rules"`
+	Families []string `json:"families"`
+}
+
+func (a *Artifact) copy() Artifact {
+	c := *a
+	c.Origins = append([]Origin{}, a.Origins...)
+	c.Rules = append([]string{}, a.Rules...)
+	c.Families = append([]string{}, a.Families...)
+	return c
+}
+
+// Content addressed store of the artifacts.
+// The content and the metadata of each artifact are kept in files named by its SHA-256
+type Store struct {
+	mu sync.RWMutex
+
+	// Directory of the files, the content is not kept when empty
+	dir string
+	// Limits of the size of each artifact and of all of them
+	maxSize  int64
+	maxTotal int64
+	// Size of the artifacts kept
+	total int64
+
+	artifacts map[string]*Artifact
+
+	// Downloader of the URLs in the commands, if enabled
+	downloader *downloader
+}
+
+// Keep the content of the artifacts in a directory, loading the artifacts already in it.
+// Use an empty directory to only keep the metadata in memory
+func (st *Store) SetDirectory(dir string) (err error) {
+	artifacts := make(map[string]*Artifact)
+	var total int64
+
+	if dir != "" {
+		if err = os.MkdirAll(dir, 0o750); err != nil {
+			return
+		}
+
+		// Load the metadata of the artifacts already captured
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(path) != ".json" {
+				return err
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			a := &Artifact{}
+			if err := json.Unmarshal(data, a); err != nil || !sha256Regex.MatchString(a.SHA256) {
+				lr.Log.Warn().Str("path", path).Msg("Invalid artifact metadata, ignored")
+				return nil
+			}
+
+			artifacts[a.SHA256] = a
+			if a.Stored {
+				total += a.Size
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.dir = dir
+	st.artifacts = artifacts
+	st.total = total
+	return
+}
+
+// Set the maximum size of each artifact and of all of them, in bytes
+func (st *Store) SetLimits(maxSize int64, maxTotal int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.maxSize = maxSize
+	st.maxTotal = maxTotal
+}
+
+// Returns the path of a file of the store
+func (st *Store) path(sha string, ext string) string {
+	return filepath.Join(st.dir, sha[:2], sha+ext)
+}
+
+// Read a file until the end and add it to the store. The origin is completed
+// with the session of the attacker, and an event is emitted with the hashes of the file
+func (st *Store) Capture(r io.Reader, origin Origin) (a Artifact, err error) {
+	st.mu.RLock()
+	dir, maxSize := st.dir, st.maxSize
+	st.mu.RUnlock()
+
+	// Services see the proxy as their client, find out who the attacker is
+	ev := events.NewEvent(events.FileEvent, origin.Service, origin.Source)
+	if link, ok := events.Events.Resolve(origin.Source); ok {
+		origin.Session, origin.Source = link.Session, link.Source
+		ev.Session, ev.Proxy, ev.Source, ev.Destination = link.Session, link.Proxy, link.Source, link.Destination
+	} else {
+		ev.Session = origin.Session
+	}
+	if origin.Time.IsZero() {
+		origin.Time = time.Now()
+	}
+
+	// Hash the whole file, keeping the content up to the limit in a temporary file
+	hSHA256, hMD5, hSHA1 := sha256.New(), md5.New(), sha1.New()
+	sniff := &prefixWriter{max: 512}
+	// Content matched with the signatures, up to the limit too
+	scan := &prefixWriter{max: int(maxSize)}
+	writers := []io.Writer{hSHA256, hMD5, hSHA1, sniff, scan}
+
+	var tmp *os.File
+	var content *limitedWriter
+	if dir != "" {
+		if tmp, err = os.CreateTemp(dir, ".capture-*"); err != nil {
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		content = &limitedWriter{w: tmp, max: maxSize}
+		writers = append(writers, content)
+	}
+
+	size, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return
+	}
+
+	sha := hex.EncodeToString(hSHA256.Sum(nil))
+	matches := signatures.Signatures.Scan(scan.buf)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	artifact, ok := st.artifacts[sha]
+	if !ok {
+		artifact = &Artifact{
+			SHA256:      sha,
+			MD5:         hex.EncodeToString(hMD5.Sum(nil)),
+			SHA1:        hex.EncodeToString(hSHA1.Sum(nil)),
+			Size:        size,
+			ContentType: http.DetectContentType(sniff.buf),
+			FirstSeen:   origin.Time,
+			Origins:     []Origin{},
+		}
+		st.artifacts[sha] = artifact
+	}
+
+	// Keep the content if it fits in the limits and the directory did not change meanwhile
+	if !artifact.Stored && content != nil && !content.exceeded && st.dir == dir && st.total+size <= st.maxTotal {
+		dst := st.path(sha, "")
+		if err := os.MkdirAll(filepath.Dir(dst), 0o750); err == nil && os.Rename(tmp.Name(), dst) == nil {
+			artifact.Stored = true
+			st.total += size
+		}
+	}
+
+	// The rules may have changed since the file was first seen
+	artifact.Rules, artifact.Families = []string{}, []string{}
+	for _, m := range matches {
+		artifact.Rules = append(artifact.Rules, m.Rule)
+		if m.Family != "" {
+			artifact.Families = appendUnique(artifact.Families, m.Family)
+		}
+	}
+
+	artifact.Count++
+	artifact.LastSeen = origin.Time
+	if len(artifact.Origins) < maxOrigins {
+		artifact.Origins = append(artifact.Origins, origin)
+	}
+
+	if st.dir != "" {
+		if err := st.saveMetadata(artifact); err != nil {
+			lr.Log.Warn().Err(err).Str("sha256", sha).Msg("Could not save the metadata of the artifact")
+		}
+	}
+
+	ev.With("filename", origin.Filename).With("method", origin.Method).With("size", size).With("stored", artifact.Stored)
+	ev.With("sha256", artifact.SHA256).With("md5", artifact.MD5).With("sha1", artifact.SHA1)
+	if origin.URL != "" {
+		ev.With("url", origin.URL)
+	}
+	signatures.Annotate(ev, matches)
+	events.Events.Emit(ev)
+
+	return artifact.copy(), nil
+}
+
+// Write the metadata of an artifact next to its content
+func (st *Store) saveMetadata(a *Artifact) (err error) {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return
+	}
+
+	path := st.path(a.SHA256, ".json")
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return
+	}
+	return os.WriteFile(path, data, 0o640)
+}
+
+// Get an artifact by SHA-256
+func (st *Store) GetArtifact(sha string) (a Artifact, err error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	artifact, ok := st.artifacts[sha]
+	if !ok {
+		err = fmt.Errorf("artifact not found: %s", sha)
+		return
+	}
+	return artifact.copy(), nil
+}
+
+// Get the artifacts, the last seen first
+func (st *Store) GetArtifacts() (ret []Artifact) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	ret = []Artifact{}
+	for _, a := range st.artifacts {
+		ret = append(ret, a.copy())
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if !ret[i].LastSeen.Equal(ret[j].LastSeen) {
+			return ret[i].LastSeen.After(ret[j].LastSeen)
+		}
+		return ret[i].SHA256 < ret[j].SHA256
+	})
+	return
+}
+
+// Open the content of an artifact
+func (st *Store) Open(sha string) (f *os.File, a Artifact, err error) {
+	if a, err = st.GetArtifact(sha); err != nil {
+		return
+	}
+
+	if !a.Stored {
+		err = fmt.Errorf("content of the artifact not stored: %s", sha)
+		return
+	}
+
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	f, err = os.Open(st.path(sha, ""))
+	return
+}
+
+// Returns the size of the artifacts kept
+func (st *Store) GetTotal() int64 {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return st.total
+}
+
+// Create a store that keeps the metadata in memory until a directory is set
+func NewStore() *Store {
+	return &Store{
+		maxSize:   DefaultMaxSize,
+		maxTotal:  DefaultMaxTotal,
+		artifacts: make(map[string]*Artifact),
+	}
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// Writer that keeps up to a number of bytes and discards the rest
+type limitedWriter struct {
+	w   io.Writer
+	max int64
+	n   int64
+	// Whether more bytes than the limit were written
+	exceeded bool
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if !l.exceeded {
+		if l.n+int64(len(p)) > l.max {
+			l.exceeded = true
+		} else if _, err := l.w.Write(p); err != nil {
+			return 0, err
+		}
+		l.n += int64(len(p))
+	}
+	return len(p), nil
+}
+
+// Writer that keeps the first bytes, e.g., to detect the type of the content
+type prefixWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if rest := w.max - len(w.buf); rest > 0 {
+		if len(p) < rest {
+			rest = len(p)
+		}
+		w.buf = append(w.buf, p[:rest]...)
+	}
+	return len(p), nil
+}
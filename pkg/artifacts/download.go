@@ -0,0 +1,252 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	lr "github.com/riotpot/pkg/logger"
+	"github.com/riotpot/pkg/sessions"
+)
+
+const (
+	// Time to download a file
+	downloadTimeout = 30 * time.Second
+	// Time a URL is not downloaded again
+	downloadInterval = time.Hour
+	// Downloads at the same time
+	maxDownloads = 4
+	// Maximum number of URLs remembered
+	maxDownloaded = 10_000
+)
+
+// Downloads the files referenced in the commands of the attackers, e.g., with wget or curl.
+// Only HTTP and HTTPS URLs of public addresses are downloaded, the honeypot must not
+// be used to reach the internal networks
+type downloader struct {
+	store  *Store
+	client *http.Client
+
+	mu sync.Mutex
+	// Last download of each URL
+	downloaded map[string]time.Time
+	// Slots for the downloads in progress
+	slots chan struct{}
+}
+
+func (d *downloader) GetName() string {
+	return "artifacts"
+}
+
+// Download the URLs in the commands
+func (d *downloader) Send(ev *events.Event) error {
+	if ev.Type != events.CommandEvent {
+		return nil
+	}
+
+	for _, u := range sessions.FindURLs(ev.GetString("command")) {
+		if !d.claim(u) {
+			continue
+		}
+
+		origin := Origin{
+			Session: ev.Session,
+			Source:  ev.Source,
+			Service: ev.Service,
+			Method:  DownloadMethod,
+			URL:     u,
+		}
+
+		select {
+		case d.slots <- struct{}{}:
+			go func() {
+				defer func() { <-d.slots }()
+
+				if err := d.download(origin); err != nil {
+					lr.Log.Warn().Err(err).Str("url", origin.URL).Msg("Could not download the artifact")
+				}
+			}()
+		default:
+			lr.Log.Warn().Str("url", u).Msg("Too many downloads in progress, artifact skipped")
+		}
+	}
+	return nil
+}
+
+// Whether the URL must be downloaded, remembering it if so
+func (d *downloader) claim(u string) bool {
+	lower := strings.ToLower(u)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if last, ok := d.downloaded[u]; ok && time.Since(last) < downloadInterval {
+		return false
+	}
+
+	if len(d.downloaded) >= maxDownloaded {
+		d.downloaded = make(map[string]time.Time)
+	}
+	d.downloaded[u] = time.Now()
+	return true
+}
+
+func (d *downloader) download(origin Origin) (err error) {
+	req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+	if err != nil {
+		return
+	}
+	// Look like the tool the attackers use the most
+	req.Header.Set("User-Agent", "Wget/1.21.2")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	origin.Filename = resp.Request.URL.Path
+	_, err = d.store.Capture(resp.Body, origin)
+	return
+}
+
+// Networks that are not reachable on the Internet, or reach private networks through a
+// translator. See https://www.iana.org/assignments/iana-ipv4-special-registry and
+// https://www.iana.org/assignments/iana-ipv6-special-registry
+var reserved = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.88.99.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"64:ff9b:1::/48",
+	"100::/64",
+	"2001::/23",
+	"2001:db8::/32",
+	"2002::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// Refuse to connect to the addresses that are not public
+func publicOnly(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !IsPublic(net.ParseIP(host)) {
+		return fmt.Errorf("address not allowed: %s", host)
+	}
+	return nil
+}
+
+// Returns whether the IP is reachable on the Internet, and not through a translator
+func IsPublic(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	// The IPv4 addresses are checked as such, even when mapped to IPv6
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range reserved {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func newDownloader(store *Store, allowPrivate bool) *downloader {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = publicOnly
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
+	return &downloader{
+		store: store,
+		client: &http.Client{
+			Timeout:   downloadTimeout,
+			Transport: transport,
+		},
+		downloaded: make(map[string]time.Time),
+		slots:      make(chan struct{}, maxDownloads),
+	}
+}
+
+// Download the files referenced in the commands of the attackers. Disabled by default,
+// the downloads reveal the honeypot to the owners of the files.
+// Private addresses are only allowed for testing
+func (st *Store) SetDownloads(enabled bool, allowPrivate bool) {
+	st.mu.Lock()
+	previous := st.downloader
+	st.downloader = nil
+	if enabled {
+		st.downloader = newDownloader(st, allowPrivate)
+	}
+	current := st.downloader
+	st.mu.Unlock()
+
+	if previous != nil {
+		events.Events.Unregister(previous.GetName())
+	}
+	if current != nil {
+		events.Events.Register(current)
+	}
+}
+
+// Whether the files referenced in the commands are downloaded
+func (st *Store) IsDownloading() bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return st.downloader != nil
+}
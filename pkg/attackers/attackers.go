@@ -0,0 +1,291 @@
+/*
+This package groups the sessions of the attackers in profiles, so the activity of an
+attacker can be followed across the services, e.g., a scan of Modbus followed by a
+brute force of SSH from the same network
+*/
+package attackers
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/riotpot/pkg/geoip"
+	"github.com/riotpot/pkg/sessions"
+)
+
+const (
+	// Failed authentications after which an attacker is a bruteforcer
+	bruteforceAttempts = 3
+	// Maximum number of commands kept in a profile
+	maxCommands = 1_000
+)
+
+// How the sessions are grouped
+type GroupBy string
+
+const (
+	// Sessions from the same IP
+	IPGroup GroupBy = "ip"
+	// Sessions from the same /24 (IPv4) or /64 (IPv6) network
+	SubnetGroup GroupBy = "subnet"
+	// Sessions with the same client fingerprint, or from the same IP when they have none
+	FingerprintGroup GroupBy = "fingerprint"
+)
+
+func ParseGroupBy(group string) (GroupBy, error) {
+	switch g := GroupBy(strings.ToLower(group)); g {
+	case "":
+		return IPGroup, nil
+	case IPGroup, SubnetGroup, FingerprintGroup:
+		return g, nil
+	}
+	return "", fmt.Errorf("invalid group: %s", group)
+}
+
+// Fingerprints used to group the sessions, the most specific first
+var groupFingerprints = []string{"hassh", "ja4", "ja3_hash"}
+
+// What the attacker did
+type Class string
+
+const (
+	// Connected to the services without authenticating
+	Scanner Class = "scanner"
+	// Tried several credentials
+	Bruteforcer Class = "bruteforcer"
+	// Downloaded files or ran commands once logged in
+	Exploiter Class = "exploiter"
+)
+
+// Activity of an attacker in all the services
+type Profile struct {
+	// IP, network or fingerprint that groups the sessions
+	ID string `json:"id"`
+	// IPs of the attacker
+	Addresses []string `json:"addresses"`
+
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// IDs of the sessions, the oldest first
+	Sessions []string `json:"sessions"`
+	// Services touched by the attacker
+	Services []string `json:"services"`
+
+	// Credentials tried, once each
+	Credentials []sessions.Credential `json:"credentials"`
+	// Authentications attempted, including the repeated credentials
+	Attempts int             `json:"attempts"`
+	Commands []string        `json:"commands"`
+	URLs     []string        `json:"urls"`
+	Files    []sessions.File `json:"files"`
+	// Fingerprints of the clients, by type
+	Fingerprints map[string][]string `json:"fingerprints"`
+	// Location of the last session located
+	Location geoip.Location `json:"location"`
+	// Name of the research scanner, if it is a known one
+	Scanner string   `json:"scanner,omitempty"`
+	Tags    []string `json:"tags"`
+
+	Class Class `json:"class"`
+}
+
+// Add a session to the profile
+func (p *Profile) add(s sessions.Session) {
+	if len(p.Sessions) == 0 || s.Start.Before(p.FirstSeen) {
+		p.FirstSeen = s.Start
+	}
+	if s.End.After(p.LastSeen) {
+		p.LastSeen = s.End
+	}
+
+	p.Sessions = append(p.Sessions, s.ID)
+	p.Addresses = appendUnique(p.Addresses, s.GetSourceIP())
+	for _, srv := range s.Services {
+		p.Services = appendUnique(p.Services, srv)
+	}
+
+	for _, c := range s.Credentials {
+		p.Attempts++
+		if !containsCredential(p.Credentials, c) {
+			p.Credentials = append(p.Credentials, c)
+		}
+	}
+
+	for _, command := range s.Commands {
+		if len(p.Commands) >= maxCommands {
+			break
+		}
+		p.Commands = appendUnique(p.Commands, command)
+	}
+	for _, u := range s.URLs {
+		p.URLs = appendUnique(p.URLs, u)
+	}
+	for _, f := range s.Files {
+		if !containsFile(p.Files, f) {
+			p.Files = append(p.Files, f)
+		}
+	}
+
+	for key, value := range s.Fingerprints {
+		p.Fingerprints[key] = appendUnique(p.Fingerprints[key], value)
+	}
+	if !s.Location.IsEmpty() {
+		p.Location = s.Location
+	}
+	if s.Scanner != "" {
+		p.Scanner = s.Scanner
+	}
+	for _, tag := range s.Tags {
+		p.Tags = appendUnique(p.Tags, tag)
+	}
+}
+
+// Classify the attacker by the most dangerous thing it did
+func (p *Profile) classify() Class {
+	if len(p.URLs) > 0 || len(p.Files) > 0 {
+		return Exploiter
+	}
+
+	failed := 0
+	for _, c := range p.Credentials {
+		if c.Success && len(p.Commands) > 0 {
+			return Exploiter
+		}
+		if !c.Success {
+			failed++
+		}
+	}
+
+	if failed >= bruteforceAttempts {
+		return Bruteforcer
+	}
+	return Scanner
+}
+
+// Returns the ID of the profile of a session
+func Key(s sessions.Session, by GroupBy) string {
+	ip := s.GetSourceIP()
+
+	switch by {
+	case SubnetGroup:
+		return Subnet(ip)
+	case FingerprintGroup:
+		for _, t := range groupFingerprints {
+			if value := s.Fingerprints[t]; value != "" {
+				return t + ":" + value
+			}
+		}
+	}
+
+	return ip
+}
+
+// Returns the /24 (IPv4) or /64 (IPv6) network of an IP, or the IP if it is not valid
+func Subnet(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+
+	if ip4 := parsed.To4(); ip4 != nil {
+		n := net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+		return n.String()
+	}
+
+	n := net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	return n.String()
+}
+
+// Group the sessions in profiles, the attackers seen first first
+func Profiles(ss []sessions.Session, by GroupBy) []Profile {
+	profiles := map[string]*Profile{}
+	order := []string{}
+
+	// Add the sessions in order, so the lists of the profiles are in order too
+	sorted := append([]sessions.Session{}, ss...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+
+	for _, s := range sorted {
+		key := Key(s, by)
+		if key == "" {
+			continue
+		}
+
+		p, ok := profiles[key]
+		if !ok {
+			p = &Profile{
+				ID:           key,
+				Addresses:    []string{},
+				Sessions:     []string{},
+				Services:     []string{},
+				Credentials:  []sessions.Credential{},
+				Commands:     []string{},
+				URLs:         []string{},
+				Files:        []sessions.File{},
+				Fingerprints: make(map[string][]string),
+				Tags:         []string{},
+			}
+			profiles[key] = p
+			order = append(order, key)
+		}
+		p.add(s)
+	}
+
+	ret := make([]Profile, 0, len(order))
+	for _, key := range order {
+		p := profiles[key]
+		p.Class = p.classify()
+		ret = append(ret, *p)
+	}
+	return ret
+}
+
+// Get the profile with an ID. The profiles grouped by network can also be found
+// by any of the IPs in the network
+func GetProfile(ss []sessions.Session, by GroupBy, id string) (p Profile, err error) {
+	if by == SubnetGroup && net.ParseIP(id) != nil {
+		id = Subnet(id)
+	}
+
+	for _, profile := range Profiles(ss, by) {
+		if profile.ID == id {
+			return profile, nil
+		}
+	}
+
+	err = fmt.Errorf("attacker not found: %s", id)
+	return
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, item := range list {
+		if item == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+func containsCredential(list []sessions.Credential, c sessions.Credential) bool {
+	for _, item := range list {
+		if item == c {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFile(list []sessions.File, f sessions.File) bool {
+	for _, item := range list {
+		if item == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+/*
+This package generates and loads the TLS certificates used by the proxies and the services
+*/
+package certificates
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+)
+
+var (
+	// Hosts of the self-signed certificates when none is given, as named by default by the distributions
+	defaultHosts = []string{"localhost", "localhost.localdomain", "ubuntu", "debian", "server", "web", "www"}
+	// Organizations filled in by default by the tools generating the certificates, e.g., OpenSSL
+	defaultOrganizations = []string{"", "Internet Widgits Pty Ltd", "Default Company Ltd", "Acme Co"}
+	// Days the certificates are valid, the usual ones of the self-signed certificates
+	validities = []int{365, 730, 825, 3650}
+)
+
+// Create a self-signed certificate on the fly for the hosts. Hosts can be domains or IPs.
+// The subject, serial and validity are random, so the certificates do not tell the honeypot apart
+// Modified from https://gist.github.com/samuel/8b500ddd3f6118d052b5e6bc16bc4c09
+func NewSelfSigned(hosts ...string) (tls.Certificate, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if len(hosts) == 0 {
+		hosts = []string{defaultHosts[random(len(defaultHosts))]}
+	}
+
+	// Random serial numbers of 128 bits, as the ones of the common tools
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	// The certificate was created some time during the last year
+	age := time.Duration(random(365*24*60*60)) * time.Second
+	notBefore := time.Now().Add(-age).Truncate(time.Second)
+	notAfter := notBefore.AddDate(0, 0, validities[random(len(validities))])
+
+	subject := pkix.Name{CommonName: hosts[0]}
+	if organization := defaultOrganizations[random(len(defaultOrganizations))]; organization != "" {
+		subject.Organization = []string{organization}
+	}
+
+	// configure the certificate
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, h := range hosts {
+		ip := net.ParseIP(h)
+		if ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// Returns a random number in [0, n)
+func random(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0
+	}
+	return int(i.Int64())
+}
+
+// Load a certificate from PEM files
+func Load(certFile string, keyFile string) (tls.Certificate, error) {
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// Parse a certificate from PEM encoded data
+func Parse(certPEM []byte, keyPEM []byte) (tls.Certificate, error) {
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// Returns the leaf of the certificate, parsing it if needed
+func Leaf(cert tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
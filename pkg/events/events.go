@@ -0,0 +1,111 @@
+/*
+This package implements the events emitted by the proxies and the services while
+interacting with attackers, and the manager that delivers them to the sinks
+*/
+package events
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Type string
+
+const (
+	// A client connected to a proxy
+	ConnectionEvent Type = "connection"
+	// A client disconnected from a proxy
+	DisconnectionEvent Type = "disconnection"
+	// A client attempted to authenticate in a service
+	AuthEvent Type = "auth"
+	// A client sent a command to a service
+	CommandEvent Type = "command"
+	// A client uploaded a file to a service, or referenced one that was downloaded
+	FileEvent Type = "file"
+	// A command was run in a fake shell, with its arguments expanded
+	ExecEvent Type = "exec"
+	// A service fingerprinted the client of a session, e.g., from its TLS ClientHello
+	FingerprintEvent Type = "fingerprint"
+)
+
+// Something that happened while interacting with an attacker
+type Event struct {
+	ID   string    `json:"id"`
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Session (connection) the event belongs to
+	Session string `json:"session,omitempty"`
+	// Proxy that received the connection
+	Proxy string `json:"proxy,omitempty"`
+	// Name of the service that emitted the event
+	Service string `json:"service,omitempty"`
+	// Address of the attacker
+	Source string `json:"source,omitempty"`
+	// Address the attacker connected to
+	Destination string `json:"destination,omitempty"`
+
+	// Additional information about the event, e.g., the username used to authenticate
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// Labels attached to the event
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Set a field and return the event, to chain calls
+func (ev *Event) With(key string, value interface{}) *Event {
+	ev.Fields[key] = value
+	return ev
+}
+
+// Returns an attribute of the event, e.g., "service", or one of its fields, e.g., "user"
+func (ev *Event) Get(key string) (value interface{}, ok bool) {
+	switch key {
+	case "id":
+		return ev.ID, true
+	case "type":
+		return string(ev.Type), true
+	case "session":
+		return ev.Session, true
+	case "proxy":
+		return ev.Proxy, true
+	case "service":
+		return ev.Service, true
+	case "source":
+		return ev.Source, true
+	case "destination":
+		return ev.Destination, true
+	case "tags":
+		return ev.Tags, true
+	}
+
+	value, ok = ev.Fields[key]
+	return
+}
+
+// Returns a field as a string, or an empty string if it is not set
+func (ev *Event) GetString(key string) string {
+	if value, ok := ev.Fields[key].(string); ok {
+		return value
+	}
+	return ""
+}
+
+// Returns a field as a boolean, or false if it is not set
+func (ev *Event) GetBool(key string) bool {
+	if value, ok := ev.Fields[key].(bool); ok {
+		return value
+	}
+	return false
+}
+
+func NewEvent(t Type, service string, source string) *Event {
+	return &Event{
+		ID:      uuid.New().String(),
+		Type:    t,
+		Time:    time.Now(),
+		Service: service,
+		Source:  source,
+		Fields:  make(map[string]interface{}),
+	}
+}
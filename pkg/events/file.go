@@ -0,0 +1,91 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Sink that appends the events to a file, one JSON object per line
+type FileSink struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (s *FileSink) GetName() string {
+	return "file"
+}
+
+func (s *FileSink) GetPath() string {
+	return s.path
+}
+
+func (s *FileSink) Send(ev *Event) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// Create a sink that appends the events to the file in the path, creating it if needed
+func NewFileSink(path string) (s *FileSink, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return
+	}
+
+	return &FileSink{path: path, file: file}, nil
+}
+
+// Read the events written by a file sink
+func ReadEvents(r io.Reader) (evs []*Event, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		ev := &Event{}
+		if err = json.Unmarshal(scanner.Bytes(), ev); err != nil {
+			return nil, fmt.Errorf("invalid event in line %d: %w", line, err)
+		}
+
+		if ev.Fields == nil {
+			ev.Fields = make(map[string]interface{})
+		}
+		evs = append(evs, ev)
+	}
+
+	return evs, scanner.Err()
+}
+
+// Read the events of a file
+func LoadEvents(path string) (evs []*Event, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	return ReadEvents(file)
+}
@@ -0,0 +1,338 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Vendor and product in the headers of the CEF and LEEF messages
+	vendor  = "RIoTPot"
+	product = "RIoTPot"
+	// Version of the product in the headers
+	productVersion = "2.0"
+)
+
+// Format of the events sent to a SIEM
+type Format string
+
+const (
+	// JSON object with the fields of the event
+	JSONFormat Format = "json"
+	// ArcSight Common Event Format
+	CEFFormat Format = "cef"
+	// IBM QRadar Log Event Extended Format
+	LEEFFormat Format = "leef"
+)
+
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(strings.ToLower(format)); f {
+	case JSONFormat, CEFFormat, LEEFFormat:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid format: %s", format)
+}
+
+// Severity of an event, as defined by syslog
+type Severity int
+
+const (
+	EmergencySeverity Severity = iota
+	AlertSeverity
+	CriticalSeverity
+	ErrorSeverity
+	WarningSeverity
+	NoticeSeverity
+	InformationalSeverity
+	DebugSeverity
+)
+
+// Severity of an event. Successful logins and commands are the most relevant
+func EventSeverity(ev *Event) Severity {
+	switch ev.Type {
+	case AuthEvent:
+		if ev.GetBool("success") {
+			return WarningSeverity
+		}
+		return NoticeSeverity
+	case CommandEvent:
+		return WarningSeverity
+	}
+	return InformationalSeverity
+}
+
+// Severity in the 0 to 10 scale of CEF
+func cefSeverity(s Severity) int {
+	switch s {
+	case EmergencySeverity, AlertSeverity:
+		return 10
+	case CriticalSeverity:
+		return 9
+	case ErrorSeverity:
+		return 8
+	case WarningSeverity:
+		return 7
+	case NoticeSeverity:
+		return 5
+	}
+	return 3
+}
+
+// Human-readable names of the event types, used in the CEF headers
+var eventNames = map[Type]string{
+	ConnectionEvent:    "Connection",
+	DisconnectionEvent: "Disconnection",
+	AuthEvent:          "Authentication attempt",
+	CommandEvent:       "Command",
+	ExecEvent:          "Shell command",
+	FingerprintEvent:   "Fingerprint",
+}
+
+// Default mapping of the fields of the events to the CEF extension keys
+var CEFMapping = map[string]string{
+	"id":               "externalId",
+	"type":             "cat",
+	"time":             "rt",
+	"service":          "app",
+	"source":           "",
+	"source_ip":        "src",
+	"source_port":      "spt",
+	"destination":      "",
+	"destination_ip":   "dst",
+	"destination_port": "dpt",
+	"user":             "suser",
+	"session":          "cs1",
+	"proxy":            "cs2",
+	"password":         "cs3",
+	"command":          "cs4",
+	"tags":             "cs5",
+	"network":          "proto",
+}
+
+// Default mapping of the fields of the events to the LEEF attributes
+var LEEFMapping = map[string]string{
+	"type":             "cat",
+	"time":             "devTime",
+	"source":           "",
+	"source_ip":        "src",
+	"source_port":      "srcPort",
+	"destination":      "",
+	"destination_ip":   "dst",
+	"destination_port": "dstPort",
+	"user":             "usrName",
+	"network":          "proto",
+}
+
+// CEF custom extension keys, which carry a label with the name of the field
+var cefCustomKey = regexp.MustCompile(`^c(s[1-6]|n[1-3])$`)
+
+// Formats the events as the message of a log entry
+type Formatter struct {
+	format Format
+	// Name of the fields in the output, by the name of the field in the event.
+	// Fields mapped to an empty name are left out
+	Mapping map[string]string
+}
+
+func (f *Formatter) GetFormat() Format {
+	return f.format
+}
+
+// Flatten the event into a single set of fields
+func (f *Formatter) fields(ev *Event) map[string]interface{} {
+	fields := map[string]interface{}{
+		"id":          ev.ID,
+		"type":        string(ev.Type),
+		"time":        ev.Time,
+		"session":     ev.Session,
+		"proxy":       ev.Proxy,
+		"service":     ev.Service,
+		"source":      ev.Source,
+		"destination": ev.Destination,
+	}
+
+	for key, value := range ev.Fields {
+		fields[key] = value
+	}
+
+	addHostPort(fields, "source", ev.Source)
+	addHostPort(fields, "destination", ev.Destination)
+
+	if len(ev.Tags) > 0 {
+		fields["tags"] = strings.Join(ev.Tags, ",")
+	}
+
+	ret := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		if value == "" {
+			continue
+		}
+
+		name := key
+		if mapped, ok := f.Mapping[key]; ok {
+			name = mapped
+		}
+
+		if name == "" {
+			continue
+		}
+		ret[name] = value
+
+		// Label the CEF custom fields with the name of the field
+		if f.format == CEFFormat && cefCustomKey.MatchString(name) {
+			ret[name+"Label"] = key
+		}
+	}
+	return ret
+}
+
+// Format the event
+func (f *Formatter) Format(ev *Event) (string, error) {
+	fields := f.fields(ev)
+
+	switch f.format {
+	case CEFFormat:
+		return f.cef(ev, fields), nil
+	case LEEFFormat:
+		return f.leef(ev, fields), nil
+	}
+
+	for key, value := range fields {
+		if t, ok := value.(time.Time); ok {
+			fields[key] = t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	ret, err := json.Marshal(fields)
+	return string(ret), err
+}
+
+// See https://www.microfocus.com/documentation/arcsight/arcsight-smartconnectors/pdfdoc/common-event-format-v25/common-event-format-v25.pdf
+func (f *Formatter) cef(ev *Event, fields map[string]interface{}) string {
+	name, ok := eventNames[ev.Type]
+	if !ok {
+		name = string(ev.Type)
+	}
+
+	header := []string{
+		"CEF:0",
+		cefHeader(vendor),
+		cefHeader(product),
+		cefHeader(productVersion),
+		cefHeader(string(ev.Type)),
+		cefHeader(name),
+		strconv.Itoa(cefSeverity(EventSeverity(ev))),
+	}
+
+	extension := make([]string, 0, len(fields))
+	for _, key := range sortedKeys(fields) {
+		value := fields[key]
+		if t, ok := value.(time.Time); ok {
+			value = t.UnixMilli()
+		}
+		extension = append(extension, key+"="+cefValue(formatValue(value)))
+	}
+
+	return strings.Join(header, "|") + "|" + strings.Join(extension, " ")
+}
+
+// See https://www.ibm.com/docs/en/dsm?topic=overview-leef-event-components
+func (f *Formatter) leef(ev *Event, fields map[string]interface{}) string {
+	header := []string{
+		"LEEF:1.0",
+		leefHeader(vendor),
+		leefHeader(product),
+		leefHeader(productVersion),
+		leefHeader(string(ev.Type)),
+	}
+
+	attributes := make([]string, 0, len(fields)+1)
+	attributes = append(attributes, "sev="+strconv.Itoa(cefSeverity(EventSeverity(ev))))
+
+	for _, key := range sortedKeys(fields) {
+		value := fields[key]
+		if t, ok := value.(time.Time); ok {
+			// Default format of the device time
+			value = t.Format("Jan 02 2006 15:04:05")
+		}
+		attributes = append(attributes, key+"="+leefValue(formatValue(value)))
+	}
+
+	return strings.Join(header, "|") + "|" + strings.Join(attributes, "\t")
+}
+
+func NewFormatter(format Format, mapping map[string]string) *Formatter {
+	m := make(map[string]string)
+
+	// Start from the defaults of the format and override them
+	switch format {
+	case CEFFormat:
+		for key, value := range CEFMapping {
+			m[key] = value
+		}
+	case LEEFFormat:
+		for key, value := range LEEFMapping {
+			m[key] = value
+		}
+	}
+
+	for key, value := range mapping {
+		m[key] = value
+	}
+
+	return &Formatter{format: format, Mapping: m}
+}
+
+// Add the host and the port of an address as separate fields
+func addHostPort(fields map[string]interface{}, prefix string, addr string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+
+	fields[prefix+"_ip"] = host
+	if p, err := strconv.Atoi(port); err == nil {
+		fields[prefix+"_port"] = p
+	}
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func sortedKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func cefHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ").Replace(value)
+}
+
+func cefValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
+
+func leefHeader(value string) string {
+	return strings.NewReplacer("|", " ", "\n", " ", "\r", " ").Replace(value)
+}
+
+func leefValue(value string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(value)
+}
@@ -0,0 +1,266 @@
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	lr "github.com/riotpot/pkg/logger"
+	"github.com/riotpot/pkg/metrics"
+)
+
+var (
+	// Exportable events manager, used by the proxies and services to emit events
+	Events = NewEventManager()
+)
+
+// Metrics of the events
+var (
+	eventsEmitted = metrics.Metrics.NewCounter(
+		"riotpot_events_total",
+		"Events emitted by the proxies and the services",
+		"type", "service",
+	)
+	eventsDropped = metrics.Metrics.NewCounter(
+		"riotpot_events_dropped_total",
+		"Events dropped because the queue was full",
+	)
+	authAttempts = metrics.Metrics.NewCounter(
+		"riotpot_auth_attempts_total",
+		"Authentication attempts in the services",
+		"service", "success",
+	)
+	sinkErrors = metrics.Metrics.NewCounter(
+		"riotpot_event_sink_errors_total",
+		"Events that could not be delivered to a sink",
+		"sink",
+	)
+	queueLength = metrics.Metrics.NewGaugeFunc(
+		"riotpot_events_queue_length",
+		"Events waiting to be delivered to the sinks",
+		func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(Events.QueueLength())}}
+		},
+	)
+)
+
+const (
+	// Number of events waiting to be delivered before new events are dropped
+	queueSize = 1024
+)
+
+// Destination of the events, e.g., a log file or a SIEM
+type Sink interface {
+	// Unique name of the sink
+	GetName() string
+	// Deliver an event
+	Send(ev *Event) error
+}
+
+// Adds information to the events before they are delivered, e.g., the location of the source
+type Enricher interface {
+	// Unique name of the enricher
+	GetName() string
+	// Add the information to the event
+	Enrich(ev *Event)
+}
+
+type EventManager interface {
+	// Emit an event to all the sinks
+	Emit(ev *Event)
+
+	// Add an enricher, applied to the events in the order they were added
+	AddEnricher(enricher Enricher)
+
+	// Register a new sink
+	Register(sink Sink) (Sink, error)
+	// Remove a sink by name
+	Unregister(name string) error
+	// Get the registered sinks
+	GetSinks() []Sink
+	// Number of events waiting to be delivered
+	QueueLength() int
+
+	// Relate the address of the proxy side of a connection to a service with the attacker.
+	// Services see the proxy as their client, the link tells them who the attacker is
+	Link(peer string, link Link)
+	// Remove a link once the connection is closed
+	Unlink(peer string)
+	// Get the link of an address
+	Resolve(peer string) (Link, bool)
+}
+
+// Connection from a proxy to a service, made on behalf of an attacker
+type Link struct {
+	Session     string
+	Proxy       string
+	Source      string
+	Destination string
+}
+
+type eventManager struct {
+	EventManager
+
+	// Sinks registered
+	sinks []Sink
+	// Enrichers applied before delivering the events
+	enrichers []Enricher
+	mu        sync.RWMutex
+
+	// Events waiting to be delivered
+	queue chan *Event
+
+	// Links of the connections from the proxies to the services, by local address
+	links sync.Map
+}
+
+func (em *eventManager) Emit(ev *Event) {
+	// Events emitted by the services carry the address of the proxy as the source,
+	// replace it with the attacker
+	if link, ok := em.Resolve(ev.Source); ok {
+		ev.Session = link.Session
+		ev.Proxy = link.Proxy
+		ev.Source = link.Source
+		if ev.Destination == "" {
+			ev.Destination = link.Destination
+		}
+	}
+
+	eventsEmitted.With(string(ev.Type), ev.Service).Inc()
+	if ev.Type == AuthEvent {
+		authAttempts.With(ev.Service, strconv.FormatBool(ev.GetBool("success"))).Inc()
+	}
+
+	select {
+	case em.queue <- ev:
+	default:
+		eventsDropped.With().Inc()
+		lr.Log.Warn().Str("type", string(ev.Type)).Msg("Events queue full, event dropped")
+	}
+}
+
+func (em *eventManager) AddEnricher(enricher Enricher) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	for ind, added := range em.enrichers {
+		if added.GetName() == enricher.GetName() {
+			em.enrichers[ind] = enricher
+			return
+		}
+	}
+
+	em.enrichers = append(em.enrichers, enricher)
+}
+
+func (em *eventManager) Register(sink Sink) (s Sink, err error) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	for _, registered := range em.sinks {
+		if registered.GetName() == sink.GetName() {
+			err = fmt.Errorf("sink already registered: %s", sink.GetName())
+			return
+		}
+	}
+
+	em.sinks = append(em.sinks, sink)
+	s = sink
+	return
+}
+
+func (em *eventManager) Unregister(name string) (err error) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	for ind, sink := range em.sinks {
+		if sink.GetName() == name {
+			em.sinks = append(em.sinks[:ind], em.sinks[ind+1:]...)
+			return
+		}
+	}
+
+	err = fmt.Errorf("sink not found: %s", name)
+	return
+}
+
+func (em *eventManager) GetSinks() []Sink {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	return append([]Sink{}, em.sinks...)
+}
+
+func (em *eventManager) QueueLength() int {
+	return len(em.queue)
+}
+
+func (em *eventManager) Link(peer string, link Link) {
+	em.links.Store(peer, link)
+}
+
+func (em *eventManager) Unlink(peer string) {
+	em.links.Delete(peer)
+}
+
+func (em *eventManager) Resolve(peer string) (link Link, ok bool) {
+	value, ok := em.links.Load(peer)
+	if !ok {
+		return
+	}
+
+	return value.(Link), true
+}
+
+// Deliver the events in the queue to every sink
+func (em *eventManager) dispatch() {
+	for ev := range em.queue {
+		em.mu.RLock()
+		enrichers := append([]Enricher{}, em.enrichers...)
+		em.mu.RUnlock()
+
+		for _, enricher := range enrichers {
+			enricher.Enrich(ev)
+		}
+
+		for _, sink := range em.GetSinks() {
+			if err := sink.Send(ev); err != nil {
+				sinkErrors.With(sink.GetName()).Inc()
+				lr.Log.Warn().Err(err).Str("sink", sink.GetName()).Msg("Could not deliver the event")
+			}
+		}
+	}
+}
+
+// Constructor for the events manager.
+// The manager logs every event by default
+func NewEventManager() *eventManager {
+	em := &eventManager{
+		sinks: []Sink{&LoggerSink{}},
+		queue: make(chan *Event, queueSize),
+	}
+
+	go em.dispatch()
+	return em
+}
+
+// Sink that writes the events in the application log
+type LoggerSink struct{}
+
+func (s *LoggerSink) GetName() string {
+	return "logger"
+}
+
+func (s *LoggerSink) Send(ev *Event) error {
+	lr.Log.Info().
+		Str("id", ev.ID).
+		Str("type", string(ev.Type)).
+		Str("session", ev.Session).
+		Str("service", ev.Service).
+		Str("source", ev.Source).
+		Str("destination", ev.Destination).
+		Interface("fields", ev.Fields).
+		Strs("tags", ev.Tags).
+		Msg("Event")
+	return nil
+}
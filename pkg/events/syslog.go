@@ -0,0 +1,268 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lr "github.com/riotpot/pkg/logger"
+)
+
+const (
+	// Name of the application in the syslog messages
+	appName = "riotpot"
+
+	// Time to connect or write to the syslog server
+	syslogTimeout = 5 * time.Second
+
+	// Number of messages waiting to be sent before new events are dropped
+	syslogBufferSize = 4096
+)
+
+// Syslog facility of the messages
+type Facility int
+
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+func (f Facility) String() string {
+	if f >= 0 && int(f) < len(facilities) {
+		return facilities[f]
+	}
+	return strconv.Itoa(int(f))
+}
+
+// Parse a facility by name, e.g., "local0", or by number
+func ParseFacility(facility string) (Facility, error) {
+	for ind, name := range facilities {
+		if strings.EqualFold(name, facility) {
+			return Facility(ind), nil
+		}
+	}
+
+	f, err := strconv.Atoi(facility)
+	if err != nil || f < 0 || f >= len(facilities) {
+		return 0, fmt.Errorf("invalid facility: %s", facility)
+	}
+	return Facility(f), nil
+}
+
+// Sink that sends the events to a syslog server with RFC 5424 messages. The messages are
+// buffered and sent by a goroutine of the sink, a slow server does not delay the other sinks.
+// See https://www.rfc-editor.org/rfc/rfc5424
+type SyslogSink struct {
+	// Network used to send the messages: udp, tcp or tls
+	network string
+	// Address of the server
+	address string
+	// Configuration of the TLS connections
+	tlsConfig *tls.Config
+
+	Facility  Facility
+	Formatter *Formatter
+
+	hostname string
+	procID   string
+
+	buffer chan string
+	// Cancels the connection in progress when the sink is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func (s *SyslogSink) GetName() string {
+	return "syslog"
+}
+
+func (s *SyslogSink) GetNetwork() string {
+	return s.network
+}
+
+func (s *SyslogSink) GetAddress() string {
+	return s.address
+}
+
+// Buffer the message of the event to be sent
+func (s *SyslogSink) Send(ev *Event) error {
+	msg, err := s.Formatter.Format(ev)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.buffer <- s.message(ev, msg):
+		return nil
+	default:
+		return fmt.Errorf("syslog buffer full, event dropped")
+	}
+}
+
+// Stop sending the messages. The messages buffered are lost
+func (s *SyslogSink) Close() (err error) {
+	s.once.Do(func() {
+		s.cancel()
+		s.wg.Wait()
+	})
+	return
+}
+
+// Send the buffered messages, reconnecting to the server when needed
+func (s *SyslogSink) run() {
+	defer s.wg.Done()
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		var line string
+		select {
+		case line = <-s.buffer:
+		case <-s.ctx.Done():
+			return
+		}
+
+		// Reconnect once if the connection was closed by the server
+		for attempt := 0; attempt < 2; attempt++ {
+			var err error
+			if conn == nil {
+				if conn, err = s.dial(); err != nil {
+					if s.ctx.Err() == nil {
+						lr.Log.Warn().Err(err).Str("server", s.address).Msg("Could not connect to the syslog server")
+					}
+					break
+				}
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+			if _, err = conn.Write(s.frame(line)); err == nil {
+				break
+			}
+
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+// Build the RFC 5424 message:
+// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *SyslogSink) message(ev *Event, msg string) string {
+	priority := int(s.Facility)*8 + int(EventSeverity(ev))
+
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s - %s",
+		priority,
+		ev.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		header(s.hostname, 255),
+		appName,
+		header(s.procID, 128),
+		header(string(ev.Type), 32),
+		msg,
+	)
+}
+
+// Stream transports use octet counting to delimit the messages.
+// See https://www.rfc-editor.org/rfc/rfc6587#section-3.4.1
+func (s *SyslogSink) frame(msg string) []byte {
+	if s.network == "udp" {
+		return []byte(msg)
+	}
+	return []byte(strconv.Itoa(len(msg)) + " " + msg)
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, syslogTimeout)
+	defer cancel()
+
+	dialer := &net.Dialer{}
+	if s.network == "tls" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: s.tlsConfig}
+		return tlsDialer.DialContext(ctx, "tcp", s.address)
+	}
+	return dialer.DialContext(ctx, s.network, s.address)
+}
+
+// Create a sink for a syslog server. The server is given as an URL with the network
+// as the scheme, e.g., "udp://siem:514", "tcp://siem:601" or "tls://siem:6514".
+// The TLS configuration is only used with TLS, and may be nil
+func NewSyslogSink(server string, facility Facility, formatter *Formatter, tlsConfig *tls.Config) (s *SyslogSink, err error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return
+	}
+
+	network := strings.ToLower(u.Scheme)
+	switch network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("invalid syslog network: %s", u.Scheme)
+	}
+
+	if u.Port() == "" {
+		return nil, fmt.Errorf("missing port in the syslog server: %s", server)
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	s = &SyslogSink{
+		network:   network,
+		address:   u.Host,
+		tlsConfig: tlsConfig,
+		Facility:  facility,
+		Formatter: formatter,
+		hostname:  hostname,
+		procID:    strconv.Itoa(os.Getpid()),
+		buffer:    make(chan string, syslogBufferSize),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// Header fields are printable ASCII without spaces, with a maximum length
+func header(value string, length int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+
+	if value == "" {
+		return "-"
+	}
+
+	if len(value) > length {
+		value = value[:length]
+	}
+	return value
+}
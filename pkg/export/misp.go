@@ -0,0 +1,169 @@
+package export
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/riotpot/pkg/sessions"
+)
+
+// MISP event in the JSON format of the MISP API.
+// See https://www.misp-project.org/datamodels/
+type MISPEvent struct {
+	Event mispEvent `json:"Event"`
+}
+
+type mispEvent struct {
+	UUID          string          `json:"uuid"`
+	Info          string          `json:"info"`
+	Date          string          `json:"date"`
+	Timestamp     string          `json:"timestamp"`
+	ThreatLevelID string          `json:"threat_level_id"`
+	Analysis      string          `json:"analysis"`
+	Distribution  string          `json:"distribution"`
+	Tag           []mispTag       `json:"Tag"`
+	Attribute     []mispAttribute `json:"Attribute"`
+	Object        []mispObject    `json:"Object"`
+}
+
+type mispTag struct {
+	Name string `json:"name"`
+}
+
+type mispAttribute struct {
+	UUID           string `json:"uuid"`
+	Type           string `json:"type"`
+	Category       string `json:"category"`
+	Value          string `json:"value"`
+	ToIDS          bool   `json:"to_ids"`
+	Comment        string `json:"comment,omitempty"`
+	ObjectRelation string `json:"object_relation,omitempty"`
+	FirstSeen      string `json:"first_seen,omitempty"`
+	LastSeen       string `json:"last_seen,omitempty"`
+}
+
+type mispObject struct {
+	UUID         string          `json:"uuid"`
+	Name         string          `json:"name"`
+	MetaCategory string          `json:"meta-category"`
+	Comment      string          `json:"comment,omitempty"`
+	Attribute    []mispAttribute `json:"Attribute"`
+}
+
+// MISP attribute types of the fingerprints of the sessions
+var mispFingerprints = map[string]string{
+	"ja3_hash": "ja3-fingerprint-md5",
+	"hassh":    "hassh-md5",
+}
+
+// Builds an event without repeating the attributes shared by several sessions
+type mispBuilder struct {
+	event *mispEvent
+	// Index of the attributes by type and value
+	index map[string]int
+}
+
+// Add an attribute, or extend the time it was seen if it is already in the event
+func (b *mispBuilder) attribute(t string, category string, value string, toIDS bool, comment string, s sessions.Session) {
+	if value == "" {
+		return
+	}
+
+	key := t + "|" + value
+	if ind, ok := b.index[key]; ok {
+		a := &b.event.Attribute[ind]
+		if first := timestamp(s.Start); first < a.FirstSeen {
+			a.FirstSeen = first
+		}
+		if last := timestamp(s.End); last > a.LastSeen {
+			a.LastSeen = last
+		}
+		return
+	}
+
+	b.index[key] = len(b.event.Attribute)
+	b.event.Attribute = append(b.event.Attribute, mispAttribute{
+		UUID:      uuid.NewSHA1(riotpotNamespace, []byte("misp/"+key)).String(),
+		Type:      t,
+		Category:  category,
+		Value:     value,
+		ToIDS:     toIDS,
+		Comment:   comment,
+		FirstSeen: timestamp(s.Start),
+		LastSeen:  timestamp(s.End),
+	})
+}
+
+func (b *mispBuilder) session(s sessions.Session) {
+	src, _ := splitAddress(s.Source)
+	b.attribute("ip-src", "Network activity", src, true, "Attacker", s)
+
+	for _, value := range s.URLs {
+		b.attribute("url", "Payload delivery", value, true, "Found in a command", s)
+	}
+
+	for _, f := range s.Files {
+		b.attribute("md5", "Payload delivery", f.MD5, true, f.Name, s)
+		b.attribute("sha1", "Payload delivery", f.SHA1, true, f.Name, s)
+		b.attribute("sha256", "Payload delivery", f.SHA256, true, f.Name, s)
+	}
+
+	for key, t := range mispFingerprints {
+		b.attribute(t, "Network activity", s.Fingerprints[key], false, "", s)
+	}
+	b.attribute("user-agent", "Network activity", s.Fingerprints["ssh_client_version"], false, "SSH client version", s)
+
+	// The credentials go in an object for each pair
+	for ind, c := range s.Credentials {
+		relation := func(name string, value string) mispAttribute {
+			return mispAttribute{
+				UUID:           uuid.NewSHA1(riotpotNamespace, []byte(fmt.Sprintf("misp/%s/%d/%s", s.ID, ind, name))).String(),
+				Type:           "text",
+				Category:       "Other",
+				Value:          value,
+				ObjectRelation: name,
+			}
+		}
+
+		b.event.Object = append(b.event.Object, mispObject{
+			UUID:         uuid.NewSHA1(riotpotNamespace, []byte(fmt.Sprintf("misp/%s/%d", s.ID, ind))).String(),
+			Name:         "credential",
+			MetaCategory: "misc",
+			Comment:      fmt.Sprintf("Used by %s in %s (success: %t)", src, c.Service, c.Success),
+			Attribute: []mispAttribute{
+				relation("username", c.User),
+				relation("password", c.Password),
+			},
+		})
+	}
+}
+
+// Export the sessions as a MISP event with the indicators of all of them
+func MISP(ss []sessions.Session) *MISPEvent {
+	now := time.Now().UTC()
+
+	b := &mispBuilder{
+		event: &mispEvent{
+			UUID:      uuid.New().String(),
+			Info:      fmt.Sprintf("RIoTPot sessions (%d)", len(ss)),
+			Date:      now.Format("2006-01-02"),
+			Timestamp: strconv.FormatInt(now.Unix(), 10),
+			// Low threat, analysis completed, only for this organisation
+			ThreatLevelID: "3",
+			Analysis:      "2",
+			Distribution:  "0",
+			Tag:           []mispTag{{Name: "riotpot"}, {Name: "honeypot"}},
+			Attribute:     []mispAttribute{},
+			Object:        []mispObject{},
+		},
+		index: make(map[string]int),
+	}
+
+	for _, s := range ss {
+		b.session(s)
+	}
+
+	return &MISPEvent{Event: *b.event}
+}
@@ -0,0 +1,324 @@
+/*
+This package exports the sessions of the attackers in the formats used to share
+threat intelligence: STIX 2.1 bundles and MISP events
+*/
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/riotpot/pkg/sessions"
+)
+
+// Format of the export
+type Format string
+
+const (
+	STIXFormat Format = "stix"
+	MISPFormat Format = "misp"
+)
+
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(strings.ToLower(format)); f {
+	case STIXFormat, MISPFormat:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid export format: %s", format)
+}
+
+// Export the sessions in a format
+func Export(format Format, ss []sessions.Session) (interface{}, error) {
+	switch format {
+	case STIXFormat:
+		return STIX(ss), nil
+	case MISPFormat:
+		return MISP(ss), nil
+	}
+	return nil, fmt.Errorf("invalid export format: %s", format)
+}
+
+var (
+	// Namespace of the identifiers of the STIX Cyber-observable Objects.
+	// See https://docs.oasis-open.org/cti/stix/v2.1/os/stix-v2.1-os.html#_64yvzeku5a5c
+	stixNamespace = uuid.MustParse("00abedb4-aa42-466c-9c01-fed23315a9b7")
+	// Namespace of the identifiers derived from the sessions
+	riotpotNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/honeynet/riotpot"))
+
+	// Identity that creates the objects
+	identityID = "identity--" + uuid.NewSHA1(riotpotNamespace, []byte("identity")).String()
+)
+
+// STIX 2.1 bundle.
+// See https://docs.oasis-open.org/cti/stix/v2.1/stix-v2.1.html
+type Bundle struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []interface{} `json:"objects"`
+}
+
+// Properties common to the STIX Domain Objects
+type sdo struct {
+	Type         string `json:"type"`
+	SpecVersion  string `json:"spec_version"`
+	ID           string `json:"id"`
+	Created      string `json:"created"`
+	Modified     string `json:"modified"`
+	CreatedByRef string `json:"created_by_ref,omitempty"`
+}
+
+type identity struct {
+	sdo
+	Name          string `json:"name"`
+	IdentityClass string `json:"identity_class"`
+}
+
+type observedData struct {
+	sdo
+	FirstObserved  string   `json:"first_observed"`
+	LastObserved   string   `json:"last_observed"`
+	NumberObserved int      `json:"number_observed"`
+	ObjectRefs     []string `json:"object_refs"`
+	Labels         []string `json:"labels,omitempty"`
+}
+
+type note struct {
+	sdo
+	Abstract   string   `json:"abstract"`
+	Content    string   `json:"content"`
+	ObjectRefs []string `json:"object_refs"`
+}
+
+// Properties common to the STIX Cyber-observable Objects
+type sco struct {
+	Type        string `json:"type"`
+	SpecVersion string `json:"spec_version"`
+	ID          string `json:"id"`
+}
+
+type address struct {
+	sco
+	Value string `json:"value"`
+}
+
+type networkTraffic struct {
+	sco
+	SrcRef    string   `json:"src_ref,omitempty"`
+	DstRef    string   `json:"dst_ref,omitempty"`
+	SrcPort   int      `json:"src_port,omitempty"`
+	DstPort   int      `json:"dst_port,omitempty"`
+	Protocols []string `json:"protocols"`
+	Start     string   `json:"start,omitempty"`
+	End       string   `json:"end,omitempty"`
+}
+
+type url struct {
+	sco
+	Value string `json:"value"`
+}
+
+type file struct {
+	sco
+	Name   string            `json:"name,omitempty"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+// Builds a bundle without repeating the observables shared by several sessions
+type stixBuilder struct {
+	objects []interface{}
+	ids     map[string]bool
+}
+
+func (b *stixBuilder) add(id string, object interface{}) string {
+	if !b.ids[id] {
+		b.ids[id] = true
+		b.objects = append(b.objects, object)
+	}
+	return id
+}
+
+// Add an IPv4 or IPv6 address. Returns an empty ID when the value is not an IP
+func (b *stixBuilder) address(value string) string {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return ""
+	}
+
+	t := "ipv4-addr"
+	if ip.To4() == nil {
+		t = "ipv6-addr"
+	}
+
+	id := scoID(t, map[string]interface{}{"value": value})
+	return b.add(id, address{sco: sco{Type: t, SpecVersion: "2.1", ID: id}, Value: value})
+}
+
+func (b *stixBuilder) session(s sessions.Session) {
+	refs := []string{}
+
+	src, srcPort := splitAddress(s.Source)
+	dst, dstPort := splitAddress(s.Destination)
+
+	srcRef := b.address(src)
+	dstRef := b.address(dst)
+	if srcRef != "" {
+		refs = append(refs, srcRef)
+	}
+
+	// Connection of the attacker. The proxies were only TCP before the sessions had a network
+	network := s.Network
+	if network == "" {
+		network = "tcp"
+	}
+	protocols := []string{network}
+	for _, srv := range s.Services {
+		protocols = append(protocols, strings.ToLower(srv))
+	}
+
+	traffic := networkTraffic{
+		SrcRef:    srcRef,
+		DstRef:    dstRef,
+		SrcPort:   srcPort,
+		DstPort:   dstPort,
+		Protocols: protocols,
+		Start:     timestamp(s.Start),
+	}
+	if s.Closed {
+		traffic.End = timestamp(s.End)
+	}
+
+	// Identified by the properties that contribute to the ID, as the rest of the observables
+	properties := map[string]interface{}{"start": traffic.Start, "protocols": protocols}
+	if traffic.End != "" {
+		properties["end"] = traffic.End
+	}
+	if srcRef != "" {
+		properties["src_ref"] = srcRef
+	}
+	if dstRef != "" {
+		properties["dst_ref"] = dstRef
+	}
+	if srcPort != 0 {
+		properties["src_port"] = srcPort
+	}
+	if dstPort != 0 {
+		properties["dst_port"] = dstPort
+	}
+	id := scoID("network-traffic", properties)
+	traffic.sco = sco{Type: "network-traffic", SpecVersion: "2.1", ID: id}
+	refs = append(refs, b.add(traffic.ID, traffic))
+
+	for _, value := range s.URLs {
+		id := scoID("url", map[string]interface{}{"value": value})
+		refs = append(refs, b.add(id, url{sco: sco{Type: "url", SpecVersion: "2.1", ID: id}, Value: value}))
+	}
+
+	for _, f := range s.Files {
+		hashes := map[string]string{}
+		if f.MD5 != "" {
+			hashes["MD5"] = f.MD5
+		}
+		if f.SHA1 != "" {
+			hashes["SHA-1"] = f.SHA1
+		}
+		if f.SHA256 != "" {
+			hashes["SHA-256"] = f.SHA256
+		}
+
+		id := scoID("file", map[string]interface{}{"hashes": hashes, "name": f.Name})
+		refs = append(refs, b.add(id, file{sco: sco{Type: "file", SpecVersion: "2.1", ID: id}, Name: f.Name, Hashes: hashes}))
+	}
+
+	observed := observedData{
+		sdo:            b.sdo("observed-data", s.ID, s.Start, s.End),
+		FirstObserved:  timestamp(s.Start),
+		LastObserved:   timestamp(s.End),
+		NumberObserved: 1,
+		ObjectRefs:     refs,
+		Labels:         s.Tags,
+	}
+	b.add(observed.ID, observed)
+
+	// The credentials are not observables, they go in a note about the session
+	if len(s.Credentials) > 0 {
+		lines := make([]string, 0, len(s.Credentials))
+		for _, c := range s.Credentials {
+			lines = append(lines, fmt.Sprintf("%s: %s / %s (success: %t)", c.Service, c.User, c.Password, c.Success))
+		}
+
+		n := note{
+			sdo:        b.sdo("note", s.ID+"/credentials", s.Start, s.End),
+			Abstract:   "Credentials used by " + src,
+			Content:    strings.Join(lines, "\n"),
+			ObjectRefs: []string{observed.ID},
+		}
+		b.add(n.ID, n)
+	}
+}
+
+// Properties of a domain object derived from a session, so exporting the
+// same session twice gives the same identifiers
+func (b *stixBuilder) sdo(t string, name string, created time.Time, modified time.Time) sdo {
+	return sdo{
+		Type:         t,
+		SpecVersion:  "2.1",
+		ID:           t + "--" + uuid.NewSHA1(riotpotNamespace, []byte(t+"/"+name)).String(),
+		Created:      timestamp(created),
+		Modified:     timestamp(modified),
+		CreatedByRef: identityID,
+	}
+}
+
+// Export the sessions as a STIX 2.1 bundle with the observed data of each session
+func STIX(ss []sessions.Session) *Bundle {
+	b := &stixBuilder{ids: make(map[string]bool)}
+
+	b.add(identityID, identity{
+		sdo: sdo{
+			Type:        "identity",
+			SpecVersion: "2.1",
+			ID:          identityID,
+			Created:     timestamp(time.Unix(0, 0)),
+			Modified:    timestamp(time.Unix(0, 0)),
+		},
+		Name:          "RIoTPot",
+		IdentityClass: "system",
+	})
+
+	for _, s := range ss {
+		b.session(s)
+	}
+
+	return &Bundle{
+		Type:    "bundle",
+		ID:      "bundle--" + uuid.New().String(),
+		Objects: b.objects,
+	}
+}
+
+// Deterministic identifier of an observable from its contributing properties
+func scoID(t string, properties map[string]interface{}) string {
+	// encoding/json sorts the keys of the maps, as required by the canonicalization
+	data, _ := json.Marshal(properties)
+	return t + "--" + uuid.NewSHA1(stixNamespace, data).String()
+}
+
+// Timestamps in UTC with millisecond precision
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func splitAddress(addr string) (host string, port int) {
+	h, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+
+	port, _ = strconv.Atoi(p)
+	return h, port
+}
@@ -0,0 +1,110 @@
+package fingerprint
+
+import (
+	"net"
+	"sync"
+
+	"github.com/riotpot/pkg/events"
+)
+
+// Connection that inspects the first bytes read from a TLS client to get its ClientHello.
+// The bytes are passed through untouched, so the connection can be given to a TLS server
+type TLSConn struct {
+	net.Conn
+
+	mu sync.Mutex
+	// Bytes read until the ClientHello is complete
+	buf []byte
+	// Whether the inspection is finished, with or without a ClientHello
+	done  bool
+	hello *ClientHello
+
+	// Function called once the ClientHello has been read
+	OnHello func(ch *ClientHello)
+}
+
+func (c *TLSConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	if n > 0 {
+		c.inspect(b[:n])
+	}
+	return
+}
+
+// Returns the ClientHello sent by the client, or nil if it has not been read (yet)
+func (c *TLSConn) ClientHello() *ClientHello {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hello
+}
+
+func (c *TLSConn) inspect(data []byte) {
+	c.mu.Lock()
+	if c.done {
+		c.mu.Unlock()
+		return
+	}
+
+	c.buf = append(c.buf, data...)
+	ch, err := ParseClientHello(c.buf)
+	if err == ErrIncomplete && len(c.buf) < maxClientHello {
+		c.mu.Unlock()
+		return
+	}
+
+	// Stop inspecting, whether the message is a ClientHello or not
+	c.done = true
+	c.buf = nil
+	c.hello = ch
+	c.mu.Unlock()
+
+	if ch != nil && c.OnHello != nil {
+		c.OnHello(ch)
+	}
+}
+
+func NewTLSConn(conn net.Conn) *TLSConn {
+	return &TLSConn{Conn: conn}
+}
+
+// Listener that inspects the ClientHello of every connection accepted
+type TLSListener struct {
+	net.Listener
+
+	// Function called with the connection once its ClientHello has been read
+	OnHello func(conn net.Conn, ch *ClientHello)
+}
+
+func (l *TLSListener) Accept() (conn net.Conn, err error) {
+	conn, err = l.Listener.Accept()
+	if err != nil {
+		return
+	}
+
+	tc := NewTLSConn(conn)
+	if l.OnHello != nil {
+		tc.OnHello = func(ch *ClientHello) { l.OnHello(conn, ch) }
+	}
+	return tc, nil
+}
+
+// Wrap the listener to inspect the ClientHello of the connections.
+// Place it below the TLS listener, e.g., `tls.NewListener(NewTLSListener(listener, fn), config)`
+func NewTLSListener(listener net.Listener, onHello func(conn net.Conn, ch *ClientHello)) *TLSListener {
+	return &TLSListener{Listener: listener, OnHello: onHello}
+}
+
+// Add the fingerprints of the ClientHello to the event
+func (ch *ClientHello) Annotate(ev *events.Event) *events.Event {
+	ja3, ja3Hash := ch.JA3()
+
+	ev.With("ja3", ja3).With("ja3_hash", ja3Hash).With("ja4", ch.JA4())
+	if ch.ServerName != "" {
+		ev.With("sni", ch.ServerName)
+	}
+	if len(ch.ALPN) > 0 {
+		ev.With("alpn", ch.ALPN)
+	}
+	return ev
+}
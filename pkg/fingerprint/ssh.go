@@ -0,0 +1,222 @@
+package fingerprint
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/riotpot/pkg/events"
+)
+
+const (
+	// SSH message number of the key exchange initialization
+	msgKexInit = 20
+
+	// Largest amount of data inspected before giving up on the KEXINIT
+	maxSSHInspection = 64 * 1024
+)
+
+var (
+	ErrNotKexInit = fmt.Errorf("not an SSH KEXINIT")
+)
+
+// Algorithms offered by an SSH client to negotiate the connection
+type KexInit struct {
+	KexAlgorithms           []string
+	HostKeyAlgorithms       []string
+	CiphersClientServer     []string
+	CiphersServerClient     []string
+	MACsClientServer        []string
+	MACsServerClient        []string
+	CompressionClientServer []string
+	CompressionServerClient []string
+}
+
+// Returns the HASSH algorithms string and its MD5 hash.
+// See https://github.com/salesforce/hassh
+func (k *KexInit) HASSH() (algorithms string, hash string) {
+	algorithms = strings.Join([]string{
+		strings.Join(k.KexAlgorithms, ","),
+		strings.Join(k.CiphersClientServer, ","),
+		strings.Join(k.MACsClientServer, ","),
+		strings.Join(k.CompressionClientServer, ","),
+	}, ";")
+
+	sum := md5.Sum([]byte(algorithms))
+	return algorithms, hex.EncodeToString(sum[:])
+}
+
+// Add the fingerprints of the key exchange to the event
+func (k *KexInit) Annotate(ev *events.Event) *events.Event {
+	algorithms, hash := k.HASSH()
+
+	return ev.With("hassh", hash).
+		With("hassh_algorithms", algorithms).
+		With("ssh_kex_algorithms", k.KexAlgorithms).
+		With("ssh_host_key_algorithms", k.HostKeyAlgorithms)
+}
+
+// Parse the payload of a KEXINIT message, starting with the message number
+func ParseKexInit(payload []byte) (k *KexInit, err error) {
+	r := &reader{data: payload}
+	if r.uint8() != msgKexInit {
+		return nil, ErrNotKexInit
+	}
+
+	// Cookie
+	r.skip(16)
+
+	k = &KexInit{}
+	lists := []*[]string{
+		&k.KexAlgorithms,
+		&k.HostKeyAlgorithms,
+		&k.CiphersClientServer,
+		&k.CiphersServerClient,
+		&k.MACsClientServer,
+		&k.MACsServerClient,
+		&k.CompressionClientServer,
+		&k.CompressionServerClient,
+	}
+
+	for _, list := range lists {
+		length := r.uint32()
+		if r.err != nil || int(length) > len(r.data) {
+			return nil, ErrNotKexInit
+		}
+
+		value := string(r.bytes(int(length)))
+		if value != "" {
+			*list = strings.Split(value, ",")
+		}
+	}
+
+	if r.err != nil {
+		return nil, ErrNotKexInit
+	}
+	return
+}
+
+// Connection that inspects the first bytes read from an SSH client to get its
+// version string and KEXINIT. The bytes are passed through untouched, so the
+// connection can be given to an SSH server
+type SSHConn struct {
+	net.Conn
+
+	mu sync.Mutex
+	// Bytes read until the KEXINIT is complete
+	buf []byte
+	// Number of bytes inspected
+	inspected int
+	// Whether the inspection is finished, with or without a KEXINIT
+	done    bool
+	version string
+	kex     *KexInit
+}
+
+func (c *SSHConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	if n > 0 {
+		c.inspect(b[:n])
+	}
+	return
+}
+
+// Returns the version string sent by the client, e.g., "SSH-2.0-OpenSSH_8.9"
+func (c *SSHConn) ClientVersion() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.version
+}
+
+// Returns the KEXINIT sent by the client, or nil if it has not been read (yet)
+func (c *SSHConn) KexInit() *KexInit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.kex
+}
+
+// Add the version and the fingerprints of the client to the event
+func (c *SSHConn) Annotate(ev *events.Event) *events.Event {
+	if version := c.ClientVersion(); version != "" {
+		ev.With("ssh_client_version", version)
+	}
+
+	if kex := c.KexInit(); kex != nil {
+		kex.Annotate(ev)
+	}
+	return ev
+}
+
+func (c *SSHConn) inspect(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return
+	}
+
+	c.buf = append(c.buf, data...)
+	c.inspected += len(data)
+
+	// The version line goes first, other lines may be sent before it
+	for c.version == "" {
+		ind := bytes.IndexByte(c.buf, '\n')
+		if ind < 0 {
+			c.giveUp()
+			return
+		}
+
+		line := strings.TrimRight(string(c.buf[:ind]), "\r")
+		c.buf = c.buf[ind+1:]
+
+		if strings.HasPrefix(line, "SSH-") {
+			c.version = line
+		}
+	}
+
+	// Then the first binary packet, which is the KEXINIT in plain text
+	if len(c.buf) < 5 {
+		c.giveUp()
+		return
+	}
+
+	length := int(binary.BigEndian.Uint32(c.buf))
+	if length > maxSSHInspection {
+		c.finish()
+		return
+	}
+
+	if len(c.buf) < 4+length {
+		c.giveUp()
+		return
+	}
+
+	padding := int(c.buf[4])
+	if padding+1 <= length {
+		c.kex, _ = ParseKexInit(c.buf[5 : 4+length-padding])
+	}
+	c.finish()
+}
+
+// Stop the inspection once too much data was read without finding what was expected
+func (c *SSHConn) giveUp() {
+	if c.inspected > maxSSHInspection {
+		c.finish()
+	}
+}
+
+func (c *SSHConn) finish() {
+	c.done = true
+	c.buf = nil
+}
+
+func NewSSHConn(conn net.Conn) *SSHConn {
+	return &SSHConn{Conn: conn}
+}
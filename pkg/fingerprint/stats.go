@@ -0,0 +1,180 @@
+package fingerprint
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+)
+
+var (
+	// Exportable counter of the fingerprints seen in the events
+	Fingerprints = NewCounter(JA3Fingerprint, JA4Fingerprint, HASSHFingerprint, SSHClientFingerprint)
+)
+
+const (
+	// Maximum number of different values counted for each type of fingerprint
+	maxFingerprints = 10_000
+	// Maximum number of sources remembered for each fingerprint
+	maxSources = 1_000
+)
+
+// Type of fingerprint, the name of the event field that holds it
+type Type string
+
+const (
+	// MD5 of the JA3 string of TLS clients
+	JA3Fingerprint Type = "ja3_hash"
+	// JA4 fingerprint of TLS clients
+	JA4Fingerprint Type = "ja4"
+	// Fingerprint of the key exchange of SSH clients
+	HASSHFingerprint Type = "hassh"
+	// Version string of SSH clients, e.g., "SSH-2.0-libssh_0.9.6"
+	SSHClientFingerprint Type = "ssh_client_version"
+)
+
+// Number of times a fingerprint was seen
+type Count struct {
+	Type  Type   `json:"type"`
+	Value string `json:"value"`
+	// Complete string the fingerprint was computed from, if any, e.g., the JA3 string
+	Detail string `json:"detail,omitempty"`
+
+	Count     int       `json:"count"`
+	Sources   int       `json:"sources"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// IPs that sent the fingerprint
+	sources map[string]bool
+}
+
+// Sink that counts the fingerprints attached to the events
+type Counter struct {
+	mu sync.RWMutex
+
+	// Types of fingerprints counted
+	types []Type
+	// Counts by type and value
+	counts map[Type]map[string]*Count
+}
+
+func (c *Counter) GetName() string {
+	return "fingerprints"
+}
+
+// Count the fingerprints of the event
+func (c *Counter) Send(ev *events.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, t := range c.types {
+		value := ev.GetString(string(t))
+		if value == "" {
+			continue
+		}
+
+		counts := c.counts[t]
+		count, ok := counts[value]
+		if !ok {
+			if len(counts) >= maxFingerprints {
+				continue
+			}
+
+			count = &Count{
+				Type:      t,
+				Value:     value,
+				Detail:    detail(ev, t),
+				FirstSeen: ev.Time,
+				sources:   make(map[string]bool),
+			}
+			counts[value] = count
+		}
+
+		count.Count++
+		count.LastSeen = ev.Time
+
+		if len(count.sources) < maxSources {
+			count.sources[host(ev.Source)] = true
+		}
+		count.Sources = len(count.sources)
+	}
+
+	return nil
+}
+
+// Returns the types of fingerprints counted
+func (c *Counter) GetTypes() []Type {
+	return append([]Type{}, c.types...)
+}
+
+// Returns the counts of a type of fingerprint, the most frequent first
+func (c *Counter) GetCounts(t Type) (ret []Count, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	counts, ok := c.counts[t]
+	if !ok {
+		return nil, fmt.Errorf("fingerprint type not found: %s", t)
+	}
+
+	ret = []Count{}
+	for _, count := range counts {
+		ret = append(ret, *count)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Count != ret[j].Count {
+			return ret[i].Count > ret[j].Count
+		}
+		return ret[i].Value < ret[j].Value
+	})
+	return
+}
+
+// Forget every fingerprint counted
+func (c *Counter) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, t := range c.types {
+		c.counts[t] = make(map[string]*Count)
+	}
+}
+
+// Create a counter for the types of fingerprints
+func NewCounter(types ...Type) *Counter {
+	c := &Counter{
+		types:  types,
+		counts: make(map[Type]map[string]*Count),
+	}
+	c.Reset()
+	return c
+}
+
+// Returns the complete string a fingerprint was computed from, when the event has it
+func detail(ev *events.Event, t Type) string {
+	switch t {
+	case JA3Fingerprint:
+		return ev.GetString("ja3")
+	case HASSHFingerprint:
+		return ev.GetString("hassh_algorithms")
+	}
+	return ""
+}
+
+// Returns the IP of an address
+func host(addr string) string {
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return h
+}
+
+func init() {
+	events.Events.Register(Fingerprints)
+}
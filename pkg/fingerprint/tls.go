@@ -0,0 +1,408 @@
+/*
+This package fingerprints the clients of the honeypot from the first messages they send,
+e.g., the TLS ClientHello (JA3, JA4) or the SSH key exchange (HASSH)
+*/
+package fingerprint
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TLS record content type of the handshake messages
+	recordHandshake = 0x16
+	// Handshake message type of the ClientHello
+	handshakeClientHello = 0x01
+
+	// Extensions used by the fingerprints
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+
+	// Largest ClientHello accepted, bigger messages are not fingerprinted
+	maxClientHello = 1 << 16
+)
+
+var (
+	ErrNotClientHello = fmt.Errorf("not a TLS ClientHello")
+	ErrIncomplete     = fmt.Errorf("incomplete TLS ClientHello")
+)
+
+// Fields of a TLS ClientHello used to fingerprint the client
+type ClientHello struct {
+	// Version in the ClientHello, not in the supported versions extension
+	Version             uint16
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedGroups     []uint16
+	ECPointFormats      []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+	ALPN                []string
+	ServerName          string
+}
+
+// Returns the JA3 string and its MD5 hash.
+// GREASE values are ignored, as described in https://github.com/salesforce/ja3
+func (ch *ClientHello) JA3() (ja3 string, hash string) {
+	formats := make([]uint16, len(ch.ECPointFormats))
+	for ind, f := range ch.ECPointFormats {
+		formats[ind] = uint16(f)
+	}
+
+	ja3 = strings.Join([]string{
+		strconv.Itoa(int(ch.Version)),
+		joinDecimal(withoutGrease(ch.CipherSuites)),
+		joinDecimal(withoutGrease(ch.Extensions)),
+		joinDecimal(withoutGrease(ch.SupportedGroups)),
+		joinDecimal(formats),
+	}, ",")
+
+	sum := md5.Sum([]byte(ja3))
+	return ja3, hex.EncodeToString(sum[:])
+}
+
+// Returns the JA4 fingerprint of a ClientHello received over TCP.
+// See https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4.md
+func (ch *ClientHello) JA4() string {
+	ciphers := withoutGrease(ch.CipherSuites)
+	extensions := withoutGrease(ch.Extensions)
+
+	sni := "i"
+	if ch.ServerName != "" {
+		sni = "d"
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s",
+		ch.tlsVersion(),
+		sni,
+		count(ciphers),
+		count(extensions),
+		ch.alpnCode(),
+	)
+
+	// Ciphers and extensions are sorted, the server name and ALPN extensions are not hashed
+	sortedCiphers := append([]uint16{}, ciphers...)
+	sort.Slice(sortedCiphers, func(i, j int) bool { return sortedCiphers[i] < sortedCiphers[j] })
+
+	sortedExtensions := []uint16{}
+	for _, ext := range extensions {
+		if ext != extServerName && ext != extALPN {
+			sortedExtensions = append(sortedExtensions, ext)
+		}
+	}
+	sort.Slice(sortedExtensions, func(i, j int) bool { return sortedExtensions[i] < sortedExtensions[j] })
+
+	b := truncatedHash(joinHex(sortedCiphers))
+
+	c := joinHex(sortedExtensions)
+	if algorithms := withoutGrease(ch.SignatureAlgorithms); len(algorithms) > 0 {
+		c += "_" + joinHex(algorithms)
+	}
+	if len(sortedExtensions) == 0 {
+		c = ""
+	}
+
+	return a + "_" + b + "_" + truncatedHash(c)
+}
+
+// Returns the highest version offered, as used by JA4
+func (ch *ClientHello) tlsVersion() string {
+	version := ch.Version
+	for _, v := range withoutGrease(ch.SupportedVersions) {
+		if v > version {
+			version = v
+		}
+	}
+
+	switch version {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	default:
+		return "00"
+	}
+}
+
+// Returns the first and last characters of the first ALPN value, as used by JA4
+func (ch *ClientHello) alpnCode() string {
+	if len(ch.ALPN) == 0 || len(ch.ALPN[0]) == 0 {
+		return "00"
+	}
+
+	alpn := ch.ALPN[0]
+	first, last := alpn[0], alpn[len(alpn)-1]
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		h := hex.EncodeToString([]byte(alpn))
+		return string(h[0]) + string(h[len(h)-1])
+	}
+
+	return string(first) + string(last)
+}
+
+// Parse the ClientHello from the first bytes sent by the client.
+// The message can be split in several TLS records.
+// Returns ErrIncomplete when more data is needed to parse the message
+func ParseClientHello(data []byte) (ch *ClientHello, err error) {
+	// Join the handshake records until the message is complete
+	var msg []byte
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, ErrIncomplete
+		}
+
+		if data[0] != recordHandshake {
+			return nil, ErrNotClientHello
+		}
+
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			return nil, ErrIncomplete
+		}
+
+		msg = append(msg, data[5:5+length]...)
+		data = data[5+length:]
+
+		if len(msg) >= 4 && len(msg) >= 4+msgLength(msg) {
+			break
+		}
+	}
+
+	if len(msg) < 4 {
+		return nil, ErrIncomplete
+	}
+
+	if msg[0] != handshakeClientHello {
+		return nil, ErrNotClientHello
+	}
+
+	length := msgLength(msg)
+	if length > maxClientHello {
+		return nil, ErrNotClientHello
+	}
+
+	if len(msg) < 4+length {
+		return nil, ErrIncomplete
+	}
+
+	return parseClientHelloBody(msg[4 : 4+length])
+}
+
+func parseClientHelloBody(body []byte) (ch *ClientHello, err error) {
+	r := &reader{data: body}
+	ch = &ClientHello{}
+
+	ch.Version = r.uint16()
+	// Random
+	r.skip(32)
+	// Session ID
+	r.skip(int(r.uint8()))
+
+	ciphers := r.bytes(int(r.uint16()))
+	for len(ciphers) >= 2 {
+		ch.CipherSuites = append(ch.CipherSuites, binary.BigEndian.Uint16(ciphers))
+		ciphers = ciphers[2:]
+	}
+
+	// Compression methods
+	r.skip(int(r.uint8()))
+
+	if r.err != nil {
+		return nil, ErrNotClientHello
+	}
+
+	// The extensions are optional
+	if r.empty() {
+		return
+	}
+
+	extensions := &reader{data: r.bytes(int(r.uint16()))}
+	for !extensions.empty() && extensions.err == nil {
+		t := extensions.uint16()
+		data := extensions.bytes(int(extensions.uint16()))
+		if extensions.err != nil {
+			break
+		}
+
+		ch.Extensions = append(ch.Extensions, t)
+		ch.parseExtension(t, data)
+	}
+
+	if r.err != nil || extensions.err != nil {
+		return nil, ErrNotClientHello
+	}
+
+	return
+}
+
+// Parse the content of the extensions used by the fingerprints
+func (ch *ClientHello) parseExtension(t uint16, data []byte) {
+	r := &reader{data: data}
+
+	switch t {
+	case extServerName:
+		list := &reader{data: r.bytes(int(r.uint16()))}
+		for !list.empty() && list.err == nil {
+			nameType := list.uint8()
+			name := list.bytes(int(list.uint16()))
+			if nameType == 0 && list.err == nil {
+				ch.ServerName = string(name)
+				return
+			}
+		}
+	case extSupportedGroups:
+		ch.SupportedGroups = uint16s(r.bytes(int(r.uint16())))
+	case extECPointFormats:
+		ch.ECPointFormats = append([]uint8{}, r.bytes(int(r.uint8()))...)
+	case extSignatureAlgorithms:
+		ch.SignatureAlgorithms = uint16s(r.bytes(int(r.uint16())))
+	case extSupportedVersions:
+		ch.SupportedVersions = uint16s(r.bytes(int(r.uint8())))
+	case extALPN:
+		list := &reader{data: r.bytes(int(r.uint16()))}
+		for !list.empty() && list.err == nil {
+			proto := list.bytes(int(list.uint8()))
+			if list.err == nil {
+				ch.ALPN = append(ch.ALPN, string(proto))
+			}
+		}
+	}
+}
+
+// Length of a handshake message from its header
+func msgLength(msg []byte) int {
+	return int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+}
+
+// Minimal reader of TLS vectors that remembers the first error
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) empty() bool {
+	return len(r.data) == 0
+}
+
+func (r *reader) bytes(n int) (b []byte) {
+	if r.err != nil {
+		return
+	}
+
+	if n > len(r.data) {
+		r.err = ErrIncomplete
+		return
+	}
+
+	b, r.data = r.data[:n], r.data[n:]
+	return
+}
+
+func (r *reader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *reader) uint8() uint8 {
+	b := r.bytes(1)
+	if len(b) < 1 {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.bytes(2)
+	if len(b) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.bytes(4)
+	if len(b) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// Decode a list of 16 bits integers
+func uint16s(data []byte) (ret []uint16) {
+	for len(data) >= 2 {
+		ret = append(ret, binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+	return
+}
+
+// GREASE values are random values sent by the clients to keep the servers tolerant,
+// they must be ignored by the fingerprints. See RFC 8701
+func isGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGrease(values []uint16) (ret []uint16) {
+	for _, v := range values {
+		if !isGrease(v) {
+			ret = append(ret, v)
+		}
+	}
+	return
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Number of values, up to 99, as used by JA4
+func count(values []uint16) int {
+	if len(values) > 99 {
+		return 99
+	}
+	return len(values)
+}
+
+func joinDecimal(values []uint16) string {
+	parts := make([]string, len(values))
+	for ind, v := range values {
+		parts[ind] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, "-")
+}
+
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for ind, v := range values {
+		parts[ind] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// First 12 characters of the SHA-256 of the value, or zeros when the value is empty
+func truncatedHash(value string) string {
+	if value == "" {
+		return "000000000000"
+	}
+
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:12]
+}
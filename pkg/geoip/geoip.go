@@ -0,0 +1,180 @@
+/*
+This package locates the attackers with local MaxMind DB files (GeoIP2 and GeoLite2),
+so the events can be enriched without network access
+*/
+package geoip
+
+import (
+	"net"
+	"sync"
+
+	"github.com/riotpot/pkg/events"
+)
+
+var (
+	// Exportable locator that enriches the connection events
+	GeoIP = NewLocator()
+)
+
+// Location and network of an IP
+type Location struct {
+	// ISO 3166-1 code of the country, e.g., "DE"
+	Country     string  `json:"country,omitempty"`
+	CountryName string  `json:"country_name,omitempty"`
+	City        string  `json:"city,omitempty"`
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+	// Autonomous system
+	ASN   uint64 `json:"asn,omitempty"`
+	ASOrg string `json:"as_org,omitempty"`
+}
+
+// Whether anything was found about the IP
+func (l Location) IsEmpty() bool {
+	return l == Location{}
+}
+
+// Add the location to the event
+func (l Location) Annotate(ev *events.Event) *events.Event {
+	if l.Country != "" {
+		ev.With("geo_country", l.Country).With("geo_country_name", l.CountryName)
+	}
+	if l.City != "" {
+		ev.With("geo_city", l.City)
+	}
+	if l.Latitude != 0 || l.Longitude != 0 {
+		ev.With("geo_latitude", l.Latitude).With("geo_longitude", l.Longitude)
+	}
+	if l.ASN != 0 {
+		ev.With("asn", l.ASN).With("as_org", l.ASOrg)
+	}
+	return ev
+}
+
+// Read the location added to an event
+func FromEvent(ev *events.Event) (l Location) {
+	l.Country = ev.GetString("geo_country")
+	l.CountryName = ev.GetString("geo_country_name")
+	l.City = ev.GetString("geo_city")
+	l.Latitude = numberOf(ev.Fields["geo_latitude"])
+	l.Longitude = numberOf(ev.Fields["geo_longitude"])
+	l.ASN = uint64(numberOf(ev.Fields["asn"]))
+	l.ASOrg = ev.GetString("as_org")
+	return
+}
+
+// Enricher that adds the location of the source to the connection events
+type Locator struct {
+	mu sync.RWMutex
+
+	// Database with the country or the city of the networks
+	city *Reader
+	// Database with the autonomous system of the networks
+	asn *Reader
+}
+
+func (l *Locator) GetName() string {
+	return "geoip"
+}
+
+// Set the country or city database, e.g., GeoLite2-City.mmdb. Use nil to remove it
+func (l *Locator) SetCityDatabase(r *Reader) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.city = r
+}
+
+// Set the ASN database, e.g., GeoLite2-ASN.mmdb. Use nil to remove it
+func (l *Locator) SetASNDatabase(r *Reader) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.asn = r
+}
+
+// Whether there is a database to locate the IPs
+func (l *Locator) IsEnabled() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.city != nil || l.asn != nil
+}
+
+// Locate an IP in the databases
+func (l *Locator) Locate(ip net.IP) (loc Location) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.city != nil {
+		if record, err := l.city.Lookup(ip); err == nil {
+			m, _ := record.(map[string]interface{})
+
+			country := child(m, "country")
+			loc.Country = stringOf(country["iso_code"])
+			loc.CountryName = stringOf(child(country, "names")["en"])
+			loc.City = stringOf(child(child(m, "city"), "names")["en"])
+
+			location := child(m, "location")
+			loc.Latitude, _ = location["latitude"].(float64)
+			loc.Longitude, _ = location["longitude"].(float64)
+		}
+	}
+
+	if l.asn != nil {
+		if record, err := l.asn.Lookup(ip); err == nil {
+			m, _ := record.(map[string]interface{})
+
+			loc.ASN = uintOf(m["autonomous_system_number"])
+			loc.ASOrg = stringOf(m["autonomous_system_organization"])
+		}
+	}
+
+	return
+}
+
+// Add the location of the source to the connection events
+func (l *Locator) Enrich(ev *events.Event) {
+	if ev.Type != events.ConnectionEvent || !l.IsEnabled() {
+		return
+	}
+
+	host, _, err := net.SplitHostPort(ev.Source)
+	if err != nil {
+		host = ev.Source
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return
+	}
+
+	l.Locate(ip).Annotate(ev)
+}
+
+func NewLocator() *Locator {
+	return &Locator{}
+}
+
+// Returns a number of an event, which is a float when the event was read from JSON
+func numberOf(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case uint64:
+		return float64(v)
+	case int:
+		return float64(v)
+	}
+	return 0
+}
+
+// Returns a nested map of a record, or nil
+func child(m map[string]interface{}, key string) map[string]interface{} {
+	c, _ := m[key].(map[string]interface{})
+	return c
+}
+
+func init() {
+	events.Events.AddEnricher(GeoIP)
+}
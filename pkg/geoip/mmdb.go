@@ -0,0 +1,358 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// Marker that precedes the metadata, at the end of the file
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const (
+	// Size of the separator between the search tree and the data section
+	dataSeparator = 16
+
+	// Maximum nesting of the maps and arrays, a corrupt database could nest them forever
+	maxDepth = 512
+)
+
+// Types of the values in the data section
+const (
+	extendedType = iota
+	pointerType
+	stringType
+	doubleType
+	bytesType
+	uint16Type
+	uint32Type
+	mapType
+	int32Type
+	uint64Type
+	uint128Type
+	arrayType
+	containerType
+	endMarkerType
+	booleanType
+	floatType
+)
+
+// Description of the database
+type Metadata struct {
+	DatabaseType string
+	Description  string
+	IPVersion    int
+	NodeCount    int
+	RecordSize   int
+	BuildEpoch   uint64
+}
+
+// Reader of the MaxMind DB format, used by the GeoIP2 and GeoLite2 databases.
+// See https://maxmind.github.io/MaxMind-DB/
+type Reader struct {
+	Metadata Metadata
+
+	buf []byte
+	// Start of the data section
+	data int
+	// Node of the IPv4 addresses in IPv6 trees
+	ipv4Start int
+}
+
+// Returns the record of the network that contains the IP, or nil when there is none
+func (r *Reader) Lookup(ip net.IP) (record interface{}, err error) {
+	bits := ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+	} else if r.Metadata.IPVersion == 4 {
+		return nil, fmt.Errorf("IPv6 address in an IPv4 database: %s", ip)
+	}
+
+	node := 0
+	if len(bits) == net.IPv4len {
+		node = r.ipv4Start
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.Metadata.NodeCount; i++ {
+		bit := (bits[i/8] >> (7 - uint(i%8))) & 1
+		node = r.record(node, int(bit))
+	}
+
+	switch {
+	case node == r.Metadata.NodeCount:
+		return nil, nil
+	case node < r.Metadata.NodeCount:
+		return nil, fmt.Errorf("invalid search tree")
+	}
+
+	offset := node - r.Metadata.NodeCount - dataSeparator
+	record, _, err = r.decode(r.data+offset, 0)
+	return
+}
+
+// Returns the left (0) or right (1) record of a node
+func (r *Reader) record(node int, side int) int {
+	size := r.Metadata.RecordSize
+	b := r.buf[node*size/4:]
+
+	switch size {
+	case 24:
+		b = b[side*3:]
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	case 28:
+		if side == 0 {
+			return int(b[3]&0xF0)<<20 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		}
+		return int(b[3]&0x0F)<<24 | int(b[4])<<16 | int(b[5])<<8 | int(b[6])
+	default:
+		return int(binary.BigEndian.Uint32(b[side*4:]))
+	}
+}
+
+// Decode the value at an offset of the buffer, nested in as many maps and arrays as the depth.
+// Returns the value and the offset after it
+func (r *Reader) decode(offset int, depth int) (value interface{}, next int, err error) {
+	if offset < 0 || offset >= len(r.buf) {
+		return nil, 0, fmt.Errorf("offset out of the database")
+	}
+	if depth > maxDepth {
+		return nil, 0, fmt.Errorf("values nested too deep")
+	}
+
+	ctrl := r.buf[offset]
+	offset++
+
+	t := int(ctrl >> 5)
+	if t == extendedType {
+		if offset >= len(r.buf) {
+			return nil, 0, fmt.Errorf("truncated type")
+		}
+		t = 7 + int(r.buf[offset])
+		offset++
+	}
+
+	if t == pointerType {
+		ptr, next, err := r.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// A pointer to a pointer is invalid, it could point to itself
+		if target := r.data + ptr; target < len(r.buf) && int(r.buf[target]>>5) == pointerType {
+			return nil, 0, fmt.Errorf("pointer to a pointer")
+		}
+
+		value, _, err = r.decode(r.data+ptr, depth)
+		return value, next, err
+	}
+
+	size := int(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(r.buf) {
+			return nil, 0, fmt.Errorf("truncated size")
+		}
+
+		extra := 0
+		for _, b := range r.buf[offset : offset+n] {
+			extra = extra<<8 | int(b)
+		}
+		offset += n
+
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	// Each entry takes at least a byte, the size can not be larger than the rest of the database
+	if (t == mapType || t == arrayType) && size > len(r.buf)-offset {
+		return nil, 0, fmt.Errorf("truncated container")
+	}
+
+	switch t {
+	case mapType:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			var key, val interface{}
+			if key, offset, err = r.decode(offset, depth+1); err != nil {
+				return
+			}
+			if val, offset, err = r.decode(offset, depth+1); err != nil {
+				return
+			}
+
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("invalid map key")
+			}
+			m[k] = val
+		}
+		return m, offset, nil
+	case arrayType:
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			var val interface{}
+			if val, offset, err = r.decode(offset, depth+1); err != nil {
+				return
+			}
+			a = append(a, val)
+		}
+		return a, offset, nil
+	case booleanType:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(r.buf) {
+		return nil, 0, fmt.Errorf("truncated value")
+	}
+	b := r.buf[offset : offset+size]
+	next = offset + size
+
+	switch t {
+	case stringType:
+		return string(b), next, nil
+	case bytesType:
+		return append([]byte{}, b...), next, nil
+	case doubleType:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case floatType:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case uint16Type, uint32Type, uint64Type:
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, next, nil
+	case int32Type:
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		// Shorter values are padded with zeroes
+		if size == 4 {
+			return int64(int32(v)), next, nil
+		}
+		return int64(v), next, nil
+	case uint128Type:
+		return new(big.Int).SetBytes(b), next, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown data type: %d", t)
+}
+
+// Decode a pointer to the data section. Returns the pointer and the offset after it
+func (r *Reader) pointer(ctrl byte, offset int) (ptr int, next int, err error) {
+	size := int((ctrl>>3)&0x3) + 1
+	if offset+size > len(r.buf) {
+		return 0, 0, fmt.Errorf("truncated pointer")
+	}
+
+	b := r.buf[offset : offset+size]
+	next = offset + size
+
+	switch size {
+	case 1:
+		ptr = int(ctrl&0x7)<<8 | int(b[0])
+	case 2:
+		ptr = (int(ctrl&0x7)<<16 | int(b[0])<<8 | int(b[1])) + 2048
+	case 3:
+		ptr = (int(ctrl&0x7)<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])) + 526336
+	default:
+		ptr = int(binary.BigEndian.Uint32(b))
+	}
+	return
+}
+
+// Parse a database
+func NewReader(buf []byte) (r *Reader, err error) {
+	ind := bytes.LastIndex(buf, metadataMarker)
+	if ind < 0 {
+		return nil, fmt.Errorf("invalid MaxMind DB: metadata not found")
+	}
+
+	r = &Reader{buf: buf}
+
+	// The metadata is decoded as if it was in the data section
+	r.data = ind + len(metadataMarker)
+	value, _, err := r.decode(r.data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: %w", err)
+	}
+
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata")
+	}
+
+	r.Metadata = Metadata{
+		DatabaseType: stringOf(m["database_type"]),
+		IPVersion:    int(uintOf(m["ip_version"])),
+		NodeCount:    int(uintOf(m["node_count"])),
+		RecordSize:   int(uintOf(m["record_size"])),
+		BuildEpoch:   uintOf(m["build_epoch"]),
+	}
+	if description, ok := m["description"].(map[string]interface{}); ok {
+		r.Metadata.Description = stringOf(description["en"])
+	}
+
+	switch r.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size: %d", r.Metadata.RecordSize)
+	}
+
+	// The node count is checked first so the size of the tree does not overflow
+	treeSize := r.Metadata.NodeCount * r.Metadata.RecordSize / 4
+	if r.Metadata.NodeCount < 0 || r.Metadata.NodeCount > ind || treeSize+dataSeparator > ind {
+		return nil, fmt.Errorf("invalid MaxMind DB: search tree out of the file")
+	}
+	r.data = treeSize + dataSeparator
+
+	// IPv4 addresses are in the ::/96 subtree of IPv6 databases
+	if r.Metadata.IPVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.Metadata.NodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+
+	return r, nil
+}
+
+// Open a database file
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(buf)
+}
+
+func stringOf(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+func uintOf(value interface{}) uint64 {
+	switch v := value.(type) {
+	case uint64:
+		return v
+	case int64:
+		return uint64(v)
+	}
+	return 0
+}
@@ -0,0 +1,233 @@
+/*
+This package implements a client of the HPFeeds protocol, used by the honeynet community
+to share the data of the honeypots, and a sink that publishes the events with it.
+See https://hpfeeds.org/wire-protocol
+*/
+package hpfeeds
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Operation codes of the messages
+const (
+	opError     = 0
+	opInfo      = 1
+	opAuth      = 2
+	opPublish   = 3
+	opSubscribe = 4
+)
+
+const (
+	// Size of the header of the messages: length and operation code
+	headerSize = 5
+	// Largest message accepted from the broker
+	maxMessageSize = 1024 * 1024
+
+	// Time to connect and authenticate with the broker
+	connectTimeout = 10 * time.Second
+)
+
+var (
+	// Time to write a message to the broker, a broker that stops reading is given up
+	WriteTimeout = 10 * time.Second
+)
+
+// Message of the protocol
+type Message struct {
+	Op      uint8
+	Payload []byte
+}
+
+// Read a message
+func ReadMessage(r io.Reader) (msg *Message, err error) {
+	header := make([]byte, headerSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length < headerSize || length > maxMessageSize {
+		return nil, fmt.Errorf("invalid message length: %d", length)
+	}
+
+	msg = &Message{Op: header[4], Payload: make([]byte, length-headerSize)}
+	_, err = io.ReadFull(r, msg.Payload)
+	return
+}
+
+// Write a message
+func WriteMessage(w io.Writer, op uint8, payload []byte) (err error) {
+	buf := make([]byte, headerSize, headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(headerSize+len(payload)))
+	buf[4] = op
+
+	_, err = w.Write(append(buf, payload...))
+	return
+}
+
+// Encode a string prefixed with its length in a byte
+func putString(buf []byte, value string) []byte {
+	return append(append(buf, byte(len(value))), value...)
+}
+
+// Decode a string prefixed with its length in a byte
+func getString(buf []byte) (value string, rest []byte, err error) {
+	if len(buf) < 1 || len(buf) < 1+int(buf[0]) {
+		return "", nil, fmt.Errorf("truncated string")
+	}
+	return string(buf[1 : 1+buf[0]]), buf[1+buf[0]:], nil
+}
+
+// Returns the hash proving the knowledge of the secret for the nonce of the broker
+func AuthHash(nonce []byte, secret string) []byte {
+	sum := sha1.Sum(append(append([]byte{}, nonce...), secret...))
+	return sum[:]
+}
+
+// Parse the payload of an info message, with the name of the broker and the nonce
+func ParseInfo(payload []byte) (name string, nonce []byte, err error) {
+	name, nonce, err = getString(payload)
+	if err == nil && len(nonce) == 0 {
+		err = fmt.Errorf("missing nonce")
+	}
+	return
+}
+
+// Parse the payload of an auth message, with the identity and the hash of the secret
+func ParseAuth(payload []byte) (ident string, hash []byte, err error) {
+	return getString(payload)
+}
+
+// Parse the payload of a publish message
+func ParsePublish(payload []byte) (ident string, channel string, data []byte, err error) {
+	ident, rest, err := getString(payload)
+	if err != nil {
+		return
+	}
+
+	channel, data, err = getString(rest)
+	return
+}
+
+// Connection to an HPFeeds broker
+type Client struct {
+	// Identity and secret to authenticate in the broker
+	ident  string
+	secret string
+
+	mu   sync.Mutex
+	conn net.Conn
+	// Name of the broker
+	broker string
+	// Error sent by the broker or the error reading from it
+	err error
+}
+
+func (c *Client) GetIdent() string {
+	return c.ident
+}
+
+// Name the broker sent when connecting
+func (c *Client) GetBroker() string {
+	return c.broker
+}
+
+// Publish a payload in a channel
+func (c *Client) Publish(channel string, payload []byte) (err error) {
+	if len(channel) > 255 {
+		return fmt.Errorf("channel name too long: %s", channel)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	buf := putString(putString(nil, c.ident), channel)
+
+	c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	if err = WriteMessage(c.conn, opPublish, append(buf, payload...)); err != nil {
+		// Part of the message may have been written, the connection can not be used anymore
+		c.err = err
+		c.conn.Close()
+	}
+	return
+}
+
+// Close the connection to the broker
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Wait for errors sent by the broker. The broker does not answer the publications,
+// so the errors are only seen in the next publication
+func (c *Client) read(r *bufio.Reader) {
+	for {
+		msg, err := ReadMessage(r)
+		if err == nil && msg.Op == opError {
+			err = fmt.Errorf("broker error: %s", msg.Payload)
+		}
+
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// Connect and authenticate with a broker
+func Dial(address string, ident string, secret string) (c *Client, err error) {
+	if len(ident) > 255 {
+		return nil, fmt.Errorf("identity too long: %s", ident)
+	}
+
+	conn, err := net.DialTimeout("tcp", address, connectTimeout)
+	if err != nil {
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(connectTimeout))
+	r := bufio.NewReader(conn)
+
+	// The broker starts by sending its name and a nonce to authenticate
+	msg, err := ReadMessage(r)
+	if err == nil && msg.Op != opInfo {
+		err = fmt.Errorf("expected an info message, got %d", msg.Op)
+	}
+
+	var name string
+	var nonce []byte
+	if err == nil {
+		name, nonce, err = ParseInfo(msg.Payload)
+	}
+
+	if err == nil {
+		payload := append(putString(nil, ident), AuthHash(nonce, secret)...)
+		err = WriteMessage(conn, opAuth, payload)
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	c = &Client{ident: ident, secret: secret, conn: conn, broker: name}
+	go c.read(r)
+	return
+}
@@ -0,0 +1,149 @@
+package hpfeeds
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	lr "github.com/riotpot/pkg/logger"
+)
+
+const (
+	// Number of events waiting to be published before new events are dropped
+	bufferSize = 4096
+
+	// Time waited before reconnecting, doubled on each failure
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Sink that publishes the events in an HPFeeds broker, in a channel for each service,
+// e.g., "riotpot.ssh". The events are buffered while the broker is unavailable
+type Sink struct {
+	address string
+	ident   string
+	secret  string
+	// Prefix of the channels
+	prefix string
+
+	buffer chan *events.Event
+	quit   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func (s *Sink) GetName() string {
+	return "hpfeeds"
+}
+
+func (s *Sink) GetAddress() string {
+	return s.address
+}
+
+// Returns the channel of the events of a service
+func (s *Sink) Channel(service string) string {
+	if service == "" {
+		return s.prefix
+	}
+	return s.prefix + "." + strings.ToLower(strings.ReplaceAll(service, " ", "_"))
+}
+
+// Buffer the event to be published
+func (s *Sink) Send(ev *events.Event) error {
+	select {
+	case s.buffer <- ev:
+		return nil
+	default:
+		return fmt.Errorf("hpfeeds buffer full, event dropped")
+	}
+}
+
+// Stop publishing. The events buffered are lost
+func (s *Sink) Close() {
+	s.once.Do(func() {
+		close(s.quit)
+		s.wg.Wait()
+	})
+}
+
+// Publish the buffered events, reconnecting to the broker when needed
+func (s *Sink) run() {
+	defer s.wg.Done()
+
+	var (
+		client  *Client
+		pending *events.Event
+		backoff = minBackoff
+	)
+
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	for {
+		if pending == nil {
+			select {
+			case pending = <-s.buffer:
+			case <-s.quit:
+				return
+			}
+		}
+
+		if client == nil {
+			var err error
+			client, err = Dial(s.address, s.ident, s.secret)
+			if err != nil {
+				lr.Log.Warn().Err(err).Str("broker", s.address).Msg("Could not connect to the HPFeeds broker")
+
+				select {
+				case <-time.After(backoff):
+				case <-s.quit:
+					return
+				}
+
+				if backoff *= 2; backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+				continue
+			}
+			backoff = minBackoff
+		}
+
+		payload, err := json.Marshal(pending)
+		if err != nil {
+			lr.Log.Warn().Err(err).Msg("Could not encode the event")
+			pending = nil
+			continue
+		}
+
+		// Keep the event to publish it again after reconnecting
+		if err = client.Publish(s.Channel(pending.Service), payload); err != nil {
+			lr.Log.Warn().Err(err).Str("broker", s.address).Msg("Lost the connection to the HPFeeds broker")
+			client.Close()
+			client = nil
+			continue
+		}
+		pending = nil
+	}
+}
+
+// Create a sink that publishes the events in the broker with the identity and the secret given
+func NewSink(address string, ident string, secret string, prefix string) *Sink {
+	s := &Sink{
+		address: address,
+		ident:   ident,
+		secret:  secret,
+		prefix:  prefix,
+		buffer:  make(chan *events.Event, bufferSize),
+		quit:    make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
@@ -0,0 +1,321 @@
+/*
+This package implements the metrics of riotpot, exposed in the Prometheus text format.
+See https://prometheus.io/docs/instrumenting/exposition_formats/
+*/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// Exportable registry with the metrics of the application
+	Metrics = NewRegistry()
+)
+
+// Type of a metric family
+type Kind string
+
+const (
+	CounterKind Kind = "counter"
+	GaugeKind   Kind = "gauge"
+)
+
+// Value of a metric for a set of label values
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// Family of metrics with the same name
+type Collector interface {
+	GetName() string
+	GetHelp() string
+	GetKind() Kind
+	GetLabels() []string
+	// Current samples of the family
+	Collect() []Sample
+}
+
+// Time series of a metric for a set of label values
+type Series struct {
+	mu     sync.Mutex
+	labels []string
+	value  float64
+}
+
+// Add a value to the series
+func (s *Series) Add(v float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.value += v
+}
+
+func (s *Series) Inc() {
+	s.Add(1)
+}
+
+func (s *Series) Dec() {
+	s.Add(-1)
+}
+
+// Set the value of the series. Only meant for gauges
+func (s *Series) Set(v float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.value = v
+}
+
+func (s *Series) Get() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.value
+}
+
+// Metric family with a series for each set of label values
+type Metric struct {
+	name   string
+	help   string
+	kind   Kind
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*Series
+}
+
+func (m *Metric) GetName() string {
+	return m.name
+}
+
+func (m *Metric) GetHelp() string {
+	return m.help
+}
+
+func (m *Metric) GetKind() Kind {
+	return m.kind
+}
+
+func (m *Metric) GetLabels() []string {
+	return m.labels
+}
+
+// Returns the series for the label values, creating it if needed.
+// The values are given in the same order as the labels of the metric
+func (m *Metric) With(values ...string) *Series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s expects %d labels, got %d", m.name, len(m.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &Series{labels: values}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Remove the series of the label values, e.g., when a proxy is deleted
+func (m *Metric) Delete(values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.series, strings.Join(values, "\xff"))
+}
+
+func (m *Metric) Collect() (samples []Sample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.series {
+		samples = append(samples, Sample{Labels: s.labels, Value: s.Get()})
+	}
+	return
+}
+
+// Gauge computed when the metrics are collected, e.g., the length of a queue
+type GaugeFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func() []Sample
+}
+
+func (g *GaugeFunc) GetName() string {
+	return g.name
+}
+
+func (g *GaugeFunc) GetHelp() string {
+	return g.help
+}
+
+func (g *GaugeFunc) GetKind() Kind {
+	return GaugeKind
+}
+
+func (g *GaugeFunc) GetLabels() []string {
+	return g.labels
+}
+
+func (g *GaugeFunc) Collect() []Sample {
+	return g.fn()
+}
+
+// Set of metric families
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+// Register a metric family. Registering a name twice returns the first collector,
+// so packages can declare their metrics without coordinating
+func (r *Registry) Register(c Collector) Collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if registered, ok := r.collectors[c.GetName()]; ok {
+		return registered
+	}
+
+	r.collectors[c.GetName()] = c
+	return c
+}
+
+// Create and register a counter
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Metric {
+	return r.newMetric(name, help, CounterKind, labels)
+}
+
+// Create and register a gauge
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Metric {
+	return r.newMetric(name, help, GaugeKind, labels)
+}
+
+// Create and register a gauge computed on every collection
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() []Sample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labels: labels, fn: fn}
+	if registered, ok := r.Register(g).(*GaugeFunc); ok {
+		return registered
+	}
+	return g
+}
+
+func (r *Registry) newMetric(name string, help string, kind Kind, labels []string) *Metric {
+	m := &Metric{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*Series),
+	}
+
+	if registered, ok := r.Register(m).(*Metric); ok {
+		return registered
+	}
+	return m
+}
+
+// Returns the registered collectors sorted by name
+func (r *Registry) GetCollectors() (collectors []Collector) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].GetName() < collectors[j].GetName()
+	})
+	return
+}
+
+// Write the metrics in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (n int64, err error) {
+	var sb strings.Builder
+
+	for _, c := range r.GetCollectors() {
+		samples := c.Collect()
+		sort.Slice(samples, func(i, j int) bool {
+			return strings.Join(samples[i].Labels, ",") < strings.Join(samples[j].Labels, ",")
+		})
+
+		fmt.Fprintf(&sb, "# HELP %s %s\n", c.GetName(), escapeHelp(c.GetHelp()))
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", c.GetName(), c.GetKind())
+
+		for _, s := range samples {
+			sb.WriteString(c.GetName())
+			sb.WriteString(formatLabels(c.GetLabels(), s.Labels))
+			sb.WriteString(" ")
+			sb.WriteString(formatValue(s.Value))
+			sb.WriteString("\n")
+		}
+	}
+
+	written, err := io.WriteString(w, sb.String())
+	return int64(written), err
+}
+
+// HTTP handler that serves the metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]Collector),
+	}
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for ind, name := range names {
+		value := ""
+		if ind < len(values) {
+			value = values[ind]
+		}
+		pairs[ind] = fmt.Sprintf("%s=\"%s\"", name, escapeLabel(value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
@@ -0,0 +1,327 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	lr "github.com/riotpot/pkg/logger"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/utils"
+)
+
+const (
+	// Maximum number of sources remembered by the sticky balancing
+	maxStickySources = 10_000
+)
+
+// Service placed behind a proxy
+type Backend struct {
+	service service.Service
+	// Relative weight used by the weighted balancing
+	weight int
+	// Result of the last health check or connection attempt
+	healthy atomic.Bool
+	// Number of connections currently forwarded to the service
+	connections atomic.Int64
+}
+
+func (b *Backend) GetService() service.Service {
+	return b.service
+}
+
+func (b *Backend) GetWeight() int {
+	return b.weight
+}
+
+func (b *Backend) IsHealthy() bool {
+	return b.healthy.Load()
+}
+
+func (b *Backend) GetConnections() int64 {
+	return b.connections.Load()
+}
+
+// Load of the backend relative to its weight
+func (b *Backend) load() float64 {
+	return float64(b.GetConnections()) / float64(b.weight)
+}
+
+func newBackend(srv service.Service, weight int) *Backend {
+	if weight < 1 {
+		weight = 1
+	}
+
+	b := &Backend{service: srv, weight: weight}
+	// Services are considered healthy until a check says otherwise
+	b.healthy.Store(true)
+	return b
+}
+
+// Ordered set of services behind a proxy, with their health
+type backendPool struct {
+	mu sync.RWMutex
+
+	// Backends in order of preference
+	backends []*Backend
+	// How to choose the backend for a connection
+	balancing utils.Balancing
+	// Backend assigned to each source IP by the sticky balancing
+	sticky map[string]*Backend
+	// Counter used to distribute the connections by weight
+	counter atomic.Uint64
+
+	// Time between health checks
+	interval time.Duration
+	// Time to wait for the service to answer a health check
+	timeout time.Duration
+}
+
+// Returns a copy of the backends
+func (p *backendPool) list() []*Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return append([]*Backend{}, p.backends...)
+}
+
+// Returns the first service, or nil if there is none
+func (p *backendPool) primary() service.Service {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.backends) == 0 {
+		return nil
+	}
+	return p.backends[0].service
+}
+
+// Replace the first service
+func (p *backendPool) setPrimary(srv service.Service) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sticky = make(map[string]*Backend)
+
+	// Drop the current first service, and the new one if it was already in the list
+	rest := make([]*Backend, 0)
+	for ind, b := range p.backends {
+		if ind == 0 || (srv != nil && b.service.GetID() == srv.GetID()) {
+			continue
+		}
+		rest = append(rest, b)
+	}
+
+	if srv == nil {
+		p.backends = rest
+		return
+	}
+
+	p.backends = append([]*Backend{newBackend(srv, 1)}, rest...)
+}
+
+// Add a service at the end of the list
+func (p *backendPool) add(srv service.Service, weight int) (b *Backend, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, b := range p.backends {
+		if b.service.GetID() == srv.GetID() {
+			return nil, fmt.Errorf("service already in the proxy")
+		}
+	}
+
+	b = newBackend(srv, weight)
+	p.backends = append(p.backends, b)
+	return
+}
+
+// Remove a service from the list
+func (p *backendPool) remove(id string) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for ind, b := range p.backends {
+		if b.service.GetID() == id {
+			p.backends = append(p.backends[:ind], p.backends[ind+1:]...)
+			p.sticky = make(map[string]*Backend)
+			return
+		}
+	}
+
+	return fmt.Errorf("service not found")
+}
+
+// Returns the backends in the order they should be tried for a connection from the source.
+// Healthy backends are sorted by the balancing, and the unhealthy ones are kept as a last resort
+func (p *backendPool) candidates(source net.Addr) (ret []*Backend) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var healthy, unhealthy []*Backend
+	for _, b := range p.backends {
+		if b.IsHealthy() {
+			healthy = append(healthy, b)
+		} else {
+			unhealthy = append(unhealthy, b)
+		}
+	}
+
+	switch p.balancing {
+	case utils.WeightedBalancing:
+		healthy = p.byWeight(healthy)
+	case utils.LeastConnectionsBalancing:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].load() < healthy[j].load()
+		})
+	case utils.StickyBalancing:
+		// Sources without a backend assigned use the least loaded one
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].load() < healthy[j].load()
+		})
+
+		if b, ok := p.sticky[sourceIP(source)]; ok && b.IsHealthy() {
+			healthy = append([]*Backend{b}, without(healthy, b)...)
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// Sort the backends with a weighted round robin.
+// The first backend is chosen by weight, the rest keep their order
+func (p *backendPool) byWeight(backends []*Backend) []*Backend {
+	total := 0
+	for _, b := range backends {
+		total += b.weight
+	}
+
+	if total == 0 {
+		return backends
+	}
+
+	pick := int(p.counter.Add(1) % uint64(total))
+	for _, b := range backends {
+		if pick < b.weight {
+			return append([]*Backend{b}, without(backends, b)...)
+		}
+		pick -= b.weight
+	}
+
+	return backends
+}
+
+// Remember the backend that served the source
+func (p *backendPool) pin(source net.Addr, b *Backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.balancing != utils.StickyBalancing {
+		return
+	}
+
+	// Forget every source once there are too many, instead of growing forever
+	if len(p.sticky) >= maxStickySources {
+		p.sticky = make(map[string]*Backend)
+	}
+	p.sticky[sourceIP(source)] = b
+}
+
+func (p *backendPool) getBalancing() utils.Balancing {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.balancing
+}
+
+func (p *backendPool) setBalancing(balancing utils.Balancing) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.balancing = balancing
+	p.sticky = make(map[string]*Backend)
+}
+
+// Check whether the service of the backend is reachable
+func (p *backendPool) check(b *Backend) (healthy bool) {
+	srv := b.service
+	conn, err := net.DialTimeout(srv.GetNetwork().String(), srv.GetAddress(), p.timeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	if srv.GetNetwork() != utils.UDP {
+		return true
+	}
+
+	// UDP services rarely answer an empty datagram, but a closed port makes the
+	// next read fail with "connection refused"
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	if _, err = conn.Write([]byte{}); err != nil {
+		return false
+	}
+
+	_, err = conn.Read(make([]byte, 1))
+	return !errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Check the health of every backend periodically until the channel is closed
+func (p *backendPool) watch(quit chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, b := range p.list() {
+			healthy := p.check(b)
+			if healthy != b.IsHealthy() {
+				lr.Log.Info().Str("service", b.service.GetName()).Bool("healthy", healthy).Msg("Service health changed")
+			}
+			b.healthy.Store(healthy)
+		}
+
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func newBackendPool() *backendPool {
+	return &backendPool{
+		backends:  make([]*Backend, 0),
+		balancing: utils.OrderedBalancing,
+		sticky:    make(map[string]*Backend),
+		interval:  10 * time.Second,
+		timeout:   1 * time.Second,
+	}
+}
+
+// Returns the IP of an address, used to identify the source of a connection
+func sourceIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Returns the backends without the given one
+func without(backends []*Backend, b *Backend) (ret []*Backend) {
+	for _, other := range backends {
+		if other != b {
+			ret = append(ret, other)
+		}
+	}
+	return
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/utils"
+)
+
+const (
+	// Maximum number of sources remembered by the escalation, the oldest are forgotten first
+	maxEscalationSources = 10_000
+)
+
+// Policy to send the first contact of an attacker to low-interaction services and,
+// once the attacker becomes interesting, every following connection to high-interaction services.
+// This saves the expensive services for the attackers that deserve them.
+// The policy listens to the events of the proxy to find out what the attackers do
+type Escalation struct {
+	// Number of sessions from a source after which it is escalated. Disabled when 0
+	Sessions int
+	// Escalate the sources that authenticate successfully
+	Login bool
+	// Escalate the sources that send a command containing any of these strings
+	Commands []string
+
+	// ID of the proxy using the policy
+	proxy string
+
+	mu sync.RWMutex
+	// Sessions seen by source IP
+	sessions map[string]int
+	// Sources escalated
+	escalated map[string]bool
+	// Sources in the order they were first seen, to forget the oldest
+	order []string
+}
+
+// Name of the policy as an events sink
+func (e *Escalation) GetName() string {
+	return "escalation-" + e.proxy
+}
+
+// Check whether the event makes the source interesting
+func (e *Escalation) Send(ev *events.Event) error {
+	// Events from services that could not be related to a proxy could come from any of them
+	if ev.Proxy != e.proxy {
+		return nil
+	}
+
+	switch ev.Type {
+	case events.AuthEvent:
+		if e.Login && ev.GetBool("success") {
+			e.escalate(ev.Source)
+		}
+	case events.CommandEvent:
+		command := ev.GetString("command")
+		for _, c := range e.Commands {
+			if c != "" && strings.Contains(command, c) {
+				e.escalate(ev.Source)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Whether the source has been escalated
+func (e *Escalation) IsEscalated(source string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.escalated[hostOf(source)]
+}
+
+// Returns the list of sources escalated
+func (e *Escalation) GetEscalated() (sources []string) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	sources = []string{}
+	for source := range e.escalated {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return
+}
+
+// Escalate a source
+func (e *Escalation) escalate(source string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ip := hostOf(source)
+	e.remember(ip)
+	e.escalated[ip] = true
+}
+
+// Count a new session from the source
+func (e *Escalation) observe(source net.Addr) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ip := sourceIP(source)
+	e.remember(ip)
+	e.sessions[ip]++
+
+	if e.Sessions > 0 && e.sessions[ip] >= e.Sessions {
+		e.escalated[ip] = true
+	}
+}
+
+// Keep track of a new source, forgetting the oldest when there are too many.
+// Must be called with the lock held
+func (e *Escalation) remember(ip string) {
+	if _, ok := e.sessions[ip]; ok {
+		return
+	}
+
+	for len(e.order) >= maxEscalationSources {
+		oldest := e.order[0]
+		e.order = e.order[1:]
+		delete(e.sessions, oldest)
+		delete(e.escalated, oldest)
+	}
+
+	e.order = append(e.order, ip)
+	e.sessions[ip] = 0
+}
+
+// Sort the backends so the source goes to the services of its interaction level first.
+// The order of health is kept: healthy backends go before the unhealthy ones
+func (e *Escalation) sort(backends []*Backend, source net.Addr) []*Backend {
+	preferred := utils.Low
+	if e.IsEscalated(sourceIP(source)) {
+		preferred = utils.High
+	}
+
+	rank := func(b *Backend) (r int) {
+		if !b.IsHealthy() {
+			r += 2
+		}
+		if b.GetService().GetInteraction() != preferred {
+			r += 1
+		}
+		return
+	}
+
+	sorted := append([]*Backend{}, backends...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+	return sorted
+}
+
+func NewEscalation(sessions int, login bool, commands []string) *Escalation {
+	return &Escalation{
+		Sessions:  sessions,
+		Login:     login,
+		Commands:  commands,
+		sessions:  make(map[string]int),
+		escalated: make(map[string]bool),
+	}
+}
+
+// Returns the host of an address, or the address itself when it has no port
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -21,6 +21,8 @@ type ProxyManager interface {
 	GetProxies() []Proxy
 	// Create a new proxy and add it to the manager
 	CreateProxy(protocol string, port int) (Proxy, error)
+	// Create a new proxy that terminates TLS and add it to the manager
+	CreateTLSProxy(port int, termination *Termination) (Proxy, error)
 
 	// Methods for proxies the using ID field
 	GetProxy(id string) (Proxy, error)
@@ -28,7 +30,7 @@ type ProxyManager interface {
 	DeleteProxy(id string) error
 
 	// Wrapper method to find a proxy using the port and protocol
-	GetProxyFromParams(network string, port int)
+	GetProxyFromParams(network utils.Network, port int) (Proxy, error)
 
 	// Set the service for a proxy
 	SetService(port int, service service.Service) (pe Proxy, err error)
@@ -61,6 +63,18 @@ func (pm *proxyManager) CreateProxy(network utils.Network, port int) (pe Proxy,
 	return
 }
 
+// Create a new proxy that terminates TLS and add it to the manager
+func (pm *proxyManager) CreateTLSProxy(port int, termination *Termination) (pe Proxy, err error) {
+	pe, err = NewTLSProxy(port, termination)
+	if err != nil {
+		return
+	}
+
+	// Append the proxy to the list
+	pm.proxies = append(pm.proxies, pe)
+	return
+}
+
 func (pm *proxyManager) GetProxy(id string) (pe Proxy, err error) {
 	// Get all the proxies registered
 	proxies := pm.GetProxies()
@@ -76,6 +90,19 @@ func (pm *proxyManager) GetProxy(id string) (pe Proxy, err error) {
 	return
 }
 
+// Find a proxy using the port and protocol
+func (pm *proxyManager) GetProxyFromParams(network utils.Network, port int) (pe Proxy, err error) {
+	for _, proxy := range pm.GetProxies() {
+		if proxy.GetNetwork() == network && proxy.GetPort() == port {
+			pe = proxy
+			return
+		}
+	}
+
+	err = fmt.Errorf("proxy not found")
+	return
+}
+
 func (pm *proxyManager) SetProxy(px Proxy) (pe Proxy, err error) {
 	// Get all the proxies registered
 	proxies := pm.GetProxies()
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"strconv"
+
+	"github.com/riotpot/pkg/metrics"
+)
+
+// Reasons to reject a connection
+const (
+	upstreamRejection   = "upstream"
+	handshakeRejection  = "tls_handshake"
+	middlewareRejection = "middleware"
+	backendRejection    = "no_backend"
+	scannerRejection    = "scanner"
+)
+
+// Metrics of the proxies
+var (
+	connectionsAccepted = metrics.Metrics.NewCounter(
+		"riotpot_proxy_connections_accepted_total",
+		"Connections accepted by the proxy and forwarded to a service",
+		"proxy", "port", "network",
+	)
+	connectionsRejected = metrics.Metrics.NewCounter(
+		"riotpot_proxy_connections_rejected_total",
+		"Connections closed by the proxy before reaching a service",
+		"proxy", "port", "network", "reason",
+	)
+	activeSessions = metrics.Metrics.NewGauge(
+		"riotpot_proxy_sessions_active",
+		"Sessions currently open in the proxy",
+		"proxy", "port", "network",
+	)
+	bytesForwarded = metrics.Metrics.NewCounter(
+		"riotpot_proxy_bytes_total",
+		"Bytes forwarded by the proxy. The direction is \"in\" from the client and \"out\" to the client",
+		"proxy", "port", "network", "direction",
+	)
+	backendHealth = metrics.Metrics.NewGaugeFunc(
+		"riotpot_backend_healthy",
+		"Whether the service behind the proxy answered the last health check (1) or not (0)",
+		collectBackendHealth,
+		"proxy", "port", "service",
+	)
+)
+
+// Returns the labels that identify the proxy in the metrics
+func (pe *baseProxy) metricLabels(extra ...string) []string {
+	return append([]string{pe.GetID(), strconv.Itoa(pe.GetPort()), pe.GetNetwork().String()}, extra...)
+}
+
+// Count a connection rejected by the proxy
+func (pe *baseProxy) reject(reason string) {
+	connectionsRejected.With(pe.metricLabels(reason)...).Inc()
+}
+
+func collectBackendHealth() (samples []metrics.Sample) {
+	for _, px := range Proxies.GetProxies() {
+		for _, b := range px.GetBackends() {
+			value := 0.0
+			if b.IsHealthy() {
+				value = 1
+			}
+
+			samples = append(samples, metrics.Sample{
+				Labels: []string{px.GetID(), strconv.Itoa(px.GetPort()), b.GetService().GetName()},
+				Value:  value,
+			})
+		}
+	}
+	return
+}
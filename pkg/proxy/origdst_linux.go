@@ -0,0 +1,56 @@
+//go:build linux
+
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// Socket option used by netfilter to store the destination of redirected connections.
+// It has the same value for IPv4 (SO_ORIGINAL_DST) and IPv6 (IP6T_SO_ORIGINAL_DST)
+const soOriginalDst = 80
+
+// Returns the destination the client connected to before being redirected
+// by an iptables/nftables REDIRECT rule
+func originalDestination(conn *net.TCPConn) (addr *net.TCPAddr, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	ipv4 := conn.LocalAddr().(*net.TCPAddr).IP.To4() != nil
+
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		// The `sockaddr_in6` structure fits in the `IPv6MTUInfo` used by the syscall package,
+		// and `sockaddr_in` fits in its first bytes
+		level := syscall.IPPROTO_IPV6
+		if ipv4 {
+			level = syscall.IPPROTO_IP
+		}
+
+		info, e := syscall.GetsockoptIPv6MTUInfo(int(fd), level, soOriginalDst)
+		if e != nil {
+			serr = e
+			return
+		}
+
+		// The port is stored in network byte order
+		port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+		addr = &net.TCPAddr{Port: int(port[0])<<8 | int(port[1])}
+
+		if ipv4 {
+			in := (*syscall.RawSockaddrInet4)(unsafe.Pointer(&info.Addr))
+			addr.IP = net.IPv4(in.Addr[0], in.Addr[1], in.Addr[2], in.Addr[3])
+		} else {
+			addr.IP = net.IP(info.Addr.Addr[:])
+		}
+	})
+
+	if err == nil {
+		err = serr
+	}
+	return
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+)
+
+// Returns the destination the client connected to before being redirected.
+// Only supported on Linux
+func originalDestination(conn *net.TCPConn) (addr *net.TCPAddr, err error) {
+	err = fmt.Errorf("transparent interception is only supported on linux")
+	return
+}
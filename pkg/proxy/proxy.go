@@ -1,7 +1,12 @@
 package proxy
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/google/uuid"
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/proxyproto"
 	"github.com/riotpot/pkg/service"
 	"github.com/riotpot/pkg/utils"
 )
@@ -18,10 +23,23 @@ type Proxy interface {
 	GetNetwork() utils.Network
 	IsRunning() utils.Status
 	GetService() service.Service
+	GetBackends() []*Backend
+	GetBalancing() utils.Balancing
+	GetTrustedUpstreams() []string
+	GetEscalation() *Escalation
+	GetScannerPolicy() *ScannerPolicy
 
 	// Setters
 	SetPort(port int) int
 	SetService(service service.Service) service.Service
+	SetBalancing(balancing utils.Balancing) utils.Balancing
+	SetTrustedUpstreams(cidrs []string) ([]string, error)
+	SetEscalation(escalation *Escalation) *Escalation
+	SetScannerPolicy(policy *ScannerPolicy) (*ScannerPolicy, error)
+
+	// Manage the services behind the proxy
+	AddBackend(service service.Service, weight int) (*Backend, error)
+	RemoveBackend(id string) error
 }
 
 // Abstraction of the proxy endpoint
@@ -46,8 +64,20 @@ type baseProxy struct {
 	// Perhaps this can be changed in the future given the need to apply middlewares per proxy
 	middlewares *middlewareManager
 
-	// Service to proxy
-	service service.Service
+	// Services to proxy, the first one is the primary service
+	backends *backendPool
+
+	// Policy to read the PROXY protocol header sent by the upstreams in front of the proxy
+	upstreams *proxyproto.Policy
+
+	// Policy to escalate attackers from low to high interaction services, if any
+	escalation *Escalation
+
+	// Policy for the known scanners, they are served like any other client when nil
+	scanners *ScannerPolicy
+
+	// Protects the policies, that change while the proxy serves the connections
+	mu sync.RWMutex
 
 	// Generic listener
 	listener interface{ Close() error }
@@ -80,9 +110,19 @@ func (pe *baseProxy) GetPort() int {
 	return pe.port
 }
 
-// Returns the service
+// Returns the primary service
 func (pe *baseProxy) GetService() service.Service {
-	return pe.service
+	return pe.backends.primary()
+}
+
+// Returns the services behind the proxy, in order of preference
+func (pe *baseProxy) GetBackends() []*Backend {
+	return pe.backends.list()
+}
+
+// Returns how the proxy chooses the service for each connection
+func (pe *baseProxy) GetBalancing() utils.Balancing {
+	return pe.backends.getBalancing()
 }
 
 // Returns the service
@@ -90,6 +130,19 @@ func (pe *baseProxy) GetNetwork() utils.Network {
 	return pe.network
 }
 
+// Returns the networks allowed to send PROXY protocol headers to the proxy
+func (pe *baseProxy) GetTrustedUpstreams() []string {
+	return pe.GetUpstreams().GetTrusted()
+}
+
+// Returns the policy to read the PROXY protocol header of the incoming connections
+func (pe *baseProxy) GetUpstreams() *proxyproto.Policy {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	return pe.upstreams
+}
+
 // Set the port
 // NOTE: use the ValidatePort before assigning
 func (pe *baseProxy) SetPort(port int) int {
@@ -97,17 +150,104 @@ func (pe *baseProxy) SetPort(port int) int {
 	return pe.port
 }
 
-// Set the service based on the list of registered services
+// Set the primary service based on the list of registered services
 func (pe *baseProxy) SetService(service service.Service) service.Service {
-	pe.service = service
-	return pe.service
+	pe.backends.setPrimary(service)
+	return pe.GetService()
+}
+
+// Set how the proxy chooses the service for each connection
+func (pe *baseProxy) SetBalancing(balancing utils.Balancing) utils.Balancing {
+	pe.backends.setBalancing(balancing)
+	return pe.GetBalancing()
+}
+
+// Add a service behind the primary one, to be used for failover or balancing
+func (pe *baseProxy) AddBackend(service service.Service, weight int) (*Backend, error) {
+	if service.GetNetwork() != pe.GetNetwork() {
+		return nil, fmt.Errorf("service network %s does not match the proxy", service.GetNetwork().String())
+	}
+
+	return pe.backends.add(service, weight)
+}
+
+// Remove a service from the proxy
+func (pe *baseProxy) RemoveBackend(id string) error {
+	return pe.backends.remove(id)
+}
+
+// Set the networks allowed to send PROXY protocol headers to the proxy.
+// An empty list disables the PROXY protocol for the incoming connections
+func (pe *baseProxy) SetTrustedUpstreams(cidrs []string) (trusted []string, err error) {
+	policy, err := proxyproto.NewPolicy(cidrs)
+	if err != nil {
+		return
+	}
+
+	pe.mu.Lock()
+	pe.upstreams = policy
+	pe.mu.Unlock()
+
+	return policy.GetTrusted(), nil
+}
+
+// Returns the escalation policy of the proxy, or nil if there is none
+func (pe *baseProxy) GetEscalation() *Escalation {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	return pe.escalation
+}
+
+// Set the escalation policy of the proxy. Use nil to remove it
+func (pe *baseProxy) SetEscalation(escalation *Escalation) *Escalation {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	// Stop listening to the events with the previous policy
+	if pe.escalation != nil {
+		events.Events.Unregister(pe.escalation.GetName())
+	}
+
+	pe.escalation = escalation
+	if escalation != nil {
+		escalation.proxy = pe.GetID()
+		events.Events.Register(escalation)
+	}
+
+	return pe.escalation
+}
+
+// Returns the policy of the proxy for the known scanners, or nil if there is none
+func (pe *baseProxy) GetScannerPolicy() *ScannerPolicy {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	return pe.scanners
+}
+
+// Set the policy of the proxy for the known scanners. Use nil to serve them like any other client
+func (pe *baseProxy) SetScannerPolicy(policy *ScannerPolicy) (*ScannerPolicy, error) {
+	if policy != nil && policy.persona != nil && policy.GetPersona().GetNetwork() != pe.GetNetwork() {
+		return nil, fmt.Errorf("service network %s does not match the proxy", policy.GetPersona().GetNetwork().String())
+	}
+
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	pe.scanners = policy
+	return pe.scanners, nil
 }
 
 func newProxy(port int, network utils.Network) (px *baseProxy) {
+	upstreams, _ := proxyproto.NewPolicy(nil)
+
 	return &baseProxy{
 		id:          uuid.New(),
 		port:        port,
 		network:     network,
 		middlewares: Middlewares,
+		upstreams:   upstreams,
+		backends:    newBackendPool(),
 	}
 }
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/riotpot/pkg/service"
+)
+
+// What the proxy does with the connections of the known scanners
+type ScannerAction string
+
+const (
+	// Forward them to the services, like any other connection
+	ServeScanners ScannerAction = "serve"
+	// Close them before they reach the services
+	DropScanners ScannerAction = "drop"
+	// Forward them to a different service, the persona shown to the scanners
+	PersonaScanners ScannerAction = "persona"
+)
+
+func ParseScannerAction(action string) (ScannerAction, error) {
+	switch a := ScannerAction(strings.ToLower(action)); a {
+	case ServeScanners, DropScanners, PersonaScanners:
+		return a, nil
+	}
+	return "", fmt.Errorf("invalid scanner action: %s", action)
+}
+
+// Policy of the proxy for the known scanners, see the scanners package
+type ScannerPolicy struct {
+	Action ScannerAction
+
+	// Service shown to the scanners by the persona action
+	persona *Backend
+}
+
+// Returns the service shown to the scanners, or nil if there is none
+func (p *ScannerPolicy) GetPersona() service.Service {
+	if p.persona == nil {
+		return nil
+	}
+	return p.persona.GetService()
+}
+
+func NewScannerPolicy(action ScannerAction, persona service.Service) (*ScannerPolicy, error) {
+	p := &ScannerPolicy{Action: action}
+
+	switch action {
+	case PersonaScanners:
+		if persona == nil {
+			return nil, fmt.Errorf("the persona action requires a service")
+		}
+		p.persona = newBackend(persona, 1)
+	case ServeScanners, DropScanners:
+	default:
+		return nil, fmt.Errorf("invalid scanner action: %s", action)
+	}
+
+	return p, nil
+}
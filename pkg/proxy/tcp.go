@@ -7,7 +7,15 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/fingerprint"
 	lr "github.com/riotpot/pkg/logger"
+	"github.com/riotpot/pkg/metrics"
+	"github.com/riotpot/pkg/proxyproto"
+	"github.com/riotpot/pkg/scanners"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/tracing"
 	"github.com/riotpot/pkg/utils"
 	"github.com/riotpot/pkg/validators"
 )
@@ -17,6 +25,9 @@ import (
 type tcpProxy struct {
 	*baseProxy
 	listener net.Listener
+
+	// TLS settings, only set by the proxies that terminate TLS
+	termination *Termination
 }
 
 // Start listening for connections
@@ -35,6 +46,9 @@ func (px *tcpProxy) Start() (err error) {
 		return
 	}
 
+	// Check the health of the services while the proxy runs
+	go px.backends.watch(px.quit)
+
 	// Add a waiting task
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -55,31 +69,15 @@ func (px *tcpProxy) Start() (err error) {
 				if err != nil {
 					return
 				}
-				defer client.Close()
-
-				// Apply the middlewares to the connection before dialing the server
-				_, err = px.middlewares.Apply(client)
-				if err != nil {
-					return
-				}
-
-				// Get a connection to the server for each new connection with the client
-				server, servErr := net.DialTimeout(utils.TCP.String(), px.service.GetAddress(), 1*time.Second)
-
-				// If there was an error, close the connection to the server and return
-				if servErr != nil {
-					return
-				}
-				defer server.Close()
 
 				// Add a waiting task
 				wg.Add(1)
 
 				go func() {
 					defer wg.Done()
-					// Handle the connection between the client and the server
+					// Forward the connection to the service
 					// NOTE: The handlers will defer the connections
-					px.handle(client, server)
+					px.serve(client)
 				}()
 			}
 
@@ -89,6 +87,225 @@ func (px *tcpProxy) Start() (err error) {
 	return
 }
 
+// Prepare the connection from the client and forward it to the service
+func (px *tcpProxy) serve(conn net.Conn) {
+	// Each connection is a trace
+	span := tracing.Tracer.Start("proxy.session", tracing.ServerKind, nil)
+	span.SetAttribute("riotpot.proxy.id", px.GetID()).SetAttribute("server.port", px.GetPort())
+	defer span.Finish()
+
+	// Recover the address of the attacker when the connection comes from a trusted upstream,
+	// so every decision taken from here on uses it
+	client, err := px.GetUpstreams().Accept(conn)
+	if err != nil {
+		lr.Log.Warn().Err(err).Msg("Could not read the PROXY protocol header")
+		span.SetError(err)
+		px.reject(upstreamRejection)
+		conn.Close()
+		return
+	}
+
+	// Known scanners may not be welcome. Wait for the reverse DNS lookup only when
+	// the proxy treats them differently, the rest of the clients are served right away
+	policy := px.GetScannerPolicy()
+	var scanner string
+	if policy != nil && policy.Action != ServeScanners {
+		scanner = scanners.Scanners.Identify(sourceIP(client.RemoteAddr()))
+	} else {
+		scanner = scanners.Scanners.IdentifyNow(sourceIP(client.RemoteAddr()))
+	}
+	if scanner != "" {
+		span.SetAttribute("riotpot.scanner", scanner)
+
+		if policy != nil && policy.Action == DropScanners {
+			px.reject(scannerRejection)
+			client.Close()
+			return
+		}
+	}
+
+	// Decrypt the connection, so the middlewares and the services see the plaintext.
+	// The ClientHello is inspected on the way to fingerprint the client
+	var hello *fingerprint.TLSConn
+	if termination := px.termination; termination != nil {
+		hello = fingerprint.NewTLSConn(client)
+
+		step := span.Child("proxy.tls_handshake", tracing.InternalKind)
+		tlsClient, err := termination.accept(hello)
+		step.SetError(err)
+		step.Finish()
+
+		if err != nil {
+			span.SetError(err)
+			lr.Log.Warn().Err(err).Str("source", client.RemoteAddr().String()).Msg("TLS handshake failed")
+			px.reject(handshakeRejection)
+			client.Close()
+			return
+		}
+		client = tlsClient
+	}
+
+	// Apply the middlewares to the connection before dialing the server
+	step := span.Child("proxy.middlewares", tracing.InternalKind)
+	_, err = px.middlewares.Apply(client)
+	step.SetError(err)
+	step.Finish()
+
+	if err != nil {
+		span.SetError(err)
+		px.reject(middlewareRejection)
+		client.Close()
+		return
+	}
+
+	// Each connection is a session with the attacker
+	session := uuid.New().String()
+	source := client.RemoteAddr().String()
+	destination := client.LocalAddr().String()
+	span.SetAttribute("riotpot.session", session).SetAttribute("client.address", source)
+
+	ev := events.NewEvent(events.ConnectionEvent, "", source)
+	ev.Session, ev.Proxy, ev.Destination = session, px.GetID(), destination
+	ev.With("network", px.GetNetwork().String())
+	if hello != nil && hello.ClientHello() != nil {
+		hello.ClientHello().Annotate(ev)
+	}
+	if scanner != "" {
+		scanners.Annotate(ev, scanner)
+	}
+	events.Events.Emit(ev)
+
+	defer func() {
+		ev := events.NewEvent(events.DisconnectionEvent, "", source)
+		ev.Session, ev.Proxy, ev.Destination = session, px.GetID(), destination
+		events.Events.Emit(ev.With("network", px.GetNetwork().String()))
+	}()
+
+	// Get a connection to a server for each new connection with the client
+	// Scanners see their own persona, when there is one
+	var persona *Backend
+	if scanner != "" && policy != nil && policy.Action == PersonaScanners {
+		persona = policy.persona
+	}
+
+	server, backend, err := px.dial(client, persona, span)
+	if err != nil {
+		lr.Log.Warn().Err(err).Msg("Could not connect to any service")
+		span.SetError(err)
+		px.reject(backendRejection)
+		client.Close()
+		return
+	}
+
+	// Tell the service who the client is, when the service expects it
+	if err := px.sendProxyHeader(backend.GetService(), client, server); err != nil {
+		lr.Log.Warn().Err(err).Msg("Could not send the PROXY protocol header")
+		span.SetError(err)
+		server.Close()
+		client.Close()
+		return
+	}
+
+	// Relate the connection to the service with the attacker, so the events
+	// emitted by the service refer to the attacker. The services that read the
+	// PROXY protocol header see the address of the attacker instead of the proxy
+	peers := []string{server.LocalAddr().String()}
+	if backend.GetService().GetProxyProtocol() != utils.NoProxyProtocol {
+		peers = append(peers, source)
+	}
+
+	for _, peer := range peers {
+		events.Events.Link(peer, events.Link{
+			Session:     session,
+			Proxy:       px.GetID(),
+			Source:      source,
+			Destination: destination,
+		})
+		defer events.Events.Unlink(peer)
+
+		// The spans of the service join the trace of the connection
+		tracing.Tracer.Link(peer, span)
+		defer tracing.Tracer.Unlink(peer)
+	}
+
+	// Encrypt the connection again for the services that expect TLS
+	if termination := px.termination; termination != nil {
+		server = termination.originate(server, serverName(backend.GetService().GetAddress()))
+	}
+
+	backend.connections.Add(1)
+	defer backend.connections.Add(-1)
+
+	connectionsAccepted.With(px.metricLabels()...).Inc()
+	sessions := activeSessions.With(px.metricLabels()...)
+	sessions.Inc()
+	defer sessions.Dec()
+
+	// Handle the connection between the client and the server
+	forward := span.Child("proxy.forward", tracing.InternalKind)
+	in, out := px.handle(client, server)
+	forward.SetAttribute("riotpot.bytes.in", in).SetAttribute("riotpot.bytes.out", out)
+	forward.Finish()
+
+	span.AddEvent("close", nil)
+}
+
+// Connect to the services in order of preference until one of them answers.
+// Services that do not answer are marked as unhealthy until the next health check.
+// The persona, if any, is the only service used
+func (px *tcpProxy) dial(client net.Conn, persona *Backend, parent *tracing.Span) (server net.Conn, backend *Backend, err error) {
+	span := parent.Child("proxy.dial", tracing.ClientKind)
+	defer span.Finish()
+
+	candidates := px.backends.candidates(client.RemoteAddr())
+
+	// Send the attacker to the services of its interaction level
+	if escalation := px.GetEscalation(); escalation != nil {
+		candidates = escalation.sort(candidates, client.RemoteAddr())
+		escalation.observe(client.RemoteAddr())
+	}
+
+	if persona != nil {
+		candidates = []*Backend{persona}
+	}
+
+	for _, backend = range candidates {
+		srv := backend.GetService()
+
+		server, err = net.DialTimeout(utils.TCP.String(), srv.GetAddress(), 1*time.Second)
+		if err != nil {
+			lr.Log.Warn().Err(err).Str("service", srv.GetName()).Msg("Service unavailable, failing over")
+			span.AddEvent("failover", map[string]interface{}{"riotpot.service": srv.GetName(), "error": err.Error()})
+			backend.healthy.Store(false)
+			continue
+		}
+
+		span.SetAttribute("riotpot.service", srv.GetName()).SetAttribute("server.address", srv.GetAddress())
+		backend.healthy.Store(true)
+		if persona == nil {
+			px.backends.pin(client.RemoteAddr(), backend)
+		}
+		return
+	}
+
+	err = fmt.Errorf("no service available for the proxy in port %d", px.GetPort())
+	span.SetError(err)
+	return nil, nil, err
+}
+
+// Send the PROXY protocol header with the address of the client to the server,
+// if the service is configured to receive it
+func (px *tcpProxy) sendProxyHeader(srv service.Service, client net.Conn, server net.Conn) (err error) {
+	version := srv.GetProxyProtocol()
+	if version == utils.NoProxyProtocol {
+		return
+	}
+
+	header := proxyproto.NewHeader(version, client.RemoteAddr(), client.LocalAddr())
+	_, err = header.WriteTo(server)
+	return
+}
+
 func (px *tcpProxy) GetListener() (listener net.Listener, err error) {
 	listener = px.listener
 
@@ -120,17 +337,24 @@ func (px *tcpProxy) NewListener() (listener net.Listener, err error) {
 	return
 }
 
-// TCP synchronous tunnel that forwards requests from source to destination and back
-func (px *tcpProxy) handle(from net.Conn, to net.Conn) {
+// TCP synchronous tunnel that forwards requests from source to destination and back.
+// Returns the number of bytes forwarded in each direction
+func (px *tcpProxy) handle(from net.Conn, to net.Conn) (in int64, out int64) {
 	// Create the waiting group for the connections so they can answer the each other
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	handler := func(source net.Conn, dest net.Conn) {
+	handler := func(source net.Conn, dest net.Conn, direction string) {
 		defer wg.Done()
 
-		// Write the content from the source to the destination
-		_, err := io.Copy(dest, source)
+		// Write the content from the source to the destination, counting the bytes as they go
+		counter := &countingWriter{Writer: dest, series: bytesForwarded.With(px.metricLabels(direction)...)}
+		n, err := io.Copy(counter, source)
+		if direction == "in" {
+			in = n
+		} else {
+			out = n
+		}
 		if err != nil {
 			lr.Log.Warn().Err(err).Msg("Could not copy from source to destination")
 		}
@@ -142,7 +366,8 @@ func (px *tcpProxy) handle(from net.Conn, to net.Conn) {
 
 		// Attempt to close the writter. This may not always work
 		// Another solution is to just call `Close()` on the writter
-		if d, ok := dest.(*net.TCPConn); ok {
+		// TLS connections send their close notification
+		if d, ok := dest.(interface{ CloseWrite() error }); ok {
 			if err := d.CloseWrite(); err != nil {
 				lr.Log.Warn().Err(err)
 			}
@@ -153,11 +378,24 @@ func (px *tcpProxy) handle(from net.Conn, to net.Conn) {
 	// Start the workers
 	// TODO: [7/3/2022] Check somewhere if the connection is still alive from the source and destination
 	// Otherwise there is no need to wait
-	go handler(from, to)
-	go handler(to, from)
+	go handler(from, to, "in")
+	go handler(to, from, "out")
 
 	// Wait until the forwarding is done
 	wg.Wait()
+	return
+}
+
+// Writer that counts the bytes written in a metric
+type countingWriter struct {
+	io.Writer
+	series *metrics.Series
+}
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.Writer.Write(p)
+	w.series.Add(float64(n))
+	return
 }
 
 func NewTCPProxy(port int) (proxy *tcpProxy, err error) {
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/riotpot/pkg/certificates"
+)
+
+// TLS settings of a proxy that terminates the encryption of the clients
+type Termination struct {
+	// Certificate presented to the clients
+	certificate tls.Certificate
+	// Whether the certificate was generated on the fly
+	selfSigned bool
+
+	// Encrypt the connection to the service again, for services that only talk TLS
+	Reencrypt bool
+
+	// Time the client has to complete the handshake
+	HandshakeTimeout time.Duration
+}
+
+// Returns the SHA-256 fingerprint of the certificate presented to the clients
+func (t *Termination) GetFingerprint() string {
+	if len(t.certificate.Certificate) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(t.certificate.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the subject of the certificate presented to the clients
+func (t *Termination) GetSubject() string {
+	leaf, err := certificates.Leaf(t.certificate)
+	if err != nil {
+		return ""
+	}
+	return leaf.Subject.String()
+}
+
+func (t *Termination) IsSelfSigned() bool {
+	return t.selfSigned
+}
+
+// Wrap the connection with the client in a TLS server connection and complete the handshake
+func (t *Termination) accept(conn net.Conn) (client *tls.Conn, err error) {
+	client = tls.Server(conn, &tls.Config{
+		Certificates: []tls.Certificate{t.certificate},
+	})
+
+	client.SetDeadline(time.Now().Add(t.HandshakeTimeout))
+	if err = client.Handshake(); err != nil {
+		return
+	}
+	client.SetDeadline(time.Time{})
+	return
+}
+
+// Wrap the connection with the service in a TLS client connection, when the service expects TLS.
+// Services are honeypots, their certificates are not verified
+func (t *Termination) originate(conn net.Conn, serverName string) net.Conn {
+	if !t.Reencrypt {
+		return conn
+	}
+
+	return tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+}
+
+// Create the TLS settings with the certificate.
+// A self-signed certificate is generated when the certificate is nil
+func NewTermination(certificate *tls.Certificate, reencrypt bool) (t *Termination, err error) {
+	t = &Termination{
+		Reencrypt:        reencrypt,
+		HandshakeTimeout: 10 * time.Second,
+	}
+
+	if certificate != nil {
+		t.certificate = *certificate
+		return
+	}
+
+	t.certificate, err = certificates.NewSelfSigned()
+	t.selfSigned = true
+	return
+}
+
+// Proxy that terminates the TLS connections of the clients
+type TLSProxy interface {
+	Proxy
+
+	GetTermination() *Termination
+	SetTermination(termination *Termination) *Termination
+}
+
+// Implementation of a TCP proxy that terminates TLS.
+// Middlewares and the services see the plaintext stream of the client
+type tlsProxy struct {
+	*tcpProxy
+}
+
+// Returns the TLS settings of the proxy
+func (px *tlsProxy) GetTermination() *Termination {
+	return px.termination
+}
+
+// Replace the TLS settings used for the new connections
+func (px *tlsProxy) SetTermination(termination *Termination) *Termination {
+	px.termination = termination
+	return px.termination
+}
+
+func NewTLSProxy(port int, termination *Termination) (proxy *tlsProxy, err error) {
+	px, err := NewTCPProxy(port)
+	if err != nil {
+		return
+	}
+
+	proxy = &tlsProxy{tcpProxy: px}
+	proxy.SetTermination(termination)
+	return
+}
+
+// Returns the host of the service, used as the server name when encrypting again
+func serverName(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/riotpot/pkg/events"
+	lr "github.com/riotpot/pkg/logger"
+	"github.com/riotpot/pkg/utils"
+)
+
+// Connection redirected by the firewall.
+// The local address is the destination the client intended to reach
+type redirectedConn struct {
+	net.Conn
+	destination *net.TCPAddr
+}
+
+func (c *redirectedConn) LocalAddr() net.Addr {
+	return c.destination
+}
+
+// Catch-all TCP listener for the connections redirected by an iptables/nftables REDIRECT rule.
+// E.g., `iptables -t nat -A PREROUTING -p tcp -j REDIRECT --to-ports 9999`
+//
+// Each connection is dispatched to the TCP proxy registered for its original destination port,
+// whether the proxy is listening or not, which allows a single process to cover every port.
+// Connections to any other port are handled by a generic responder that records what
+// the client sends first and answers with a banner.
+// NOTE: this is only supported on Linux
+type Interceptor struct {
+	// Port the redirected connections arrive to
+	port int
+
+	// Proxies used to dispatch the connections
+	proxies *proxyManager
+
+	// Returns the destination the client intended to reach, read from the socket by default
+	OriginalDestination func(conn net.Conn) (*net.TCPAddr, error)
+
+	// Time the responder waits for the client to speak first
+	BannerTimeout time.Duration
+	// Banner sent by the responder when the client does not speak first
+	Banner []byte
+
+	listener net.Listener
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func (ic *Interceptor) GetPort() int {
+	return ic.port
+}
+
+func (ic *Interceptor) IsRunning() (alive utils.Status) {
+	if ic.quit == nil {
+		return
+	}
+
+	select {
+	case <-ic.quit:
+		return
+	default:
+		return utils.RunningStatus
+	}
+}
+
+// Start listening for redirected connections
+func (ic *Interceptor) Start() (err error) {
+	ic.listener, err = net.Listen(utils.TCP.String(), fmt.Sprintf(":%d", ic.port))
+	if err != nil {
+		return
+	}
+
+	ic.quit = make(chan struct{})
+	ic.wg.Add(1)
+
+	go func() {
+		defer ic.wg.Done()
+
+		for {
+			conn, err := ic.listener.Accept()
+			if err != nil {
+				select {
+				case <-ic.quit:
+					return
+				default:
+					lr.Log.Warn().Err(err).Msg("Could not accept a redirected connection")
+					continue
+				}
+			}
+
+			ic.wg.Add(1)
+			go func() {
+				defer ic.wg.Done()
+				ic.dispatch(conn)
+			}()
+		}
+	}()
+
+	return
+}
+
+// Stop the listener and wait for the open connections to finish
+func (ic *Interceptor) Stop() {
+	if ic.IsRunning() != utils.RunningStatus {
+		return
+	}
+
+	close(ic.quit)
+	ic.listener.Close()
+	ic.wg.Wait()
+}
+
+// Send the connection to the proxy registered for the original destination port,
+// or to the responder when there is none
+func (ic *Interceptor) dispatch(conn net.Conn) {
+	dst, err := ic.OriginalDestination(conn)
+	if err != nil {
+		lr.Log.Warn().Err(err).Msg("Could not get the original destination of the connection")
+		conn.Close()
+		return
+	}
+
+	client := &redirectedConn{Conn: conn, destination: dst}
+
+	pe, err := ic.proxies.GetProxyFromParams(utils.TCP, dst.Port)
+	if err == nil && pe.GetService() != nil {
+		switch px := pe.(type) {
+		case *tcpProxy:
+			px.serve(client)
+			return
+		case *tlsProxy:
+			px.serve(client)
+			return
+		}
+	}
+
+	ic.respond(client)
+}
+
+// Generic responder for the ports without a proxy.
+// It records the first message of the client, and sends a banner if the client does not talk first
+func (ic *Interceptor) respond(conn net.Conn) {
+	defer conn.Close()
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(ic.BannerTimeout))
+	n, _ := conn.Read(buf)
+
+	if n == 0 && len(ic.Banner) > 0 {
+		conn.SetWriteDeadline(time.Now().Add(ic.BannerTimeout))
+		conn.Write(ic.Banner)
+
+		// Give the client a chance to answer the banner
+		conn.SetReadDeadline(time.Now().Add(ic.BannerTimeout))
+		n, _ = conn.Read(buf)
+	}
+
+	// The connection is a session of its own, with what the client sent
+	session := uuid.New().String()
+	source := conn.RemoteAddr().String()
+	destination := conn.LocalAddr().String()
+
+	ev := events.NewEvent(events.ConnectionEvent, "", source)
+	ev.Session, ev.Destination = session, destination
+	events.Events.Emit(ev.With("payload", string(buf[:n])))
+
+	ev = events.NewEvent(events.DisconnectionEvent, "", source)
+	ev.Session, ev.Destination = session, destination
+	events.Events.Emit(ev)
+}
+
+// Returns the original destination of a TCP connection
+func socketDestination(conn net.Conn) (*net.TCPAddr, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("not a TCP connection: %s", conn.RemoteAddr())
+	}
+	return originalDestination(tcpConn)
+}
+
+// Create an interceptor listening in the port, that dispatches the connections to the registered proxies
+func NewInterceptor(port int) *Interceptor {
+	return &Interceptor{
+		port:                port,
+		proxies:             Proxies,
+		OriginalDestination: socketDestination,
+		BannerTimeout:       5 * time.Second,
+		Banner:              []byte("\r\n"),
+	}
+}
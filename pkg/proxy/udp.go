@@ -1,17 +1,53 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/riotpot/pkg/events"
 	lr "github.com/riotpot/pkg/logger"
+	"github.com/riotpot/pkg/proxyproto"
 	"github.com/riotpot/pkg/utils"
 )
 
+const (
+	// Time without datagrams after which the session of a client ends
+	udpSessionTimeout = 60 * time.Second
+	// Largest datagram forwarded
+	maxDatagram = 64 << 10
+	// Maximum number of sessions at once, each one holds a socket
+	maxUDPSessions = 1024
+)
+
 type udpProxy struct {
 	*baseProxy
 	listener *net.UDPConn
+
+	mu sync.Mutex
+	// Sessions of the clients, by their address
+	sessions map[string]*udpSession
+}
+
+// Datagrams exchanged between a client and a service. Each session has its own socket
+// to the service, so the answers of the service can be sent back to the client
+type udpSession struct {
+	id     string
+	client *net.UDPAddr
+	// Address the client sent the datagrams to
+	destination string
+
+	mu      sync.Mutex
+	server  *net.UDPConn
+	backend *Backend
+	// Services not tried yet, in order of preference
+	candidates []*Backend
+	// Last datagram of the client, sent again to the next service when one is unreachable
+	last []byte
 }
 
 func (px *udpProxy) Start() (err error) {
@@ -25,55 +61,47 @@ func (px *udpProxy) Start() (err error) {
 	px.quit = make(chan struct{})
 
 	// Get the listener or create a new one
-	client, err := px.GetListener()
+	listener, err := px.GetListener()
 	if err != nil {
 		return
 	}
-	defer client.Close()
 
-	// Add a waiting task
-	var wg sync.WaitGroup
-	wg.Add(1)
+	// Check the health of the services while the proxy runs
+	go px.backends.watch(px.quit)
 
-	srvAddr := net.UDPAddr{
-		Port: px.service.GetPort(),
-	}
+	go px.serve(listener)
+	return
+}
 
-	go func() {
-		defer wg.Done()
+// Stop the proxy and end the sessions of the clients
+func (px *udpProxy) Stop() {
+	px.baseProxy.Stop()
 
-		for {
-			select {
-			case <-px.quit:
-				return
+	if px.listener != nil {
+		px.listener.Close()
+		px.listener = nil
+	}
+}
 
-			default:
-				// Get a connection to the server for each new connection with the client
-				server, servErr := net.DialUDP(utils.UDP.String(), nil, &srvAddr)
-				// If there was an error, close the connection to the server and return
-				if servErr != nil {
-					return
-				}
-				defer server.Close()
-
-				// Add a waiting task
-				wg.Add(1)
-
-				go func() {
-					defer wg.Done()
-					// TODO: Handle the middlewares! they only accept TCP connections
-					// Apply the middlewares to the connection
-					//udpProxy.middlewares.Apply(listener)
-
-					// Handle the connection between the client and the server
-					// NOTE: The handlers will defer the connections
-					px.handle(client, server)
-				}()
-			}
-		}
-	}()
+// The UDP proxies do not read the PROXY protocol header, only an empty list is accepted
+func (px *udpProxy) SetTrustedUpstreams(cidrs []string) ([]string, error) {
+	policy, err := proxyproto.NewPolicy(cidrs)
+	if err != nil {
+		return nil, err
+	}
 
-	return
+	if policy.IsEnabled() {
+		return nil, fmt.Errorf("the UDP proxies do not accept the PROXY protocol")
+	}
+	return px.baseProxy.SetTrustedUpstreams(nil)
+}
+
+// The UDP proxies do not identify the scanners, no policy is accepted
+func (px *udpProxy) SetScannerPolicy(policy *ScannerPolicy) (*ScannerPolicy, error) {
+	if policy != nil {
+		return nil, fmt.Errorf("the UDP proxies do not accept a scanner policy")
+	}
+	return px.baseProxy.SetScannerPolicy(nil)
 }
 
 // Get or create a new listener
@@ -82,9 +110,8 @@ func (px *udpProxy) GetListener() (listener *net.UDPConn, err error) {
 
 	// Check if there is a listener
 	if listener == nil || px.IsRunning() != utils.RunningStatus {
-		// Get the address of the UDP server
 		addr := net.UDPAddr{
-			Port: px.service.GetPort(),
+			Port: px.GetPort(),
 		}
 
 		listener, err = net.ListenUDP(utils.UDP.String(), &addr)
@@ -98,40 +125,210 @@ func (px *udpProxy) GetListener() (listener *net.UDPConn, err error) {
 	return
 }
 
-// TODO: Test this function
-// UDP asynchronous tunnel
-func (px *udpProxy) handle(client *net.UDPConn, server *net.UDPConn) {
-	var buf [2 << 10]byte
-	var wg sync.WaitGroup
-	wg.Add(2)
+// Forward the datagrams of the clients to their sessions until the listener is closed
+func (px *udpProxy) serve(listener *net.UDPConn) {
+	defer px.closeSessions()
 
-	// Function to copy messages from one pipe to the other
-	var handle = func(from *net.UDPConn, to *net.UDPConn) {
-		n, addr, err := from.ReadFrom(buf[0:])
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := listener.ReadFromUDP(buf)
 		if err != nil {
-			lr.Log.Warn().Err(err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
 		}
 
-		_, err = to.WriteTo(buf[:n], addr)
+		s, err := px.session(listener, addr)
 		if err != nil {
-			lr.Log.Warn().Err(err)
+			lr.Log.Warn().Err(err).Msg("Could not connect to any service")
+			px.reject(backendRejection)
+			continue
+		}
+
+		px.forward(s, append([]byte{}, buf[:n]...))
+	}
+}
+
+// Returns the session of the client, or a new one connected to the preferred service
+func (px *udpProxy) session(listener *net.UDPConn, client *net.UDPAddr) (s *udpSession, err error) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+
+	if s, ok := px.sessions[client.String()]; ok {
+		return s, nil
+	}
+
+	// The source of the datagrams is easily spoofed, the sessions can not grow forever
+	if len(px.sessions) >= maxUDPSessions {
+		return nil, fmt.Errorf("too many sessions")
+	}
+
+	s = &udpSession{
+		id:          uuid.New().String(),
+		client:      client,
+		destination: listener.LocalAddr().String(),
+		candidates:  px.backends.candidates(client),
+	}
+	if err = px.connect(s); err != nil {
+		return nil, err
+	}
+
+	px.sessions[client.String()] = s
+	connectionsAccepted.With(px.metricLabels()...).Inc()
+	activeSessions.With(px.metricLabels()...).Inc()
+	px.emit(events.ConnectionEvent, s)
+
+	go px.reply(listener, s)
+	return
+}
+
+// Connect the session to the next service it has not tried yet
+func (px *udpProxy) connect(s *udpSession) (err error) {
+	if s.backend != nil {
+		s.server.Close()
+		s.backend.connections.Add(-1)
+		s.server, s.backend = nil, nil
+	}
+
+	err = fmt.Errorf("no services available")
+	for len(s.candidates) > 0 {
+		backend := s.candidates[0]
+		s.candidates = s.candidates[1:]
+
+		addr, rerr := net.ResolveUDPAddr(utils.UDP.String(), backend.GetService().GetAddress())
+		if rerr != nil {
+			err = rerr
+			continue
+		}
+
+		server, derr := net.DialUDP(utils.UDP.String(), nil, addr)
+		if derr != nil {
+			err = derr
+			continue
+		}
+
+		s.server, s.backend = server, backend
+		backend.connections.Add(1)
+		px.backends.pin(s.client, backend)
+		return nil
+	}
+	return
+}
+
+// Send a datagram of the client to the service of the session
+func (px *udpProxy) forward(s *udpSession, datagram []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.server == nil {
+		return
+	}
+
+	s.last = datagram
+	s.server.SetReadDeadline(time.Now().Add(udpSessionTimeout))
+	if n, err := s.server.Write(datagram); err == nil {
+		bytesForwarded.With(px.metricLabels("in")...).Add(float64(n))
+	}
+}
+
+// Send the answers of the service back to the client until the session ends.
+// The client moves to the next service when the service is unreachable
+func (px *udpProxy) reply(listener *net.UDPConn, s *udpSession) {
+	defer px.endSession(s)
+
+	buf := make([]byte, maxDatagram)
+	for {
+		s.mu.Lock()
+		server := s.server
+		s.mu.Unlock()
+		if server == nil {
+			return
+		}
+
+		n, err := server.Read(buf)
+		if err == nil {
+			n, _ = listener.WriteToUDP(buf[:n], s.client)
+			bytesForwarded.With(px.metricLabels("out")...).Add(float64(n))
+			continue
+		}
+
+		// The port of the service is closed, the datagram is sent again to the next service
+		if !errors.Is(err, syscall.ECONNREFUSED) || !px.failover(s) {
+			return
 		}
 	}
+}
 
-	defer client.Close()
-	defer server.Close()
+// Move the session to the next service and send it the last datagram of the client
+func (px *udpProxy) failover(s *udpSession) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	go handle(client, server)
-	go handle(server, client)
+	if s.backend == nil {
+		return false
+	}
+
+	lr.Log.Warn().Str("service", s.backend.GetService().GetName()).Msg("Service unreachable, trying the next one")
+	s.backend.healthy.Store(false)
 
-	// Wait until the forwarding is done
-	wg.Wait()
+	if err := px.connect(s); err != nil {
+		return false
+	}
+
+	s.server.SetReadDeadline(time.Now().Add(udpSessionTimeout))
+	if s.last != nil {
+		s.server.Write(s.last)
+	}
+	return true
+}
+
+// Forget the session and close its socket
+func (px *udpProxy) endSession(s *udpSession) {
+	px.mu.Lock()
+	if px.sessions[s.client.String()] == s {
+		delete(px.sessions, s.client.String())
+		activeSessions.With(px.metricLabels()...).Dec()
+		px.emit(events.DisconnectionEvent, s)
+	}
+	px.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.backend != nil {
+		s.server.Close()
+		s.backend.connections.Add(-1)
+		s.server, s.backend = nil, nil
+	}
+}
+
+// Emit an event of the session, as the TCP proxies do for each connection
+func (px *udpProxy) emit(t events.Type, s *udpSession) {
+	ev := events.NewEvent(t, "", s.client.String())
+	ev.Session, ev.Proxy, ev.Destination = s.id, px.GetID(), s.destination
+	events.Events.Emit(ev.With("network", px.GetNetwork().String()))
+}
+
+// End every session, e.g., when the proxy stops
+func (px *udpProxy) closeSessions() {
+	px.mu.Lock()
+	sessions := make([]*udpSession, 0, len(px.sessions))
+	for _, s := range px.sessions {
+		sessions = append(sessions, s)
+	}
+	px.mu.Unlock()
+
+	for _, s := range sessions {
+		px.endSession(s)
+	}
 }
 
 func NewUDPProxy(port int) (proxy *udpProxy, err error) {
 	// Create a new proxy
 	proxy = &udpProxy{
 		baseProxy: newProxy(port, utils.UDP),
+		sessions:  make(map[string]*udpSession),
 	}
 
 	// Set the port
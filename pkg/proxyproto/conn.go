@@ -0,0 +1,113 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// Connection that reads the PROXY protocol header before any other data.
+// The header is read on the first call to `Read`, `RemoteAddr` or `LocalAddr`,
+// so accepting connections never blocks
+type Conn struct {
+	net.Conn
+
+	reader *bufio.Reader
+	// Maximum time to wait for the header
+	timeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// Read the header once
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+
+		c.header, c.err = Read(c.reader)
+	})
+}
+
+// Returns the header sent with the connection, if any
+func (c *Conn) Header() (*Header, error) {
+	c.readHeader()
+	return c.header, c.err
+}
+
+func (c *Conn) Read(b []byte) (n int, err error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+// Returns the address of the client sent in the header, or the address
+// of the peer when the header does not contain one
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && !c.header.Local {
+		return c.header.Source
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// Returns the address the client connected to according to the header,
+// or the local address when the header does not contain one
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && !c.header.Local {
+		return c.header.Destination
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	return &Conn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}
+}
+
+// Listener that expects the connections to start with a PROXY protocol header.
+// Use it in the services placed behind a proxy that sends the header
+type Listener struct {
+	net.Listener
+
+	// Maximum time to wait for the header of each connection
+	Timeout time.Duration
+	// Whether the next connection carries a header.
+	// The check is done on every connection, so the setting can change while running
+	Enabled func() bool
+}
+
+func (l *Listener) Accept() (conn net.Conn, err error) {
+	conn, err = l.Listener.Accept()
+	if err != nil {
+		return
+	}
+
+	if l.Enabled != nil && !l.Enabled() {
+		return
+	}
+
+	return NewConn(conn, l.Timeout), nil
+}
+
+func NewListener(listener net.Listener, enabled func() bool) *Listener {
+	return &Listener{
+		Listener: listener,
+		Timeout:  5 * time.Second,
+		Enabled:  enabled,
+	}
+}
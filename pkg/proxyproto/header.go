@@ -0,0 +1,309 @@
+/*
+This package implements the HAProxy PROXY protocol (versions 1 and 2).
+It is used to send the address of the attacker to the services behind the proxies,
+and to recover it from the connections made through a proxy.
+
+Specification: https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+*/
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/riotpot/pkg/utils"
+)
+
+var (
+	// Prefix of the version 1 header
+	v1Prefix = []byte("PROXY ")
+	// Signature of the version 2 header
+	v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+	// Error returned when the connection does not start with a header
+	ErrNoHeader = fmt.Errorf("no PROXY protocol header")
+)
+
+const (
+	// Maximum length of a version 1 header, including the CRLF
+	v1MaxLength = 107
+
+	// Version 2 commands
+	v2Local = 0x20
+	v2Proxy = 0x21
+
+	// Version 2 address families and transports
+	v2Unspec = 0x00
+	v2TCP4   = 0x11
+	v2UDP4   = 0x12
+	v2TCP6   = 0x21
+	v2UDP6   = 0x22
+)
+
+// PROXY protocol header
+type Header struct {
+	// Version of the protocol used
+	Version utils.ProxyProtocol
+	// Whether the connection does not carry the addresses of a client.
+	// This is the case for health checks (LOCAL) and unknown protocols (UNKNOWN)
+	Local bool
+	// Address of the client
+	Source net.Addr
+	// Address the client connected to
+	Destination net.Addr
+}
+
+// Returns the network of the header, either TCP or UDP
+func (h *Header) network() utils.Network {
+	if _, ok := h.Source.(*net.UDPAddr); ok {
+		return utils.UDP
+	}
+	return utils.TCP
+}
+
+// Returns the IP and port of an address
+func splitAddr(addr net.Addr) (ip net.IP, port int) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	}
+	return
+}
+
+// Creates an address for the given network
+func joinAddr(network utils.Network, ip net.IP, port int) net.Addr {
+	if network == utils.UDP {
+		return &net.UDPAddr{IP: ip, Port: port}
+	}
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
+// Serialize the header as version 1
+func (h *Header) formatV1() (ret []byte, err error) {
+	srcIP, srcPort := splitAddr(h.Source)
+	dstIP, dstPort := splitAddr(h.Destination)
+
+	// Version 1 only supports TCP
+	if h.Local || srcIP == nil || dstIP == nil || h.network() != utils.TCP {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+
+	family := "TCP4"
+	if srcIP.To4() == nil || dstIP.To4() == nil {
+		family = "TCP6"
+		srcIP, dstIP = srcIP.To16(), dstIP.To16()
+	} else {
+		srcIP, dstIP = srcIP.To4(), dstIP.To4()
+	}
+
+	ret = []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, srcPort, dstPort))
+	return
+}
+
+// Serialize the header as version 2
+func (h *Header) formatV2() (ret []byte, err error) {
+	buf := bytes.NewBuffer(append([]byte{}, v2Signature...))
+
+	srcIP, srcPort := splitAddr(h.Source)
+	dstIP, dstPort := splitAddr(h.Destination)
+
+	if h.Local || srcIP == nil || dstIP == nil {
+		buf.Write([]byte{v2Local, v2Unspec, 0x00, 0x00})
+		return buf.Bytes(), nil
+	}
+
+	var (
+		family byte
+		addrs  []byte
+	)
+
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		family = v2TCP4
+		addrs = append(append(addrs, src4...), dst4...)
+	} else {
+		family = v2TCP6
+		addrs = append(append(addrs, srcIP.To16()...), dstIP.To16()...)
+	}
+
+	if h.network() == utils.UDP {
+		family++
+	}
+
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, uint16(srcPort))
+	binary.BigEndian.PutUint16(ports[2:], uint16(dstPort))
+	addrs = append(addrs, ports...)
+
+	buf.Write([]byte{v2Proxy, family})
+	binary.Write(buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+
+	return buf.Bytes(), nil
+}
+
+// Serialize the header in its version
+func (h *Header) Format() (ret []byte, err error) {
+	switch h.Version {
+	case utils.ProxyProtocolV1:
+		return h.formatV1()
+	case utils.ProxyProtocolV2:
+		return h.formatV2()
+	}
+
+	err = fmt.Errorf("invalid PROXY protocol version: %s", h.Version.String())
+	return
+}
+
+// Write the header to the writer, e.g., a connection to a service
+func (h *Header) WriteTo(w io.Writer) (n int64, err error) {
+	content, err := h.Format()
+	if err != nil {
+		return
+	}
+
+	written, err := w.Write(content)
+	return int64(written), err
+}
+
+// Parse a version 1 header
+func readV1(br *bufio.Reader) (h *Header, err error) {
+	line := make([]byte, 0, v1MaxLength)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= v1MaxLength {
+			return nil, fmt.Errorf("PROXY protocol header too long")
+		}
+
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	h = &Header{Version: utils.ProxyProtocolV1}
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		h.Local = true
+		return
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol header: %q", line)
+	}
+
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || srcErr != nil || dstErr != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol addresses: %q", line)
+	}
+
+	h.Source = joinAddr(utils.TCP, srcIP, int(srcPort))
+	h.Destination = joinAddr(utils.TCP, dstIP, int(dstPort))
+	return
+}
+
+// Parse a version 2 header
+func readV2(br *bufio.Reader) (h *Header, err error) {
+	fixed := make([]byte, 16)
+	if _, err = io.ReadFull(br, fixed); err != nil {
+		return
+	}
+
+	if !bytes.Equal(fixed[:12], v2Signature) || fixed[12]>>4 != 0x2 {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 signature")
+	}
+
+	// The payload contains the addresses followed by optional TLVs, which are ignored
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err = io.ReadFull(br, payload); err != nil {
+		return
+	}
+
+	h = &Header{Version: utils.ProxyProtocolV2}
+
+	command, family := fixed[12], fixed[13]
+	if command == v2Local {
+		h.Local = true
+		return
+	}
+
+	if command != v2Proxy {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 command: %x", command)
+	}
+
+	var size int
+	network := utils.TCP
+
+	switch family {
+	case v2TCP4, v2UDP4:
+		size = net.IPv4len
+	case v2TCP6, v2UDP6:
+		size = net.IPv6len
+	default:
+		// Unix sockets and unspecified families do not carry addresses we can use
+		h.Local = true
+		return
+	}
+
+	if family == v2UDP4 || family == v2UDP6 {
+		network = utils.UDP
+	}
+
+	if len(payload) < 2*size+4 {
+		return nil, fmt.Errorf("PROXY protocol v2 addresses too short")
+	}
+
+	srcIP := net.IP(payload[:size])
+	dstIP := net.IP(payload[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(payload[2*size:])
+	dstPort := binary.BigEndian.Uint16(payload[2*size+2:])
+
+	h.Source = joinAddr(network, srcIP, int(srcPort))
+	h.Destination = joinAddr(network, dstIP, int(dstPort))
+	return
+}
+
+// Read a header (version 1 or 2) from the beginning of the reader.
+// Returns `ErrNoHeader` when the reader does not start with a header
+func Read(br *bufio.Reader) (h *Header, err error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+
+	switch first[0] {
+	case v1Prefix[0]:
+		prefix, err := br.Peek(len(v1Prefix))
+		if err != nil || !bytes.Equal(prefix, v1Prefix) {
+			return nil, ErrNoHeader
+		}
+		return readV1(br)
+	case v2Signature[0]:
+		signature, err := br.Peek(len(v2Signature))
+		if err != nil || !bytes.Equal(signature, v2Signature) {
+			return nil, ErrNoHeader
+		}
+		return readV2(br)
+	}
+
+	return nil, ErrNoHeader
+}
+
+// Create a new header for the connection made from the source to the destination
+func NewHeader(version utils.ProxyProtocol, source net.Addr, destination net.Addr) *Header {
+	return &Header{
+		Version:     version,
+		Source:      source,
+		Destination: destination,
+	}
+}
@@ -0,0 +1,114 @@
+package proxyproto
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Policy to accept PROXY protocol headers from the upstreams (load balancers, NATs)
+// in front of the proxies. Only the connections from trusted upstreams are parsed,
+// any other connection is considered to come directly from the attacker
+type Policy struct {
+	// Networks allowed to send the header
+	trusted []*net.IPNet
+	// Maximum time to wait for the header
+	Timeout time.Duration
+}
+
+// Returns the trusted networks as strings
+func (p *Policy) GetTrusted() (cidrs []string) {
+	cidrs = []string{}
+	for _, network := range p.trusted {
+		cidrs = append(cidrs, network.String())
+	}
+	return
+}
+
+// Whether the policy parses headers at all
+func (p *Policy) IsEnabled() bool {
+	return len(p.trusted) > 0
+}
+
+// Whether the address belongs to a trusted upstream
+func (p *Policy) Trusts(addr net.Addr) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+
+	for _, network := range p.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read the header of a connection coming from a trusted upstream.
+// The returned connection reports the address of the attacker as the remote address.
+// Connections from trusted upstreams without a valid header are rejected
+func (p *Policy) Accept(conn net.Conn) (ret net.Conn, err error) {
+	if !p.Trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	pc := NewConn(conn, p.Timeout)
+	if _, err = pc.Header(); err != nil {
+		return nil, fmt.Errorf("upstream %s: %w", conn.RemoteAddr().String(), err)
+	}
+
+	return pc, nil
+}
+
+// Parse a list of CIDRs or single IP addresses
+func ParseCIDRs(cidrs []string) (networks []*net.IPNet, err error) {
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		// Single addresses are converted into a network of one address
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address: %s", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, perr := net.ParseCIDR(cidr)
+		if perr != nil {
+			return nil, perr
+		}
+		networks = append(networks, network)
+	}
+
+	return
+}
+
+func NewPolicy(trusted []string) (policy *Policy, err error) {
+	networks, err := ParseCIDRs(trusted)
+	if err != nil {
+		return
+	}
+
+	policy = &Policy{
+		trusted: networks,
+		Timeout: 5 * time.Second,
+	}
+	return
+}
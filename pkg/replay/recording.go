@@ -0,0 +1,93 @@
+/*
+This package implements the replay of recorded attacker sessions against a service
+*/
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type Direction string
+
+const (
+	// Bytes sent by the client (attacker) to the server
+	ClientDirection Direction = "client"
+	// Bytes sent by the server back to the client
+	ServerDirection Direction = "server"
+)
+
+// Piece of a recorded stream.
+// The data is serialized in base64 when stored as JSON
+type Chunk struct {
+	// Who sent the bytes
+	Direction Direction `json:"direction"`
+	// Milliseconds since the beginning of the session
+	Time int64 `json:"time"`
+	// Raw bytes sent
+	Data []byte `json:"data"`
+}
+
+// Offset of the chunk from the beginning of the session
+func (c Chunk) Offset() time.Duration {
+	return time.Duration(c.Time) * time.Millisecond
+}
+
+// Recorded session between an attacker and a service
+type Recording struct {
+	// Name of the service that received the session
+	Service string `json:"service"`
+	// Network used in the session, e.g., "tcp"
+	Network string `json:"network"`
+	// Address of the attacker
+	Source string `json:"source"`
+	// Chunks exchanged, in order
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Returns the chunks sent in the given direction
+func (r *Recording) Filter(direction Direction) (chunks []Chunk) {
+	for _, chunk := range r.Chunks {
+		if chunk.Direction == direction {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return
+}
+
+// Validates the recording before replaying it
+func (r *Recording) Validate() (err error) {
+	var last int64
+	for i, chunk := range r.Chunks {
+		if chunk.Direction != ClientDirection && chunk.Direction != ServerDirection {
+			return fmt.Errorf("chunk %d: invalid direction %q", i, chunk.Direction)
+		}
+
+		if chunk.Time < last {
+			return fmt.Errorf("chunk %d: time goes backwards", i)
+		}
+		last = chunk.Time
+	}
+	return
+}
+
+// Load a recording stored as JSON
+func Load(path string) (rec *Recording, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	rec = &Recording{}
+	if err = json.Unmarshal(content, rec); err != nil {
+		return nil, err
+	}
+
+	if err = rec.Validate(); err != nil {
+		return nil, err
+	}
+
+	return
+}
@@ -0,0 +1,205 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// Maximum size of a response of the service, the rest is not compared
+	maxResponse = 1 << 20
+)
+
+type Timing int8
+
+const (
+	// Respect the original gaps between the client chunks
+	OriginalTiming Timing = iota
+	// Shorten the gaps between client chunks to a maximum delay
+	CompressedTiming
+
+	// Value for the original timing
+	OriginalTimingValue = "original"
+	// Value for the compressed timing
+	CompressedTimingValue = "compressed"
+)
+
+func (t Timing) String() string {
+	switch t {
+	case OriginalTiming:
+		return OriginalTimingValue
+	case CompressedTiming:
+		return CompressedTimingValue
+	}
+
+	return fmt.Sprintf("%d", t)
+}
+
+func ParseTiming(timing string) (t Timing, err error) {
+	switch timing {
+	case OriginalTiming.String():
+		return OriginalTiming, nil
+	case CompressedTiming.String():
+		return CompressedTiming, nil
+	}
+
+	err = fmt.Errorf("invalid timing: %s", timing)
+	return
+}
+
+// Difference between what the recording expected from the server and what it answered
+type Mismatch struct {
+	// Index of the first chunk of the response in the recording
+	Chunk    int
+	Expected []byte
+	Actual   []byte
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("chunk %d:\n- expected: %q\n+ actual:   %q", m.Chunk, m.Expected, m.Actual)
+}
+
+// Result of replaying a recording
+type Result struct {
+	// Number of chunks sent to the service
+	Sent int
+	// Number of responses compared with the recording. The server chunks between
+	// two client chunks are a single response
+	Compared int
+	// Responses that differ from the recording
+	Mismatches []Mismatch
+}
+
+// Whether the service answered exactly as recorded
+func (r *Result) Equal() bool {
+	return len(r.Mismatches) == 0
+}
+
+type Replayer struct {
+	// How to wait between the client chunks
+	Timing Timing
+	// Maximum wait between client chunks when using the compressed timing
+	MaxDelay time.Duration
+	// Time to wait for the service to start each response
+	ReadTimeout time.Duration
+	// Time without data after which the response of the service is complete
+	IdleTimeout time.Duration
+	// Time to wait for the connection to the service
+	DialTimeout time.Duration
+}
+
+// Wait before sending the next client chunk
+func (rp *Replayer) wait(start time.Time, prev time.Duration, chunk Chunk) {
+	switch rp.Timing {
+	case OriginalTiming:
+		time.Sleep(time.Until(start.Add(chunk.Offset())))
+	case CompressedTiming:
+		gap := chunk.Offset() - prev
+		if gap > rp.MaxDelay {
+			gap = rp.MaxDelay
+		}
+		time.Sleep(gap)
+	}
+}
+
+// Read a response of the service, until it stops sending data for the idle timeout
+func (rp *Replayer) read(conn net.Conn) (data []byte, err error) {
+	buf := make([]byte, 4096)
+
+	// The first bytes of the response may take longer than the following ones
+	conn.SetReadDeadline(time.Now().Add(rp.ReadTimeout))
+	for len(data) < maxResponse {
+		var n int
+		n, err = conn.Read(buf)
+		data = append(data, buf[:n]...)
+		if err != nil {
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(rp.IdleTimeout))
+	}
+
+	if len(data) > maxResponse {
+		data = data[:maxResponse]
+	}
+
+	// Running out of time or data ends the response, it is not an error
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return
+}
+
+// Replay the client side of the recording against the address and compare
+// the responses with the server side of the recording
+func (rp *Replayer) Replay(rec *Recording, address string) (res *Result, err error) {
+	network := rec.Network
+	if network == "" {
+		network = "tcp"
+	}
+
+	conn, err := net.DialTimeout(network, address, rp.DialTimeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	res = &Result{}
+	start := time.Now()
+	var prev time.Duration
+
+	for i := 0; i < len(rec.Chunks); i++ {
+		chunk := rec.Chunks[i]
+
+		switch chunk.Direction {
+		case ClientDirection:
+			rp.wait(start, prev, chunk)
+			prev = chunk.Offset()
+
+			if _, err = conn.Write(chunk.Data); err != nil {
+				return
+			}
+			res.Sent++
+
+		case ServerDirection:
+			// The server chunks until the next client chunk are a single response,
+			// the service does not have to split it the same way
+			first := i
+			expected := append([]byte{}, chunk.Data...)
+			for i+1 < len(rec.Chunks) && rec.Chunks[i+1].Direction == ServerDirection {
+				i++
+				expected = append(expected, rec.Chunks[i].Data...)
+			}
+
+			actual, rerr := rp.read(conn)
+			if rerr != nil {
+				return res, rerr
+			}
+			res.Compared++
+
+			if !bytes.Equal(expected, actual) {
+				res.Mismatches = append(res.Mismatches, Mismatch{
+					Chunk:    first,
+					Expected: expected,
+					Actual:   actual,
+				})
+			}
+		}
+	}
+
+	return
+}
+
+func NewReplayer(timing Timing) *Replayer {
+	return &Replayer{
+		Timing:      timing,
+		MaxDelay:    100 * time.Millisecond,
+		ReadTimeout: 2 * time.Second,
+		IdleTimeout: 200 * time.Millisecond,
+		DialTimeout: 1 * time.Second,
+	}
+}
@@ -0,0 +1,56 @@
+package scanners
+
+// Scanners known by default. The list can be replaced with a local file, see Registry.Load
+var Defaults = []Scanner{
+	{
+		Name:    "Shodan",
+		Domains: []string{"shodan.io"},
+	},
+	{
+		Name: "Censys",
+		CIDRs: []string{
+			"162.142.125.0/24",
+			"167.94.138.0/24",
+			"167.94.145.0/24",
+			"167.94.146.0/24",
+			"167.248.133.0/24",
+			"199.45.154.0/24",
+			"199.45.155.0/24",
+			"206.168.34.0/24",
+			"2602:80d:1000::/44",
+		},
+		Domains:    []string{"censys-scanner.com"},
+		Signatures: []string{"CensysInspect"},
+	},
+	{
+		Name:    "Shadowserver",
+		Domains: []string{"shadowserver.org"},
+	},
+	{
+		Name:       "BinaryEdge",
+		Domains:    []string{"binaryedge.ninja"},
+		Signatures: []string{"BinaryEdge"},
+	},
+	{
+		Name:       "Palo Alto Networks Cortex Xpanse",
+		Domains:    []string{"expanse.co"},
+		Signatures: []string{"Expanse, a Palo Alto Networks company"},
+	},
+	{
+		Name:    "Rapid7 Project Sonar",
+		Domains: []string{"sonar.labs.rapid7.com"},
+	},
+	{
+		Name:    "Stretchoid",
+		Domains: []string{"stretchoid.com"},
+	},
+	{
+		Name:       "LeakIX",
+		Signatures: []string{"l9explore", "l9tcpid"},
+	},
+	{
+		Name:       "Internet Measurement",
+		Domains:    []string{"internet-measurement.com"},
+		Signatures: []string{"internet-measurement.com"},
+	},
+}
@@ -0,0 +1,421 @@
+/*
+This package identifies the research scanners that crawl the Internet, e.g., Shodan or Censys,
+so their sessions can be told apart from the ones of the attackers
+*/
+package scanners
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+)
+
+var (
+	// Exportable registry of the known scanners
+	Scanners = NewRegistry()
+)
+
+const (
+	// Tag added to the events of the scanners
+	Tag = "scanner"
+	// Field of the events with the name of the scanner
+	Field = "scanner"
+
+	// Time a reverse DNS lookup is remembered
+	lookupTTL = time.Hour
+	// Time to wait for a reverse DNS lookup
+	lookupTimeout = 500 * time.Millisecond
+	// Maximum number of IPs remembered
+	maxCached = 10_000
+)
+
+// How to recognise a scanner
+type Scanner struct {
+	Name string `json:"name"`
+	// Networks the scanner uses
+	CIDRs []string `json:"cidrs"`
+	// Suffixes of the reverse DNS names of the scanner, e.g., "shodan.io"
+	Domains []string `json:"domains"`
+	// Strings sent by the scanner, e.g., its User-Agent
+	Signatures []string `json:"signatures"`
+}
+
+// Scanner ready to be matched
+type matcher struct {
+	Scanner
+	networks   []*net.IPNet
+	signatures [][]byte
+}
+
+// Whether a reverse DNS name belongs to the scanner
+func (m *matcher) matchName(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, domain := range m.Domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func newMatcher(s Scanner) (m *matcher, err error) {
+	if s.Name == "" {
+		return nil, fmt.Errorf("scanner without name")
+	}
+
+	m = &matcher{Scanner: s}
+	for _, cidr := range s.CIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network of %s: %w", s.Name, err)
+		}
+		m.networks = append(m.networks, network)
+	}
+
+	for _, signature := range s.Signatures {
+		if signature != "" {
+			m.signatures = append(m.signatures, bytes.ToLower([]byte(signature)))
+		}
+	}
+	return
+}
+
+// Result of a reverse DNS lookup
+type lookup struct {
+	scanner string
+	expires time.Time
+}
+
+// Resolver of the DNS names, e.g., net.DefaultResolver
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Registry of the known scanners. It also remembers the IPs identified by their signatures
+// and the reverse DNS lookups, and enriches the events of the scanners
+type Registry struct {
+	mu       sync.RWMutex
+	matchers []*matcher
+
+	// Scanners identified by signature, by IP
+	identified map[string]string
+	// Reverse DNS lookups, by IP
+	lookups map[string]lookup
+	// Lookups in progress, by IP. The connections from the IP share them
+	pending map[string]*pendingLookup
+
+	resolver Resolver
+}
+
+// Reverse DNS lookup in progress. The name of the scanner is set once done is closed
+type pendingLookup struct {
+	done    chan struct{}
+	scanner string
+}
+
+func (r *Registry) GetName() string {
+	return "scanners"
+}
+
+// Replace the known scanners
+func (r *Registry) SetScanners(scanners []Scanner) (err error) {
+	matchers := make([]*matcher, 0, len(scanners))
+	for _, s := range scanners {
+		m, err := newMatcher(s)
+		if err != nil {
+			return err
+		}
+		matchers = append(matchers, m)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.matchers = matchers
+	r.identified = make(map[string]string)
+	r.lookups = make(map[string]lookup)
+	return
+}
+
+// Returns the known scanners
+func (r *Registry) GetScanners() []Scanner {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	scanners := make([]Scanner, 0, len(r.matchers))
+	for _, m := range r.matchers {
+		scanners = append(scanners, m.Scanner)
+	}
+	return scanners
+}
+
+// Replace the known scanners with the ones of a JSON file
+func (r *Registry) Load(path string) (err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	var scanners []Scanner
+	if err = json.Unmarshal(data, &scanners); err != nil {
+		return fmt.Errorf("invalid scanners file: %w", err)
+	}
+
+	return r.SetScanners(scanners)
+}
+
+// Set the function used for the reverse DNS lookups. Use nil to disable them
+func (r *Registry) SetResolver(resolver Resolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.resolver = resolver
+	r.lookups = make(map[string]lookup)
+}
+
+// Returns the name of the scanner that uses the IP, or an empty string.
+// The reverse DNS name of the IP is looked up when it is not known yet
+func (r *Registry) Identify(ip string) string {
+	name, known := r.identify(ip)
+	if known {
+		return name
+	}
+
+	// The IP is not identified while too many lookups are in progress
+	l := r.lookup(ip)
+	if l == nil {
+		return ""
+	}
+
+	<-l.done
+	return l.scanner
+}
+
+// Same as Identify, without waiting for the reverse DNS lookup. The lookup is done in the
+// background, so the next connections from the IP are identified
+func (r *Registry) IdentifyNow(ip string) string {
+	name, known := r.identify(ip)
+	if known {
+		return name
+	}
+
+	r.lookup(ip)
+	return ""
+}
+
+// Look up the IP in the background, unless it is already being looked up.
+// Returns nil when too many lookups are in progress
+func (r *Registry) lookup(ip string) *pendingLookup {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if l, ok := r.pending[ip]; ok {
+		return l
+	}
+	if len(r.pending) >= maxCached {
+		return nil
+	}
+
+	l := &pendingLookup{done: make(chan struct{})}
+	r.pending[ip] = l
+	go func() {
+		l.scanner = r.reverse(ip)
+
+		r.mu.Lock()
+		delete(r.pending, ip)
+		r.mu.Unlock()
+		close(l.done)
+	}()
+	return l
+}
+
+// Identify the IP without the reverse DNS lookup. Returns whether the IP was known
+func (r *Registry) identify(ip string) (name string, known bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", true
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if name, ok := r.identified[ip]; ok {
+		return name, true
+	}
+
+	for _, m := range r.matchers {
+		for _, network := range m.networks {
+			if network.Contains(parsed) {
+				return m.Name, true
+			}
+		}
+	}
+
+	if r.resolver == nil {
+		return "", true
+	}
+
+	if l, ok := r.lookups[ip]; ok && time.Now().Before(l.expires) {
+		return l.scanner, true
+	}
+	return "", false
+}
+
+// Look up the reverse DNS names of the IP and match them with the domains of the scanners.
+// The names must resolve to the IP, anyone can set the reverse DNS name of their IPs
+func (r *Registry) reverse(ip string) (name string) {
+	r.mu.RLock()
+	resolver := r.resolver
+	r.mu.RUnlock()
+
+	if resolver == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	// Failed lookups are remembered too, so the IP is not looked up again for a while
+	names, _ := resolver.LookupAddr(ctx, ip)
+
+	r.mu.RLock()
+	matchers := r.matchers
+	r.mu.RUnlock()
+
+	for _, n := range names {
+		for _, m := range matchers {
+			if m.matchName(n) && confirm(ctx, resolver, n, ip) {
+				name = m.Name
+				break
+			}
+		}
+		if name != "" {
+			break
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.lookups) >= maxCached {
+		r.lookups = make(map[string]lookup)
+	}
+	r.lookups[ip] = lookup{scanner: name, expires: time.Now().Add(lookupTTL)}
+	return
+}
+
+// Returns the name of the scanner whose signature is in the data, or an empty string
+func (r *Registry) Match(data []byte) string {
+	data = bytes.ToLower(data)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, m := range r.matchers {
+		for _, signature := range m.signatures {
+			if bytes.Contains(data, signature) {
+				return m.Name
+			}
+		}
+	}
+	return ""
+}
+
+// Remember the scanner that uses an IP
+func (r *Registry) Remember(ip string, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.identified) >= maxCached {
+		r.identified = make(map[string]string)
+	}
+	r.identified[ip] = name
+}
+
+// Tag the events of the scanners. The events are identified by their source and
+// by the signatures in their fields, e.g., the commands or the client versions.
+// The reverse DNS names are only used once known, to avoid blocking the events
+func (r *Registry) Enrich(ev *events.Event) {
+	if ev.GetString(Field) != "" {
+		return
+	}
+
+	ip := host(ev.Source)
+
+	name := r.IdentifyNow(ip)
+	if name == "" {
+		for _, value := range ev.Fields {
+			if s, ok := value.(string); ok && s != "" {
+				if name = r.Match([]byte(s)); name != "" {
+					r.Remember(ip, name)
+					break
+				}
+			}
+		}
+	}
+
+	if name != "" {
+		Annotate(ev, name)
+	}
+}
+
+// Add the scanner to an event
+func Annotate(ev *events.Event, name string) *events.Event {
+	ev.With(Field, name)
+	for _, tag := range ev.Tags {
+		if tag == Tag {
+			return ev
+		}
+	}
+	ev.Tags = append(ev.Tags, Tag)
+	return ev
+}
+
+// Create a registry with the default scanners, using the system resolver
+func NewRegistry() *Registry {
+	r := &Registry{
+		resolver: net.DefaultResolver,
+		pending:  make(map[string]*pendingLookup),
+	}
+	r.SetScanners(Defaults)
+	return r
+}
+
+// Whether the name resolves to the IP
+func confirm(ctx context.Context, resolver Resolver, name string, ip string) bool {
+	addrs, err := resolver.LookupHost(ctx, name)
+	if err != nil {
+		return false
+	}
+
+	parsed := net.ParseIP(ip)
+	for _, addr := range addrs {
+		if parsed.Equal(net.ParseIP(addr)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the IP of an address
+func host(addr string) string {
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return h
+}
+
+func init() {
+	events.Events.AddEnricher(Scanners)
+}
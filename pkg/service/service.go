@@ -22,6 +22,7 @@ type Service interface {
 	GetPort() int
 	GetAddress() string
 	GetHost() string
+	GetProxyProtocol() utils.ProxyProtocol
 	IsLocked() bool
 
 	// Setters
@@ -29,6 +30,7 @@ type Service interface {
 	SetName(name string)
 	SetHost(host string)
 	SetLocked(locked bool) (bool, error)
+	SetProxyProtocol(version utils.ProxyProtocol) utils.ProxyProtocol
 }
 
 // Implements a mixin service that can be used as a base for any other service `struct` type.
@@ -43,6 +45,9 @@ type service struct {
 	host        string
 	locked      bool
 	interaction utils.Interaction
+	// Version of the PROXY protocol header sent to the service by the proxies.
+	// Only TCP proxies send the header
+	proxyProtocol utils.ProxyProtocol
 }
 
 // Getters
@@ -74,6 +79,10 @@ func (as *service) GetAddress() string {
 	return fmt.Sprintf("%s:%d", as.host, as.port)
 }
 
+func (as *service) GetProxyProtocol() utils.ProxyProtocol {
+	return as.proxyProtocol
+}
+
 func (as *service) IsLocked() bool {
 	return as.locked
 }
@@ -103,6 +112,11 @@ func (as *service) SetLocked(locked bool) (bool, error) {
 	return as.locked, nil
 }
 
+func (as *service) SetProxyProtocol(version utils.ProxyProtocol) utils.ProxyProtocol {
+	as.proxyProtocol = version
+	return as.proxyProtocol
+}
+
 // Implementation of a plugin-based service
 // These services are stored localy as binary files that are mounted into the
 // application as symbols that can be called
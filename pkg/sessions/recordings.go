@@ -0,0 +1,155 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// Exportable store with the terminals recorded in the sessions
+	Recordings = NewRecordings(maxRecordings, maxRecordingSize)
+)
+
+const (
+	// Maximum number of recordings kept, the oldest are forgotten first
+	maxRecordings = 1_000
+	// Maximum size of a recording, the rest of the session is not recorded
+	maxRecordingSize = 256 << 10
+
+	// Kinds of the events of a recording
+	OutputKind = "o"
+	InputKind  = "i"
+)
+
+// Header of an asciicast v2 file, see https://docs.asciinema.org/manual/asciicast/v2/
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Terminal of a session in the asciicast v2 format: a header, followed by a line for each
+// input or output, e.g., `[1.250000, "i", "uname -a\r\n"]`
+type Recording struct {
+	mu sync.Mutex
+
+	start time.Time
+	buf   bytes.Buffer
+	max   int
+	// Whether the size of the recording reached the maximum
+	full bool
+}
+
+// Add the bytes read from or written to the terminal, with the time since the start
+func (r *Recording) Write(kind string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.full {
+		return
+	}
+
+	// The invalid UTF-8 sequences are replaced, the players only accept text
+	text, _ := json.Marshal(string(data))
+	elapsed := strconv.FormatFloat(time.Since(r.start).Seconds(), 'f', 6, 64)
+	line := fmt.Sprintf("[%s, %q, %s]\n", elapsed, kind, text)
+
+	if r.buf.Len()+len(line) > r.max {
+		r.full = true
+		return
+	}
+	r.buf.WriteString(line)
+}
+
+// Returns the content of the recording
+func (r *Recording) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]byte{}, r.buf.Bytes()...)
+}
+
+// Store of the recordings, by the ID of the session
+type RecordingStore struct {
+	mu sync.RWMutex
+
+	recordings map[string]*Recording
+	// IDs of the sessions recorded, the oldest first
+	order []string
+	max   int
+	size  int
+}
+
+// Returns the recording of a session, created with the size of the terminal if it does not
+// exist. The shells opened in the same session, e.g., two SSH channels, share the recording
+func (st *RecordingStore) Record(id string, width int, height int, env map[string]string) *Recording {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if r, ok := st.recordings[id]; ok {
+		return r
+	}
+
+	// Forget the oldest recording when the store is full
+	if len(st.order) >= st.max {
+		delete(st.recordings, st.order[0])
+		st.order = st.order[1:]
+	}
+
+	r := &Recording{start: time.Now(), max: st.size}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     id,
+		Env:       env,
+	})
+	r.buf.Write(header)
+	r.buf.WriteByte('\n')
+
+	st.recordings[id] = r
+	st.order = append(st.order, id)
+	return r
+}
+
+// Get the asciicast of a session by ID
+func (st *RecordingStore) GetRecording(id string) (cast []byte, err error) {
+	st.mu.RLock()
+	r, ok := st.recordings[id]
+	st.mu.RUnlock()
+
+	if !ok {
+		err = fmt.Errorf("recording not found: %s", id)
+		return
+	}
+
+	return r.Bytes(), nil
+}
+
+// Forget every recording
+func (st *RecordingStore) Reset() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.recordings = make(map[string]*Recording)
+	st.order = []string{}
+}
+
+// Create a store that keeps up to a maximum number of recordings, each one of a maximum size
+func NewRecordings(max int, size int) *RecordingStore {
+	st := &RecordingStore{max: max, size: size}
+	st.Reset()
+	return st
+}
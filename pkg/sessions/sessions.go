@@ -0,0 +1,321 @@
+/*
+This package keeps the sessions of the attackers, built from the events emitted by the
+proxies and the services
+*/
+package sessions
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/geoip"
+)
+
+var (
+	// Exportable store with the sessions seen
+	Sessions = NewStore(maxSessions)
+)
+
+const (
+	// Maximum number of sessions kept, the oldest are forgotten first
+	maxSessions = 10_000
+	// Maximum number of commands kept in a session
+	maxCommands = 1_000
+)
+
+// URLs in the commands, e.g., the files downloaded with wget or curl
+var urlRegex = regexp.MustCompile(`(?i)\b(?:https?|ftp|tftp)://[^\s'"<>;|&` + "`" + `]+`)
+
+// Credentials used to authenticate in a service
+type Credential struct {
+	Service  string `json:"service"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Success  bool   `json:"success"`
+}
+
+// File seen in a session, identified by its hashes
+type File struct {
+	Name   string `json:"name,omitempty"`
+	MD5    string `json:"md5,omitempty"`
+	SHA1   string `json:"sha1,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Interaction of an attacker with a proxy and the services behind it
+type Session struct {
+	ID    string `json:"id"`
+	Proxy string `json:"proxy,omitempty"`
+	// Services that emitted events in the session
+	Services []string `json:"services"`
+	// Address of the attacker
+	Source string `json:"source"`
+	// Address the attacker connected to
+	Destination string `json:"destination,omitempty"`
+	// Transport protocol of the proxy, e.g., "tcp" or "udp"
+	Network string `json:"network,omitempty"`
+
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Whether the attacker disconnected
+	Closed bool `json:"closed"`
+
+	Credentials []Credential `json:"credentials"`
+	Commands    []string     `json:"commands"`
+	// URLs found in the commands
+	URLs  []string `json:"urls"`
+	Files []File   `json:"files"`
+	// Fingerprints of the client, by type, e.g., "ja3_hash" or "hassh"
+	Fingerprints map[string]string `json:"fingerprints"`
+	// Location of the source, when the GeoIP databases are configured
+	Location geoip.Location `json:"location"`
+	// Name of the research scanner that opened the session, if it is a known one
+	Scanner string   `json:"scanner,omitempty"`
+	Tags    []string `json:"tags"`
+
+	// Number of events in the session
+	Events int `json:"events"`
+}
+
+// Returns the IP of the attacker
+func (s *Session) GetSourceIP() string {
+	return host(s.Source)
+}
+
+// Update the session with an event
+func (s *Session) add(ev *events.Event) {
+	if s.Events == 0 || ev.Time.Before(s.Start) {
+		s.Start = ev.Time
+	}
+	if ev.Time.After(s.End) {
+		s.End = ev.Time
+	}
+	s.Events++
+
+	if s.Proxy == "" {
+		s.Proxy = ev.Proxy
+	}
+	if s.Source == "" {
+		s.Source = ev.Source
+	}
+	if s.Destination == "" {
+		s.Destination = ev.Destination
+	}
+	if network := ev.GetString("network"); s.Network == "" {
+		s.Network = network
+	}
+	if ev.Service != "" {
+		s.Services = appendUnique(s.Services, ev.Service)
+	}
+	for _, tag := range ev.Tags {
+		s.Tags = appendUnique(s.Tags, tag)
+	}
+
+	switch ev.Type {
+	case events.DisconnectionEvent:
+		s.Closed = true
+	case events.AuthEvent:
+		s.Credentials = append(s.Credentials, Credential{
+			Service:  ev.Service,
+			User:     ev.GetString("user"),
+			Password: ev.GetString("password"),
+			Success:  ev.GetBool("success"),
+		})
+	case events.CommandEvent:
+		command := ev.GetString("command")
+		if command != "" && len(s.Commands) < maxCommands {
+			s.Commands = append(s.Commands, command)
+		}
+		for _, u := range FindURLs(command) {
+			s.URLs = appendUnique(s.URLs, u)
+		}
+	}
+
+	// Fingerprints of the client, set in the connection and fingerprint events
+	for _, key := range []string{"ja3_hash", "ja4", "hassh", "ssh_client_version"} {
+		if value := ev.GetString(key); value != "" {
+			s.Fingerprints[key] = value
+		}
+	}
+
+	if loc := geoip.FromEvent(ev); !loc.IsEmpty() {
+		s.Location = loc
+	}
+
+	if scanner := ev.GetString("scanner"); scanner != "" {
+		s.Scanner = scanner
+	}
+
+	// Files captured or uploaded, identified by their hashes
+	file := File{
+		Name:   ev.GetString("filename"),
+		MD5:    ev.GetString("md5"),
+		SHA1:   ev.GetString("sha1"),
+		SHA256: ev.GetString("sha256"),
+	}
+	if file.MD5 != "" || file.SHA1 != "" || file.SHA256 != "" {
+		s.Files = append(s.Files, file)
+	}
+}
+
+// Returns a copy of the session that can be used without locking the store
+func (s *Session) copy() Session {
+	c := *s
+	c.Services = append([]string{}, s.Services...)
+	c.Credentials = append([]Credential{}, s.Credentials...)
+	c.Commands = append([]string{}, s.Commands...)
+	c.URLs = append([]string{}, s.URLs...)
+	c.Files = append([]File{}, s.Files...)
+	c.Tags = append([]string{}, s.Tags...)
+	c.Fingerprints = make(map[string]string, len(s.Fingerprints))
+	for key, value := range s.Fingerprints {
+		c.Fingerprints[key] = value
+	}
+	return c
+}
+
+// Sink that groups the events in sessions
+type Store struct {
+	mu sync.RWMutex
+
+	sessions map[string]*Session
+	// IDs of the sessions, the oldest first
+	order []string
+	max   int
+}
+
+func (st *Store) GetName() string {
+	return "sessions"
+}
+
+// Add the event to its session. Events without a session, e.g., from services
+// that are not behind a proxy, are grouped by their source
+func (st *Store) Send(ev *events.Event) error {
+	id := ev.Session
+	if id == "" {
+		id = ev.Source
+	}
+
+	if id == "" {
+		return nil
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.sessions[id]
+	if !ok {
+		// Forget the oldest session when the store is full
+		if len(st.order) >= st.max {
+			delete(st.sessions, st.order[0])
+			st.order = st.order[1:]
+		}
+
+		s = &Session{
+			ID:           id,
+			Services:     []string{},
+			Credentials:  []Credential{},
+			Commands:     []string{},
+			URLs:         []string{},
+			Files:        []File{},
+			Fingerprints: make(map[string]string),
+			Tags:         []string{},
+		}
+		st.sessions[id] = s
+		st.order = append(st.order, id)
+	}
+
+	s.add(ev)
+	return nil
+}
+
+// Add the events to the store, e.g., the events of a file
+func (st *Store) Load(evs []*events.Event) {
+	for _, ev := range evs {
+		st.Send(ev)
+	}
+}
+
+// Get a session by ID
+func (st *Store) GetSession(id string) (s Session, err error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	session, ok := st.sessions[id]
+	if !ok {
+		err = fmt.Errorf("session not found: %s", id)
+		return
+	}
+
+	return session.copy(), nil
+}
+
+// Get the sessions active since a time, the oldest first. Use the zero time to get all of them
+func (st *Store) GetSessions(since time.Time) (ret []Session) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	ret = []Session{}
+	for _, session := range st.sessions {
+		if session.End.Before(since) {
+			continue
+		}
+		ret = append(ret, session.copy())
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if !ret[i].Start.Equal(ret[j].Start) {
+			return ret[i].Start.Before(ret[j].Start)
+		}
+		return ret[i].ID < ret[j].ID
+	})
+	return
+}
+
+// Forget every session
+func (st *Store) Reset() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.sessions = make(map[string]*Session)
+	st.order = []string{}
+}
+
+// Create a store that keeps up to a maximum number of sessions
+func NewStore(max int) *Store {
+	st := &Store{max: max}
+	st.Reset()
+	return st
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, item := range list {
+		if item == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// Returns the URLs in a command, e.g., the files downloaded with wget or curl
+func FindURLs(command string) []string {
+	return urlRegex.FindAllString(command, -1)
+}
+
+// Returns the IP of an address
+func host(addr string) string {
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return h
+}
+
+func init() {
+	events.Events.Register(Sessions)
+}
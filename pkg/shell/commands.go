@@ -3,50 +3,381 @@ package shell
 import (
 	"fmt"
 	"io"
+	"path"
+	"sort"
 	"strings"
 )
 
+func init() {
+	for name, command := range map[string]Command{
+		"enable": (*Shell).cEnable,
+		"exit":   (*Shell).cExit,
+		"cd":     (*Shell).cCd,
+		"pwd":    (*Shell).cPwd,
+		"ls":     (*Shell).cLs,
+		"cat":    (*Shell).cCat,
+		"echo":   (*Shell).cEcho,
+		"rm":     (*Shell).cRm,
+		"mkdir":  (*Shell).cMkdir,
+		"chmod":  (*Shell).cChmod,
+		"export": (*Shell).cExport,
+		"unset":  (*Shell).cUnset,
+		"true":   (*Shell).cTrue,
+		"false":  (*Shell).cFalse,
+	} {
+		Commands.Register(name, command)
+	}
+}
+
 // Parses any other command
-func cDefault(com string, conn io.Writer) (err error) {
-	_, err = fmt.Fprintf(conn, "command not found: %s\n", com)
-	return
+func (s *Shell) cDefault(args []string, in io.Reader, conn io.Writer) (err error) {
+	fmt.Fprintf(conn, "bash: %s: command not found\n", args[0])
+	return ExitStatus(127)
 }
 
 // Parses the `enable` command.
-func cEnable(com string, conn io.Writer) (err error) {
-	// split the string into 2 parts, after the `enable` string
-	sp := strings.SplitAfter(com, "enable ")
+func (s *Shell) cEnable(args []string, in io.Reader, conn io.Writer) (err error) {
 	rsp := fmt.Sprintf("%s\n%s\n%s\n",
 		"cd",
 		"enable",
 		"exit",
 	)
 
-	if len(sp) > 1 {
+	if len(args) > 1 {
 		// check if the arguments are a flag or an actual command
 		// this doesn't go further than just complaining
-		if strings.HasPrefix(sp[1], "-") {
+		if strings.HasPrefix(args[1], "-") {
 			rsp = "enable: bad option: %s\n"
 		} else {
 			rsp = "enable: no such hash table element: %s\n"
 		}
 
-		rsp = fmt.Sprintf(rsp, sp[1])
+		fmt.Fprintf(conn, rsp, args[1])
+		return ExitStatus(1)
 	}
 
-	_, err = fmt.Fprintf(conn, rsp)
+	_, err = io.WriteString(conn, rsp)
 	return
 }
 
-// Parses the exit command
-func cExit(com string, conn io.ReadWriteCloser) (err error) {
+// Parses the exit command, closing the connection
+func (s *Shell) cExit(args []string, in io.Reader, conn io.Writer) (err error) {
 	_, err = fmt.Fprintf(conn, "bye\n")
-	conn.Close()
+	s.exited = true
+	s.closer.Close()
+	return
+}
+
+// Parses the `export` command, listing the variables without arguments
+func (s *Shell) cExport(args []string, in io.Reader, conn io.Writer) (err error) {
+	if len(args) == 1 {
+		names := make([]string, 0, len(s.Env))
+		for name := range s.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Fprintf(conn, "declare -x %s=%q\n", name, s.Env[name])
+		}
+		return
+	}
+
+	for _, arg := range args[1:] {
+		name, value, set := strings.Cut(arg, "=")
+		switch {
+		case !isName(name):
+			fmt.Fprintf(conn, "bash: export: `%s': not a valid identifier\n", arg)
+			err = ExitStatus(1)
+		case set:
+			s.Env[name] = value
+		}
+	}
+	return
+}
+
+// Parses the `unset` command
+func (s *Shell) cUnset(args []string, in io.Reader, conn io.Writer) (err error) {
+	for _, name := range args[1:] {
+		delete(s.Env, name)
+	}
+	return
+}
+
+// Parses the `true` and `false` commands, that only return their status
+func (s *Shell) cTrue(args []string, in io.Reader, conn io.Writer) (err error) {
+	return
+}
+
+func (s *Shell) cFalse(args []string, in io.Reader, conn io.Writer) (err error) {
+	return ExitStatus(1)
+}
+
+// Parses the `cd` command, going to the home without arguments
+func (s *Shell) cCd(args []string, in io.Reader, conn io.Writer) (err error) {
+	args = args[1:]
+
+	dir := s.Home
+	if len(args) > 0 {
+		dir = s.abs(args[0])
+	}
+
+	f, err := s.FS.Stat(dir)
+	switch {
+	case err != nil:
+		fmt.Fprintf(conn, "bash: cd: %s: %s\n", args[0], err)
+		return ExitStatus(1)
+	case !f.IsDir():
+		fmt.Fprintf(conn, "bash: cd: %s: %s\n", args[0], ErrNotDir)
+		return ExitStatus(1)
+	}
+
+	s.Path = dir
+	return
+}
+
+// Parses the `pwd` command
+func (s *Shell) cPwd(args []string, in io.Reader, conn io.Writer) (err error) {
+	_, err = fmt.Fprintln(conn, s.Path)
+	return
+}
+
+// Parses the `ls` command, with the flags -l and -a
+func (s *Shell) cLs(args []string, in io.Reader, conn io.Writer) (err error) {
+	flags, names := splitFlags(args[1:])
+	long, all := strings.Contains(flags, "l"), strings.Contains(flags, "a")
+
+	if len(names) == 0 {
+		names = []string{"."}
+	}
+
+	// The files are listed first, then the content of the directories
+	files, dirs := []DirEntry{}, []string{}
+	for _, name := range names {
+		f, statErr := s.FS.Stat(s.abs(name))
+		if statErr != nil {
+			fmt.Fprintf(conn, "ls: cannot access '%s': %s\n", name, statErr)
+			err = ExitStatus(2)
+			continue
+		}
+
+		if f.IsDir() {
+			dirs = append(dirs, name)
+		} else {
+			files = append(files, DirEntry{Name: name, File: f})
+		}
+	}
+
+	s.list(conn, files, long, false)
+	for i, name := range dirs {
+		if len(names) > 1 {
+			if len(files) > 0 || i > 0 {
+				fmt.Fprintln(conn)
+			}
+			fmt.Fprintf(conn, "%s:\n", name)
+		}
+
+		entries, _ := s.FS.ReadDir(s.abs(name))
+		if all {
+			self, _ := s.FS.Stat(s.abs(name))
+			parent, _ := s.FS.Stat(path.Dir(s.abs(name)))
+			entries = append([]DirEntry{{Name: ".", File: self}, {Name: "..", File: parent}}, entries...)
+		}
+
+		visible := []DirEntry{}
+		for _, e := range entries {
+			if all || !strings.HasPrefix(e.Name, ".") {
+				visible = append(visible, e)
+			}
+		}
+		s.list(conn, visible, long, true)
+	}
+	return
+}
+
+// Write the entries listed by ls
+func (s *Shell) list(conn io.Writer, entries []DirEntry, long bool, total bool) {
+	if !long {
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		if len(names) > 0 {
+			fmt.Fprintln(conn, strings.Join(names, "  "))
+		}
+		return
+	}
+
+	if total {
+		blocks := int64(0)
+		for _, e := range entries {
+			blocks += (e.File.Size() + 4095) / 4096 * 4
+		}
+		fmt.Fprintf(conn, "total %d\n", blocks)
+	}
+
+	for _, e := range entries {
+		links := 1
+		if e.File.IsDir() {
+			links = 2
+		}
+		fmt.Fprintf(conn, "%s %d root root %5d %s %s\n",
+			formatMode(e.File.Mode), links, e.File.Size(), e.File.ModTime.Format("Jan _2 15:04"), e.Name)
+	}
+}
+
+// Parses the `cat` command, writing the input without files, e.g., in a pipeline
+func (s *Shell) cCat(args []string, in io.Reader, conn io.Writer) (err error) {
+	_, names := splitFlags(args[1:])
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+
+	for _, name := range names {
+		if name == "-" {
+			if in != nil {
+				io.Copy(conn, in)
+			}
+			continue
+		}
+
+		content, readErr := s.FS.ReadFile(s.abs(name))
+		if readErr != nil {
+			fmt.Fprintf(conn, "cat: %s: %s\n", name, readErr)
+			err = ExitStatus(1)
+			continue
+		}
+		conn.Write(content)
+	}
+	return
+}
+
+// Parses the `echo` command, with the flags -n and -e
+func (s *Shell) cEcho(args []string, in io.Reader, conn io.Writer) (err error) {
+	args = args[1:]
+
+	newline, escapes := true, false
+	for len(args) > 0 && len(args[0]) > 1 && strings.Trim(args[0], "-neE") == "" && args[0][0] == '-' {
+		newline = newline && !strings.Contains(args[0], "n")
+		escapes = escapes || strings.Contains(args[0], "e")
+		args = args[1:]
+	}
+
+	out := strings.Join(args, " ")
+	if escapes {
+		out = unescape(out)
+	}
+	if newline {
+		out += "\n"
+	}
+
+	_, err = io.WriteString(conn, out)
+	return
+}
+
+// Parses the `rm` command, with the flags -r and -f
+func (s *Shell) cRm(args []string, in io.Reader, conn io.Writer) (err error) {
+	flags, names := splitFlags(args[1:])
+	recursive, force := strings.ContainsAny(flags, "rR"), strings.Contains(flags, "f")
+
+	if len(names) == 0 && !force {
+		fmt.Fprintf(conn, "rm: missing operand\nTry 'rm --help' for more information.\n")
+		return ExitStatus(1)
+	}
+
+	for _, name := range names {
+		p := s.abs(name)
+		if p == "/" && recursive {
+			fmt.Fprintf(conn, "rm: it is dangerous to operate recursively on '/'\nrm: use --no-preserve-root to override this failsafe\n")
+			err = ExitStatus(1)
+			continue
+		}
+
+		removeErr := s.FS.Remove(p, recursive)
+		if removeErr == ErrPermission {
+			removeErr = fmt.Errorf("Operation not permitted")
+		}
+		if removeErr != nil && !(force && removeErr == ErrNotExist) {
+			fmt.Fprintf(conn, "rm: cannot remove '%s': %s\n", name, removeErr)
+			err = ExitStatus(1)
+		}
+	}
 	return
 }
 
-// parses an attempt to execute a file
-func cExec(com string, conn io.Writer) (err error) {
-	_, err = fmt.Fprintf(conn, "no such file or directory: %s\n", com)
+// Parses the `mkdir` command, with the flag -p
+func (s *Shell) cMkdir(args []string, in io.Reader, conn io.Writer) (err error) {
+	flags, names := splitFlags(args[1:])
+
+	if len(names) == 0 {
+		fmt.Fprintf(conn, "mkdir: missing operand\nTry 'mkdir --help' for more information.\n")
+		return ExitStatus(1)
+	}
+
+	for _, name := range names {
+		if mkdirErr := s.FS.Mkdir(s.abs(name), strings.Contains(flags, "p")); mkdirErr != nil {
+			fmt.Fprintf(conn, "mkdir: cannot create directory '%s': %s\n", name, mkdirErr)
+			err = ExitStatus(1)
+		}
+	}
+	return
+}
+
+// Parses the `chmod` command, with octal (755) or symbolic (+x, u+rwx,go-w) modes
+func (s *Shell) cChmod(args []string, in io.Reader, conn io.Writer) (err error) {
+	_, operands := splitFlags(args[1:])
+	// Symbolic modes may start with a dash, e.g., -x
+	if len(operands) < 2 {
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "-") && strings.Trim(arg, "-rwxXst") == "" {
+				operands = append([]string{arg}, operands...)
+			}
+		}
+	}
+
+	if len(operands) < 2 {
+		fmt.Fprintf(conn, "chmod: missing operand\nTry 'chmod --help' for more information.\n")
+		return ExitStatus(1)
+	}
+
+	for _, name := range operands[1:] {
+		p := s.abs(name)
+		f, statErr := s.FS.Stat(p)
+		if statErr != nil {
+			fmt.Fprintf(conn, "chmod: cannot access '%s': %s\n", name, statErr)
+			err = ExitStatus(1)
+			continue
+		}
+
+		mode, modeErr := changeMode(f.Mode, operands[0])
+		if modeErr != nil {
+			fmt.Fprintf(conn, "chmod: invalid mode: '%s'\nTry 'chmod --help' for more information.\n", operands[0])
+			return ExitStatus(1)
+		}
+
+		if chmodErr := s.FS.Chmod(p, mode); chmodErr != nil {
+			fmt.Fprintf(conn, "chmod: changing permissions of '%s': %s\n", name, chmodErr)
+			err = ExitStatus(1)
+		}
+	}
+	return
+}
+
+// Parses an attempt to execute a file of the filesystem
+func (s *Shell) cExec(args []string, in io.Reader, conn io.Writer) (err error) {
+	name := args[0]
+
+	f, err := s.FS.Stat(s.abs(name))
+	switch {
+	case err != nil:
+		fmt.Fprintf(conn, "bash: %s: %s\n", name, err)
+		return ExitStatus(127)
+	case f.IsDir():
+		fmt.Fprintf(conn, "bash: %s: %s\n", name, ErrIsDir)
+		return ExitStatus(126)
+	case f.Mode&0o111 == 0:
+		fmt.Fprintf(conn, "bash: %s: %s\n", name, ErrPermission)
+		return ExitStatus(126)
+	}
 	return
 }
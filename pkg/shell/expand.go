@@ -0,0 +1,260 @@
+package shell
+
+import (
+	"bytes"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Fields of a word being expanded
+type fields struct {
+	list []string
+	cur  strings.Builder
+	// Whether the current field exists, e.g., an empty string in quotes
+	started bool
+	// Whether the current field has wildcards out of quotes
+	glob  bool
+	globs []bool
+}
+
+func (f *fields) write(s string) {
+	f.cur.WriteString(s)
+	f.started = true
+}
+
+func (f *fields) end() {
+	if f.started {
+		f.list = append(f.list, f.cur.String())
+		f.globs = append(f.globs, f.glob)
+	}
+	f.cur.Reset()
+	f.started, f.glob = false, false
+}
+
+// Add the value of an expansion out of quotes, split in fields by the spaces
+func (f *fields) split(value string) {
+	if value == "" {
+		return
+	}
+
+	if isSpace(value[0]) {
+		f.end()
+	}
+	words := strings.Fields(value)
+	for i, word := range words {
+		if i > 0 {
+			f.end()
+		}
+		f.write(word)
+	}
+	if isSpace(value[len(value)-1]) && len(words) > 0 {
+		f.end()
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// Expand a word in fields: the quotes are removed, and the variables, the commands
+// and the wildcards out of quotes are replaced, e.g., "$(cat /tmp/a)" or "/tmp/*"
+func (s *Shell) expand(word string) []string {
+	f := &fields{}
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch {
+		case c == '\\' && i+1 < len(word):
+			i++
+			f.write(word[i : i+1])
+		case c == '\'':
+			end := strings.IndexByte(word[i+1:], '\'')
+			if end < 0 {
+				// Not closed, the parser rejects it
+				f.write(word[i:])
+				i = len(word)
+				continue
+			}
+			f.write(word[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			end, err := quoteEnd(word, i+1)
+			if err != nil {
+				f.write(word[i:])
+				i = len(word)
+				continue
+			}
+			f.write(s.expandQuoted(word[i+1 : end]))
+			i = end
+		case c == '$' || c == '`':
+			value, end := s.expansion(word, i)
+			if end == i+1 && c == '$' {
+				f.write("$")
+				continue
+			}
+			f.split(value)
+			i = end - 1
+		case c == '~' && i == 0 && (len(word) == 1 || word[1] == '/'):
+			f.write(s.Home)
+		case c == '*' || c == '?' || c == '[':
+			f.glob = true
+			f.write(word[i : i+1])
+		default:
+			f.write(word[i : i+1])
+		}
+	}
+	f.end()
+
+	ret := []string{}
+	for i, field := range f.list {
+		if f.globs[i] {
+			if matches := s.glob(field); len(matches) > 0 {
+				ret = append(ret, matches...)
+				continue
+			}
+		}
+		ret = append(ret, field)
+	}
+	return ret
+}
+
+// Expand a word in one field, e.g., the target of a redirection
+func (s *Shell) expandWord(word string) string {
+	return strings.Join(s.expand(word), " ")
+}
+
+// Expand the content of double quotes, without splitting it
+func (s *Shell) expandQuoted(content string) string {
+	var b strings.Builder
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '\\' && i+1 < len(content) && strings.IndexByte("$`\"\\\n", content[i+1]) >= 0:
+			i++
+			b.WriteByte(content[i])
+		case c == '$' || c == '`':
+			value, end := s.expansion(content, i)
+			if end == i+1 && c == '$' {
+				b.WriteByte('$')
+				continue
+			}
+			b.WriteString(value)
+			i = end - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Returns the value of the expansion that starts at i, and where it ends
+func (s *Shell) expansion(word string, i int) (value string, end int) {
+	end, err := expansionEnd(word, i)
+	if err != nil {
+		return "", len(word)
+	}
+
+	switch {
+	case word[i] == '`':
+		return s.substitute(word[i+1 : end-1]), end
+	case strings.HasPrefix(word[i:], "$(("):
+		// Arithmetic is not supported
+		return "0", end
+	case strings.HasPrefix(word[i:], "$("):
+		return s.substitute(word[i+2 : end-1]), end
+	case strings.HasPrefix(word[i:], "${"):
+		return s.GetVar(word[i+2 : end-1]), end
+	case end == i+2:
+		// Special variable, e.g., "$?"
+		return s.GetVar(word[i+1 : end]), end
+	}
+
+	// Name of a variable, e.g., "$HOME"
+	end = i + 1
+	for end < len(word) && isName(word[i+1:end+1]) {
+		end++
+	}
+	if end == i+1 {
+		return "", end
+	}
+	return s.GetVar(word[i+1 : end]), end
+}
+
+// Returns the output of the commands, without the last line endings
+func (s *Shell) substitute(commands string) string {
+	if s.depth >= maxDepth {
+		return ""
+	}
+
+	s.depth++
+	defer func() { s.depth-- }()
+
+	var out bytes.Buffer
+	s.commands(commands, &out)
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// Returns the value of a variable, including the special ones, e.g., "$?"
+func (s *Shell) GetVar(name string) string {
+	switch name {
+	case "?":
+		return strconv.Itoa(s.status)
+	case "$":
+		return strconv.Itoa(s.pid)
+	case "0":
+		return "-bash"
+	case "#":
+		return "0"
+	case "PWD":
+		return s.Path
+	case "RANDOM":
+		return strconv.Itoa(rand.Intn(32768))
+	}
+	return s.Env[name]
+}
+
+// Returns the paths that match a pattern, sorted, e.g., "/tmp/*.sh"
+func (s *Shell) glob(pattern string) []string {
+	dir := s.Path
+	if path.IsAbs(pattern) {
+		dir = "/"
+	}
+
+	matches := []string{""}
+	if path.IsAbs(pattern) {
+		matches = []string{"/"}
+	}
+
+	for _, part := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		next := []string{}
+		for _, m := range matches {
+			if !strings.ContainsAny(part, "*?[") {
+				if _, err := s.FS.Stat(path.Join(dir, m, part)); err == nil {
+					next = append(next, join(m, part))
+				}
+				continue
+			}
+
+			entries, _ := s.FS.ReadDir(path.Join(dir, m))
+			for _, e := range entries {
+				// The hidden files only match explicitly
+				if strings.HasPrefix(e.Name, ".") && !strings.HasPrefix(part, ".") {
+					continue
+				}
+				if ok, _ := path.Match(part, e.Name); ok {
+					next = append(next, join(m, e.Name))
+				}
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+func join(dir string, name string) string {
+	if dir == "" {
+		return name
+	}
+	return path.Join(dir, name)
+}
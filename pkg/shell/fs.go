@@ -0,0 +1,392 @@
+package shell
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors of the filesystem, with the messages of the system
+var (
+	ErrNotExist   = errors.New("No such file or directory")
+	ErrExist      = errors.New("File exists")
+	ErrIsDir      = errors.New("Is a directory")
+	ErrNotDir     = errors.New("Not a directory")
+	ErrPermission = errors.New("Permission denied")
+	ErrNoSpace    = errors.New("No space left on device")
+)
+
+const (
+	// Maximum number of bytes written by a session, the attackers must not exhaust the memory
+	maxWritten = 8 << 20
+	// Maximum number of files changed by a session
+	maxChanged = 10_000
+)
+
+// Directories of the kernel, the attackers can not change them
+var readOnly = []string{"/proc", "/sys"}
+
+// File or directory of the filesystem
+type File struct {
+	Mode    os.FileMode
+	Content []byte
+	ModTime time.Time
+}
+
+func (f *File) IsDir() bool {
+	return f.Mode.IsDir()
+}
+
+// Returns the size shown for the file, directories use a block
+func (f *File) Size() int64 {
+	if f.IsDir() {
+		return 4096
+	}
+	return int64(len(f.Content))
+}
+
+func (f *File) copy() *File {
+	c := *f
+	c.Content = append([]byte{}, f.Content...)
+	return &c
+}
+
+// Entry of a directory
+type DirEntry struct {
+	Name string
+	File File
+}
+
+// How a file was changed in a session
+type Operation string
+
+const (
+	CreatedOperation  Operation = "created"
+	ModifiedOperation Operation = "modified"
+	RemovedOperation  Operation = "removed"
+)
+
+// File changed in a session
+type Change struct {
+	Path      string      `json:"path"`
+	Operation Operation   `json:"operation"`
+	Mode      os.FileMode `json:"mode"`
+	Size      int64       `json:"size"`
+	// Content of the files created or modified
+	Content []byte `json:"-"`
+}
+
+// Filesystem of a session. The files of the image are shared by all the sessions,
+// and the changes of the attacker are kept apart (copy-on-write) until the session ends
+type FileSystem struct {
+	mu sync.RWMutex
+
+	image *Image
+	// Files added for the session that are not changes of the attacker, e.g., the home
+	seed map[string]*File
+	// Files changed by the attacker, nil when removed
+	upper map[string]*File
+	// Bytes written by the attacker
+	written int64
+}
+
+// Returns a file of the lower layers, the image and the seed
+func (fs *FileSystem) lower(p string) (*File, bool) {
+	if f, ok := fs.seed[p]; ok {
+		return f, true
+	}
+	f, ok := fs.image.files[p]
+	return f, ok
+}
+
+// Returns a file, without copying it
+func (fs *FileSystem) get(p string) (*File, bool) {
+	if f, ok := fs.upper[p]; ok {
+		return f, f != nil
+	}
+	return fs.lower(p)
+}
+
+// Returns a directory, or an error if it is not one
+func (fs *FileSystem) dir(p string) (*File, error) {
+	f, ok := fs.get(p)
+	if !ok {
+		return nil, ErrNotExist
+	}
+	if !f.IsDir() {
+		return nil, ErrNotDir
+	}
+	return f, nil
+}
+
+// Keep a change of the attacker
+func (fs *FileSystem) set(p string, f *File) error {
+	if isReadOnly(p) {
+		return ErrPermission
+	}
+
+	previous := int64(0)
+	if old, ok := fs.upper[p]; ok && old != nil {
+		previous = int64(len(old.Content))
+	} else if !ok && len(fs.upper) >= maxChanged {
+		return ErrNoSpace
+	}
+
+	written := fs.written - previous
+	if f != nil {
+		written += int64(len(f.Content))
+	}
+	if written > maxWritten {
+		return ErrNoSpace
+	}
+
+	fs.written = written
+	fs.upper[p] = f
+	return nil
+}
+
+// Returns the names of the children of a directory, sorted
+func (fs *FileSystem) children(p string) []string {
+	names := make(map[string]bool)
+	add := func(files map[string]*File) {
+		for child := range files {
+			if child != "/" && path.Dir(child) == p {
+				names[path.Base(child)] = true
+			}
+		}
+	}
+	add(fs.image.files)
+	add(fs.seed)
+	add(fs.upper)
+
+	ret := []string{}
+	for name := range names {
+		if _, ok := fs.get(path.Join(p, name)); ok {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Returns a file or directory
+func (fs *FileSystem) Stat(p string) (f File, err error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	file, ok := fs.get(path.Clean(p))
+	if !ok {
+		return f, ErrNotExist
+	}
+	return *file.copy(), nil
+}
+
+// Returns the entries of a directory, sorted by name
+func (fs *FileSystem) ReadDir(p string) (entries []DirEntry, err error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	p = path.Clean(p)
+	if _, err = fs.dir(p); err != nil {
+		return
+	}
+
+	for _, name := range fs.children(p) {
+		f, _ := fs.get(path.Join(p, name))
+		entries = append(entries, DirEntry{Name: name, File: *f})
+	}
+	return
+}
+
+// Returns the content of a file
+func (fs *FileSystem) ReadFile(p string) ([]byte, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	f, ok := fs.get(path.Clean(p))
+	if !ok {
+		return nil, ErrNotExist
+	}
+	if f.IsDir() {
+		return nil, ErrIsDir
+	}
+	return append([]byte{}, f.Content...), nil
+}
+
+// Write a file, creating it if needed. The content is added to the end of the file when appending
+func (fs *FileSystem) WriteFile(p string, data []byte, appending bool) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	p = path.Clean(p)
+	if _, err := fs.dir(path.Dir(p)); err != nil {
+		return err
+	}
+
+	f := &File{Mode: 0o644}
+	if old, ok := fs.get(p); ok {
+		if old.IsDir() {
+			return ErrIsDir
+		}
+		f = old.copy()
+		if !appending {
+			f.Content = []byte{}
+		}
+	}
+
+	f.Content = append(f.Content, data...)
+	f.ModTime = time.Now()
+	return fs.set(p, f)
+}
+
+// Create a directory. The parents are created too when requested
+func (fs *FileSystem) Mkdir(p string, parents bool) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.mkdir(path.Clean(p), parents)
+}
+
+func (fs *FileSystem) mkdir(p string, parents bool) error {
+	if f, ok := fs.get(p); ok {
+		if parents && f.IsDir() {
+			return nil
+		}
+		return ErrExist
+	}
+
+	if _, err := fs.dir(path.Dir(p)); err != nil {
+		if err != ErrNotExist || !parents {
+			return err
+		}
+		if err := fs.mkdir(path.Dir(p), true); err != nil {
+			return err
+		}
+	}
+
+	return fs.set(p, &File{Mode: os.ModeDir | 0o755, ModTime: time.Now()})
+}
+
+// Remove a file, or a directory and its content when recursive
+func (fs *FileSystem) Remove(p string, recursive bool) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.remove(path.Clean(p), recursive)
+}
+
+func (fs *FileSystem) remove(p string, recursive bool) error {
+	f, ok := fs.get(p)
+	if !ok {
+		return ErrNotExist
+	}
+	if isReadOnly(p) || p == "/" {
+		return ErrPermission
+	}
+
+	if f.IsDir() {
+		if !recursive {
+			return ErrIsDir
+		}
+		for _, name := range fs.children(p) {
+			if err := fs.remove(path.Join(p, name), true); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Files that were not in the image are forgotten
+	if _, ok := fs.lower(p); !ok {
+		if old := fs.upper[p]; old != nil {
+			fs.written -= int64(len(old.Content))
+		}
+		delete(fs.upper, p)
+		return nil
+	}
+	return fs.set(p, nil)
+}
+
+// Change the permissions of a file, keeping its type
+func (fs *FileSystem) Chmod(p string, mode os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	p = path.Clean(p)
+	f, ok := fs.get(p)
+	if !ok {
+		return ErrNotExist
+	}
+
+	f = f.copy()
+	f.Mode = f.Mode&os.ModeType | mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)
+	return fs.set(p, f)
+}
+
+// Add a directory for the session that is not a change of the attacker, e.g., the home
+func (fs *FileSystem) seedDir(p string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for p = path.Clean(p); ; p = path.Dir(p) {
+		if _, ok := fs.get(p); ok {
+			return
+		}
+		fs.seed[p] = &File{Mode: os.ModeDir | 0o755, ModTime: fs.image.ModTime}
+	}
+}
+
+// Returns the changes of the attacker, sorted by path
+func (fs *FileSystem) Snapshot() (changes []Change) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	changes = []Change{}
+	for p, f := range fs.upper {
+		old, existed := fs.lower(p)
+
+		c := Change{Path: p}
+		switch {
+		case f == nil:
+			c.Operation, c.Mode = RemovedOperation, old.Mode
+		case !existed:
+			c.Operation = CreatedOperation
+		default:
+			c.Operation = ModifiedOperation
+		}
+
+		if f != nil {
+			c.Mode, c.Size = f.Mode, f.Size()
+			if !f.IsDir() {
+				c.Content = append([]byte{}, f.Content...)
+			}
+		}
+		changes = append(changes, c)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return
+}
+
+// Create the filesystem of a session from an image
+func NewFileSystem(image *Image) *FileSystem {
+	return &FileSystem{
+		image: image,
+		seed:  make(map[string]*File),
+		upper: make(map[string]*File),
+	}
+}
+
+func isReadOnly(p string) bool {
+	for _, dir := range readOnly {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
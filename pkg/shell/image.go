@@ -0,0 +1,289 @@
+package shell
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	imageMu sync.RWMutex
+	// Image of the filesystem of the new shells
+	currentImage = DefaultImage()
+)
+
+// Set the image of the filesystem of the new shells, e.g., the one of a persona
+func SetImage(image *Image) {
+	imageMu.Lock()
+	defer imageMu.Unlock()
+
+	currentImage = image
+}
+
+// Returns the image of the filesystem of the new shells
+func GetImage() *Image {
+	imageMu.RLock()
+	defer imageMu.RUnlock()
+
+	return currentImage
+}
+
+// Identity of the system shown by the commands, e.g., `uname -a` or `busybox`
+type Persona struct {
+	// Name, release and version of the kernel, e.g., "Linux", "5.15.0-72-generic"
+	Kernel  string `json:"kernel"`
+	Release string `json:"release"`
+	Version string `json:"version"`
+	// Hardware name, e.g., "x86_64" or "armv7l"
+	Machine string `json:"machine"`
+	OS      string `json:"os"`
+	// Version of BusyBox, e.g., "v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3)"
+	BusyBox string `json:"busybox"`
+
+	// Credentials accepted by the login of the services, e.g., "root" and "xc3511" for
+	// the Mirai bots. Any credentials are accepted when there are none
+	Logins []Login `json:"logins"`
+	// Failed logins before the connection is closed, and seconds the source is
+	// locked out after them. The sources are not locked out when zero
+	MaxAttempts int `json:"max_attempts"`
+	Lockout     int `json:"lockout"`
+}
+
+// Files of a system, shared by the filesystems of the sessions. It must not change once used
+type Image struct {
+	files map[string]*File
+	// Time of the files of the image, e.g., when the system was installed
+	ModTime time.Time
+	Persona Persona
+}
+
+// Add a file, creating its parent directories
+func (img *Image) Add(p string, mode os.FileMode, content []byte) {
+	p = path.Clean("/" + p)
+	if p != "/" {
+		if parent, ok := img.files[path.Dir(p)]; !ok || !parent.IsDir() {
+			img.Add(path.Dir(p), os.ModeDir|0o755, nil)
+		}
+	}
+
+	img.files[p] = &File{Mode: mode, Content: content, ModTime: img.ModTime}
+}
+
+// Add a directory, creating its parents
+func (img *Image) AddDir(p string, mode os.FileMode) {
+	img.Add(p, os.ModeDir|mode, nil)
+}
+
+// Returns the paths of the image, sorted
+func (img *Image) Paths() []string {
+	paths := make([]string, 0, len(img.files))
+	for p := range img.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// File of an image file
+type ImageFile struct {
+	Path string `json:"path"`
+	// Type of the file, "file" (default) or "dir"
+	Type string `json:"type"`
+	// Permissions in octal, e.g., "0644" or "1777"
+	Mode    string `json:"mode"`
+	Content string `json:"content"`
+}
+
+// Load an image from a JSON file with the list of files, e.g., {"files": [{"path": "/etc/hostname", "content": "router\n"}]},
+// and the persona. The values of the persona not given are the ones of the default image
+func LoadImage(p string) (img *Image, err error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return
+	}
+
+	var file struct {
+		Persona Persona     `json:"persona"`
+		Files   []ImageFile `json:"files"`
+	}
+	file.Persona = DefaultPersona()
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid image file: %w", err)
+	}
+
+	img = NewImage(time.Now())
+	img.Persona = file.Persona
+	for _, f := range file.Files {
+		if f.Path == "" {
+			return nil, fmt.Errorf("image file without path")
+		}
+
+		mode := os.FileMode(0o644)
+		if f.Type == "dir" {
+			mode = 0o755
+		}
+		if f.Mode != "" {
+			if mode, err = ParseMode(f.Mode); err != nil {
+				return nil, fmt.Errorf("invalid mode of %s: %w", f.Path, err)
+			}
+		}
+
+		switch f.Type {
+		case "", "file":
+			img.Add(f.Path, mode, []byte(f.Content))
+		case "dir":
+			img.AddDir(f.Path, mode)
+		default:
+			return nil, fmt.Errorf("invalid type of %s: %s", f.Path, f.Type)
+		}
+	}
+	return
+}
+
+// Parse permissions in octal, e.g., "755" or "1777"
+func ParseMode(s string) (mode os.FileMode, err error) {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0o7777 {
+		return 0, fmt.Errorf("invalid mode: %s", s)
+	}
+
+	mode = os.FileMode(v & 0o777)
+	if v&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if v&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if v&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return
+}
+
+// Create an image with the root directory and the default persona
+func NewImage(modTime time.Time) *Image {
+	img := &Image{files: make(map[string]*File), ModTime: modTime, Persona: DefaultPersona()}
+	img.AddDir("/", 0o755)
+	return img
+}
+
+// Returns the persona of an Ubuntu server
+func DefaultPersona() Persona {
+	return Persona{
+		Kernel:  "Linux",
+		Release: "5.15.0-72-generic",
+		Version: "#79-Ubuntu SMP Wed Apr 19 08:22:18 UTC 2023",
+		Machine: "x86_64",
+		OS:      "GNU/Linux",
+		BusyBox: "v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3)",
+
+		MaxAttempts: 3,
+	}
+}
+
+// Header of the binaries of the image
+var elfHeader = []byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03\x00\x3e\x00\x01\x00\x00\x00")
+
+// Create the image of an Ubuntu server
+func DefaultImage() *Image {
+	img := NewImage(time.Date(2023, time.April, 21, 9, 14, 0, 0, time.UTC))
+
+	for _, dir := range []string{
+		"/bin", "/boot", "/dev", "/etc/ssh", "/etc/cron.d", "/etc/init.d", "/home", "/lib", "/media",
+		"/mnt", "/opt", "/run", "/sbin", "/srv", "/usr/bin", "/usr/sbin", "/usr/lib",
+		"/usr/local/bin", "/usr/share", "/var/lib", "/var/log", "/var/mail", "/var/spool/cron", "/var/www/html",
+	} {
+		img.AddDir(dir, 0o755)
+	}
+	img.AddDir("/root", 0o700)
+	img.AddDir("/root/.ssh", 0o700)
+	img.AddDir("/tmp", 0o777|os.ModeSticky)
+	img.AddDir("/var/tmp", 0o777|os.ModeSticky)
+	img.AddDir("/dev/shm", 0o777|os.ModeSticky)
+	img.AddDir("/sys/class", 0o555)
+	img.AddDir("/proc/self", 0o555)
+
+	for _, bin := range []string{"bash", "busybox", "cat", "chmod", "cp", "echo", "hostname", "ls", "mkdir", "mv", "ps", "pwd", "rm", "sh", "touch", "uname"} {
+		img.Add("/bin/"+bin, 0o755, elfHeader)
+	}
+	for _, bin := range []string{"curl", "id", "nproc", "perl", "python3", "wget", "whoami"} {
+		img.Add("/usr/bin/"+bin, 0o755, elfHeader)
+	}
+
+	for p, content := range map[string]string{
+		"/etc/hostname":    "ubuntu\n",
+		"/etc/hosts":       "127.0.0.1 localhost\n127.0.1.1 ubuntu\n\n::1     ip6-localhost ip6-loopback\nfe00::0 ip6-localnet\nff00::0 ip6-mcastprefix\nff02::1 ip6-allnodes\nff02::2 ip6-allrouters\n",
+		"/etc/issue":       "Ubuntu 22.04.2 LTS \\n \\l\n\n",
+		"/etc/resolv.conf": "nameserver 127.0.0.53\noptions edns0 trust-ad\nsearch .\n",
+		"/etc/shells":      "# /etc/shells: valid login shells\n/bin/sh\n/bin/bash\n/usr/bin/bash\n/bin/dash\n/usr/bin/dash\n",
+		"/etc/os-release": strings.Join([]string{
+			`PRETTY_NAME="Ubuntu 22.04.2 LTS"`, `NAME="Ubuntu"`, `VERSION_ID="22.04"`, `VERSION="22.04.2 LTS (Jammy Jellyfish)"`,
+			`VERSION_CODENAME=jammy`, `ID=ubuntu`, `ID_LIKE=debian`, `HOME_URL="https://www.ubuntu.com/"`, `UBUNTU_CODENAME=jammy`, ``,
+		}, "\n"),
+		"/etc/passwd": strings.Join([]string{
+			"root:x:0:0:root:/root:/bin/bash",
+			"daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin",
+			"bin:x:2:2:bin:/bin:/usr/sbin/nologin",
+			"sys:x:3:3:sys:/dev:/usr/sbin/nologin",
+			"sync:x:4:65534:sync:/bin:/bin/sync",
+			"www-data:x:33:33:www-data:/var/www:/usr/sbin/nologin",
+			"nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin",
+			"systemd-network:x:100:102:systemd Network Management,,,:/run/systemd:/usr/sbin/nologin",
+			"sshd:x:105:65534::/run/sshd:/usr/sbin/nologin",
+			"ubuntu:x:1000:1000:Ubuntu:/home/ubuntu:/bin/bash", "",
+		}, "\n"),
+		"/etc/group": strings.Join([]string{
+			"root:x:0:", "daemon:x:1:", "bin:x:2:", "sys:x:3:", "adm:x:4:syslog,ubuntu", "sudo:x:27:ubuntu",
+			"www-data:x:33:", "nogroup:x:65534:", "ubuntu:x:1000:", "",
+		}, "\n"),
+		"/etc/crontab": "SHELL=/bin/sh\nPATH=/usr/local/sbin:/usr/local/bin:/sbin:/bin:/usr/sbin:/usr/bin\n\n" +
+			"17 *\t* * *\troot\tcd / && run-parts --report /etc/cron.hourly\n",
+		"/etc/ssh/sshd_config": "Include /etc/ssh/sshd_config.d/*.conf\nKbdInteractiveAuthentication no\nUsePAM yes\n" +
+			"X11Forwarding yes\nPrintMotd no\nAcceptEnv LANG LC_*\nSubsystem\tsftp\t/usr/lib/openssh/sftp-server\n",
+		"/root/.bashrc":              "# ~/.bashrc: executed by bash(1) for non-login shells.\n\n[ -z \"$PS1\" ] && return\n\nHISTCONTROL=ignoredups:ignorespace\nshopt -s histappend\n",
+		"/root/.profile":             "# ~/.profile: executed by Bourne-compatible login shells.\n\nif [ \"$BASH\" ]; then\n  if [ -f ~/.bashrc ]; then\n    . ~/.bashrc\n  fi\nfi\n\nmesg n 2> /dev/null || true\n",
+		"/root/.ssh/authorized_keys": "",
+		"/var/log/auth.log":          "",
+		"/home/ubuntu/.bashrc":       "# ~/.bashrc: executed by bash(1) for non-login shells.\n",
+	} {
+		img.Add(p, 0o644, []byte(content))
+	}
+	img.Add("/etc/shadow", 0o640, []byte("root:*:19468:0:99999:7:::\ndaemon:*:19468:0:99999:7:::\nubuntu:$6$rounds=4096$Yk4.8aQ2$:19468:0:99999:7:::\n"))
+	img.files["/root/.ssh/authorized_keys"].Mode = 0o600
+
+	// Kernel files
+	for p, content := range map[string]string{
+		"/proc/version": "Linux version 5.15.0-72-generic (buildd@lcy02-amd64-041) (gcc (Ubuntu 11.3.0-1ubuntu1~22.04) 11.3.0, GNU ld (GNU Binutils for Ubuntu) 2.38) #79-Ubuntu SMP Wed Apr 19 08:22:18 UTC 2023\n",
+		"/proc/cpuinfo": cpuinfo(2),
+		"/proc/meminfo": "MemTotal:        8028160 kB\nMemFree:          318704 kB\nMemAvailable:    6722340 kB\nBuffers:          182464 kB\nCached:          5884356 kB\n" +
+			"SwapCached:            0 kB\nSwapTotal:             0 kB\nSwapFree:              0 kB\n",
+		"/proc/uptime":  "1836512.43 3598203.11\n",
+		"/proc/loadavg": "0.08 0.03 0.01 1/143 48213\n",
+		"/proc/mounts": "/dev/root / ext4 rw,relatime,discard,errors=remount-ro 0 0\ndevtmpfs /dev devtmpfs rw,relatime,size=4008536k,nr_inodes=1002134,mode=755 0 0\n" +
+			"proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0\nsysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0\ntmpfs /dev/shm tmpfs rw,nosuid,nodev 0 0\n",
+	} {
+		img.Add(p, 0o444, []byte(content))
+	}
+	return img
+}
+
+// Returns the processors of the default image
+func cpuinfo(cpus int) string {
+	var b strings.Builder
+	for i := 0; i < cpus; i++ {
+		fmt.Fprintf(&b, "processor\t: %d\nvendor_id\t: GenuineIntel\ncpu family\t: 6\nmodel\t\t: 85\n", i)
+		b.WriteString("model name\t: Intel(R) Xeon(R) Platinum 8259CL CPU @ 2.50GHz\nstepping\t: 7\ncpu MHz\t\t: 2499.998\n")
+		fmt.Fprintf(&b, "cache size\t: 36608 KB\nphysical id\t: 0\nsiblings\t: %d\ncore id\t\t: %d\ncpu cores\t: %d\n", cpus, i, cpus)
+		b.WriteString("flags\t\t: fpu vme de pse tsc msr pae mce cx8 apic sep mtrr pge mca cmov pat pse36 clflush mmx fxsr sse sse2 ss ht syscall nx " +
+			"pdpe1gb rdtscp lm constant_tsc rep_good nopl xtopology nonstop_tsc cpuid tsc_known_freq pni pclmulqdq ssse3 fma cx16 pcid " +
+			"sse4_1 sse4_2 x2apic movbe popcnt aes xsave avx f16c rdrand hypervisor lahf_lm abm 3dnowprefetch\n")
+		b.WriteString("bogomips\t: 4999.99\naddress sizes\t: 46 bits physical, 48 bits virtual\n\n")
+	}
+	return b.String()
+}
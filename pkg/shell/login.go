@@ -0,0 +1,74 @@
+package shell
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// Sources locked out after failing to log in
+	Lockouts = NewLockouts()
+)
+
+// Credentials accepted by the login of a persona. The wildcard "*" matches any value
+type Login struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+func (l Login) matches(user string, password string) bool {
+	return (l.User == "*" || l.User == user) && (l.Password == "*" || l.Password == password)
+}
+
+// Whether the persona accepts the credentials
+func (p Persona) Accepts(user string, password string) bool {
+	if len(p.Logins) == 0 {
+		return true
+	}
+
+	for _, login := range p.Logins {
+		if login.matches(user, password) {
+			return true
+		}
+	}
+	return false
+}
+
+// Time the sources are locked out after failing to log in, e.g., brute forcing the credentials
+type LockoutStore struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// Lock out a source, by IP, for a duration
+func (l *LockoutStore) Lock(ip string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The expired lockouts are forgotten, so the store does not grow with the sources
+	now := time.Now()
+	for key, until := range l.until {
+		if now.After(until) {
+			delete(l.until, key)
+		}
+	}
+
+	l.until[ip] = now.Add(d)
+}
+
+// Whether a source is locked out
+func (l *LockoutStore) Locked(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until, ok := l.until[ip]
+	return ok && time.Now().Before(until)
+}
+
+func NewLockouts() *LockoutStore {
+	return &LockoutStore{until: make(map[string]time.Time)}
+}
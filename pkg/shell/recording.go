@@ -0,0 +1,44 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/sessions"
+)
+
+// Output of the terminal, recorded as it is written
+type recorder struct {
+	w   io.Writer
+	rec *sessions.Recording
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.rec.Write(sessions.OutputKind, crlf(p))
+	return r.w.Write(p)
+}
+
+// Start recording the terminal in the session of the client, and record the output
+func (s *Shell) record() *sessions.Recording {
+	// The services behind a proxy see its address, the session is the one of the proxy
+	id := s.Source
+	if link, ok := events.Events.Resolve(s.Source); ok && link.Session != "" {
+		id = link.Session
+	}
+
+	rec := sessions.Recordings.Record(id, s.Width, s.Height, map[string]string{
+		"SHELL": s.Env["SHELL"],
+		"TERM":  s.Env["TERM"],
+	})
+
+	// The errors of the commands are written to the output as well
+	s.stdout = &recorder{w: s.stdout, rec: rec}
+	return rec
+}
+
+// Returns the bytes with the line endings of a terminal, as the players expect them
+func crlf(p []byte) []byte {
+	p = bytes.ReplaceAll(p, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))
+}
@@ -0,0 +1,78 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+var (
+	// Commands of the shells, e.g., `ls`
+	Commands = NewRegistry()
+	// Applets of BusyBox that do not behave as the command of the same name, e.g., `busybox wget`
+	Applets = NewRegistry()
+)
+
+// Function of a command. The arguments include the name of the command. The input is
+// the output of the previous command of a pipeline, or nil in the terminal, and the
+// output is the terminal, the next command or the file the command is redirected to.
+// Commands that fail write their message and return an error, e.g., an ExitStatus
+type Command func(s *Shell, args []string, in io.Reader, out io.Writer) error
+
+// Exit status of a command that failed, e.g., 127 when it is not found
+type ExitStatus int
+
+func (e ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// Registry of commands by name
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+// Add a command, replacing the one with the same name
+func (r *Registry) Register(name string, command Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.commands[name] = command
+}
+
+// Remove a command by name
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.commands, name)
+}
+
+// Returns a command by name
+func (r *Registry) Get(name string) (command Command, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	command, ok = r.commands[name]
+	return
+}
+
+// Returns the names of the commands, sorted
+func (r *Registry) GetNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		commands: make(map[string]Command),
+	}
+}
@@ -9,12 +9,19 @@ package shell
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"reflect"
+	"math/rand"
+	"path"
 	"strings"
 	"sync"
+
+	"github.com/riotpot/pkg/artifacts"
+	"github.com/riotpot/pkg/events"
+	lr "github.com/riotpot/pkg/logger"
+	"github.com/riotpot/pkg/sessions"
 )
 
 type shellface interface {
@@ -23,26 +30,45 @@ type shellface interface {
 	commands()
 }
 
-func New(user string, host string) *shell {
-	commands := [][]interface{}{
-		{"enable", cEnable},
-		{"exit", cExit},
-		{"./", cExec},
+func New(user string, host string) *Shell {
+	// The home of the users other than root is created if the image does not have it
+	home := "/root"
+	if user != "root" && user != "" && !strings.ContainsAny(user, "/.") {
+		home = path.Join("/home", user)
 	}
 
-	return &shell{
-		comm:     commands,
-		mu:       &sync.Mutex{},
-		User:     user,
-		Host:     host,
-		Path:     "",
-		RspChan:  make(chan []byte, 10),
-		doneChan: make(chan error, 2),
-		Running:  false,
+	image := GetImage()
+	fs := NewFileSystem(image)
+	fs.seedDir(home)
+
+	s := &Shell{
+		mu:      &sync.Mutex{},
+		User:    user,
+		Host:    host,
+		Home:    home,
+		Path:    home,
+		FS:      fs,
+		Persona: image.Persona,
+		Env: map[string]string{
+			"HOME":    home,
+			"USER":    user,
+			"LOGNAME": user,
+			"SHELL":   "/bin/bash",
+			"PATH":    "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+			"TERM":    "xterm",
+			"LANG":    "C.UTF-8",
+		},
+		Width:   80,
+		Height:  24,
+		pid:     1000 + rand.Intn(30000),
+		done:    make(chan struct{}),
+		Running: false,
 	}
+
+	return s
 }
 
-type shell struct {
+type Shell struct {
 	stdin  io.Reader
 	stdout io.Writer
 	stderr io.Writer
@@ -50,20 +76,48 @@ type shell struct {
 
 	shellface
 
-	User    string
-	Host    string
+	User string
+	Host string
+	// Home and working directory of the user
+	Home    string
 	Path    string
 	Running bool
 
-	comm [][]interface{}
+	// Filesystem of the session, the changes of the attacker are kept apart from the image
+	FS *FileSystem
+	// Identity of the system shown by the commands
+	Persona Persona
+
+	// Name of the service using the shell and address of the client,
+	// used to emit an event for each command
+	Service string
+	Source  string
+
+	// Variables of the shell, e.g., "HOME"
+	Env map[string]string
+	// Size of the terminal of the client, in columns and rows
+	Width  int
+	Height int
 
-	RspChan  chan []byte
-	doneChan chan error
+	// Number of nested shells, e.g., `sh -c`
+	depth int
+	// Number of commands the line can still run
+	budget int
+	// Status of the last command, and identifier of the process of the shell
+	status int
+	pid    int
+	// Whether the attacker exited
+	exited bool
+
+	// Closed when the terminal ends, with the error of closing the connection
+	done    chan struct{}
+	doneErr error
+	started bool
 
 	mu *sync.Mutex
 }
 
-func (s *shell) SetIo(conn io.ReadWriteCloser) {
+func (s *Shell) SetIo(conn io.ReadWriteCloser) {
 	s.stdin = conn
 	s.stdout = conn
 	s.stderr = conn
@@ -71,36 +125,40 @@ func (s *shell) SetIo(conn io.ReadWriteCloser) {
 }
 
 // Method necessary to start fake shells on ssh
-func (s *shell) SetReadWriteCloser(r io.Reader, w io.Writer, c io.Closer) {
+func (s *Shell) SetReadWriteCloser(r io.Reader, w io.Writer, c io.Closer) {
 	s.stdin = r
 	s.stdout = w
 	s.stderr = w
 	s.closer = c
 }
 
-func (s *shell) Start() error {
+func (s *Shell) Start() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.Running {
+	if s.started {
 		return errors.New("already running")
 	}
-	s.Running = true
+	s.Running, s.started = true, true
 	go s.terminal()
 
 	return nil
 }
 
-func (s *shell) Wait() error {
+func (s *Shell) Wait() error {
 	s.mu.Lock()
-	if !s.Running {
-		s.mu.Unlock()
+	started := s.started
+	s.mu.Unlock()
+	if !started {
 		return errors.New("not running")
 	}
-	s.mu.Unlock()
-	return <-s.doneChan
+
+	// The result is kept for the calls made after the terminal ended
+	<-s.done
+	return s.doneErr
 }
 
-func (s *shell) terminal() {
+func (s *Shell) terminal() {
+	rec := s.record()
 	br := bufio.NewReader(s.stdin)
 
 	for {
@@ -112,55 +170,278 @@ func (s *shell) terminal() {
 			break
 		}
 
-		// send the response to the channel of responses
-		s.RspChan <- lineBytes
+		// The terminal of the client echoes the line, it is part of the output replayed
+		rec.Write(sessions.InputKind, lineBytes)
+		rec.Write(sessions.OutputKind, crlf(lineBytes))
+
 		line := string(lineBytes)
 		// remove the line endings to compare the strings to regular commands
 		line = strings.TrimRight(line, "\r\n")
 
-		s.commands(line)
+		ev := events.NewEvent(events.CommandEvent, s.Service, s.Source)
+		events.Events.Emit(ev.With("command", line))
+
+		s.commands(line, s.stdout)
 	}
 
+	s.save()
+
+	err := s.closer.Close()
+
+	s.mu.Lock()
 	s.Running = false
-	s.doneChan <- s.closer.Close()
+	s.doneErr = err
+	s.mu.Unlock()
+	close(s.done)
 }
 
-func (s *shell) prompt() string {
-	return fmt.Sprintf("%s@%s:~%s# ", s.User, s.Host, s.Path)
+// Run a single command line without a terminal, e.g., sent with an SSH exec request.
+// Returns the exit status of the line
+func (s *Shell) Exec(line string, out io.Writer) int {
+	s.commands(line, out)
+	s.save()
+	return s.status
 }
 
-func (s *shell) commands(line string) {
-	commands := strings.Split(line, ";")
+func (s *Shell) prompt() string {
+	return fmt.Sprintf("%s@%s:%s# ", s.User, s.Host, s.displayPath())
+}
+
+// Returns the working directory as shown in the prompt, relative to the home
+func (s *Shell) displayPath() string {
+	switch {
+	case s.Path == s.Home:
+		return "~"
+	case strings.HasPrefix(s.Path, s.Home+"/"):
+		return "~" + strings.TrimPrefix(s.Path, s.Home)
+	}
+	return s.Path
+}
 
-	for _, command := range commands {
-		s.command(command)
+// Returns the absolute path of a file given by the user
+func (s *Shell) abs(p string) string {
+	switch {
+	case p == "~":
+		return s.Home
+	case strings.HasPrefix(p, "~/"):
+		p = path.Join(s.Home, p[2:])
+	case !path.IsAbs(p):
+		p = path.Join(s.Path, p)
 	}
+	return path.Clean(p)
 }
 
-func (s *shell) command(line string) {
-	for _, p := range s.comm {
+// Keep the files written by the attacker, e.g., the binaries dropped with echo
+func (s *Shell) save() {
+	for _, c := range s.FS.Snapshot() {
+		if c.Operation == RemovedOperation || c.Mode.IsDir() || len(c.Content) == 0 {
+			continue
+		}
+
+		_, err := artifacts.Artifacts.Capture(bytes.NewReader(c.Content), artifacts.Origin{
+			Source:   s.Source,
+			Service:  s.Service,
+			Method:   artifacts.ShellMethod,
+			Filename: c.Path,
+		})
+		if err != nil {
+			lr.Log.Warn().Err(err).Str("path", c.Path).Msg("Could not capture the file written in the shell")
+		}
+	}
+}
 
-		// The first value is the `command` as a string, stringify it.
-		command := fmt.Sprintf("%v", p[0])
+// Run a command line, as typed in the terminal or given to `sh -c`
+func (s *Shell) commands(line string, out io.Writer) {
+	// Each line of the terminal has its own budget, shared with the shells it runs
+	if s.depth == 0 {
+		s.budget = maxCommands
+	}
 
-		// The second is a function that takes exactly 1 argument,
-		// the command.
-		// We do this so the function can send more than one message,
-		// close the connection, etc.
-		fn := reflect.ValueOf(p[1])
-		args := make([]reflect.Value, 2)
+	list, err := Parse(line)
+	if err != nil {
+		fmt.Fprintf(out, "bash: %s\n", err)
+		s.status = 2
+		return
+	}
 
-		// Parses the value as an argument
-		args[0] = reflect.ValueOf(line)
-		args[1] = reflect.ValueOf(s.stdout)
+	for _, andOr := range list {
+		s.status = s.pipeline(andOr.Pipelines[0], out)
+		for i, op := range andOr.Operators {
+			// "&&" runs the next pipeline after a success, and "||" after a failure
+			if (op == "&&") == (s.status == 0) {
+				s.status = s.pipeline(andOr.Pipelines[i+1], out)
+			}
+		}
 
-		// find the captured command that matches the list of commands
-		// able to parse.
-		if strings.HasPrefix(line, command) {
-			fn.Call(args)
+		if s.exited || s.budget <= 0 {
 			return
 		}
 	}
+}
+
+// Run the commands of a pipeline, the output of each one is the input of the next
+func (s *Shell) pipeline(pipeline Pipeline, out io.Writer) (status int) {
+	var in io.Reader
+	for i, command := range pipeline {
+		if i == len(pipeline)-1 {
+			return s.command(command, in, out)
+		}
+
+		var buf bytes.Buffer
+		s.command(command, in, &buf)
+		in = &buf
+	}
+	return
+}
+
+// Run a command with its expansions and redirections, and return its status
+func (s *Shell) command(command *SimpleCommand, in io.Reader, out io.Writer) (status int) {
+	// The line ran out of commands, the rest are not run
+	if s.budget <= 0 {
+		return 1
+	}
+	s.budget--
+
+	args := []string{}
+	for _, word := range command.Words {
+		args = append(args, s.expand(word)...)
+	}
 
-	cDefault(line, s.stdout)
+	// The variables are kept when there is no command, e.g., "X=1"
+	for _, assignment := range command.Assignments {
+		eq := strings.IndexByte(assignment, '=')
+		name, value := assignment[:eq], s.expandWord(assignment[eq+1:])
+		if len(args) > 0 {
+			old, ok := s.Env[name]
+			defer func() {
+				if ok {
+					s.Env[name] = old
+				} else {
+					delete(s.Env, name)
+				}
+			}()
+		}
+		s.Env[name] = value
+	}
+
+	// The output is written in the last file it is redirected to, e.g., "echo a > /tmp/a"
+	target, appending, redirected := "", false, false
+	redirects := []string{}
+	for _, r := range command.Redirects {
+		t := s.expandWord(r.Target)
+		redirects = append(redirects, fmt.Sprintf("%d%s%s", r.Fd, r.Op, t))
+		if t == "" {
+			fmt.Fprintf(out, "bash: %s: ambiguous redirect\n", r.Target)
+			return 1
+		}
+
+		switch {
+		case r.Op == "<":
+			content, err := s.FS.ReadFile(s.abs(t))
+			if err != nil {
+				fmt.Fprintf(out, "bash: %s: %s\n", t, err)
+				return 1
+			}
+			in = bytes.NewReader(content)
+		case r.Op == ">&" && (isNumber(t) || t == "-"):
+			// The descriptors are duplicated, the errors are always written in the terminal
+		case r.Fd == 1:
+			// The files of the previous redirections are created anyway
+			if redirected && !s.redirect(target, nil, appending, out) {
+				return 1
+			}
+			target, appending, redirected = t, r.Op == ">>", true
+		default:
+			if !s.redirect(t, nil, r.Op == ">>", out) {
+				return 1
+			}
+		}
+	}
+
+	// A redirection without command creates the file, e.g., ">/tmp/a"
+	var buf bytes.Buffer
+	if len(args) > 0 {
+		w := out
+		if redirected {
+			w = &buf
+		}
+		status = exitStatus(s.lookup(args[0])(s, args, in, w))
+	}
+
+	if redirected && !s.redirect(target, buf.Bytes(), appending, out) {
+		status = 1
+	}
+
+	if len(args) > 0 {
+		ev := events.NewEvent(events.ExecEvent, s.Service, s.Source)
+		ev.With("name", args[0]).With("args", args[1:]).With("cwd", s.Path).With("status", status)
+		if len(redirects) > 0 {
+			ev.With("redirects", redirects)
+		}
+		events.Events.Emit(ev)
+	}
+	return
+}
+
+// Write the output of a command in a file, the writes to /dev/null are discarded
+func (s *Shell) redirect(target string, data []byte, appending bool, out io.Writer) bool {
+	p := s.abs(target)
+	if p == "/dev/null" {
+		return true
+	}
+
+	if err := s.FS.WriteFile(p, data, appending); err != nil {
+		fmt.Fprintf(out, "bash: %s: %s\n", target, err)
+		return false
+	}
+	return true
+}
+
+// Returns the status of the error of a command
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if status, ok := err.(ExitStatus); ok {
+		return int(status)
+	}
+	return 1
+}
+
+// Returns the error of the status of the last command, e.g., for the commands of `sh`
+func (s *Shell) result() error {
+	if s.status == 0 {
+		return nil
+	}
+	return ExitStatus(s.status)
+}
+
+// Returns the command to run for a name, or a path, e.g., "/bin/busybox" or "./bot"
+func (s *Shell) lookup(name string) Command {
+	if !strings.Contains(name, "/") {
+		if command, ok := Commands.Get(name); ok {
+			return command
+		}
+		return (*Shell).cDefault
+	}
+
+	// The binaries of the system run as the command of the same name
+	p := s.abs(name)
+	if isBinDir(path.Dir(p)) {
+		if _, err := s.FS.Stat(p); err == nil {
+			if command, ok := Commands.Get(path.Base(p)); ok {
+				return command
+			}
+		}
+	}
+	return (*Shell).cExec
+}
+
+// Directories of the binaries of the system
+func isBinDir(dir string) bool {
+	switch dir {
+	case "/bin", "/sbin", "/usr/bin", "/usr/sbin", "/usr/local/bin", "/usr/local/sbin":
+		return true
+	}
+	return false
 }
@@ -0,0 +1,52 @@
+package signatures
+
+// Rules used by default. They can be replaced with local ones, see RuleSet.Load
+const Defaults = `
+rule Mirai {
+	meta:
+		family = "Mirai"
+		description = "Mirai bots and their loaders, which check for busybox with a random applet"
+	strings:
+		$busybox = /\/bin\/busybox (MIRAI|ECCHI|[A-Z]{5})\b/
+		$applet = /\b(MIRAI|ECCHI|[A-Z]{5}): applet not found/
+		$dvr = "dvrHelper"
+		$watchdog1 = "/dev/watchdog"
+		$watchdog2 = "/dev/misc/watchdog"
+	condition:
+		$busybox or $applet or ($dvr and any of ($watchdog*))
+}
+
+rule Gafgyt {
+	meta:
+		family = "Gafgyt"
+		description = "Gafgyt (Bashlite) bots, controlled with plain text commands"
+	strings:
+		$gayfgt = "gayfgt" nocase
+		$cmd1 = "KILLATTK"
+		$cmd2 = "LOLNOGTFO"
+		$cmd3 = "GETLOCALIP"
+		$cmd4 = "PONG!"
+		$cmd5 = "HOLD Flooding"
+		$cmd6 = "JUNK Flooding"
+	condition:
+		$gayfgt or 3 of ($cmd*)
+}
+
+rule Mozi {
+	meta:
+		family = "Mozi"
+		description = "Mozi P2P bots, which join the BitTorrent DHT"
+	strings:
+		$file = /\bMozi\.[ma]\b/
+		$dht1 = "dht.transmissionbt.com"
+		$dht2 = "router.bittorrent.com"
+		$dht3 = "router.utorrent.com"
+		$dht4 = "bttracker.debian.org"
+		$conf1 = "[ss]"
+		$conf2 = "[hp]"
+		$conf3 = "[cpu]"
+		$conf4 = "[nd]"
+	condition:
+		$file or (2 of ($dht*) and 2 of ($conf*))
+}
+`
@@ -0,0 +1,640 @@
+package signatures
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Parser of the rules, a subset of the YARA syntax:
+//
+//	rule Mirai : botnet {
+//		meta:
+//			family = "Mirai"
+//		strings:
+//			$text = "/bin/busybox MIRAI" nocase
+//			$hex = { 4D 49 52 ?? 49 [0-4] 00 }
+//			$regex = /busybox [A-Z]{5}/
+//		condition:
+//			any of them
+//	}
+//
+// The text strings accept the nocase, ascii and wide modifiers, the hex strings accept
+// wildcards (??, 4? or ?4) and jumps ([4] or [0-4]), and the regular expressions accept the
+// i and s flags. The conditions combine the strings with and, or, not and parentheses, and
+// with the quantifiers "any", "all", "none" or a number "of them" or of a set, e.g., ($a*, $b)
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+// Skip the spaces and the comments
+func (p *parser) skip() {
+	for !p.eof() {
+		switch {
+		case unicode.IsSpace(rune(p.peek())):
+			p.next()
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			p.pos += 2
+			for !p.eof() && !strings.HasPrefix(p.src[p.pos:], "*/") {
+				p.next()
+			}
+			p.pos += 2
+		default:
+			return
+		}
+	}
+}
+
+// Whether the next character, after the spaces, is the given one. It is consumed if so
+func (p *parser) accept(c byte) bool {
+	p.skip()
+	if p.peek() == c {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(c byte) error {
+	if !p.accept(c) {
+		return p.errorf("expected '%c'", c)
+	}
+	return nil
+}
+
+func isIdent(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// Read an identifier, or an empty string if there is none
+func (p *parser) ident() string {
+	p.skip()
+	start := p.pos
+	for !p.eof() && isIdent(p.peek(), p.pos == start) {
+		p.next()
+	}
+	return p.src[start:p.pos]
+}
+
+// Whether the next identifier is the given keyword. It is consumed if so
+func (p *parser) keyword(word string) bool {
+	p.skip()
+	start, line := p.pos, p.line
+	if p.ident() == word {
+		return true
+	}
+	p.pos, p.line = start, line
+	return false
+}
+
+// Read the identifier of a string, e.g., "$a". The name can end with a wildcard in the sets
+func (p *parser) variable(wildcard bool) (string, error) {
+	if err := p.expect('$'); err != nil {
+		return "", err
+	}
+
+	name := "$"
+	for !p.eof() && isIdent(p.peek(), false) {
+		name += string(p.next())
+	}
+	if wildcard && p.peek() == '*' {
+		name += string(p.next())
+	}
+	return name, nil
+}
+
+func (p *parser) number() (int, error) {
+	p.skip()
+	start := p.pos
+	for !p.eof() && p.peek() >= '0' && p.peek() <= '9' {
+		p.next()
+	}
+	if start == p.pos {
+		return 0, p.errorf("expected a number")
+	}
+	return strconv.Atoi(p.src[start:p.pos])
+}
+
+// Read a quoted text with the escape sequences \", \\, \n, \r, \t and \xHH
+func (p *parser) text() (string, error) {
+	if err := p.expect('"'); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+
+		c := p.next()
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			switch e := p.next(); e {
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'x':
+				if p.pos+2 > len(p.src) {
+					return "", p.errorf("invalid escape sequence")
+				}
+				v, err := strconv.ParseUint(p.src[p.pos:p.pos+2], 16, 8)
+				if err != nil {
+					return "", p.errorf("invalid escape sequence")
+				}
+				p.pos += 2
+				b.WriteByte(byte(v))
+			default:
+				return "", p.errorf("invalid escape sequence: \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// Read a hex string, e.g., { 4D 5A ?? [2-4] 00 }
+func (p *parser) hex() (tokens []hexToken, err error) {
+	if err = p.expect('{'); err != nil {
+		return
+	}
+
+	for {
+		p.skip()
+		if p.eof() {
+			return nil, p.errorf("unterminated hex string")
+		}
+
+		switch c := p.peek(); {
+		case c == '}':
+			p.next()
+			if len(tokens) == 0 || tokens[0].jump || tokens[len(tokens)-1].jump {
+				return nil, p.errorf("hex strings must start and end with a byte")
+			}
+			return
+		case c == '[':
+			p.next()
+			min, err := p.number()
+			if err != nil {
+				return nil, err
+			}
+			max := min
+			if p.accept('-') {
+				if max, err = p.number(); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.expect(']'); err != nil {
+				return nil, err
+			}
+			if max < min || max > maxJump {
+				return nil, p.errorf("invalid jump [%d-%d]", min, max)
+			}
+			tokens = append(tokens, hexToken{jump: true, min: min, max: max})
+		default:
+			if p.pos+2 > len(p.src) {
+				return nil, p.errorf("unterminated hex string")
+			}
+			token, ok := parseHexByte(p.src[p.pos : p.pos+2])
+			if !ok {
+				return nil, p.errorf("invalid hex byte: %s", p.src[p.pos:p.pos+2])
+			}
+			p.pos += 2
+			tokens = append(tokens, token)
+		}
+	}
+}
+
+// Parse a byte of a hex string, with the wildcards in any of its nibbles
+func parseHexByte(s string) (t hexToken, ok bool) {
+	for i := 0; i < 2; i++ {
+		shift := uint(4 * (1 - i))
+		if s[i] == '?' {
+			continue
+		}
+		v, err := strconv.ParseUint(s[i:i+1], 16, 8)
+		if err != nil {
+			return t, false
+		}
+		t.value |= byte(v) << shift
+		t.mask |= 0xF << shift
+	}
+	return t, true
+}
+
+// Read a regular expression, e.g., /wget\s+http/i
+func (p *parser) regex() (*regexp.Regexp, error) {
+	if err := p.expect('/'); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return nil, p.errorf("unterminated regular expression")
+		}
+
+		c := p.next()
+		if c == '/' {
+			break
+		}
+		// Escaped slashes are part of the expression
+		if c == '\\' && p.peek() == '/' {
+			c = p.next()
+		} else if c == '\\' && !p.eof() {
+			b.WriteByte(c)
+			c = p.next()
+		}
+		b.WriteByte(c)
+	}
+
+	flags := ""
+	for p.peek() == 'i' || p.peek() == 's' {
+		flags += string(p.next())
+	}
+
+	expr := b.String()
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, p.errorf("invalid regular expression: %s", err)
+	}
+	return re, nil
+}
+
+// Read a rule, after the keyword "rule"
+func (p *parser) rule() (r *Rule, err error) {
+	r = &Rule{Tags: []string{}, Meta: make(map[string]string)}
+
+	if r.Name = p.ident(); r.Name == "" {
+		return nil, p.errorf("expected the name of the rule")
+	}
+
+	if p.accept(':') {
+		for {
+			tag := p.ident()
+			if tag == "" {
+				break
+			}
+			r.Tags = append(r.Tags, tag)
+		}
+	}
+
+	if err = p.expect('{'); err != nil {
+		return
+	}
+
+	if p.keyword("meta") {
+		if err = p.expect(':'); err != nil {
+			return
+		}
+		if err = p.meta(r); err != nil {
+			return
+		}
+	}
+
+	if p.keyword("strings") {
+		if err = p.expect(':'); err != nil {
+			return
+		}
+		if err = p.strings(r); err != nil {
+			return
+		}
+	}
+
+	if !p.keyword("condition") {
+		return nil, p.errorf("expected the condition of the rule %s", r.Name)
+	}
+	if err = p.expect(':'); err != nil {
+		return
+	}
+
+	if r.condition, err = p.or(r); err != nil {
+		return
+	}
+
+	if err = p.expect('}'); err != nil {
+		return
+	}
+	return
+}
+
+// Read the metadata of a rule, e.g., family = "Mirai"
+func (p *parser) meta(r *Rule) error {
+	for {
+		p.skip()
+		start, line := p.pos, p.line
+
+		key := p.ident()
+		if key == "" || key == "strings" || key == "condition" {
+			p.pos, p.line = start, line
+			return nil
+		}
+
+		if err := p.expect('='); err != nil {
+			return err
+		}
+
+		p.skip()
+		switch {
+		case p.peek() == '"':
+			value, err := p.text()
+			if err != nil {
+				return err
+			}
+			r.Meta[key] = value
+		case p.keyword("true"):
+			r.Meta[key] = "true"
+		case p.keyword("false"):
+			r.Meta[key] = "false"
+		default:
+			value, err := p.number()
+			if err != nil {
+				return err
+			}
+			r.Meta[key] = strconv.Itoa(value)
+		}
+	}
+}
+
+// Read the strings of a rule, e.g., $a = "text" nocase
+func (p *parser) strings(r *Rule) error {
+	for {
+		p.skip()
+		if p.peek() != '$' {
+			return nil
+		}
+
+		name, err := p.variable(false)
+		if err != nil {
+			return err
+		}
+		if name == "$" {
+			return p.errorf("anonymous strings are not supported")
+		}
+		for _, s := range r.strings {
+			if s.name == name {
+				return p.errorf("duplicated string %s", name)
+			}
+		}
+
+		if err := p.expect('='); err != nil {
+			return err
+		}
+
+		s := &pattern{name: name}
+		p.skip()
+		switch p.peek() {
+		case '"':
+			if s.text, err = p.text(); err != nil {
+				return err
+			}
+			if s.text == "" {
+				return p.errorf("empty string %s", name)
+			}
+			if err := p.modifiers(s); err != nil {
+				return err
+			}
+		case '{':
+			if s.hex, err = p.hex(); err != nil {
+				return err
+			}
+		case '/':
+			if s.regex, err = p.regex(); err != nil {
+				return err
+			}
+		default:
+			return p.errorf("expected a string, hex string or regular expression for %s", name)
+		}
+
+		r.strings = append(r.strings, s)
+	}
+}
+
+// Read the modifiers of a text string
+func (p *parser) modifiers(s *pattern) error {
+	ascii := false
+	for {
+		switch {
+		case p.keyword("nocase"):
+			s.nocase = true
+		case p.keyword("ascii"):
+			ascii = true
+		case p.keyword("wide"):
+			s.wide = true
+		default:
+			// Text strings are ascii unless only wide is given
+			s.ascii = ascii || !s.wide
+			return nil
+		}
+	}
+}
+
+// Read a condition: term (or term)*
+func (p *parser) or(r *Rule) (expr, error) {
+	left, err := p.and(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("or") {
+		right, err := p.and(r)
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+// Read a term: factor (and factor)*
+func (p *parser) and(r *Rule) (expr, error) {
+	left, err := p.factor(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("and") {
+		right, err := p.factor(r)
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+// Read a factor: not factor, (condition), true, false, $string or a quantifier
+func (p *parser) factor(r *Rule) (expr, error) {
+	p.skip()
+
+	switch c := p.peek(); {
+	case p.keyword("not"):
+		e, err := p.factor(r)
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	case c == '(':
+		p.next()
+		e, err := p.or(r)
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(')')
+	case p.keyword("true"):
+		return constExpr(true), nil
+	case p.keyword("false"):
+		return constExpr(false), nil
+	case c == '$':
+		name, err := p.variable(false)
+		if err != nil {
+			return nil, err
+		}
+		i := r.index(name)
+		if i < 0 {
+			return nil, p.errorf("undefined string %s", name)
+		}
+		return stringExpr(i), nil
+	}
+
+	// Quantifier of a set of strings, e.g., "2 of ($a*, $b)"
+	q := ofExpr{}
+	switch {
+	case p.keyword("any"):
+		q.min = 1
+	case p.keyword("all"):
+		q.all = true
+	case p.keyword("none"):
+		q.none = true
+	default:
+		n, err := p.number()
+		if err != nil {
+			return nil, p.errorf("expected a condition")
+		}
+		q.min = n
+	}
+
+	if !p.keyword("of") {
+		return nil, p.errorf("expected 'of'")
+	}
+
+	if p.keyword("them") {
+		for i := range r.strings {
+			q.set = append(q.set, i)
+		}
+	} else {
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		for {
+			name, err := p.variable(true)
+			if err != nil {
+				return nil, err
+			}
+
+			found := false
+			for i, s := range r.strings {
+				if s.name == name || (strings.HasSuffix(name, "*") && strings.HasPrefix(s.name, strings.TrimSuffix(name, "*"))) {
+					q.set = append(q.set, i)
+					found = true
+				}
+			}
+			if !found {
+				return nil, p.errorf("undefined string %s", name)
+			}
+
+			if !p.accept(',') {
+				break
+			}
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(q.set) == 0 {
+		return nil, p.errorf("the rule %s does not have strings", r.Name)
+	}
+	if q.min > len(q.set) {
+		return nil, p.errorf("the rule %s does not have %d strings", r.Name, q.min)
+	}
+	return q, nil
+}
+
+// Parse the rules of a source
+func Parse(src string) (rules []*Rule, err error) {
+	p := &parser{src: src, line: 1}
+	names := make(map[string]bool)
+
+	for {
+		p.skip()
+		if p.eof() {
+			return
+		}
+
+		// Private and global rules are parsed as the rest
+		p.keyword("private")
+		p.keyword("global")
+
+		if !p.keyword("rule") {
+			return nil, p.errorf("expected a rule")
+		}
+
+		r, err := p.rule()
+		if err != nil {
+			return nil, err
+		}
+
+		if names[r.Name] {
+			return nil, p.errorf("duplicated rule %s", r.Name)
+		}
+		names[r.Name] = true
+		rules = append(rules, r)
+	}
+}
@@ -0,0 +1,374 @@
+/*
+This package matches the payloads captured from the attackers and their commands with
+rules written in a YARA-like syntax, to recognise the malware families, e.g., Mirai
+*/
+package signatures
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/riotpot/pkg/events"
+)
+
+var (
+	// Exportable set of the rules used to match the payloads and the commands
+	Signatures = NewRuleSet()
+)
+
+const (
+	// Field of the events with the names of the rules matched
+	RulesField = "rules"
+	// Field of the events with the families of the rules matched
+	FamiliesField = "families"
+
+	// Maximum number of bytes skipped by a jump of a hex string
+	maxJump = 256
+)
+
+// Text, hex string or regular expression of a rule
+type pattern struct {
+	name string
+
+	text   string
+	nocase bool
+	ascii  bool
+	wide   bool
+
+	hex   []hexToken
+	regex *regexp.Regexp
+}
+
+// Byte of a hex string, compared with a mask for the wildcards, or a jump
+type hexToken struct {
+	value byte
+	mask  byte
+
+	jump     bool
+	min, max int
+}
+
+// Whether the pattern is in the data. The lower case data is given for the nocase strings
+func (s *pattern) match(data []byte, lower []byte) bool {
+	switch {
+	case s.regex != nil:
+		return s.regex.Match(data)
+	case s.hex != nil:
+		return matchHex(data, s.hex)
+	}
+
+	text, in := []byte(s.text), data
+	if s.nocase {
+		text, in = toLower(text), lower
+	}
+
+	if s.ascii && bytes.Contains(in, text) {
+		return true
+	}
+	return s.wide && bytes.Contains(in, widen(text))
+}
+
+// Returns a copy of the data with the ASCII letters in lower case. The rest of the bytes are
+// kept, unlike bytes.ToLower, as the payloads are usually binaries
+func toLower(data []byte) []byte {
+	lower := make([]byte, len(data))
+	for i, c := range data {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	return lower
+}
+
+// Encode a text as UTF-16LE, e.g., the strings of the Windows binaries
+func widen(text []byte) []byte {
+	wide := make([]byte, 0, 2*len(text))
+	for _, c := range text {
+		wide = append(wide, c, 0)
+	}
+	return wide
+}
+
+// Whether the hex string is in the data
+func matchHex(data []byte, tokens []hexToken) bool {
+	for start := range data {
+		if matchHexAt(data, start, tokens) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchHexAt(data []byte, pos int, tokens []hexToken) bool {
+	for i, t := range tokens {
+		if t.jump {
+			for skip := t.min; skip <= t.max && pos+skip <= len(data); skip++ {
+				if matchHexAt(data, pos+skip, tokens[i+1:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if pos >= len(data) || data[pos]&t.mask != t.value {
+			return false
+		}
+		pos++
+	}
+	return true
+}
+
+// Condition of a rule, evaluated with the strings found
+type expr interface {
+	eval(found []bool) bool
+}
+
+type orExpr struct{ left, right expr }
+
+func (e orExpr) eval(found []bool) bool { return e.left.eval(found) || e.right.eval(found) }
+
+type andExpr struct{ left, right expr }
+
+func (e andExpr) eval(found []bool) bool { return e.left.eval(found) && e.right.eval(found) }
+
+type notExpr struct{ e expr }
+
+func (e notExpr) eval(found []bool) bool { return !e.e.eval(found) }
+
+type constExpr bool
+
+func (e constExpr) eval(found []bool) bool { return bool(e) }
+
+// A string of the rule, by index
+type stringExpr int
+
+func (e stringExpr) eval(found []bool) bool { return found[e] }
+
+// Quantifier of a set of strings, e.g., "any of them"
+type ofExpr struct {
+	set  []int
+	min  int
+	all  bool
+	none bool
+}
+
+func (e ofExpr) eval(found []bool) bool {
+	n := 0
+	for _, i := range e.set {
+		if found[i] {
+			n++
+		}
+	}
+
+	switch {
+	case e.all:
+		return n == len(e.set)
+	case e.none:
+		return n == 0
+	}
+	return n >= e.min
+}
+
+// Rule that recognises a payload or a command
+type Rule struct {
+	Name string            `json:"name"`
+	Tags []string          `json:"tags"`
+	Meta map[string]string `json:"meta"`
+
+	strings   []*pattern
+	condition expr
+}
+
+// Returns the family of the malware recognised by the rule, given in its metadata
+func (r *Rule) GetFamily() string {
+	return r.Meta["family"]
+}
+
+// Returns the names of the strings of the rule
+func (r *Rule) GetStrings() []string {
+	names := make([]string, 0, len(r.strings))
+	for _, s := range r.strings {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// Returns the index of a string, or -1 if the rule does not have it
+func (r *Rule) index(name string) int {
+	for i, s := range r.strings {
+		if s.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the strings of the rule found in the data, and whether the condition is satisfied
+func (r *Rule) Match(data []byte) (names []string, ok bool) {
+	return r.match(data, toLower(data))
+}
+
+func (r *Rule) match(data []byte, lower []byte) (names []string, ok bool) {
+	names = []string{}
+	found := make([]bool, len(r.strings))
+	for i, s := range r.strings {
+		if found[i] = s.match(data, lower); found[i] {
+			names = append(names, s.name)
+		}
+	}
+	return names, r.condition.eval(found)
+}
+
+// Rule matched by some data
+type Match struct {
+	Rule   string `json:"rule"`
+	Family string `json:"family,omitempty"`
+	// Strings of the rule found in the data
+	Strings []string `json:"strings"`
+}
+
+// Set of rules
+type RuleSet struct {
+	mu    sync.RWMutex
+	rules []*Rule
+}
+
+func (rs *RuleSet) GetName() string {
+	return "signatures"
+}
+
+// Replace the rules
+func (rs *RuleSet) SetRules(rules []*Rule) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.rules = append([]*Rule{}, rules...)
+}
+
+// Returns the rules
+func (rs *RuleSet) GetRules() []*Rule {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	return append([]*Rule{}, rs.rules...)
+}
+
+// Replace the rules with the ones of a file, or of the .yar and .yara files of a directory
+func (rs *RuleSet) Load(path string) (err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files = []string{}
+		for _, pattern := range []string{"*.yar", "*.yara"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return err
+			}
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+	}
+
+	var src strings.Builder
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		src.Write(data)
+		src.WriteByte('\n')
+	}
+
+	rules, err := Parse(src.String())
+	if err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+
+	rs.SetRules(rules)
+	return
+}
+
+// Returns the rules matched by the data
+func (rs *RuleSet) Scan(data []byte) (matches []Match) {
+	matches = []Match{}
+	if len(data) == 0 {
+		return
+	}
+
+	lower := toLower(data)
+	for _, r := range rs.GetRules() {
+		if names, ok := r.match(data, lower); ok {
+			matches = append(matches, Match{Rule: r.Name, Family: r.GetFamily(), Strings: names})
+		}
+	}
+	return
+}
+
+// Match the commands of the attackers, e.g., the loaders of the botnets.
+// The payloads are matched when they are captured, see the artifacts package
+func (rs *RuleSet) Enrich(ev *events.Event) {
+	if ev.Type != events.CommandEvent {
+		return
+	}
+
+	Annotate(ev, rs.Scan([]byte(ev.GetString("command"))))
+}
+
+// Add the rules matched to an event. The families are also added as tags, in lower case,
+// so they are kept in the sessions
+func Annotate(ev *events.Event, matches []Match) *events.Event {
+	if len(matches) == 0 {
+		return ev
+	}
+
+	rules, _ := ev.Fields[RulesField].([]string)
+	families, _ := ev.Fields[FamiliesField].([]string)
+
+	for _, m := range matches {
+		rules = appendUnique(rules, m.Rule)
+		if m.Family != "" {
+			families = appendUnique(families, m.Family)
+			ev.Tags = appendUnique(ev.Tags, strings.ToLower(m.Family))
+		}
+	}
+
+	ev.With(RulesField, rules)
+	if len(families) > 0 {
+		ev.With(FamiliesField, families)
+	}
+	return ev
+}
+
+// Create a set with the default rules
+func NewRuleSet() *RuleSet {
+	rules, err := Parse(Defaults)
+	if err != nil {
+		panic(err)
+	}
+
+	return &RuleSet{rules: rules}
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+func init() {
+	events.Events.AddEnricher(Signatures)
+}
@@ -0,0 +1,350 @@
+/*
+This package implements the option negotiation of the Telnet protocol for the services,
+so the commands of the clients are not mixed with what they type.
+
+Specifications: RFC 854 (protocol), RFC 857 (ECHO), RFC 858 (SGA), RFC 1073 (NAWS)
+and RFC 1091 (TTYPE)
+*/
+package telnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Commands
+const (
+	SE   byte = 240
+	SB   byte = 250
+	WILL byte = 251
+	WONT byte = 252
+	DO   byte = 253
+	DONT byte = 254
+	IAC  byte = 255
+)
+
+// Options
+const (
+	ECHO  byte = 1
+	SGA   byte = 3
+	TTYPE byte = 24
+	NAWS  byte = 31
+)
+
+const (
+	// Subcommands of the terminal type
+	ttypeIs   byte = 0
+	ttypeSend byte = 1
+
+	// Maximum length of a subnegotiation, the rest is discarded
+	maxSubnegotiation = 64
+)
+
+// State of the parser of the input
+type state int8
+
+const (
+	dataState state = iota
+	iacState
+	optionState
+	sbState
+	sbIacState
+)
+
+// Connection that negotiates the options with the client and strips the commands from
+// the input. The server echoes the input when it negotiated the option, and the line
+// endings of the client, "\r\n", "\r\x00" or "\n", are read as "\r\n". The input is
+// read one line at a time, so the echo of a line follows the one read before it, e.g.,
+// the password sent along the username is not echoed
+type Conn struct {
+	net.Conn
+
+	mu sync.Mutex
+	// Options enabled in the server and in the client
+	local  map[byte]bool
+	remote map[byte]bool
+	// Options requested by the server, the client agreeing to them is not answered
+	requested map[byte]bool
+	// Whether the input is echoed, e.g., not while the password is typed
+	echo bool
+
+	// Input of the client not parsed yet, the lines after the one read
+	pending []byte
+
+	state   state
+	command byte
+	sb      []byte
+	// Whether the last byte read, or written, was a carriage return
+	readCR  bool
+	writeCR bool
+
+	width  int
+	height int
+	term   string
+}
+
+// Offer to echo the input and to suppress the go-ahead, and ask the client for the size
+// and the type of its terminal
+func (c *Conn) Negotiate() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.local[ECHO], c.local[SGA] = true, true
+	c.requested[NAWS], c.requested[TTYPE] = true, true
+	c.echo = true
+
+	_, err := c.Conn.Write([]byte{IAC, WILL, ECHO, IAC, WILL, SGA, IAC, DO, NAWS, IAC, DO, TTYPE})
+	return err
+}
+
+// Echo the input, or stop echoing it, e.g., while the password is typed
+func (c *Conn) SetEcho(echo bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.echo = echo
+}
+
+// Returns the size of the terminal of the client, zero when it was not sent
+func (c *Conn) Size() (width int, height int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.width, c.height
+}
+
+// Returns the type of the terminal of the client in lower case, e.g., "xterm", empty when it was not sent
+func (c *Conn) Term() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.term
+}
+
+// Read the data sent by the client, answering the commands found in between
+func (c *Conn) Read(b []byte) (n int, err error) {
+	// Each byte read can be copied as two, e.g., "\n" as "\r\n"
+	size := len(b) / 2
+	if size == 0 {
+		size = 1
+	}
+
+	buf := make([]byte, size)
+	for n == 0 {
+		if len(c.pending) == 0 {
+			var read int
+			read, err = c.Conn.Read(buf)
+			c.pending = buf[:read]
+		}
+
+		if len(c.pending) > 0 {
+			in := c.pending
+			if len(in) > size {
+				in = in[:size]
+			}
+
+			var parsed int
+			var reply []byte
+			parsed, n, reply = c.parse(in, b)
+			c.pending = c.pending[parsed:]
+			if len(reply) > 0 {
+				c.Conn.Write(reply)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Parse the input up to the end of the first line, copying the data to b. Returns the
+// number of bytes parsed, the number of bytes copied, and the answers to the commands and the echo
+func (c *Conn) parse(in []byte, b []byte) (parsed int, n int, out []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var reply, echo bytes.Buffer
+	for parsed < len(in) {
+		x := in[parsed]
+		parsed++
+
+		switch c.state {
+		case dataState:
+			if x == IAC {
+				c.state = iacState
+				continue
+			}
+
+			copied, eol := c.data(x, b[n:], &echo)
+			n += copied
+			if eol {
+				return parsed, n, append(reply.Bytes(), echo.Bytes()...)
+			}
+		case iacState:
+			switch x {
+			case IAC:
+				copied, _ := c.data(x, b[n:], &echo)
+				n += copied
+				c.state = dataState
+			case WILL, WONT, DO, DONT:
+				c.command = x
+				c.state = optionState
+			case SB:
+				c.sb = c.sb[:0]
+				c.state = sbState
+			default:
+				// Other commands, e.g., "are you there", are ignored
+				c.state = dataState
+			}
+		case optionState:
+			c.option(c.command, x, &reply)
+			c.state = dataState
+		case sbState:
+			if x == IAC {
+				c.state = sbIacState
+				continue
+			}
+			if len(c.sb) < maxSubnegotiation {
+				c.sb = append(c.sb, x)
+			}
+		case sbIacState:
+			switch x {
+			case SE:
+				c.subnegotiation()
+				c.state = dataState
+			case IAC:
+				if len(c.sb) < maxSubnegotiation {
+					c.sb = append(c.sb, x)
+				}
+				c.state = sbState
+			default:
+				c.state = sbState
+			}
+		}
+	}
+
+	return parsed, n, append(reply.Bytes(), echo.Bytes()...)
+}
+
+// Copy a byte of data, with the line endings of the terminal, and echo it if enabled and
+// the client agreed to it. Returns whether the byte ended the line
+func (c *Conn) data(x byte, b []byte, echo *bytes.Buffer) (n int, eol bool) {
+	cr := c.readCR
+	c.readCR = x == '\r'
+	echoing := c.echo && c.local[ECHO]
+
+	switch {
+	case cr && (x == '\n' || x == 0):
+		// The end of the line was already read with the carriage return
+		return 0, false
+	case x == '\r' || x == '\n':
+		if echoing {
+			echo.WriteString("\r\n")
+		}
+		return copy(b, "\r\n"), true
+	}
+
+	if echoing {
+		echo.WriteByte(x)
+		if x == IAC {
+			echo.WriteByte(IAC)
+		}
+	}
+	b[0] = x
+	return 1, false
+}
+
+// Answer a request of the client to enable or disable an option
+func (c *Conn) option(command byte, option byte, reply *bytes.Buffer) {
+	switch command {
+	case DO:
+		if option != ECHO && option != SGA {
+			reply.Write([]byte{IAC, WONT, option})
+			return
+		}
+		if !c.local[option] {
+			c.local[option] = true
+			reply.Write([]byte{IAC, WILL, option})
+		}
+	case DONT:
+		if c.local[option] {
+			c.local[option] = false
+			reply.Write([]byte{IAC, WONT, option})
+		}
+	case WILL:
+		if option != NAWS && option != TTYPE && option != SGA {
+			reply.Write([]byte{IAC, DONT, option})
+			return
+		}
+		if c.remote[option] {
+			return
+		}
+		c.remote[option] = true
+		if !c.requested[option] {
+			reply.Write([]byte{IAC, DO, option})
+		}
+		if option == TTYPE {
+			reply.Write([]byte{IAC, SB, TTYPE, ttypeSend, IAC, SE})
+		}
+	case WONT:
+		if c.remote[option] {
+			c.remote[option] = false
+			reply.Write([]byte{IAC, DONT, option})
+		}
+	}
+}
+
+// Keep the size or the type of the terminal sent by the client
+func (c *Conn) subnegotiation() {
+	if len(c.sb) == 0 {
+		return
+	}
+
+	switch c.sb[0] {
+	case NAWS:
+		if len(c.sb) == 5 {
+			c.width = int(binary.BigEndian.Uint16(c.sb[1:3]))
+			c.height = int(binary.BigEndian.Uint16(c.sb[3:5]))
+		}
+	case TTYPE:
+		if len(c.sb) > 2 && c.sb[1] == ttypeIs {
+			c.term = strings.ToLower(string(c.sb[2:]))
+		}
+	}
+}
+
+// Write the data escaping the IAC bytes, with the line endings of the terminal
+func (c *Conn) Write(b []byte) (n int, err error) {
+	c.mu.Lock()
+	out := make([]byte, 0, len(b)+len(b)/8)
+	for _, x := range b {
+		switch {
+		case x == IAC:
+			out = append(out, IAC, IAC)
+		case x == '\n' && !c.writeCR:
+			out = append(out, '\r', '\n')
+		default:
+			out = append(out, x)
+		}
+		c.writeCR = x == '\r'
+	}
+	c.mu.Unlock()
+
+	if _, err = c.Conn.Write(out); err != nil {
+		return
+	}
+	return len(b), nil
+}
+
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		Conn:      conn,
+		local:     make(map[byte]bool),
+		remote:    make(map[byte]bool),
+		requested: make(map[byte]bool),
+	}
+}
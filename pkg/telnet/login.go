@@ -0,0 +1,89 @@
+package telnet
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/shell"
+)
+
+var (
+	// Time waited after a failed login, as login does to slow down the brute force
+	FailDelay = 2 * time.Second
+)
+
+// Prompt for the credentials until the persona accepts them or the attempts run out,
+// emitting an event for each attempt. The sources that run out of attempts are locked out
+func Login(conn *Conn, br *bufio.Reader, service string, persona shell.Persona) (user string, ok bool) {
+	peer := conn.RemoteAddr().String()
+	ip := attackerIP(peer)
+
+	for attempt := 1; persona.MaxAttempts <= 0 || attempt <= persona.MaxAttempts; attempt++ {
+		response, err := prompt("login: ", conn, br)
+		if err != nil {
+			return
+		}
+		user = strings.TrimRight(response, "\r\n")
+
+		// The password is not echoed
+		conn.SetEcho(false)
+		response, err = prompt("Password: ", conn, br)
+		conn.SetEcho(true)
+		if err != nil {
+			return
+		}
+		password := strings.TrimRight(response, "\r\n")
+		conn.Write([]byte("\n"))
+
+		// The sources locked out fail with any credentials
+		locked := shell.Lockouts.Locked(ip)
+		ok = !locked && persona.Accepts(user, password)
+
+		// The events carry the peer, the manager replaces it with the attacker behind a proxy
+		ev := events.NewEvent(events.AuthEvent, service, peer)
+		ev.With("user", user).With("password", password).With("success", ok).With("attempt", attempt)
+		if term := conn.Term(); term != "" {
+			ev.With("term", term)
+		}
+		if locked {
+			ev.With("locked", true)
+		}
+		events.Events.Emit(ev)
+
+		if ok {
+			return
+		}
+
+		time.Sleep(FailDelay)
+		conn.Write([]byte("Login incorrect\n"))
+	}
+
+	shell.Lockouts.Lock(ip, time.Duration(persona.Lockout)*time.Second)
+	return "", false
+}
+
+// Returns the IP of the attacker. Behind a proxy the peer is the proxy, the attacker
+// is the source of the link of the proxy
+func attackerIP(peer string) string {
+	addr := peer
+	if link, ok := events.Events.Resolve(peer); ok && link.Source != "" {
+		addr = link.Source
+	}
+
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return ip
+}
+
+// Send a prompt and read the line of the client
+func prompt(msg string, conn *Conn, br *bufio.Reader) (string, error) {
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return "", err
+	}
+	return br.ReadString('\n')
+}
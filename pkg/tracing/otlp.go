@@ -0,0 +1,311 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	lr "github.com/riotpot/pkg/logger"
+)
+
+const (
+	// Name of the service in the resource of the spans
+	serviceName = "riotpot"
+
+	// Number of spans waiting to be exported before new spans are dropped
+	otlpQueueSize = 4096
+	// Maximum number of spans sent in a request
+	otlpBatchSize = 512
+)
+
+// Exporter that sends the spans to an OpenTelemetry collector with OTLP over HTTP,
+// using the JSON encoding. E.g., `http://localhost:4318/v1/traces`
+type OTLPExporter struct {
+	// URL of the traces endpoint of the collector
+	endpoint string
+	// Headers added to the requests, e.g., for authentication
+	Headers map[string]string
+
+	// Time between exports
+	interval time.Duration
+	client   *http.Client
+
+	queue chan *Span
+	quit  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+func (e *OTLPExporter) GetEndpoint() string {
+	return e.endpoint
+}
+
+// Queue the span to be exported. The span is dropped if the queue is full
+func (e *OTLPExporter) Export(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		lr.Log.Warn().Str("span", span.Name).Msg("Tracing queue full, span dropped")
+	}
+}
+
+// Export the pending spans and stop
+func (e *OTLPExporter) Shutdown() {
+	e.once.Do(func() {
+		close(e.quit)
+		e.wg.Wait()
+	})
+}
+
+// Export the spans in batches, periodically or when the batch is full
+func (e *OTLPExporter) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, otlpBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := e.send(batch); err != nil {
+			lr.Log.Warn().Err(err).Int("spans", len(batch)).Msg("Could not export the spans")
+		}
+		batch = make([]*Span, 0, otlpBatchSize)
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= otlpBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.quit:
+			// Drain the queue before leaving
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Send a batch of spans to the collector
+func (e *OTLPExporter) send(spans []*Span) (err error) {
+	body, err := json.Marshal(encodeSpans(spans))
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector answered with status %d", resp.StatusCode)
+	}
+	return
+}
+
+// Create an exporter to the traces endpoint of a collector and start it.
+// The spans are sent every interval, or every 5 seconds when the interval is 0
+func NewOTLPExporter(endpoint string, interval time.Duration) *OTLPExporter {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		Headers:  make(map[string]string),
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *Span, otlpQueueSize),
+		quit:     make(chan struct{}),
+	}
+
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+// Structures of the OTLP JSON encoding.
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Events            []otlpEvent     `json:"events,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+
+	otlpEvent struct {
+		TimeUnixNano string          `json:"timeUnixNano"`
+		Name         string          `json:"name"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue *string         `json:"stringValue,omitempty"`
+		BoolValue   *bool           `json:"boolValue,omitempty"`
+		IntValue    *string         `json:"intValue,omitempty"`
+		DoubleValue *float64        `json:"doubleValue,omitempty"`
+		ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+	}
+
+	otlpArrayValue struct {
+		Values []otlpValue `json:"values"`
+	}
+)
+
+func encodeSpans(spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.ID.String(),
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.Attributes),
+			Status:            otlpStatus{Code: int(s.Status), Message: s.StatusMessage},
+		}
+
+		if s.ParentID.IsValid() {
+			span.ParentSpanID = s.ParentID.String()
+		}
+
+		for _, ev := range s.Events {
+			span.Events = append(span.Events, otlpEvent{
+				TimeUnixNano: strconv.FormatInt(ev.Time.UnixNano(), 10),
+				Name:         ev.Name,
+				Attributes:   encodeAttributes(ev.Attributes),
+			})
+		}
+		s.mu.Unlock()
+
+		encoded = append(encoded, span)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: encodeAttributes(map[string]interface{}{"service.name": serviceName}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: serviceName},
+				Spans: encoded,
+			}},
+		}},
+	}
+}
+
+// Encode the attributes, sorted by key
+func encodeAttributes(attributes map[string]interface{}) (ret []otlpAttribute) {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		ret = append(ret, otlpAttribute{Key: key, Value: encodeValue(attributes[key])})
+	}
+	return
+}
+
+func encodeValue(value interface{}) (v otlpValue) {
+	switch val := value.(type) {
+	case string:
+		v.StringValue = &val
+	case bool:
+		v.BoolValue = &val
+	case int:
+		s := strconv.FormatInt(int64(val), 10)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(val, 10)
+		v.IntValue = &s
+	case uint16:
+		s := strconv.FormatInt(int64(val), 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &val
+	case []string:
+		array := &otlpArrayValue{Values: []otlpValue{}}
+		for _, item := range val {
+			array.Values = append(array.Values, encodeValue(item))
+		}
+		v.ArrayValue = array
+	default:
+		s := fmt.Sprintf("%v", val)
+		v.StringValue = &s
+	}
+	return
+}
@@ -0,0 +1,250 @@
+/*
+This package traces the sessions of the attackers. Each proxied connection is a trace,
+with spans for the steps taken by the proxy and the services.
+Spans are exported with the OpenTelemetry protocol (OTLP)
+*/
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+var (
+	// Exportable tracer used by the proxies and the services
+	Tracer = NewTracer()
+)
+
+// Kind of span, as defined by OpenTelemetry
+type Kind int
+
+const (
+	InternalKind Kind = 1
+	ServerKind   Kind = 2
+	ClientKind   Kind = 3
+)
+
+// Status of a span, as defined by OpenTelemetry
+type Status int
+
+const (
+	UnsetStatus Status = 0
+	OkStatus    Status = 1
+	ErrorStatus Status = 2
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// Something that happened at a point in time during a span
+type SpanEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]interface{}
+}
+
+// Operation in a trace
+type Span struct {
+	tracer *tracer
+
+	mu sync.Mutex
+
+	TraceID  TraceID
+	ID       SpanID
+	ParentID SpanID
+	Name     string
+	Kind     Kind
+
+	Start time.Time
+	End   time.Time
+
+	Attributes    map[string]interface{}
+	Events        []SpanEvent
+	Status        Status
+	StatusMessage string
+
+	// Spans are only recorded when the tracer has an exporter
+	recording bool
+	ended     bool
+}
+
+// Whether the span will be exported
+func (s *Span) IsRecording() bool {
+	return s != nil && s.recording
+}
+
+// Set an attribute and return the span, to chain calls
+func (s *Span) SetAttribute(key string, value interface{}) *Span {
+	if !s.IsRecording() {
+		return s
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Attributes[key] = value
+	return s
+}
+
+// Record something that happened during the span
+func (s *Span) AddEvent(name string, attributes map[string]interface{}) {
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Events = append(s.Events, SpanEvent{Name: name, Time: time.Now(), Attributes: attributes})
+}
+
+// Mark the span as failed
+func (s *Span) SetError(err error) {
+	if !s.IsRecording() || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Status = ErrorStatus
+	s.StatusMessage = err.Error()
+}
+
+// Finish the span and send it to the exporter
+func (s *Span) Finish() {
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.export(s)
+}
+
+// Start a child span
+func (s *Span) Child(name string, kind Kind) *Span {
+	return s.tracer.Start(name, kind, s)
+}
+
+// Destination of the finished spans
+type Exporter interface {
+	Export(span *Span)
+	// Send the pending spans and stop the exporter
+	Shutdown()
+}
+
+// Creates the spans and relates the spans of the services to the proxied connection
+type tracer struct {
+	mu       sync.RWMutex
+	exporter Exporter
+
+	// Spans of the proxied connections, by the local address of the proxy side of the
+	// connection to the service
+	links sync.Map
+}
+
+// Set the exporter of the spans. Use nil to stop tracing
+func (t *tracer) SetExporter(exporter Exporter) {
+	t.mu.Lock()
+	previous := t.exporter
+	t.exporter = exporter
+	t.mu.Unlock()
+
+	if previous != nil {
+		previous.Shutdown()
+	}
+}
+
+// Whether the spans are being exported
+func (t *tracer) IsEnabled() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.exporter != nil
+}
+
+// Start a span. When the parent is nil, the span starts a new trace
+func (t *tracer) Start(name string, kind Kind, parent *Span) *Span {
+	s := &Span{
+		tracer:     t,
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+		recording:  t.IsEnabled(),
+	}
+
+	if !s.recording {
+		return s
+	}
+
+	if parent.IsRecording() {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.ID
+	} else {
+		rand.Read(s.TraceID[:])
+	}
+	rand.Read(s.ID[:])
+
+	return s
+}
+
+// Start a span for a client of a service. The span is a child of the proxied connection
+// of the client when there is one, so the steps of the services join the trace of the proxy
+func (t *tracer) StartFor(peer string, name string, kind Kind) *Span {
+	var parent *Span
+	if value, ok := t.links.Load(peer); ok {
+		parent = value.(*Span)
+	}
+
+	return t.Start(name, kind, parent)
+}
+
+// Relate the address of the proxy side of a connection to a service with the span of the connection
+func (t *tracer) Link(peer string, span *Span) {
+	if !span.IsRecording() {
+		return
+	}
+	t.links.Store(peer, span)
+}
+
+// Remove a link once the connection is closed
+func (t *tracer) Unlink(peer string) {
+	t.links.Delete(peer)
+}
+
+func (t *tracer) export(s *Span) {
+	t.mu.RLock()
+	exporter := t.exporter
+	t.mu.RUnlock()
+
+	if exporter != nil {
+		exporter.Export(s)
+	}
+}
+
+func NewTracer() *tracer {
+	return &tracer{}
+}
@@ -1,13 +1,16 @@
 package utils
 
 import (
+	"fmt"
 	"strconv"
 )
 
 type (
-	Status      int8
-	Network     int8
-	Interaction int8
+	Status        int8
+	Network       int8
+	Interaction   int8
+	ProxyProtocol int8
+	Balancing     int8
 )
 
 // Proxy status
@@ -131,3 +134,109 @@ func ParseInteraction(interaction string) (in Interaction, err error) {
 
 	return Interaction(i), nil
 }
+
+// PROXY protocol version sent to the services
+const (
+	// Do not send the PROXY protocol header
+	NoProxyProtocol ProxyProtocol = iota
+	// Human-readable header (version 1)
+	ProxyProtocolV1
+	// Binary header (version 2)
+	ProxyProtocolV2
+
+	// Value for no PROXY protocol
+	NoProxyProtocolValue = "none"
+	// Value for the version 1
+	ProxyProtocolV1Value = "v1"
+	// Value for the version 2
+	ProxyProtocolV2Value = "v2"
+)
+
+func (p ProxyProtocol) String() string {
+	switch p {
+	case NoProxyProtocol:
+		return NoProxyProtocolValue
+	case ProxyProtocolV1:
+		return ProxyProtocolV1Value
+	case ProxyProtocolV2:
+		return ProxyProtocolV2Value
+	}
+
+	return strconv.Itoa(int(p))
+}
+
+// Parse a PROXY protocol version by name, e.g., "v1", or by number. Only the defined versions are accepted
+func ParseProxyProtocol(version string) (pp ProxyProtocol, err error) {
+	switch version {
+	case NoProxyProtocol.String(), "":
+		return NoProxyProtocol, nil
+	case ProxyProtocolV1.String():
+		return ProxyProtocolV1, nil
+	case ProxyProtocolV2.String():
+		return ProxyProtocolV2, nil
+	}
+
+	i, err := strconv.Atoi(version)
+	if err != nil || i < int(NoProxyProtocol) || i > int(ProxyProtocolV2) {
+		return NoProxyProtocol, fmt.Errorf("invalid PROXY protocol version: %s", version)
+	}
+
+	return ProxyProtocol(i), nil
+}
+
+// Selection of the service that receives a connection when a proxy has many
+const (
+	// Use the services in order, the next one is only used when the previous fail
+	OrderedBalancing Balancing = iota
+	// Distribute the connections by the weight of the services
+	WeightedBalancing
+	// Use the service with the least open connections
+	LeastConnectionsBalancing
+	// Keep sending each source to the same service
+	StickyBalancing
+
+	// Value for the ordered balancing
+	OrderedBalancingValue = "ordered"
+	// Value for the weighted balancing
+	WeightedBalancingValue = "weighted"
+	// Value for the least connections balancing
+	LeastConnectionsBalancingValue = "least-connections"
+	// Value for the sticky balancing
+	StickyBalancingValue = "sticky"
+)
+
+func (b Balancing) String() string {
+	switch b {
+	case OrderedBalancing:
+		return OrderedBalancingValue
+	case WeightedBalancing:
+		return WeightedBalancingValue
+	case LeastConnectionsBalancing:
+		return LeastConnectionsBalancingValue
+	case StickyBalancing:
+		return StickyBalancingValue
+	}
+
+	return strconv.Itoa(int(b))
+}
+
+// Parse a balancing by name, e.g., "sticky", or by number. Only the defined balancings are accepted
+func ParseBalancing(balancing string) (b Balancing, err error) {
+	switch balancing {
+	case OrderedBalancing.String():
+		return OrderedBalancing, nil
+	case WeightedBalancing.String():
+		return WeightedBalancing, nil
+	case LeastConnectionsBalancing.String():
+		return LeastConnectionsBalancing, nil
+	case StickyBalancing.String():
+		return StickyBalancing, nil
+	}
+
+	i, err := strconv.Atoi(balancing)
+	if err != nil || i < int(OrderedBalancing) || i > int(StickyBalancing) {
+		return OrderedBalancing, fmt.Errorf("invalid balancing: %s", balancing)
+	}
+
+	return Balancing(i), nil
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/riotpot/pkg/artifacts"
 	lr "github.com/riotpot/pkg/logger"
 	"github.com/riotpot/pkg/service"
 	"github.com/riotpot/pkg/utils"
@@ -142,6 +143,8 @@ func (c *FTP) handle(command string, msgs []string) {
 		} else {
 			c.reply(fmt.Sprintf("%s Create directory operation failed.", result))
 		}
+	case "stor":
+		stor(c, strings.Join(msgs, " "))
 	case "quit":
 		c.reply("221 bye")
 		c.command.Close()
@@ -167,6 +170,31 @@ func list(c *FTP, dir string) {
 
 }
 
+// Receive a file from the data connection and keep it in the artifacts store
+func stor(c *FTP, file string) {
+	if c.data == nil {
+		c.reply("425 Use PORT or PASV first.")
+		return
+	}
+
+	c.reply("150 Ok to send data.")
+	_, err := artifacts.Artifacts.Capture(c.data, artifacts.Origin{
+		Source:   c.command.RemoteAddr().String(),
+		Service:  name,
+		Method:   artifacts.FTPMethod,
+		Filename: getAbsolutePath(c.cwd, file),
+	})
+
+	c.data.Close()
+	c.data = nil
+
+	if err != nil {
+		c.reply("426 Connection closed; transfer aborted.")
+		return
+	}
+	c.reply("226 Transfer complete.")
+}
+
 func cwd(c *FTP, dir string) {
 	if dir == ".." {
 		if c.cwd != c.root {
@@ -2,10 +2,14 @@ package main
 
 import (
 	"fmt"
+	"mime"
 	"net/http"
+	"strings"
 
+	"github.com/riotpot/pkg/artifacts"
 	lr "github.com/riotpot/pkg/logger"
 	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/tracing"
 	"github.com/riotpot/pkg/utils"
 )
 
@@ -56,6 +60,10 @@ func (h *Http) serve(srv *http.Server) {
 
 // This function handles connections made to a valid path
 func (h *Http) valid(w http.ResponseWriter, req *http.Request) {
+	span := tracing.Tracer.StartFor(req.RemoteAddr, "http.request", tracing.ServerKind)
+	span.SetAttribute("http.request.method", req.Method).SetAttribute("url.path", req.URL.Path)
+	defer span.Finish()
+
 	var (
 		head, body string
 	)
@@ -98,6 +106,8 @@ func (h *Http) valid(w http.ResponseWriter, req *http.Request) {
 	`
 
 	if req.Method == http.MethodPost {
+		h.capture(req)
+
 		errormessage := `
 		<div class="alert alert-danger">
 			<p>Incorrect username or password.</p>
@@ -110,3 +120,48 @@ func (h *Http) valid(w http.ResponseWriter, req *http.Request) {
 
 	fmt.Fprint(w, response)
 }
+
+// Keep the body of the request in the artifacts store. Each file of a multipart form
+// is kept on its own, the urlencoded forms are the logins and are not files
+func (h *Http) capture(req *http.Request) {
+	origin := artifacts.Origin{
+		Source:   req.RemoteAddr,
+		Service:  name,
+		Method:   artifacts.HTTPMethod,
+		Filename: req.URL.Path,
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		return
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		if req.ContentLength != 0 {
+			if _, err := artifacts.Artifacts.Capture(req.Body, origin); err != nil {
+				lr.Log.Warn().Err(err).Msg("Could not capture the request body")
+			}
+		}
+		return
+	}
+
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return
+		}
+
+		if part.FileName() != "" {
+			origin.Filename = part.FileName()
+			if _, err := artifacts.Artifacts.Capture(part, origin); err != nil {
+				lr.Log.Warn().Err(err).Msg("Could not capture the uploaded file")
+			}
+		}
+		part.Close()
+	}
+}
@@ -1,18 +1,14 @@
 package main
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
-	"math/big"
 	"net"
 	"net/http"
-	"time"
 
+	"github.com/riotpot/pkg/certificates"
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/fingerprint"
 	lr "github.com/riotpot/pkg/logger"
 	"github.com/riotpot/pkg/service"
 	"github.com/riotpot/pkg/utils"
@@ -48,7 +44,7 @@ func (h *Https) Run() (err error) {
 
 	mux.HandleFunc("/", http.HandlerFunc(h.valid))
 
-	cert, err := generateSelfSignedCertificate()
+	cert, err := certificates.NewSelfSigned()
 	if err != nil {
 		lr.Log.Fatal().Err(err)
 	}
@@ -68,11 +64,26 @@ func (h *Https) Run() (err error) {
 }
 
 func (h *Https) serve(srv *http.Server) {
-	if err := srv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+	listener, err := net.Listen(network.String(), srv.Addr)
+	if err != nil {
+		lr.Log.Fatal().Err(err)
+		return
+	}
+
+	// Fingerprint the clients from their ClientHello
+	listener = fingerprint.NewTLSListener(listener, h.onHello)
+
+	if err := srv.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
 		lr.Log.Fatal().Err(err)
 	}
 }
 
+// Emit the fingerprints of the client, the proxy already emitted the connection
+func (h *Https) onHello(conn net.Conn, ch *fingerprint.ClientHello) {
+	ev := events.NewEvent(events.FingerprintEvent, name, conn.RemoteAddr().String())
+	events.Events.Emit(ch.Annotate(ev))
+}
+
 // This function handles connections made to a valid path
 func (h *Https) valid(w http.ResponseWriter, req *http.Request) {
 	var (
@@ -129,49 +140,3 @@ func (h *Https) valid(w http.ResponseWriter, req *http.Request) {
 
 	fmt.Fprint(w, response)
 }
-
-func generateSelfSignedCertificate() (tls.Certificate, error) {
-	// Create certificates on the fly
-	// Modified from https://gist.github.com/samuel/8b500ddd3f6118d052b5e6bc16bc4c09
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return tls.Certificate{}, err
-	}
-
-	// configure the certificate
-	notBefore := time.Now()
-	notAfter := notBefore.Add(365 * 24 * time.Hour)
-
-	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			CommonName:   "riotpot.com",
-			Organization: []string{"RiotPot"},
-		},
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-	}
-
-	hosts := []string{"riotpot.com"}
-	for _, h := range hosts {
-		ip := net.ParseIP(h)
-		if ip != nil {
-			template.IPAddresses = append(template.IPAddresses, ip)
-		} else {
-			template.DNSNames = append(template.DNSNames, h)
-		}
-	}
-
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
-	if err != nil {
-		return tls.Certificate{}, err
-	}
-
-	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
-
-	return tls.X509KeyPair(certPEM, keyPEM)
-}
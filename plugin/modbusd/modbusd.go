@@ -5,8 +5,10 @@ import (
 	"io"
 	"net"
 
+	"github.com/riotpot/pkg/events"
 	"github.com/riotpot/pkg/logger"
 	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/tracing"
 	"github.com/riotpot/pkg/utils"
 	"github.com/xiegeo/modbusone"
 )
@@ -20,6 +22,18 @@ const (
 	size    = 0x10000
 )
 
+// Names of the functions, used as the command of the events
+var functions = map[modbusone.FunctionCode]string{
+	modbusone.FcReadCoils:              "read_coils",
+	modbusone.FcReadDiscreteInputs:     "read_discrete_inputs",
+	modbusone.FcReadHoldingRegisters:   "read_holding_registers",
+	modbusone.FcReadInputRegisters:     "read_input_registers",
+	modbusone.FcWriteSingleCoil:        "write_single_coil",
+	modbusone.FcWriteSingleRegister:    "write_single_register",
+	modbusone.FcWriteMultipleCoils:     "write_multiple_coils",
+	modbusone.FcWriteMultipleRegisters: "write_multiple_registers",
+}
+
 var (
 	discretes        [size]bool
 	coils            [size]bool
@@ -88,10 +102,6 @@ func (m *Modbus) handleSession(conn net.Conn) {
 	for {
 		defer conn.Close()
 
-		wec := func(conn net.Conn, bs []byte, req modbusone.PDU, err error) {
-			writeTCP(conn, bs, modbusone.ExceptionReplyPacket(req, modbusone.ToExceptionCode(err)))
-		}
-
 		var rb []byte
 		if modbusone.OverSizeSupport {
 			rb = make(
@@ -120,35 +130,60 @@ func (m *Modbus) handleSession(conn net.Conn) {
 
 			fc := p.GetFunctionCode()
 
-			// initialize the data. It will be filled with the information
-			// in the payload.
-			var data []byte
-
-			// Only two things can happen,
-			// either read from the server or write to it.
-			if fc.IsReadToServer() {
-				data, err = m.handler.OnRead(p)
-				if err != nil {
-					wec(conn, rb, p, err)
-					continue
-				}
-				writeTCP(conn, rb, p.MakeReadReply(data))
-			} else if fc.IsWriteToServer() {
-				data, err = p.GetRequestValues()
-				if err != nil {
-					wec(conn, rb, p, err)
-					continue
-				}
-				err = m.handler.OnWrite(p, data)
-				if err != nil {
-					wec(conn, rb, p, err)
-					continue
-				}
-				writeTCP(conn, rb, p.MakeWriteReply())
-			}
+			// Each request is a step of the trace of the session
+			span := tracing.Tracer.StartFor(conn.RemoteAddr().String(), "modbus.function", tracing.ServerKind)
+			span.SetAttribute("modbus.function_code", int(fc))
+
+			err = m.reply(conn, rb, p)
+			span.SetError(err)
+			span.Finish()
+
+			ev := events.NewEvent(events.CommandEvent, name, conn.RemoteAddr().String())
+			events.Events.Emit(ev.With("command", functions[fc]).
+				With("function_code", int(fc)).
+				With("address", int(p.GetAddress())).
+				With("write", fc.IsWriteToServer()))
+
+		}
+	}
+}
+
+// Answer a request, either reading from the server or writing to it.
+// Returns the error sent back to the client, if any
+func (m *Modbus) reply(conn net.Conn, rb []byte, p modbusone.PDU) (err error) {
+	fc := p.GetFunctionCode()
 
+	// initialize the data. It will be filled with the information
+	// in the payload.
+	var data []byte
+
+	// Only two things can happen,
+	// either read from the server or write to it.
+	if fc.IsReadToServer() {
+		data, err = m.handler.OnRead(p)
+		if err != nil {
+			writeException(conn, rb, p, err)
+			return
+		}
+		writeTCP(conn, rb, p.MakeReadReply(data))
+	} else if fc.IsWriteToServer() {
+		data, err = p.GetRequestValues()
+		if err != nil {
+			writeException(conn, rb, p, err)
+			return
 		}
+		err = m.handler.OnWrite(p, data)
+		if err != nil {
+			writeException(conn, rb, p, err)
+			return
+		}
+		writeTCP(conn, rb, p.MakeWriteReply())
 	}
+	return
+}
+
+func writeException(conn net.Conn, bs []byte, req modbusone.PDU, err error) {
+	writeTCP(conn, bs, modbusone.ExceptionReplyPacket(req, modbusone.ToExceptionCode(err)))
 }
 
 // Simple handler for the Modbus functions.
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/riotpot/pkg/logger"
+	"github.com/riotpot/pkg/proxyproto"
 	"github.com/riotpot/pkg/service"
 	"github.com/riotpot/pkg/utils"
 )
@@ -46,6 +47,11 @@ func (m *Mqtt) Run() (err error) {
 	listener, err := net.Listen(m.GetNetwork().String(), port)
 	logger.Log.Error().Err(err)
 
+	// Recover the address of the attacker from the header sent by the proxy, if any
+	listener = proxyproto.NewListener(listener, func() bool {
+		return m.GetProxyProtocol() != utils.NoProxyProtocol
+	})
+
 	// build a channel stack to receive connections to the service
 	conn := make(chan net.Conn)
 
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/riotpot/pkg/artifacts"
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/shell"
+	"golang.org/x/crypto/ssh"
+)
+
+// Run a command sent with an exec request. Files sent with scp are kept
+// in the artifacts store, the rest of the commands run in the fake shell
+func (s *SSH) exec(sshItem SSHConn, conn ssh.Channel, command string) {
+	defer conn.Close()
+
+	ev := events.NewEvent(events.CommandEvent, name, sshItem.RemoteAddr)
+	events.Events.Emit(ev.With("command", command))
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		exitStatus(conn, 0)
+		return
+	}
+
+	if path.Base(fields[0]) == "scp" && contains(fields, "-t") {
+		// The target is a directory when several files are sent
+		dir := contains(fields, "-d") || contains(fields, "-r")
+		s.scp(sshItem, conn, fields[len(fields)-1], dir)
+		exitStatus(conn, 0)
+		return
+	}
+
+	// load a unix-like fake shell, without a terminal
+	shell := shell.New(sshItem.User, "ubuntu")
+	shell.Service = name
+	shell.Source = sshItem.RemoteAddr
+
+	status := shell.Exec(command, conn)
+	exitStatus(conn, uint32(status))
+}
+
+// Receive the files sent by the client with `scp -t <target>`, the sink side of the protocol.
+// See https://web.archive.org/web/20170215184048/https://blogs.oracle.com/janp/entry/how_the_scp_protocol_works
+func (s *SSH) scp(sshItem SSHConn, conn ssh.Channel, target string, dir bool) {
+	br := bufio.NewReader(conn)
+	ack := func() { conn.Write([]byte{0}) }
+
+	dirs := []string{target}
+	ack()
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil || line == "" {
+			return
+		}
+
+		switch line[0] {
+		case 'C':
+			// C<mode> <size> <name>
+			parts := strings.SplitN(strings.TrimRight(line[1:], "\n"), " ", 3)
+			if len(parts) != 3 {
+				conn.Write([]byte("\x01scp: protocol error: bad mode\n"))
+				return
+			}
+
+			size, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil || size < 0 {
+				conn.Write([]byte("\x01scp: protocol error: bad size\n"))
+				return
+			}
+			ack()
+
+			// The target may be the name of the file when a single file is sent
+			filename := path.Join(dirs[len(dirs)-1], parts[2])
+			if len(dirs) == 1 && !dir && !strings.HasSuffix(target, "/") && !directories[path.Clean(target)] {
+				filename = target
+			}
+
+			artifacts.Artifacts.Capture(io.LimitReader(br, size), artifacts.Origin{
+				Source:   sshItem.RemoteAddr,
+				Service:  name,
+				Method:   artifacts.SCPMethod,
+				Filename: filename,
+			})
+
+			// The content ends with a null byte
+			if _, err := br.ReadByte(); err != nil {
+				return
+			}
+			ack()
+		case 'D':
+			// D<mode> 0 <name>
+			parts := strings.SplitN(strings.TrimRight(line[1:], "\n"), " ", 3)
+			if len(parts) != 3 {
+				conn.Write([]byte("\x01scp: protocol error: bad directory\n"))
+				return
+			}
+			dirs = append(dirs, path.Join(dirs[len(dirs)-1], parts[2]))
+			ack()
+		case 'E':
+			if len(dirs) > 1 {
+				dirs = dirs[:len(dirs)-1]
+			}
+			ack()
+		case 'T':
+			ack()
+		default:
+			return
+		}
+	}
+}
+
+// Send the exit status of the command to the client
+func exitStatus(conn ssh.Channel, status uint32) {
+	conn.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+
+	"github.com/riotpot/pkg/artifacts"
+	"github.com/riotpot/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// Minimal SFTP version 3 server that accepts the uploads of the attackers and keeps the files
+// in the artifacts store. The filesystem is fake: the directories always exist and the
+// only files are the ones uploaded in the session.
+// See https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-02
+
+// Types of packets
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpLstat    = 7
+	sftpFstat    = 8
+	sftpSetstat  = 9
+	sftpFsetstat = 10
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpRealpath = 16
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpReadlink = 19
+	sftpSymlink  = 20
+
+	sftpStatus = 101
+	sftpHandle = 102
+	sftpName   = 104
+	sftpAttrs  = 105
+)
+
+// Status codes
+const (
+	sftpOK          = 0
+	sftpEOF         = 1
+	sftpNoSuchFile  = 2
+	sftpFailure     = 4
+	sftpUnsupported = 8
+)
+
+const (
+	sftpProtoVersion = 3
+	// Flag of the files opened to write
+	sftpWriteFlag = 0x2
+	// Flags of the attributes sent
+	sftpSizeAttr  = 0x1
+	sftpPermsAttr = 0x4
+	sftpDirMode   = 0o40755
+	sftpFileMode  = 0o100644
+
+	// Limits of the packets, the files, the bytes written in a session
+	// and the files open at the same time
+	sftpMaxPacket  = 256 << 10
+	sftpMaxFile    = 64 << 20
+	sftpMaxSession = 128 << 20
+	sftpMaxHandles = 64
+
+	// Working directory of the client
+	sftpHome = "/root"
+)
+
+// Directories that exist in the fake filesystem
+var directories = map[string]bool{
+	"/": true, "/root": true, "/tmp": true, "/var": true, "/var/tmp": true, "/var/run": true,
+	"/dev": true, "/dev/shm": true, "/etc": true, "/home": true, "/usr": true, "/usr/bin": true,
+	"/usr/local": true, "/usr/local/bin": true, "/bin": true, "/sbin": true, "/mnt": true, "/opt": true,
+}
+
+// File or directory opened by the client
+type sftpHandleEntry struct {
+	path string
+	// Content written by the client, nil for the files opened to read
+	content []byte
+	written bool
+}
+
+type sftpServer struct {
+	sshItem SSHConn
+	rw      io.ReadWriter
+
+	handles map[string]*sftpHandleEntry
+	next    int
+	// Size of the files uploaded in the session
+	files map[string]int64
+	// Bytes written in the session, the uploads fail once it reaches the maximum
+	written uint64
+}
+
+// Serve the SFTP subsystem until the client closes the channel
+func (s *SSH) sftp(sshItem SSHConn, conn ssh.Channel) {
+	defer conn.Close()
+	defer exitStatus(conn, 0)
+
+	srv := &sftpServer{
+		sshItem: sshItem,
+		rw:      conn,
+		handles: make(map[string]*sftpHandleEntry),
+		files:   make(map[string]int64),
+	}
+	defer srv.closeAll()
+
+	br := bufio.NewReader(conn)
+	for {
+		var length uint32
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil {
+			return
+		}
+		if length == 0 || length > sftpMaxPacket {
+			logger.Log.Warn().Uint32("length", length).Msg("Invalid SFTP packet")
+			return
+		}
+
+		packet := make([]byte, length)
+		if _, err := io.ReadFull(br, packet); err != nil {
+			return
+		}
+
+		if err := srv.handle(packet); err != nil {
+			return
+		}
+	}
+}
+
+// Handle a packet of the client
+func (srv *sftpServer) handle(packet []byte) error {
+	t, p := packet[0], &sftpPayload{buf: packet[1:]}
+
+	if t == sftpInit {
+		return srv.send(sftpVersion, uint32(sftpProtoVersion))
+	}
+
+	id := p.uint32()
+	if p.err != nil {
+		return p.err
+	}
+
+	switch t {
+	case sftpOpen:
+		name, flags := srv.abs(p.string()), p.uint32()
+		if p.err != nil || len(srv.handles) >= sftpMaxHandles {
+			return srv.status(id, sftpFailure, "Failure")
+		}
+
+		entry := &sftpHandleEntry{path: name}
+		if flags&sftpWriteFlag != 0 {
+			entry.content = []byte{}
+		} else if _, ok := srv.files[name]; !ok {
+			return srv.status(id, sftpNoSuchFile, "No such file")
+		}
+		return srv.send(sftpHandle, id, srv.open(entry))
+	case sftpOpendir:
+		name := srv.abs(p.string())
+		if !srv.isDir(name) {
+			return srv.status(id, sftpNoSuchFile, "No such file")
+		}
+		return srv.send(sftpHandle, id, srv.open(&sftpHandleEntry{path: name}))
+	case sftpWrite:
+		entry, offset, data := srv.handles[p.string()], p.uint64(), p.string()
+		if p.err != nil || entry == nil || entry.content == nil {
+			return srv.status(id, sftpFailure, "Failure")
+		}
+
+		// The files are written in order, a write past the end would allocate the gap
+		size := uint64(len(entry.content))
+		if offset > size {
+			return srv.status(id, sftpFailure, "Failure")
+		}
+
+		end := offset + uint64(len(data))
+		if end > sftpMaxFile || end > size && srv.written+end-size > sftpMaxSession {
+			return srv.status(id, sftpFailure, "No space left on device")
+		}
+		if end > size {
+			srv.written += end - size
+			entry.content = append(entry.content, make([]byte, end-size)...)
+		}
+		copy(entry.content[offset:], data)
+		entry.written = true
+		return srv.status(id, sftpOK, "Success")
+	case sftpRead, sftpReaddir:
+		// The files and directories are empty
+		if srv.handles[p.string()] == nil {
+			return srv.status(id, sftpFailure, "Failure")
+		}
+		return srv.status(id, sftpEOF, "End of file")
+	case sftpClose:
+		handle := p.string()
+		entry := srv.handles[handle]
+		if entry == nil {
+			return srv.status(id, sftpFailure, "Failure")
+		}
+		delete(srv.handles, handle)
+		srv.capture(entry)
+		return srv.status(id, sftpOK, "Success")
+	case sftpStat, sftpLstat:
+		return srv.attrs(id, srv.abs(p.string()))
+	case sftpFstat:
+		entry := srv.handles[p.string()]
+		if entry == nil {
+			return srv.status(id, sftpFailure, "Failure")
+		}
+		if entry.content != nil {
+			return srv.send(sftpAttrs, id, fileAttrs(int64(len(entry.content))))
+		}
+		return srv.attrs(id, entry.path)
+	case sftpRealpath:
+		name := srv.abs(p.string())
+		return srv.send(sftpName, id, uint32(1), name, name, dirAttrs())
+	case sftpSetstat, sftpFsetstat, sftpMkdir, sftpRmdir, sftpRemove, sftpRename, sftpSymlink:
+		// Pretend the changes are made
+		return srv.status(id, sftpOK, "Success")
+	case sftpReadlink:
+		return srv.status(id, sftpNoSuchFile, "No such file")
+	}
+
+	return srv.status(id, sftpUnsupported, "Operation unsupported")
+}
+
+// Register an open file or directory, returning its handle
+func (srv *sftpServer) open(entry *sftpHandleEntry) string {
+	srv.next++
+	handle := strconv.Itoa(srv.next)
+	srv.handles[handle] = entry
+	return handle
+}
+
+// Keep the content written in a file
+func (srv *sftpServer) capture(entry *sftpHandleEntry) {
+	if !entry.written {
+		return
+	}
+
+	srv.files[entry.path] = int64(len(entry.content))
+	artifacts.Artifacts.Capture(bytes.NewReader(entry.content), artifacts.Origin{
+		Source:   srv.sshItem.RemoteAddr,
+		Service:  name,
+		Method:   artifacts.SFTPMethod,
+		Filename: entry.path,
+	})
+}
+
+// Keep the files the client did not close
+func (srv *sftpServer) closeAll() {
+	for _, entry := range srv.handles {
+		srv.capture(entry)
+	}
+}
+
+func (srv *sftpServer) isDir(name string) bool {
+	_, file := srv.files[name]
+	return !file && (directories[name] || path.Dir(name) == sftpHome)
+}
+
+// Send the attributes of a path
+func (srv *sftpServer) attrs(id uint32, name string) error {
+	if size, ok := srv.files[name]; ok {
+		return srv.send(sftpAttrs, id, fileAttrs(size))
+	}
+	if srv.isDir(name) {
+		return srv.send(sftpAttrs, id, dirAttrs())
+	}
+	return srv.status(id, sftpNoSuchFile, "No such file")
+}
+
+// Returns the absolute path of a name given by the client
+func (srv *sftpServer) abs(name string) string {
+	if !path.IsAbs(name) {
+		name = path.Join(sftpHome, name)
+	}
+	return path.Clean(name)
+}
+
+func (srv *sftpServer) status(id uint32, code uint32, msg string) error {
+	return srv.send(sftpStatus, id, code, msg, "en")
+}
+
+// Send a packet with fields of type uint32, uint64, string and []byte (already encoded)
+func (srv *sftpServer) send(t byte, fields ...interface{}) error {
+	buf := []byte{0, 0, 0, 0, t}
+	for _, f := range fields {
+		switch v := f.(type) {
+		case uint32:
+			buf = binary.BigEndian.AppendUint32(buf, v)
+		case uint64:
+			buf = binary.BigEndian.AppendUint64(buf, v)
+		case string:
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+		case []byte:
+			buf = append(buf, v...)
+		default:
+			return fmt.Errorf("unsupported field: %T", f)
+		}
+	}
+
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+	_, err := srv.rw.Write(buf)
+	return err
+}
+
+func fileAttrs(size int64) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, sftpSizeAttr|sftpPermsAttr)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(size))
+	return binary.BigEndian.AppendUint32(buf, sftpFileMode)
+}
+
+func dirAttrs() []byte {
+	buf := binary.BigEndian.AppendUint32(nil, sftpPermsAttr)
+	return binary.BigEndian.AppendUint32(buf, sftpDirMode)
+}
+
+// Reader of the fields of a packet
+type sftpPayload struct {
+	buf []byte
+	err error
+}
+
+func (p *sftpPayload) uint32() uint32 {
+	if p.err != nil || len(p.buf) < 4 {
+		p.err = fmt.Errorf("truncated packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(p.buf)
+	p.buf = p.buf[4:]
+	return v
+}
+
+func (p *sftpPayload) uint64() uint64 {
+	if p.err != nil || len(p.buf) < 8 {
+		p.err = fmt.Errorf("truncated packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint64(p.buf)
+	p.buf = p.buf[8:]
+	return v
+}
+
+func (p *sftpPayload) string() string {
+	n := p.uint32()
+	if p.err != nil || uint64(len(p.buf)) < uint64(n) {
+		p.err = fmt.Errorf("truncated packet")
+		return ""
+	}
+	v := string(p.buf[:n])
+	p.buf = p.buf[n:]
+	return v
+}
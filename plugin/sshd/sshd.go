@@ -6,10 +6,14 @@ import (
 	"net"
 	"sync"
 
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/fingerprint"
 	"github.com/riotpot/pkg/logger"
 	"github.com/riotpot/pkg/plugins"
+	"github.com/riotpot/pkg/proxyproto"
 	"github.com/riotpot/pkg/service"
 	"github.com/riotpot/pkg/shell"
+	"github.com/riotpot/pkg/tracing"
 	"github.com/riotpot/pkg/utils"
 
 	"github.com/traetox/pty"
@@ -68,6 +72,11 @@ func (s *SSH) Run() (err error) {
 	}
 	defer listener.Close()
 
+	// Recover the address of the attacker from the header sent by the proxy, if any
+	listener = proxyproto.NewListener(listener, func() bool {
+		return s.GetProxyProtocol() != utils.NoProxyProtocol
+	})
+
 	// build a channel stack to receive connections to the service
 	s.serve(listener, config)
 	return
@@ -78,7 +87,16 @@ func (s *SSH) auth(c ssh.ConnMetadata, pass []byte) (perms *ssh.Permissions, err
 	// Currently we don't really care about the credentials
 	// any user will have a successful login, as long as the user
 	// uses some credentials at all.
-	if c.User() != "" && string(pass) != "" {
+	success := c.User() != "" && string(pass) != ""
+
+	span := tracing.Tracer.StartFor(c.RemoteAddr().String(), "ssh.auth", tracing.ServerKind)
+	span.SetAttribute("ssh.user", c.User()).SetAttribute("riotpot.auth.success", success)
+	span.Finish()
+
+	ev := events.NewEvent(events.AuthEvent, name, c.RemoteAddr().String())
+	events.Events.Emit(ev.With("user", c.User()).With("password", string(pass)).With("success", success))
+
+	if success {
 		return
 	}
 
@@ -96,20 +114,44 @@ func (s *SSH) serve(listener net.Listener, config *ssh.ServerConfig) {
 			continue
 		}
 
-		// upgrade the connections to ssh
-		sshConn, chans, reqs, err := ssh.NewServerConn(client, config)
-		if err != nil {
-			logger.Log.Error().Err(err)
-			continue
-		}
+		// Slow clients must not block the rest
+		go s.handshake(client, config)
+	}
+}
+
+// Upgrade the connection to ssh, recording how the client negotiates it
+func (s *SSH) handshake(client net.Conn, config *ssh.ServerConfig) {
+	// Inspect the version and key exchange of the client
+	conn := fingerprint.NewSSHConn(client)
 
-		sshItem := NewSshConn(sshConn)
+	// Each connection records its own authentication attempts
+	attempts := &authAttempts{}
+	cfg := *config
+	cfg.AuthLogCallback = attempts.log
+	cfg.PublicKeyCallback = attempts.publicKey
 
-		// Discard all global out-of-band Requests
-		go ssh.DiscardRequests(reqs)
-		// Handle all the channels open by the connection
-		go s.handleChannels(sshItem, chans)
+	// upgrade the connections to ssh
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, &cfg)
+
+	// Record how the client negotiated the session, whether it authenticated or not.
+	// The proxy already emitted the connection
+	ev := events.NewEvent(events.FingerprintEvent, name, client.RemoteAddr().String())
+	conn.Annotate(ev)
+	attempts.annotate(ev)
+	events.Events.Emit(ev.With("success", err == nil))
+
+	if err != nil {
+		logger.Log.Error().Err(err)
+		client.Close()
+		return
 	}
+
+	sshItem := NewSshConn(sshConn)
+
+	// Discard all global out-of-band Requests
+	go ssh.DiscardRequests(reqs)
+	// Handle all the channels open by the connection
+	s.handleChannels(sshItem, chans)
 }
 
 func (s *SSH) handleChannels(sshItem SSHConn, chans <-chan ssh.NewChannel) {
@@ -140,6 +182,8 @@ func (s *SSH) handleChannel(sshItem SSHConn, channel ssh.NewChannel) {
 
 // Out-of-band requests handler
 func (s *SSH) oob(sshItem SSHConn, requests <-chan *ssh.Request, conn ssh.Channel) {
+	// Size of the terminal requested by the client
+	var term ptyRequest
 
 	for req := range requests {
 
@@ -150,13 +194,36 @@ func (s *SSH) oob(sshItem SSHConn, requests <-chan *ssh.Request, conn ssh.Channe
 			}
 
 			// Give a shell to the client
-			err := s.attachShell(sshItem, conn)
+			err := s.attachShell(sshItem, conn, term)
 			if err != nil {
 				logger.Log.Error().Err(err)
 			}
 
 			req.Reply(err == nil, nil)
+		case "exec":
+			// Commands sent without a shell, e.g., scp
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+
+			req.Reply(true, nil)
+			go s.exec(sshItem, conn, payload.Command)
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+
+			req.Reply(true, nil)
+			go s.sftp(sshItem, conn)
 		case "pty-req":
+			if err := ssh.Unmarshal(req.Payload, &term); err != nil {
+				term = ptyRequest{}
+			}
+
 			// Responding 'ok' here will let the client
 			// know we have a pty ready for input
 			req.Reply(true, nil)
@@ -170,9 +237,14 @@ func (s *SSH) oob(sshItem SSHConn, requests <-chan *ssh.Request, conn ssh.Channe
 	}
 }
 
-func (s *SSH) attachShell(sshItem SSHConn, conn ssh.Channel) (err error) {
+func (s *SSH) attachShell(sshItem SSHConn, conn ssh.Channel, term ptyRequest) (err error) {
 	// load a unix-like fake shell
 	shell := shell.New(sshItem.User, "ubuntu")
+	shell.Service = name
+	shell.Source = sshItem.RemoteAddr
+	if term.Columns > 0 && term.Rows > 0 {
+		shell.Width, shell.Height = int(term.Columns), int(term.Rows)
+	}
 
 	f, err := pty.StartFaker(shell)
 	if err != nil {
@@ -210,6 +282,16 @@ func (s *SSH) PrivateKey() (key ssh.Signer) {
 	return
 }
 
+// Payload of a "pty-req" request, RFC 4254 section 6.2
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
 type SSHConn struct {
 	User          string
 	SessionID     []byte
@@ -224,6 +306,49 @@ type SSHConn struct {
 	Payload     []byte
 }
 
+// Authentication methods and public keys offered by a client
+type authAttempts struct {
+	mu       sync.Mutex
+	methods  []string
+	keyTypes []string
+}
+
+// Record the authentication method used by the client
+func (a *authAttempts) log(c ssh.ConnMetadata, method string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.methods = appendUnique(a.methods, method)
+}
+
+// Record the type of the public key offered by the client.
+// Public keys are never accepted, the client falls back to the password
+func (a *authAttempts) publicKey(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.keyTypes = appendUnique(a.keyTypes, key.Type())
+	return nil, fmt.Errorf("public key not accepted")
+}
+
+// Add the authentication attempts to the event
+func (a *authAttempts) annotate(ev *events.Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ev.With("ssh_auth_methods", append([]string{}, a.methods...))
+	ev.With("ssh_key_types", append([]string{}, a.keyTypes...))
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
 type SSHAuth struct {
 	User     string
 	Password string
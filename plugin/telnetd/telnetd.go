@@ -10,6 +10,7 @@ import (
 	lr "github.com/riotpot/pkg/logger"
 	"github.com/riotpot/pkg/service"
 	"github.com/riotpot/pkg/shell"
+	"github.com/riotpot/pkg/telnet"
 	"github.com/riotpot/pkg/utils"
 )
 
@@ -84,50 +85,44 @@ func (t *Telnet) handlePool(ch chan net.Conn) {
 	}
 }
 
-func (t *Telnet) handleConn(conn net.Conn) {
+func (t *Telnet) handleConn(client net.Conn) {
+	// Negotiate the options, so the commands of the client are not read as its input
+	conn := telnet.NewConn(client)
+	if err := conn.Negotiate(); err != nil {
+		conn.Close()
+		return
+	}
+
 	//opens a new small buffer
 	br := bufio.NewReader(conn)
 
-	// Send the authentication messages
-	t.sendAuth(conn, br)
+	// Send the welcome message and prompt for the credentials
+	conn.Write(t.banner)
+	user, ok := telnet.Login(conn, br, name, shell.GetImage().Persona)
+	if !ok {
+		conn.Close()
+		return
+	}
 	// encarcelate the client in the telnet shell loop
-	t.telnetShell(conn, br)
-}
-
-// This method shows the welcome message to the telnet
-// service, and prompts for authentication.
-func (t *Telnet) sendAuth(conn net.Conn, br *bufio.Reader) {
-	t.respond(string(t.banner), conn, br)
-
-	pass := `Password: `
-	t.respond(pass, conn, br)
+	t.telnetShell(conn, br, user)
 }
 
 // Offers a telnet shell-like experience in where
 // the client will be prompt for input and the commands
 // will be saved in the database.
-func (t *Telnet) telnetShell(conn net.Conn, br *bufio.Reader) {
+func (t *Telnet) telnetShell(conn *telnet.Conn, br *bufio.Reader, user string) {
 	// load a unix-like fake shell
-	shell := shell.New("root", "ubuntu")
-	shell.SetIo(conn)
-	shell.Start()
-}
-
-// Method to send a message to the client, receive a response and save it
-// into the database.
-func (t *Telnet) respond(
-	msg string,
-	conn net.Conn,
-	br *bufio.Reader,
-) (response []byte, err error) {
-	// send the message and wait for the client to respond
-	conn.Write([]byte(msg))
-
-	// read the response
-	response, err = br.ReadBytes('\n')
-	if err != nil { // EOF, or worse
-		return
+	shell := shell.New(user, "ubuntu")
+	shell.Service = name
+	shell.Source = conn.RemoteAddr().String()
+	if width, height := conn.Size(); width > 0 && height > 0 {
+		shell.Width, shell.Height = width, height
+	}
+	if term := conn.Term(); term != "" {
+		shell.Env["TERM"] = term
 	}
 
-	return
+	// The input buffered while logging in is read by the shell
+	shell.SetReadWriteCloser(br, conn, conn)
+	shell.Start()
 }
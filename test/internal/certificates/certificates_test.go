@@ -0,0 +1,30 @@
+package certificates
+
+import (
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/certificates"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfSigned(t *testing.T) {
+	cert, err := certificates.NewSelfSigned()
+	assert.NoError(t, err)
+	leaf, err := certificates.Leaf(cert)
+	assert.NoError(t, err)
+
+	// Nothing in the subject tells the honeypot apart
+	assert.NotEmpty(t, leaf.Subject.CommonName)
+	assert.NotContains(t, leaf.Subject.Organization, "RiotPot")
+	assert.True(t, leaf.NotBefore.Before(time.Now()))
+	assert.True(t, leaf.NotAfter.After(time.Now()))
+
+	other, err := certificates.NewSelfSigned("localhost")
+	assert.NoError(t, err)
+	otherLeaf, err := certificates.Leaf(other)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "localhost", otherLeaf.Subject.CommonName)
+	assert.NotEqual(t, leaf.SerialNumber, otherLeaf.SerialNumber)
+}
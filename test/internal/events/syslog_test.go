@@ -0,0 +1,180 @@
+package events
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/certificates"
+	"github.com/riotpot/pkg/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func authEvent() *events.Event {
+	ev := events.NewEvent(events.AuthEvent, "Telnet", "203.0.113.7:51234")
+	ev.Destination = "192.0.2.1:23"
+	ev.Session = "a1b2"
+	ev.Time = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return ev.With("user", "root").With("password", "admin|=x").With("success", true)
+}
+
+// Read a message framed with octet counting
+func readFrame(t *testing.T, r *bufio.Reader) string {
+	length, err := r.ReadString(' ')
+	assert.NoError(t, err)
+
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	assert.NoError(t, err)
+
+	buf := make([]byte, n)
+	_, err = r.Read(buf)
+	assert.NoError(t, err)
+	return string(buf)
+}
+
+func TestCEFFormat(t *testing.T) {
+	f := events.NewFormatter(events.CEFFormat, map[string]string{"password": ""})
+	msg, err := f.Format(authEvent())
+	assert.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(msg, "CEF:0|RIoTPot|RIoTPot|2.0|auth|Authentication attempt|7|"), msg)
+	assert.Contains(t, msg, " src=203.0.113.7 ")
+	assert.Contains(t, msg, " spt=51234 ")
+	assert.Contains(t, msg, " dpt=23 ")
+	assert.True(t, strings.HasSuffix(msg, " suser=root"), msg)
+	assert.Contains(t, msg, " cs1=a1b2 cs1Label=session ")
+	assert.Contains(t, msg, " rt=1767323045000 ")
+	assert.NotContains(t, msg, "admin")
+}
+
+func TestLEEFFormat(t *testing.T) {
+	f := events.NewFormatter(events.LEEFFormat, nil)
+	msg, err := f.Format(authEvent())
+	assert.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(msg, "LEEF:1.0|RIoTPot|RIoTPot|2.0|auth|sev=7\t"), msg)
+	attributes := strings.Split(strings.SplitN(msg, "|", 6)[5], "\t")
+	assert.Contains(t, attributes, "usrName=root")
+	assert.Contains(t, attributes, "srcPort=51234")
+	assert.Contains(t, attributes, "devTime=Jan 02 2026 03:04:05")
+	assert.Contains(t, attributes, "password=admin|=x")
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := events.NewSyslogSink("udp://"+conn.LocalAddr().String(), events.Facility(16), events.NewFormatter(events.JSONFormat, nil), nil)
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	assert.NoError(t, sink.Send(authEvent()))
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err)
+
+	// local0 (16) * 8 + warning (4)
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<132>1 2026-01-02T03:04:05.000000Z "), msg)
+	assert.Contains(t, msg, " riotpot ")
+	assert.Contains(t, msg, ` auth - {`)
+	assert.Contains(t, msg, `"source_ip":"203.0.113.7"`)
+}
+
+func TestSyslogTLS(t *testing.T) {
+	cert, err := certificates.NewSelfSigned("127.0.0.1")
+	assert.NoError(t, err)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		received <- []string{readFrame(t, r), readFrame(t, r)}
+	}()
+
+	leaf, err := certificates.Leaf(cert)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	sink, err := events.NewSyslogSink("tls://"+ln.Addr().String(), events.Facility(4), events.NewFormatter(events.CEFFormat, nil), &tls.Config{RootCAs: pool})
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	assert.NoError(t, sink.Send(authEvent()))
+	assert.NoError(t, sink.Send(events.NewEvent(events.ConnectionEvent, "Telnet", "203.0.113.7:51234")))
+
+	select {
+	case msgs := <-received:
+		// auth (4) * 8 + warning (4)
+		assert.True(t, strings.HasPrefix(msgs[0], "<36>1 "), msgs[0])
+		assert.Contains(t, msgs[0], " CEF:0|")
+		// auth (4) * 8 + informational (6)
+		assert.True(t, strings.HasPrefix(msgs[1], "<38>1 "), msgs[1])
+	case <-time.After(2 * time.Second):
+		t.Fatal("no messages received")
+	}
+}
+
+func TestSyslogSlowServer(t *testing.T) {
+	// The server accepts the connections but never completes the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	sink, err := events.NewSyslogSink("tls://"+ln.Addr().String(), events.Facility(4), events.NewFormatter(events.JSONFormat, nil), nil)
+	assert.NoError(t, err)
+
+	// The events are buffered without waiting for the server, until the buffer is full
+	start := time.Now()
+	for i := 0; i < 10000 && err == nil; i++ {
+		err = sink.Send(authEvent())
+	}
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Closing the sink cancels the connection in progress
+	start = time.Now()
+	sink.Close()
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestParseFacility(t *testing.T) {
+	f, err := events.ParseFacility("LOCAL7")
+	assert.NoError(t, err)
+	assert.Equal(t, events.Facility(23), f)
+	assert.Equal(t, "local7", f.String())
+
+	_, err = events.ParseFacility("local8")
+	assert.Error(t, err)
+
+	_, err = events.NewSyslogSink("http://siem:514", f, events.NewFormatter(events.JSONFormat, nil), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,167 @@
+package export
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/export"
+	"github.com/riotpot/pkg/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSessions() []sessions.Session {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	return []sessions.Session{
+		{
+			ID:          "a1b2",
+			Services:    []string{"SSH"},
+			Source:      "203.0.113.7:51234",
+			Destination: "192.0.2.1:22",
+			Start:       start,
+			End:         start.Add(time.Minute),
+			Closed:      true,
+			Credentials: []sessions.Credential{{Service: "SSH", User: "root", Password: "admin", Success: true}},
+			URLs:        []string{"http://198.51.100.1/bins/x86"},
+			Files:       []sessions.File{{Name: "x86", SHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}},
+			Fingerprints: map[string]string{
+				"hassh": "ec7378c1a92f5a8dde7e8b7a1ddf33d1",
+			},
+		},
+		{
+			ID:          "c3d4",
+			Services:    []string{"Telnet"},
+			Source:      "203.0.113.7:40000",
+			Destination: "192.0.2.1:23",
+			Start:       start.Add(time.Hour),
+			End:         start.Add(time.Hour),
+			URLs:        []string{"http://198.51.100.1/bins/x86"},
+		},
+	}
+}
+
+// Encode and decode the export to inspect it as generic JSON
+func decode(t *testing.T, value interface{}) map[string]interface{} {
+	data, err := json.Marshal(value)
+	assert.NoError(t, err)
+
+	var ret map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &ret))
+	return ret
+}
+
+func TestSTIX(t *testing.T) {
+	bundle := decode(t, export.STIX(testSessions()))
+	assert.Equal(t, "bundle", bundle["type"])
+
+	objects := map[string][]map[string]interface{}{}
+	ids := map[string]bool{}
+	for _, o := range bundle["objects"].([]interface{}) {
+		object := o.(map[string]interface{})
+		objects[object["type"].(string)] = append(objects[object["type"].(string)], object)
+		ids[object["id"].(string)] = true
+		assert.Equal(t, "2.1", object["spec_version"])
+	}
+
+	// The address and the URL shared by both sessions are only exported once
+	assert.Len(t, objects["ipv4-addr"], 2)
+	assert.Len(t, objects["url"], 1)
+	assert.Len(t, objects["network-traffic"], 2)
+	assert.Len(t, objects["observed-data"], 2)
+	assert.Len(t, objects["file"], 1)
+	assert.Len(t, objects["note"], 1)
+	assert.Len(t, objects["identity"], 1)
+
+	// Every reference points to an object of the bundle
+	for _, observed := range objects["observed-data"] {
+		assert.Equal(t, float64(1), observed["number_observed"])
+		for _, ref := range observed["object_refs"].([]interface{}) {
+			assert.True(t, ids[ref.(string)], ref)
+		}
+	}
+
+	note := objects["note"][0]
+	assert.Contains(t, note["content"], "root / admin")
+	assert.True(t, ids[note["object_refs"].([]interface{})[0].(string)])
+
+	file := objects["file"][0]["hashes"].(map[string]interface{})
+	assert.Equal(t, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", file["SHA-256"])
+
+	// Exporting the same sessions gives the same identifiers
+	again := decode(t, export.STIX(testSessions()))
+	assert.Equal(t, len(bundle["objects"].([]interface{})), len(again["objects"].([]interface{})))
+	for _, o := range again["objects"].([]interface{}) {
+		assert.True(t, ids[o.(map[string]interface{})["id"].(string)])
+	}
+}
+
+func TestSTIXAddressID(t *testing.T) {
+	bundle := decode(t, export.STIX([]sessions.Session{{ID: "x", Source: "198.51.100.3:1"}}))
+
+	for _, o := range bundle["objects"].([]interface{}) {
+		object := o.(map[string]interface{})
+		if object["type"] == "ipv4-addr" {
+			// UUIDv5 of {"value":"198.51.100.3"} in the namespace of the specification
+			assert.Equal(t, "ipv4-addr--28bb3599-77cd-5a82-a950-b5bc3caf07c4", object["id"])
+		}
+	}
+}
+
+func TestMISP(t *testing.T) {
+	event := decode(t, export.MISP(testSessions()))["Event"].(map[string]interface{})
+	assert.Equal(t, "RIoTPot sessions (2)", event["info"])
+
+	attributes := map[string]map[string]interface{}{}
+	for _, a := range event["Attribute"].([]interface{}) {
+		attribute := a.(map[string]interface{})
+		attributes[attribute["type"].(string)+"|"+attribute["value"].(string)] = attribute
+	}
+	assert.Len(t, attributes, 4)
+
+	ip := attributes["ip-src|203.0.113.7"]
+	assert.Equal(t, true, ip["to_ids"])
+	// The attribute covers both sessions
+	assert.Equal(t, "2026-01-02T03:04:05.000Z", ip["first_seen"])
+	assert.Equal(t, "2026-01-02T04:04:05.000Z", ip["last_seen"])
+
+	assert.NotNil(t, attributes["url|http://198.51.100.1/bins/x86"])
+	assert.NotNil(t, attributes["sha256|9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"])
+	assert.NotNil(t, attributes["hassh-md5|ec7378c1a92f5a8dde7e8b7a1ddf33d1"])
+
+	objects := event["Object"].([]interface{})
+	assert.Len(t, objects, 1)
+	credential := objects[0].(map[string]interface{})
+	assert.Equal(t, "credential", credential["name"])
+	assert.Len(t, credential["Attribute"], 2)
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := export.ParseFormat("STIX")
+	assert.NoError(t, err)
+	assert.Equal(t, export.STIXFormat, f)
+
+	_, err = export.ParseFormat("csv")
+	assert.Error(t, err)
+}
+
+func TestSTIXNetworkTraffic(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	bundle := decode(t, export.STIX([]sessions.Session{{
+		ID:       "e5f6",
+		Services: []string{"CoAP"},
+		Source:   "198.51.100.3:5683",
+		Network:  "udp",
+		Start:    start,
+		End:      start,
+	}}))
+
+	for _, o := range bundle["objects"].([]interface{}) {
+		object := o.(map[string]interface{})
+		if object["type"] == "network-traffic" {
+			assert.Equal(t, []interface{}{"udp", "coap"}, object["protocols"])
+			// UUIDv5 of the properties contributing to the ID in the namespace of the specification
+			assert.Equal(t, "network-traffic--f81d153c-3ff1-5731-a78d-7b965cb085bc", object["id"])
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package fingerprint
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/fingerprint"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHConn(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	// Both sides send their version first, a synchronous pipe would block
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	conns := make(chan *fingerprint.SSHConn, 1)
+	done := make(chan error, 1)
+	go func() {
+		server, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+
+		conn := fingerprint.NewSSHConn(server)
+		conns <- conn
+		_, _, _, err = ssh.NewServerConn(conn, config)
+		done <- err
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+
+	clientConfig := &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		ClientVersion:   "SSH-2.0-Scanner_1.0",
+		Config: ssh.Config{
+			KeyExchanges: []string{"curve25519-sha256"},
+			Ciphers:      []string{"aes128-ctr"},
+			MACs:         []string{"hmac-sha2-256"},
+		},
+	}
+	c, _, _, err := ssh.NewClientConn(client, ln.Addr().String(), clientConfig)
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.NoError(t, <-done)
+	conn := <-conns
+
+	assert.Equal(t, "SSH-2.0-Scanner_1.0", conn.ClientVersion())
+
+	kex := conn.KexInit()
+	if assert.NotNil(t, kex) {
+		assert.Equal(t, "curve25519-sha256", kex.KexAlgorithms[0])
+		assert.Equal(t, []string{"aes128-ctr"}, kex.CiphersClientServer)
+
+		algorithms, hash := kex.HASSH()
+		assert.True(t, strings.HasPrefix(algorithms, "curve25519-sha256"))
+		assert.True(t, strings.HasSuffix(algorithms, ";aes128-ctr;hmac-sha2-256;none"))
+		assert.Equal(t, 32, len(hash))
+	}
+
+	ev := conn.Annotate(events.NewEvent(events.ConnectionEvent, "SSH", "198.51.100.1:22"))
+	assert.Equal(t, "SSH-2.0-Scanner_1.0", ev.GetString("ssh_client_version"))
+	assert.NotEmpty(t, ev.GetString("hassh"))
+}
+
+func TestParseKexInit(t *testing.T) {
+	_, err := fingerprint.ParseKexInit([]byte{21, 0, 0})
+	assert.Equal(t, fingerprint.ErrNotKexInit, err)
+
+	// Truncated name lists
+	payload := append([]byte{20}, make([]byte, 16)...)
+	payload = append(payload, 0, 0, 0, 10, 'a')
+	_, err = fingerprint.ParseKexInit(payload)
+	assert.Equal(t, fingerprint.ErrNotKexInit, err)
+}
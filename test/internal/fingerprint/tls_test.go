@@ -0,0 +1,149 @@
+package fingerprint
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/certificates"
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/fingerprint"
+	"github.com/stretchr/testify/assert"
+)
+
+// Encode a TLS extension
+func extension(t uint16, data []byte) []byte {
+	ext := make([]byte, 4)
+	binary.BigEndian.PutUint16(ext, t)
+	binary.BigEndian.PutUint16(ext[2:], uint16(len(data)))
+	return append(ext, data...)
+}
+
+// Encode a list of 16 bits integers with its length
+func list16(values ...uint16) []byte {
+	b := make([]byte, 2+2*len(values))
+	binary.BigEndian.PutUint16(b, uint16(2*len(values)))
+	for ind, v := range values {
+		binary.BigEndian.PutUint16(b[2+2*ind:], v)
+	}
+	return b
+}
+
+// Build a ClientHello record with known values
+func clientHello() []byte {
+	sni := []byte{0x00, 0x0e, 0x00, 0x00, 0x0b}
+	sni = append(sni, []byte("example.com")...)
+
+	alpn := []byte{0x00, 0x0c, 0x02}
+	alpn = append(alpn, []byte("h2")...)
+	alpn = append(alpn, 0x08)
+	alpn = append(alpn, []byte("http/1.1")...)
+
+	var exts []byte
+	exts = append(exts, extension(0x0a0a, nil)...) // GREASE
+	exts = append(exts, extension(0x0000, sni)...)
+	exts = append(exts, extension(0x000a, list16(0x0a0a, 0x001d, 0x0017))...)
+	exts = append(exts, extension(0x000b, []byte{0x01, 0x00})...)
+	exts = append(exts, extension(0x000d, list16(0x0403, 0x0804))...)
+	exts = append(exts, extension(0x0010, alpn)...)
+	exts = append(exts, extension(0x002b, []byte{0x04, 0x03, 0x04, 0x03, 0x03})...)
+
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0x00)
+	body = append(body, list16(0x1a1a, 0x1302, 0x1301, 0xc02f)...)
+	body = append(body, 0x01, 0x00)
+	body = append(body, byte(len(exts)>>8), byte(len(exts)))
+	body = append(body, exts...)
+
+	msg := []byte{0x01, 0x00, byte(len(body) >> 8), byte(len(body))}
+	msg = append(msg, body...)
+
+	record := []byte{0x16, 0x03, 0x01, byte(len(msg) >> 8), byte(len(msg))}
+	return append(record, msg...)
+}
+
+func TestParseClientHello(t *testing.T) {
+	data := clientHello()
+
+	// Partial messages need more data
+	_, err := fingerprint.ParseClientHello(data[:20])
+	assert.Equal(t, fingerprint.ErrIncomplete, err)
+
+	_, err = fingerprint.ParseClientHello([]byte("GET / HTTP/1.1\r\n"))
+	assert.Equal(t, fingerprint.ErrNotClientHello, err)
+
+	ch, err := fingerprint.ParseClientHello(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", ch.ServerName)
+	assert.Equal(t, []string{"h2", "http/1.1"}, ch.ALPN)
+
+	// GREASE values are left out of both fingerprints
+	ja3, hash := ch.JA3()
+	assert.Equal(t, "771,4866-4865-49199,0-10-11-13-16-43,29-23,0", ja3)
+	assert.Equal(t, "7a36bd646cfd44ffe5268a460aeea992", hash)
+
+	// The hashes are the truncated SHA-256 of "1301,1302,c02f" and "000a,000b,000d,002b_0403,0804"
+	assert.Equal(t, "t13d0306h2_40b44b994229_fb71836bce29", ch.JA4())
+}
+
+func TestTLSConn(t *testing.T) {
+	cert, err := certificates.NewSelfSigned()
+	assert.NoError(t, err)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	found := make(chan *fingerprint.ClientHello, 1)
+	conn := fingerprint.NewTLSConn(server)
+	conn.OnHello = func(ch *fingerprint.ClientHello) { found <- ch }
+
+	// The connection is given to the TLS server untouched
+	go func() {
+		srv := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+		srv.Handshake()
+		srv.Close()
+	}()
+
+	cl := tls.Client(client, &tls.Config{InsecureSkipVerify: true, ServerName: "riotpot.com", NextProtos: []string{"h2"}})
+	assert.NoError(t, cl.Handshake())
+
+	select {
+	case ch := <-found:
+		assert.Equal(t, "riotpot.com", ch.ServerName)
+		assert.True(t, strings.HasPrefix(ch.JA4(), "t13d"))
+		assert.True(t, strings.Contains(ch.JA4(), "h2_"))
+		assert.Equal(t, ch, conn.ClientHello())
+	case <-time.After(5 * time.Second):
+		t.Fatal("ClientHello not found")
+	}
+}
+
+func TestCounter(t *testing.T) {
+	counter := fingerprint.NewCounter(fingerprint.JA3Fingerprint, fingerprint.JA4Fingerprint)
+	ch, err := fingerprint.ParseClientHello(clientHello())
+	assert.NoError(t, err)
+
+	for _, source := range []string{"198.51.100.1:1000", "198.51.100.1:1001", "198.51.100.2:1000"} {
+		ev := events.NewEvent(events.ConnectionEvent, "", source)
+		counter.Send(ch.Annotate(ev))
+	}
+
+	counts, err := counter.GetCounts(fingerprint.JA4Fingerprint)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(counts))
+	assert.Equal(t, ch.JA4(), counts[0].Value)
+	assert.Equal(t, 3, counts[0].Count)
+	assert.Equal(t, 2, counts[0].Sources)
+
+	counts, err = counter.GetCounts(fingerprint.JA3Fingerprint)
+	assert.NoError(t, err)
+	ja3, _ := ch.JA3()
+	assert.Equal(t, ja3, counts[0].Detail)
+
+	_, err = counter.GetCounts("unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,268 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/geoip"
+	"github.com/riotpot/pkg/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+// Encode a value in the data section format of the MaxMind DB
+func encode(value interface{}) []byte {
+	ctrl := func(t int, size int) []byte {
+		// Sizes from 29 to 284 take an extra byte
+		var extra []byte
+		if size >= 29 {
+			extra = []byte{byte(size - 29)}
+			size = 29
+		}
+
+		if t > 7 {
+			return append([]byte{byte(size), byte(t - 7)}, extra...)
+		}
+		return append([]byte{byte(t<<5 | size)}, extra...)
+	}
+
+	uintBytes := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v)
+		for len(b) > 0 && b[0] == 0 {
+			b = b[1:]
+		}
+		return b
+	}
+
+	switch v := value.(type) {
+	case string:
+		return append(ctrl(2, len(v)), v...)
+	case float64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(v))
+		return append(ctrl(3, 8), b...)
+	case uint16:
+		b := uintBytes(uint64(v))
+		return append(ctrl(5, len(b)), b...)
+	case uint32:
+		b := uintBytes(uint64(v))
+		return append(ctrl(6, len(b)), b...)
+	case uint64:
+		b := uintBytes(v)
+		return append(ctrl(9, len(b)), b...)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b := ctrl(7, len(v))
+		for _, key := range keys {
+			b = append(b, encode(key)...)
+			b = append(b, encode(v[key])...)
+		}
+		return b
+	}
+	panic("unsupported value")
+}
+
+// Write an IPv6 database with 24 bit records, where the IPv4 networks are in ::/96
+func writeDatabase(t string, networks map[string]map[string]interface{}) []byte {
+	// Children of the nodes: -1 is empty, and values under -1 are data offsets
+	nodes := [][2]int{{-1, -1}}
+	data := []byte{}
+
+	for cidr, record := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		ip := network.IP.To16()
+		ones, _ := network.Mask.Size()
+		if ip4 := network.IP.To4(); ip4 != nil {
+			ip = append(make(net.IP, 12), ip4...)
+			ones += 96
+		}
+
+		offset := len(data)
+		data = append(data, encode(record)...)
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -2 - offset
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	count := len(nodes)
+	buf := []byte{}
+	for _, node := range nodes {
+		for _, child := range node {
+			value := child
+			switch {
+			case child == -1:
+				value = count
+			case child < -1:
+				value = count + 16 + (-2 - child)
+			}
+			buf = append(buf, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, "\xAB\xCD\xEFMaxMind.com"...)
+	buf = append(buf, encode(map[string]interface{}{
+		"database_type": t,
+		"description":   map[string]interface{}{"en": "Test database"},
+		"ip_version":    uint16(6),
+		"node_count":    uint32(count),
+		"record_size":   uint16(24),
+		"build_epoch":   uint64(1700000000),
+	})...)
+	return buf
+}
+
+func cityDatabase() []byte {
+	return writeDatabase("GeoLite2-City", map[string]map[string]interface{}{
+		"203.0.113.0/24": {
+			"country":  map[string]interface{}{"iso_code": "DE", "names": map[string]interface{}{"en": "Germany"}},
+			"city":     map[string]interface{}{"names": map[string]interface{}{"en": "Berlin"}},
+			"location": map[string]interface{}{"latitude": 52.52, "longitude": 13.405},
+		},
+		"2001:db8::/32": {
+			"country": map[string]interface{}{"iso_code": "FR", "names": map[string]interface{}{"en": "France"}},
+		},
+	})
+}
+
+func asnDatabase() []byte {
+	return writeDatabase("GeoLite2-ASN", map[string]map[string]interface{}{
+		"203.0.113.0/25": {
+			"autonomous_system_number":       uint32(64496),
+			"autonomous_system_organization": "Example Networks",
+		},
+	})
+}
+
+func TestReader(t *testing.T) {
+	r, err := geoip.NewReader(cityDatabase())
+	assert.NoError(t, err)
+
+	assert.Equal(t, "GeoLite2-City", r.Metadata.DatabaseType)
+	assert.Equal(t, "Test database", r.Metadata.Description)
+	assert.Equal(t, 6, r.Metadata.IPVersion)
+	assert.Equal(t, 24, r.Metadata.RecordSize)
+	assert.Equal(t, uint64(1700000000), r.Metadata.BuildEpoch)
+
+	record, err := r.Lookup(net.ParseIP("203.0.113.200"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"iso_code": "DE", "names": map[string]interface{}{"en": "Germany"}}, record.(map[string]interface{})["country"])
+
+	record, err = r.Lookup(net.ParseIP("2001:db8::1"))
+	assert.NoError(t, err)
+	assert.NotNil(t, record)
+
+	// Networks that are not in the database
+	record, err = r.Lookup(net.ParseIP("198.51.100.1"))
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	_, err = geoip.NewReader([]byte("not a database"))
+	assert.Error(t, err)
+}
+
+func TestCorrupt(t *testing.T) {
+	marker := []byte("\xAB\xCD\xEFMaxMind.com")
+
+	cases := map[string][]byte{
+		// Map with more entries than bytes left
+		"size": {0xFF, 0xFF, 0xFF, 0xFF},
+		// Pointer to itself
+		"pointer": {0x20, 0x00},
+		// Map with a value that points to the map
+		"nesting": {0xE1, 0x41, 'a', 0x20, 0x00},
+	}
+
+	for name, metadata := range cases {
+		_, err := geoip.NewReader(append(append([]byte{}, marker...), metadata...))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	assert.NoError(t, os.WriteFile(path, cityDatabase(), 0o644))
+
+	city, err := geoip.Open(path)
+	assert.NoError(t, err)
+	asn, err := geoip.NewReader(asnDatabase())
+	assert.NoError(t, err)
+
+	locator := geoip.NewLocator()
+	assert.False(t, locator.IsEnabled())
+	locator.SetCityDatabase(city)
+	locator.SetASNDatabase(asn)
+	assert.True(t, locator.IsEnabled())
+
+	assert.Equal(t, geoip.Location{
+		Country:     "DE",
+		CountryName: "Germany",
+		City:        "Berlin",
+		Latitude:    52.52,
+		Longitude:   13.405,
+		ASN:         64496,
+		ASOrg:       "Example Networks",
+	}, locator.Locate(net.ParseIP("203.0.113.7")))
+
+	// Only in the city database
+	assert.Equal(t, geoip.Location{Country: "DE", CountryName: "Germany", City: "Berlin", Latitude: 52.52, Longitude: 13.405}, locator.Locate(net.ParseIP("203.0.113.200")))
+	assert.True(t, locator.Locate(net.ParseIP("198.51.100.1")).IsEmpty())
+}
+
+func TestEnrich(t *testing.T) {
+	city, _ := geoip.NewReader(cityDatabase())
+	asn, _ := geoip.NewReader(asnDatabase())
+
+	locator := geoip.NewLocator()
+	locator.SetCityDatabase(city)
+	locator.SetASNDatabase(asn)
+
+	ev := events.NewEvent(events.ConnectionEvent, "", "203.0.113.7:51234")
+	ev.Session = "a1b2"
+	locator.Enrich(ev)
+
+	assert.Equal(t, "DE", ev.GetString("geo_country"))
+	assert.Equal(t, "Berlin", ev.GetString("geo_city"))
+	assert.Equal(t, uint64(64496), ev.Fields["asn"])
+	assert.Equal(t, "Example Networks", ev.GetString("as_org"))
+
+	// Only the connections are located
+	auth := events.NewEvent(events.AuthEvent, "SSH", "203.0.113.7:51234")
+	locator.Enrich(auth)
+	assert.Empty(t, auth.Fields["geo_country"])
+
+	// The sessions keep the location of the attacker
+	store := sessions.NewStore(10)
+	store.Load([]*events.Event{ev})
+
+	s, err := store.GetSession("a1b2")
+	assert.NoError(t, err)
+	assert.Equal(t, locator.Locate(net.ParseIP("203.0.113.7")), s.Location)
+}
@@ -0,0 +1,203 @@
+package hpfeeds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/hpfeeds"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	ident  = "riotpot-test"
+	secret = "s3cr3t"
+)
+
+// Publication received by the broker
+type publication struct {
+	channel string
+	event   events.Event
+	// Number of the connection it was received in
+	conn int
+}
+
+// Stand-in for an HPFeeds broker that accepts the publications of a single identity.
+// The connections are closed after receiving a publication when drop is true
+func startBroker(t *testing.T, drop bool) (string, chan publication) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan publication, 16)
+	go func() {
+		for n := 1; ; n++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveBroker(t, conn, n, drop, received)
+		}
+	}()
+
+	return ln.Addr().String(), received
+}
+
+func serveBroker(t *testing.T, conn net.Conn, n int, drop bool, received chan publication) {
+	defer conn.Close()
+
+	nonce := []byte{1, 2, 3, 4}
+	info := append([]byte{byte(len("broker"))}, "broker"...)
+	hpfeeds.WriteMessage(conn, 1, append(info, nonce...))
+
+	msg, err := hpfeeds.ReadMessage(conn)
+	if err != nil || msg.Op != 2 {
+		return
+	}
+
+	name, hash, err := hpfeeds.ParseAuth(msg.Payload)
+	if err != nil || name != ident || !bytes.Equal(hash, hpfeeds.AuthHash(nonce, secret)) {
+		hpfeeds.WriteMessage(conn, 0, []byte("authentication failed"))
+		return
+	}
+
+	for {
+		msg, err := hpfeeds.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+
+		name, channel, data, err := hpfeeds.ParsePublish(msg.Payload)
+		assert.NoError(t, err)
+		assert.Equal(t, ident, name)
+
+		p := publication{channel: channel, conn: n}
+		assert.NoError(t, json.Unmarshal(data, &p.event))
+		received <- p
+
+		if drop {
+			return
+		}
+	}
+}
+
+func receive(t *testing.T, received chan publication) publication {
+	select {
+	case p := <-received:
+		return p
+	case <-time.After(3 * time.Second):
+		t.Fatal("no publication received")
+	}
+	return publication{}
+}
+
+func TestMessages(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, hpfeeds.WriteMessage(&buf, 3, []byte("payload")))
+	assert.Equal(t, uint32(12), binary.BigEndian.Uint32(buf.Bytes()))
+
+	msg, err := hpfeeds.ReadMessage(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(3), msg.Op)
+	assert.Equal(t, []byte("payload"), msg.Payload)
+
+	// Messages shorter than the header are invalid
+	_, err = hpfeeds.ReadMessage(bytes.NewReader([]byte{0, 0, 0, 2, 3}))
+	assert.Error(t, err)
+}
+
+func TestPublish(t *testing.T) {
+	address, received := startBroker(t, false)
+
+	sink := hpfeeds.NewSink(address, ident, secret, "riotpot")
+	defer sink.Close()
+
+	ev := events.NewEvent(events.AuthEvent, "SSH", "203.0.113.7:4000").With("user", "root")
+	assert.NoError(t, sink.Send(ev))
+	assert.NoError(t, sink.Send(events.NewEvent(events.CommandEvent, "Telnet", "203.0.113.7:4001")))
+
+	p := receive(t, received)
+	assert.Equal(t, "riotpot.ssh", p.channel)
+	assert.Equal(t, ev.ID, p.event.ID)
+	assert.Equal(t, "root", p.event.Fields["user"])
+
+	assert.Equal(t, "riotpot.telnet", receive(t, received).channel)
+}
+
+func TestReconnect(t *testing.T) {
+	address, received := startBroker(t, true)
+
+	sink := hpfeeds.NewSink(address, ident, secret, "riotpot")
+	defer sink.Close()
+
+	assert.NoError(t, sink.Send(events.NewEvent(events.ConnectionEvent, "SSH", "203.0.113.7:4000")))
+	assert.Equal(t, 1, receive(t, received).conn)
+
+	// Give the client time to notice the broker closed the connection
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, sink.Send(events.NewEvent(events.ConnectionEvent, "SSH", "203.0.113.7:4001")))
+	p := receive(t, received)
+	assert.Equal(t, 2, p.conn)
+	assert.Equal(t, "203.0.113.7:4001", p.event.Source)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	timeout := hpfeeds.WriteTimeout
+	hpfeeds.WriteTimeout = 100 * time.Millisecond
+	defer func() { hpfeeds.WriteTimeout = timeout }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	// The broker authenticates the client and stops reading
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		info := append([]byte{byte(len("broker"))}, "broker"...)
+		hpfeeds.WriteMessage(conn, 1, append(info, 1, 2, 3, 4))
+		hpfeeds.ReadMessage(conn)
+		<-stop
+	}()
+
+	client, err := hpfeeds.Dial(ln.Addr().String(), ident, secret)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	// The publications fail once the buffers of the connection are full
+	payload := make([]byte, 1<<20)
+	start := time.Now()
+	for i := 0; i < 64 && err == nil; i++ {
+		err = client.Publish("riotpot.ssh", payload)
+	}
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// The connection is not used anymore
+	assert.Error(t, client.Publish("riotpot.ssh", []byte("{}")))
+}
+
+func TestAuthFailure(t *testing.T) {
+	address, _ := startBroker(t, false)
+
+	client, err := hpfeeds.Dial(address, ident, "wrong")
+	assert.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, "broker", client.GetBroker())
+
+	// The broker answers the authentication with an error
+	assert.Eventually(t, func() bool {
+		return client.Publish("riotpot.ssh", []byte("{}")) != nil
+	}, time.Second, 10*time.Millisecond)
+}
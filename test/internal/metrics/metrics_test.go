@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/riotpot/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	counter := registry.NewCounter("test_requests_total", "Requests received", "method")
+	counter.With("GET").Inc()
+	counter.With("GET").Add(2)
+	counter.With("POST").Inc()
+
+	// Registering the same name again returns the first metric
+	assert.Equal(t, counter, registry.NewCounter("test_requests_total", "Requests received", "method"))
+
+	gauge := registry.NewGauge("test_sessions", "Open sessions")
+	gauge.With().Inc()
+	gauge.With().Dec()
+	gauge.With().Set(4)
+
+	registry.NewGaugeFunc("test_queue_length", "Items \"queued\"", func() []metrics.Sample {
+		return []metrics.Sample{{Labels: []string{"a\"b"}, Value: 1.5}}
+	}, "queue")
+
+	var sb strings.Builder
+	_, err := registry.WriteTo(&sb)
+	assert.NoError(t, err)
+
+	expected := `# HELP test_queue_length Items "queued"
+# TYPE test_queue_length gauge
+test_queue_length{queue="a\"b"} 1.5
+# HELP test_requests_total Requests received
+# TYPE test_requests_total counter
+test_requests_total{method="GET"} 3
+test_requests_total{method="POST"} 1
+# HELP test_sessions Open sessions
+# TYPE test_sessions gauge
+test_sessions 4
+`
+	assert.Equal(t, expected, sb.String())
+}
+
+func TestHandler(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.NewCounter("test_total", "Test").With().Inc()
+
+	rec := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, 200, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+	assert.Contains(t, rec.Body.String(), "test_total 1\n")
+}
@@ -75,7 +75,7 @@ func TestValidPathHandler(t *testing.T) {
 	assert.NotEmpty(t, certs)
 	cert := certs[0]
 
-	// Assert the subject common name of the certificate
-	expectedCN := "riotpot.com"
-	assert.Equal(t, expectedCN, cert.Subject.CommonName)
+	// Assert the subject of the certificate does not tell the honeypot apart
+	assert.NotEmpty(t, cert.Subject.CommonName)
+	assert.NotContains(t, cert.Subject.Organization, "RiotPot")
 }
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/proxy"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a port that is free at the moment
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// Start a server that answers every connection with the message
+func startServer(t *testing.T, msg string) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(msg))
+			conn.Close()
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// Connect to the proxy and read the first line
+func readLine(t *testing.T, port int) string {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	return line
+}
+
+func TestFailover(t *testing.T) {
+	// The primary service is not listening
+	primary := service.NewService("primary", freePort(t), utils.TCP, "127.0.0.1", utils.High)
+	fallback := service.NewService("fallback", startServer(t, "fallback\n"), utils.TCP, "127.0.0.1", utils.Low)
+
+	port := freePort(t)
+	px, err := proxy.NewTCPProxy(port)
+	assert.NoError(t, err)
+
+	px.SetService(primary)
+	_, err = px.AddBackend(fallback, 1)
+	assert.NoError(t, err)
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	assert.Equal(t, "fallback\n", readLine(t, port))
+
+	// The primary service is marked as unhealthy after the failed attempt
+	backends := px.GetBackends()
+	assert.Equal(t, 2, len(backends))
+	assert.False(t, backends[0].IsHealthy())
+	assert.True(t, backends[1].IsHealthy())
+}
+
+func TestWeightedBalancing(t *testing.T) {
+	first := service.NewService("first", startServer(t, "first\n"), utils.TCP, "127.0.0.1", utils.Low)
+	second := service.NewService("second", startServer(t, "second\n"), utils.TCP, "127.0.0.1", utils.Low)
+
+	port := freePort(t)
+	px, err := proxy.NewTCPProxy(port)
+	assert.NoError(t, err)
+
+	px.SetService(first)
+	px.AddBackend(second, 3)
+	px.SetBalancing(utils.WeightedBalancing)
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[readLine(t, port)]++
+	}
+
+	assert.Equal(t, 2, counts["first\n"])
+	assert.Equal(t, 6, counts["second\n"])
+}
+
+// Start a UDP server that answers every datagram with the message
+func startUDPServer(t *testing.T, msg string) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo([]byte(msg), addr)
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Returns a UDP port without a server
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestUDPFailover(t *testing.T) {
+	// The primary service is not listening
+	primary := service.NewService("primary", freeUDPPort(t), utils.UDP, "127.0.0.1", utils.High)
+	fallback := service.NewService("fallback", startUDPServer(t, "fallback\n"), utils.UDP, "127.0.0.1", utils.Low)
+
+	port := freeUDPPort(t)
+	px, err := proxy.NewUDPProxy(port)
+	assert.NoError(t, err)
+
+	px.SetService(primary)
+	_, err = px.AddBackend(fallback, 1)
+	assert.NoError(t, err)
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	listener, err := px.GetListener()
+	assert.NoError(t, err)
+	sink := &connectionSink{destination: listener.LocalAddr().String()}
+	events.Events.Register(sink)
+	defer events.Events.Unregister(sink.GetName())
+
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// The datagram is sent again to the next service when the first one is unreachable
+	_, err = conn.Write([]byte("ping\n"))
+	assert.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "fallback\n", string(buf[:n]))
+
+	// The health check probes the UDP services as well
+	assert.Eventually(t, func() bool {
+		return !px.GetBackends()[0].IsHealthy() && px.GetBackends()[1].IsHealthy()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// The client is a session of the proxy, as the TCP connections
+	assert.Eventually(t, func() bool { return len(sink.get()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	ev := sink.get()[0]
+	assert.Equal(t, px.GetID(), ev.Proxy)
+	assert.Equal(t, conn.LocalAddr().String(), ev.Source)
+	assert.Equal(t, "udp", ev.GetString("network"))
+}
+
+func TestParseBalancing(t *testing.T) {
+	b, err := utils.ParseBalancing("sticky")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.StickyBalancing, b)
+
+	b, err = utils.ParseBalancing("1")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.WeightedBalancing, b)
+
+	for _, balancing := range []string{"4", "-1", "random"} {
+		_, err = utils.ParseBalancing(balancing)
+		assert.Error(t, err, balancing)
+	}
+}
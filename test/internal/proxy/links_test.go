@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/proxy"
+	"github.com/riotpot/pkg/proxyproto"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/tracing"
+	"github.com/riotpot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// Sink keeping the authentication events of a service
+type authSink struct {
+	mu      sync.Mutex
+	service string
+	events  []*events.Event
+}
+
+func (s *authSink) GetName() string {
+	return "links_test"
+}
+
+func (s *authSink) Send(ev *events.Event) error {
+	if ev.Type == events.AuthEvent && ev.Service == s.service {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.events = append(s.events, ev)
+	}
+	return nil
+}
+
+func (s *authSink) get() []*events.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*events.Event{}, s.events...)
+}
+
+// Start an SSH service as the plugin does: it reads the PROXY protocol header with the
+// listener of the plugins, and emits the events and the spans with the remote address
+func startSSH(t *testing.T, name string) int {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			span := tracing.Tracer.StartFor(c.RemoteAddr().String(), "ssh.auth", tracing.ServerKind)
+			span.Finish()
+
+			ev := events.NewEvent(events.AuthEvent, name, c.RemoteAddr().String())
+			events.Events.Emit(ev.With("user", c.User()).With("success", true))
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	listener := proxyproto.NewListener(ln, func() bool { return true })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestProxyProtocolLinks(t *testing.T) {
+	rec := &recorder{spans: make(map[string]*tracing.Span)}
+	tracing.Tracer.SetExporter(rec)
+	defer tracing.Tracer.SetExporter(nil)
+
+	sink := &authSink{service: t.Name()}
+	events.Events.Register(sink)
+	defer events.Events.Unregister(sink.GetName())
+
+	srv := service.NewService(t.Name(), startSSH(t, t.Name()), utils.TCP, "127.0.0.1", utils.High)
+	srv.SetProxyProtocol(utils.ProxyProtocolV1)
+
+	port := freePort(t)
+	px, err := proxy.NewTCPProxy(port)
+	assert.NoError(t, err)
+	px.SetService(srv)
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	config := &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("root")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         2 * time.Second,
+	}
+	c, _, _, err := ssh.NewClientConn(conn, conn.RemoteAddr().String(), config)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// The service sees the attacker, the events still belong to the session of the proxy
+	assert.Eventually(t, func() bool {
+		return len(sink.get()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ev := sink.get()[0]
+	assert.Equal(t, px.GetID(), ev.Proxy)
+	assert.NotEmpty(t, ev.Session)
+	assert.Equal(t, conn.LocalAddr().String(), ev.Source)
+
+	// The span of the service joins the trace of the proxy
+	auth := rec.get("ssh.auth")
+	if assert.NotNil(t, auth) {
+		assert.NotEqual(t, tracing.SpanID{}, auth.ParentID)
+	}
+}
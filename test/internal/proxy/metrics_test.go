@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/metrics"
+	"github.com/riotpot/pkg/proxy"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyMetrics(t *testing.T) {
+	srv := service.NewService("metrics", startServer(t, "hello\n"), utils.TCP, "127.0.0.1", utils.Low)
+
+	port := freePort(t)
+	px, err := proxy.NewTCPProxy(port)
+	assert.NoError(t, err)
+	px.SetService(srv)
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	assert.Equal(t, "hello\n", readLine(t, port))
+
+	labels := fmt.Sprintf(`proxy="%s",port="%d",network="tcp"`, px.GetID(), port)
+	assert.Eventually(t, func() bool {
+		var sb strings.Builder
+		metrics.Metrics.WriteTo(&sb)
+		out := sb.String()
+
+		return strings.Contains(out, "riotpot_proxy_connections_accepted_total{"+labels+"} 1\n") &&
+			strings.Contains(out, "riotpot_proxy_bytes_total{"+labels+`,direction="out"} 6`+"\n") &&
+			strings.Contains(out, "riotpot_proxy_sessions_active{"+labels+"} 0\n")
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/riotpot/pkg/proxy"
+	"github.com/riotpot/pkg/scanners"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerPolicy(t *testing.T) {
+	// The tests connect from the loopback, make it a scanner
+	assert.NoError(t, scanners.Scanners.SetScanners([]scanners.Scanner{{Name: "Loopback", CIDRs: []string{"127.0.0.0/8"}}}))
+	defer scanners.Scanners.SetScanners(scanners.Defaults)
+
+	real := service.NewService("real", startServer(t, "real\n"), utils.TCP, "127.0.0.1", utils.High)
+	persona := service.NewService("persona", startServer(t, "persona\n"), utils.TCP, "127.0.0.1", utils.Low)
+
+	port := freePort(t)
+	px, err := proxy.NewTCPProxy(port)
+	assert.NoError(t, err)
+	px.SetService(real)
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	// Served like any other client without a policy
+	assert.Equal(t, "real\n", readLine(t, port))
+
+	policy, err := proxy.NewScannerPolicy(proxy.PersonaScanners, persona)
+	assert.NoError(t, err)
+	_, err = px.SetScannerPolicy(policy)
+	assert.NoError(t, err)
+	assert.Equal(t, "persona\n", readLine(t, port))
+
+	policy, err = proxy.NewScannerPolicy(proxy.DropScanners, nil)
+	assert.NoError(t, err)
+	_, err = px.SetScannerPolicy(policy)
+	assert.NoError(t, err)
+	assert.Equal(t, "", readLine(t, port))
+
+	// The persona needs a service of the same network
+	_, err = proxy.NewScannerPolicy(proxy.PersonaScanners, nil)
+	assert.Error(t, err)
+
+	udp := service.NewService("udp", freePort(t), utils.UDP, "127.0.0.1", utils.Low)
+	policy, _ = proxy.NewScannerPolicy(proxy.PersonaScanners, udp)
+	_, err = px.SetScannerPolicy(policy)
+	assert.Error(t, err)
+
+	_, err = proxy.ParseScannerAction("tarpit")
+	assert.Error(t, err)
+}
+
+func TestUDPScannerPolicy(t *testing.T) {
+	px, err := proxy.NewUDPProxy(freeUDPPort(t))
+	assert.NoError(t, err)
+
+	// The UDP proxies do not identify the scanners, the policies would never apply
+	policy, err := proxy.NewScannerPolicy(proxy.DropScanners, nil)
+	assert.NoError(t, err)
+	_, err = px.SetScannerPolicy(policy)
+	assert.Error(t, err)
+	assert.Nil(t, px.GetScannerPolicy())
+
+	_, err = px.SetScannerPolicy(nil)
+	assert.NoError(t, err)
+}
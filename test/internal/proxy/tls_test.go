@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/riotpot/pkg/certificates"
+	"github.com/riotpot/pkg/proxy"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Start a server that answers the first line of every connection with the line received
+func startEchoServer(t *testing.T, ln net.Listener) int {
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte("echo: " + line))
+			conn.Close()
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// Connect to the proxy with TLS, send a line and read the answer
+func exchangeTLS(t *testing.T, port int, line string) string {
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{InsecureSkipVerify: true})
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	conn.Write([]byte(line))
+	answer, _ := bufio.NewReader(conn).ReadString('\n')
+	return answer
+}
+
+func TestTLSTermination(t *testing.T) {
+	// The service receives the plaintext
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := service.NewService("plain", startEchoServer(t, ln), utils.TCP, "127.0.0.1", utils.Low)
+
+	termination, err := proxy.NewTermination(nil, false)
+	assert.NoError(t, err)
+	assert.True(t, termination.IsSelfSigned())
+	assert.NotEmpty(t, termination.GetFingerprint())
+
+	port := freePort(t)
+	px, err := proxy.NewTLSProxy(port, termination)
+	assert.NoError(t, err)
+	px.SetService(srv)
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	assert.Equal(t, "echo: hello\n", exchangeTLS(t, port, "hello\n"))
+}
+
+func TestTLSReencryption(t *testing.T) {
+	// The service only talks TLS
+	cert, err := certificates.NewSelfSigned("localhost")
+	assert.NoError(t, err)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	assert.NoError(t, err)
+	srv := service.NewService("tls", startEchoServer(t, ln), utils.TCP, "127.0.0.1", utils.Low)
+
+	termination, err := proxy.NewTermination(nil, true)
+	assert.NoError(t, err)
+
+	port := freePort(t)
+	px, err := proxy.NewTLSProxy(port, termination)
+	assert.NoError(t, err)
+	px.SetService(srv)
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	assert.Equal(t, "echo: hello\n", exchangeTLS(t, port, "hello\n"))
+}
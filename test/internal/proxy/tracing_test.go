@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/proxy"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/tracing"
+	"github.com/riotpot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Exporter that keeps the finished spans by name
+type recorder struct {
+	mu    sync.Mutex
+	spans map[string]*tracing.Span
+}
+
+func (r *recorder) Export(span *tracing.Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans[span.Name] = span
+}
+
+func (r *recorder) Shutdown() {}
+
+func (r *recorder) get(name string) *tracing.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.spans[name]
+}
+
+func TestProxyTrace(t *testing.T) {
+	rec := &recorder{spans: make(map[string]*tracing.Span)}
+	tracing.Tracer.SetExporter(rec)
+	defer tracing.Tracer.SetExporter(nil)
+
+	srv := service.NewService("tracing", startServer(t, "hello\n"), utils.TCP, "127.0.0.1", utils.Low)
+
+	port := freePort(t)
+	px, err := proxy.NewTCPProxy(port)
+	assert.NoError(t, err)
+	px.SetService(srv)
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	assert.Equal(t, "hello\n", readLine(t, port))
+
+	assert.Eventually(t, func() bool {
+		return rec.get("proxy.session") != nil
+	}, time.Second, 10*time.Millisecond)
+
+	session := rec.get("proxy.session")
+	assert.Equal(t, px.GetID(), session.Attributes["riotpot.proxy.id"])
+	assert.Equal(t, "close", session.Events[len(session.Events)-1].Name)
+
+	// Every step of the proxy is a child of the session
+	for _, name := range []string{"proxy.middlewares", "proxy.dial", "proxy.forward"} {
+		span := rec.get(name)
+		if assert.NotNil(t, span, name) {
+			assert.Equal(t, session.TraceID, span.TraceID)
+			assert.Equal(t, session.ID, span.ParentID)
+		}
+	}
+
+	assert.Equal(t, "tracing", rec.get("proxy.dial").Attributes["riotpot.service"])
+	assert.Equal(t, int64(6), rec.get("proxy.forward").Attributes["riotpot.bytes.out"])
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/proxy"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sink keeping the connection events sent to a destination
+type connectionSink struct {
+	mu          sync.Mutex
+	destination string
+	events      []*events.Event
+}
+
+func (s *connectionSink) GetName() string {
+	return "connection_test"
+}
+
+func (s *connectionSink) Send(ev *events.Event) error {
+	if ev.Type == events.ConnectionEvent && ev.Destination == s.destination {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.events = append(s.events, ev)
+	}
+	return nil
+}
+
+func (s *connectionSink) get() []*events.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*events.Event{}, s.events...)
+}
+
+func TestInterceptor(t *testing.T) {
+	// Port with a proxy that is not listening, and port without a proxy
+	proxied, unknown := freePort(t), freePort(t)
+
+	pe, err := proxy.Proxies.CreateProxy(utils.TCP, proxied)
+	assert.NoError(t, err)
+	defer proxy.Proxies.DeleteProxy(pe.GetID())
+	pe.SetService(service.NewService("proxied", startServer(t, "proxied\n"), utils.TCP, "127.0.0.1", utils.Low))
+
+	// The original destination of the connections, zero when it can not be read
+	var mu sync.Mutex
+	var destination int
+	setDestination := func(port int) {
+		mu.Lock()
+		defer mu.Unlock()
+		destination = port
+	}
+
+	port := freePort(t)
+	ic := proxy.NewInterceptor(port)
+	ic.BannerTimeout = time.Second
+	ic.OriginalDestination = func(conn net.Conn) (*net.TCPAddr, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if destination == 0 {
+			return nil, fmt.Errorf("no original destination")
+		}
+		return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: destination}, nil
+	}
+	assert.NoError(t, ic.Start())
+	defer ic.Stop()
+
+	// Dispatched to the proxy of the port
+	setDestination(proxied)
+	assert.Equal(t, "proxied\n", readLine(t, port))
+
+	// The connections without a destination are closed
+	setDestination(0)
+	assert.Equal(t, "", readLine(t, port))
+
+	// The responder records what the client sends
+	sink := &connectionSink{destination: fmt.Sprintf("127.0.0.1:%d", unknown)}
+	events.Events.Register(sink)
+	defer events.Events.Unregister(sink.GetName())
+
+	setDestination(unknown)
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.NoError(t, err)
+	conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	io.ReadAll(conn)
+	conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return len(sink.get()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ev := sink.get()[0]
+	assert.Equal(t, "GET / HTTP/1.0\r\n\r\n", ev.GetString("payload"))
+	assert.NotEmpty(t, ev.Session)
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/proxy"
+	"github.com/riotpot/pkg/service"
+	"github.com/riotpot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sink keeping the sources of the connection events of a proxy
+type sourceSink struct {
+	mu      sync.Mutex
+	proxy   string
+	sources []string
+}
+
+func (s *sourceSink) GetName() string {
+	return "upstreams_test"
+}
+
+func (s *sourceSink) Send(ev *events.Event) error {
+	if ev.Type == events.ConnectionEvent && ev.Proxy == s.proxy {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.sources = append(s.sources, ev.Source)
+	}
+	return nil
+}
+
+func (s *sourceSink) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.sources...)
+}
+
+// Connect to the proxy, send a PROXY protocol header and read the answer of the service
+func sendHeader(t *testing.T, port int) {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte("PROXY TCP4 203.0.113.9 192.0.2.1 4000 22\r\n"))
+	assert.NoError(t, err)
+
+	buf := make([]byte, 64)
+	conn.Read(buf)
+}
+
+func TestTrustedUpstreams(t *testing.T) {
+	port := freePort(t)
+	px, err := proxy.NewTCPProxy(port)
+	assert.NoError(t, err)
+	px.SetService(service.NewService("upstreams", startServer(t, "upstreams\n"), utils.TCP, "127.0.0.1", utils.Low))
+
+	sink := &sourceSink{proxy: px.GetID()}
+	events.Events.Register(sink)
+	defer events.Events.Unregister(sink.GetName())
+
+	assert.NoError(t, px.Start())
+	defer px.Stop()
+
+	// The header of a trusted upstream carries the address of the attacker
+	trusted, err := px.SetTrustedUpstreams([]string{"127.0.0.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1/32"}, trusted)
+	sendHeader(t, port)
+
+	assert.Eventually(t, func() bool {
+		return len(sink.get()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "203.0.113.9:4000", sink.get()[0])
+
+	// The header of any other peer is not honoured
+	_, err = px.SetTrustedUpstreams([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+	sendHeader(t, port)
+
+	assert.Eventually(t, func() bool {
+		return len(sink.get()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	host, _, err := net.SplitHostPort(sink.get()[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+}
+
+func TestUDPUpstreams(t *testing.T) {
+	px, err := proxy.NewUDPProxy(freePort(t))
+	assert.NoError(t, err)
+
+	_, err = px.SetTrustedUpstreams([]string{"10.0.0.0/8"})
+	assert.Error(t, err)
+
+	trusted, err := px.SetTrustedUpstreams(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, trusted)
+}
@@ -0,0 +1,103 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/riotpot/pkg/proxyproto"
+	"github.com/riotpot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	cases := []struct {
+		version utils.ProxyProtocol
+		src     net.Addr
+		dst     net.Addr
+	}{
+		{utils.ProxyProtocolV1, &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51000}, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}},
+		{utils.ProxyProtocolV1, &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51000}, &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 23}},
+		{utils.ProxyProtocolV2, &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51000}, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}},
+		{utils.ProxyProtocolV2, &net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51000}, &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5683}},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		_, err := proxyproto.NewHeader(c.version, c.src, c.dst).WriteTo(&buf)
+		assert.NoError(t, err)
+
+		// Data sent after the header must remain in the reader
+		buf.WriteString("SSH-2.0-OpenSSH\r\n")
+		br := bufio.NewReader(&buf)
+
+		h, err := proxyproto.Read(br)
+		assert.NoError(t, err)
+		assert.Equal(t, c.version, h.Version)
+		assert.Equal(t, c.src.String(), h.Source.String())
+		assert.Equal(t, c.dst.String(), h.Destination.String())
+
+		rest, _ := br.ReadString('\n')
+		assert.Equal(t, "SSH-2.0-OpenSSH\r\n", rest)
+	}
+}
+
+func TestNoHeader(t *testing.T) {
+	br := bufio.NewReader(bytes.NewBufferString("GET / HTTP/1.1\r\n"))
+	_, err := proxyproto.Read(br)
+	assert.ErrorIs(t, err, proxyproto.ErrNoHeader)
+}
+
+func TestConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	src := &net.TCPAddr{IP: net.ParseIP("198.51.100.4"), Port: 4444}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 23}
+
+	go func() {
+		proxyproto.NewHeader(utils.ProxyProtocolV2, src, dst).WriteTo(client)
+		client.Write([]byte("root\n"))
+	}()
+
+	conn := proxyproto.NewConn(server, 0)
+	assert.Equal(t, src.String(), conn.RemoteAddr().String())
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "root\n", line)
+}
+
+func TestPolicy(t *testing.T) {
+	policy, err := proxyproto.NewPolicy([]string{"10.0.0.0/8", "192.0.2.10"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.10/32"}, policy.GetTrusted())
+
+	assert.True(t, policy.Trusts(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+	assert.True(t, policy.Trusts(&net.TCPAddr{IP: net.ParseIP("192.0.2.10")}))
+	assert.False(t, policy.Trusts(&net.TCPAddr{IP: net.ParseIP("192.0.2.11")}))
+
+	_, err = proxyproto.NewPolicy([]string{"not-an-address"})
+	assert.Error(t, err)
+}
+
+func TestParseProxyProtocol(t *testing.T) {
+	pp, err := utils.ParseProxyProtocol("v2")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.ProxyProtocolV2, pp)
+
+	pp, err = utils.ParseProxyProtocol("1")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.ProxyProtocolV1, pp)
+
+	pp, err = utils.ParseProxyProtocol("")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.NoProxyProtocol, pp)
+
+	// Only the defined versions are accepted
+	for _, version := range []string{"3", "-1", "v3", "127"} {
+		_, err = utils.ParseProxyProtocol(version)
+		assert.Error(t, err, version)
+	}
+}
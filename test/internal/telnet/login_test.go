@@ -0,0 +1,119 @@
+package telnet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/events"
+	"github.com/riotpot/pkg/shell"
+	"github.com/riotpot/pkg/telnet"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sink keeping the authentication events of a service
+type authSink struct {
+	mu      sync.Mutex
+	service string
+	events  []*events.Event
+}
+
+func (s *authSink) GetName() string {
+	return "auth_test"
+}
+
+func (s *authSink) Send(ev *events.Event) error {
+	if ev.Type == events.AuthEvent && ev.Service == s.service {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.events = append(s.events, ev)
+	}
+	return nil
+}
+
+func (s *authSink) get() []*events.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*events.Event{}, s.events...)
+}
+
+// Log in through a proxy with the lines given, returning the result and the output
+func login(t *testing.T, attacker string, persona shell.Persona, lines ...string) (user string, ok bool, out string) {
+	server, client := net.Pipe()
+	defer client.Close()
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The service sees the proxy, "pipe", that links the connection to the attacker
+	events.Events.Link(server.RemoteAddr().String(), events.Link{Session: "session-" + attacker, Source: attacker + ":4000"})
+	defer events.Events.Unlink(server.RemoteAddr().String())
+
+	var output bytes.Buffer
+	read := make(chan struct{})
+	go func() {
+		io.Copy(&output, client)
+		close(read)
+	}()
+	go func() {
+		for _, line := range lines {
+			client.Write([]byte(line + "\r\n"))
+		}
+	}()
+
+	conn := telnet.NewConn(server)
+	user, ok = telnet.Login(conn, bufio.NewReader(conn), t.Name(), persona)
+	server.Close()
+	<-read
+	return user, ok, output.String()
+}
+
+func TestLogin(t *testing.T) {
+	sink := &authSink{service: t.Name()}
+	events.Events.Register(sink)
+	defer events.Events.Unregister(sink.GetName())
+
+	delay := telnet.FailDelay
+	telnet.FailDelay = 0
+	defer func() { telnet.FailDelay = delay }()
+
+	persona := shell.DefaultPersona()
+	persona.Logins = []shell.Login{{User: "root", Password: "xc3511"}}
+	persona.MaxAttempts = 2
+	persona.Lockout = 60
+
+	// The attempts run out, and the attacker is locked out
+	_, ok, out := login(t, "203.0.113.5", persona, "root", "admin", "root", "1234")
+	assert.False(t, ok)
+	assert.Equal(t, 2, strings.Count(out, "Login incorrect\r\n"))
+	assert.True(t, shell.Lockouts.Locked("203.0.113.5"))
+	// Not the proxy, the other attackers behind it can log in
+	assert.False(t, shell.Lockouts.Locked("pipe"))
+
+	// The valid credentials fail while the attacker is locked out
+	_, ok, _ = login(t, "203.0.113.5", persona, "root", "xc3511", "root", "xc3511")
+	assert.False(t, ok)
+
+	user, ok, out := login(t, "203.0.113.6", persona, "root", "xc3511")
+	assert.True(t, ok)
+	assert.Equal(t, "root", user)
+	// The input is not echoed, the options were not negotiated
+	assert.Equal(t, "login: Password: \r\n", out)
+
+	assert.Eventually(t, func() bool {
+		return len(sink.get()) == 5
+	}, 5*time.Second, 10*time.Millisecond)
+
+	evs := sink.get()
+	assert.Equal(t, "203.0.113.5:4000", evs[0].Source)
+	assert.Equal(t, "session-203.0.113.5", evs[0].Session)
+	assert.Equal(t, "admin", evs[0].GetString("password"))
+	assert.Equal(t, 2, evs[1].Fields["attempt"])
+	assert.Equal(t, true, evs[2].Fields["locked"])
+	assert.True(t, evs[4].GetBool("success"))
+	assert.Equal(t, "203.0.113.6:4000", evs[4].Source)
+}
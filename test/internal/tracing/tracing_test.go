@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riotpot/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+// Stand-in for an OpenTelemetry collector that keeps the requests received
+type collector struct {
+	mu       sync.Mutex
+	requests []map[string]interface{}
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil || req.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.requests = append(c.requests, decoded)
+	c.mu.Unlock()
+}
+
+// Returns the spans received, by name
+func (c *collector) spans() map[string]map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ret := make(map[string]map[string]interface{})
+	for _, req := range c.requests {
+		for _, rs := range req["resourceSpans"].([]interface{}) {
+			for _, ss := range rs.(map[string]interface{})["scopeSpans"].([]interface{}) {
+				for _, s := range ss.(map[string]interface{})["spans"].([]interface{}) {
+					span := s.(map[string]interface{})
+					ret[span["name"].(string)] = span
+				}
+			}
+		}
+	}
+	return ret
+}
+
+func TestOTLPExport(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	exporter := tracing.NewOTLPExporter(server.URL+"/v1/traces", 0)
+	tracing.Tracer.SetExporter(exporter)
+	defer tracing.Tracer.SetExporter(nil)
+
+	parent := tracing.Tracer.Start("proxy.session", tracing.ServerKind, nil)
+	parent.SetAttribute("server.port", 22)
+
+	// A service reaches the span of the connection through the address of the proxy
+	tracing.Tracer.Link("127.0.0.1:40000", parent)
+	child := tracing.Tracer.StartFor("127.0.0.1:40000", "ssh.auth", tracing.ServerKind)
+	child.SetAttribute("ssh.user", "root")
+	child.SetError(fmt.Errorf("invalid pair of username and password"))
+	child.Finish()
+	tracing.Tracer.Unlink("127.0.0.1:40000")
+
+	parent.AddEvent("close", nil)
+	parent.Finish()
+
+	// Shutting down the exporter sends the pending spans
+	exporter.Shutdown()
+
+	spans := c.spans()
+	assert.Len(t, spans, 2)
+
+	session, auth := spans["proxy.session"], spans["ssh.auth"]
+	assert.NotNil(t, session)
+	assert.NotNil(t, auth)
+
+	assert.Equal(t, parent.TraceID.String(), session["traceId"])
+	assert.Equal(t, session["traceId"], auth["traceId"])
+	assert.Equal(t, session["spanId"], auth["parentSpanId"])
+	assert.Nil(t, session["parentSpanId"])
+
+	assert.Equal(t, float64(tracing.ErrorStatus), auth["status"].(map[string]interface{})["code"])
+	assert.Equal(t, "close", session["events"].([]interface{})[0].(map[string]interface{})["name"])
+
+	attribute := session["attributes"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "server.port", attribute["key"])
+	assert.Equal(t, "22", attribute["value"].(map[string]interface{})["intValue"])
+}
+
+func TestTracingDisabled(t *testing.T) {
+	assert.False(t, tracing.Tracer.IsEnabled())
+
+	span := tracing.Tracer.Start("proxy.session", tracing.ServerKind, nil)
+	assert.False(t, span.IsRecording())
+
+	// Spans that are not recorded are safe to use
+	span.SetAttribute("server.port", 22).AddEvent("close", nil)
+	span.Child("proxy.dial", tracing.ClientKind).Finish()
+	span.Finish()
+}
+
+func TestExportInterval(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	exporter := tracing.NewOTLPExporter(server.URL, 10*time.Millisecond)
+	tracing.Tracer.SetExporter(exporter)
+	defer tracing.Tracer.SetExporter(nil)
+
+	tracing.Tracer.Start("http.request", tracing.ServerKind, nil).Finish()
+
+	// Exported without waiting for the shutdown
+	assert.Eventually(t, func() bool {
+		return len(c.spans()) == 1
+	}, time.Second, 10*time.Millisecond)
+}